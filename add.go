@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var cmdAdd = &command{
+	name:  "add",
+	usage: "add [-force] [-hotp | -ocra suite | -yandex] [-hex] [-transform t] [-t0 time] [-url url] [-issuer issuer] [-account account] [-note text] [-show-input | -stdin | -file file | -secret-cmd command | -clipboard | -qr image | -qr-screen | -camera] name",
+	short: "add a key to the keychain",
+	long: `Add prompts for the 2fa key of name and appends it to the keychain.
+2fa keys are case-insensitive strings [A-Z2-7]; spaces and dashes
+grouping the letters and = padding are ignored, and -hex takes a key
+in hexadecimal instead, as some vendors hand out. The key isn't shown
+while it's typed, and has to be typed twice; -show-input echoes it
+and asks only once. A name already in the keychain is refused, unless
+-force is given to replace its key in place, and so is a secret which
+another key already has, as happens when a key is imported twice,
+and a name of a gauth command, such as list, which "gauth name"
+would run; -force adds it anyway, with a warning.
+
+The key can be taken from another source instead: standard input,
+a file, the output of a command (such as "gpg -d seed.gpg"), the
+clipboard (which is cleared right after), or a QR code in an image
+file, on the screen or shown to the camera. QR codes
+hold otpauth URIs, which also set the number of digits and the type
+of the key. Images are PNG, JPEG or GIF; the camera needs zbarcam.
+
+Some providers derive the HMAC key from the secret instead of using
+it directly. -transform selects how: none, sha1 or md5 (the digest of
+the secret), or truncate:N (its first N bytes).
+
+-t0 starts the TOTP time steps of the key at time instead of the Unix
+epoch, as a few legacy enterprise tokens do: a Unix time in seconds,
+or a time such as 2010-01-01T00:00:00Z. otpauth URIs give it with a
+t0 parameter holding a Unix time.
+
+-ocra adds a challenge-response key of the OCRA suite, such as
+"OCRA-1:HOTP-SHA1-6:QN08", whose responses "gauth ocra" prints.
+-yandex adds a Yandex.Key key, whose codes are 8 letters; it asks for
+the PIN of the account, which is kept in the keychain with the key.
+otpauth://yaotp/ URIs, as Yandex's QR codes hold, are Yandex.Key keys
+too.
+
+-url records the login page of the key, which "gauth open" opens.
+-issuer and -account record the provider and the user of the key,
+which "gauth list" shows and searches; otpauth URIs set them too.
+-note records free text with the key, such as where its recovery
+codes are kept. "gauth edit" changes these details later.`,
+}
+
+var (
+	addForce     = cmdAdd.flags.Bool("force", false, "replace the key of name if there's one, and add a secret another key has")
+	addHotp      = cmdAdd.flags.Bool("hotp", false, "add key as HOTP (counter-based) key")
+	addOcra      = cmdAdd.flags.String("ocra", "", "add key as an OCRA key of `suite`")
+	addYandex    = cmdAdd.flags.Bool("yandex", false, "add key as a Yandex.Key key")
+	addHex       = cmdAdd.flags.Bool("hex", false, "read the key in hexadecimal")
+	addTransform = cmdAdd.flags.String("transform", "", "derive the HMAC key from the secret with `transform`")
+	addT0        = cmdAdd.flags.String("t0", "", "start the TOTP time steps at `time`")
+	addURL       = cmdAdd.flags.String("url", "", "record `url` as the login page of the key")
+	addIssuer    = cmdAdd.flags.String("issuer", "", "record `issuer` as the provider of the key")
+	addAccount   = cmdAdd.flags.String("account", "", "record `account` as the user of the key")
+	addNote      = cmdAdd.flags.String("note", "", "record `text` as a note on the key")
+	addShowInput = cmdAdd.flags.Bool("show-input", false, "echo the key while it's typed and don't ask to confirm it")
+	addStdin     = cmdAdd.flags.Bool("stdin", false, "read the key from stdin without prompting")
+	addFile      = cmdAdd.flags.String("file", "", "read the key from `file`")
+	addSecretCmd = cmdAdd.flags.String("secret-cmd", "", "read the key from the output of shell `command`")
+	addClipboard = cmdAdd.flags.Bool("clipboard", false, "take the key from the clipboard and clear it")
+	addQR        = cmdAdd.flags.String("qr", "", "decode the key from a QR code `image`")
+	addQRScreen  = cmdAdd.flags.Bool("qr-screen", false, "decode the key from a QR code on the screen")
+	addCamera    = cmdAdd.flags.Bool("camera", false, "decode the key from a QR code shown to the camera")
+)
+
+func init() {
+	cmdAdd.run = runAdd
+	cmdAdd.programs = func() bool {
+		return *addSecretCmd != "" || *addClipboard || *addQR != "" || *addQRScreen || *addCamera
+	}
+}
+
+// addSource returns the secret source selected by the add flags.
+func addSource(cmd *command) secretSource {
+	var sources []secretSource
+	if *addStdin {
+		sources = append(sources, stdinSource{})
+	}
+	if *addFile != "" {
+		sources = append(sources, fileSource(*addFile))
+	}
+	if *addSecretCmd != "" {
+		sources = append(sources, commandSource(*addSecretCmd))
+	}
+	if *addClipboard {
+		sources = append(sources, clipboardSource{})
+	}
+	if *addQR != "" {
+		sources = append(sources, qrImageSource(*addQR))
+	}
+	if *addQRScreen {
+		sources = append(sources, qrScreenSource{})
+	}
+	if *addCamera {
+		sources = append(sources, cameraSource{})
+	}
+	switch len(sources) {
+	case 0:
+		return promptSource{show: *addShowInput}
+	case 1:
+		return sources[0]
+	}
+	log.Print("only one key source can be given")
+	cmd.usageExit()
+	return nil
+}
+
+func runAdd(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	name := args[0]
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		log.Fatal("spaces aren't allowed")
+	}
+	if err := checkCommandName(name); err != nil && !*addForce {
+		log.Fatalf("%v (use -force to add it anyway)", err)
+	}
+	if _, err := parseTransform(*addTransform); err != nil {
+		log.Fatal(err)
+	}
+	if *addOcra != "" || *addYandex {
+		if *addHotp || *addOcra != "" && *addYandex {
+			cmd.usageExit()
+		}
+	}
+	if *addOcra != "" {
+		if _, err := parseOCRASuite(*addOcra); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *addT0 != "" {
+		if _, err := parseT0(*addT0); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *addURL != "" {
+		if err := checkURL(*addURL); err != nil {
+			log.Fatal(err)
+		}
+	}
+	openKeychain().add(name, addSource(cmd))
+}
+
+// parseT0 parses the -t0 of a key: a Unix time in seconds, or an RFC
+// 3339 time.
+func parseT0(s string) (int64, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid t0 %q: give a Unix time or a time such as 2010-01-01T00:00:00Z", s)
+	}
+	return t.Unix(), nil
+}
+
+func checkSpace(r rune) rune {
+	if unicode.IsSpace(r) {
+		return -1
+	}
+	return r
+}
+
+// handle flag conflicts and verify key validity
+func (c *Keychain) add(name string, src secretSource) {
+	c.checkWritable()
+	old, exists := c.keys[name]
+	if exists && !*addForce {
+		log.Fatalf("key %q already exists (use -force to replace it)", name)
+	}
+	text, err := src.readSecret(name)
+	if err != nil {
+		log.Fatalf("error reading key: %v", err)
+	}
+	if *addHex {
+		if strings.HasPrefix(strings.TrimSpace(text), "otpauth://") {
+			log.Fatal("-hex conflicts with the base32 key of the otpauth URI")
+		}
+		if text, err = hexSecret(text); err != nil {
+			log.Fatal(err)
+		}
+	}
+	k, counter, err := parseSecret(text)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *addHotp {
+		if strings.HasPrefix(strings.TrimSpace(text), "otpauth://totp/") {
+			log.Fatal("-hotp conflicts with the TOTP key of the otpauth URI")
+		}
+		if counter == "" {
+			counter = strings.Repeat("0", counterLen)
+		}
+	}
+	if *addOcra != "" {
+		if strings.HasPrefix(strings.TrimSpace(text), "otpauth://") {
+			log.Fatal("-ocra conflicts with the otpauth URI")
+		}
+		s, _ := parseOCRASuite(*addOcra)
+		k.digits = s.digits
+		k.set("ocra", *addOcra)
+		if s.counter {
+			counter = strings.Repeat("0", counterLen)
+		}
+	}
+	if yaotp := strings.HasPrefix(strings.TrimSpace(text), "otpauth://yaotp/"); *addYandex || yaotp {
+		if counter != "" {
+			log.Fatal("-yandex conflicts with the HOTP key of the otpauth URI")
+		}
+		if len(k.raw) < yandexSecretLen {
+			log.Fatalf("Yandex.Key secrets are at least %d bytes long", yandexSecretLen)
+		}
+		pin, err := readPassword("Yandex PIN: ")
+		if err != nil {
+			log.Fatalf("reading PIN: %v", err)
+		}
+		pin = strings.TrimSpace(pin)
+		if !attrCheckers["yandex"](pin) {
+			log.Fatal("the PIN has to be digits")
+		}
+		k.digits = 8
+		k.set("yandex", pin)
+	}
+	if *addTransform != "" && *addTransform != "none" {
+		k.set("transform", *addTransform)
+	}
+	if *addT0 != "" {
+		t0, _ := parseT0(*addT0)
+		k.set("t0", strconv.FormatInt(t0, 10))
+	}
+	if k.attr("t0") != "" && (counter != "" || k.attr("ocra") != "" || k.attr("yandex") != "") {
+		log.Fatal("only TOTP keys have a t0")
+	}
+	k.set("url", *addURL)
+	k.set("note", strings.TrimSpace(*addNote))
+	if *addIssuer != "" {
+		k.set("issuer", *addIssuer)
+	}
+	if *addAccount != "" {
+		k.set("account", *addAccount)
+	}
+	// Check the keychain again under the lock, held until the key is
+	// written, so that a key added meanwhile isn't lost nor duplicated.
+	unlock, err := lockKeychain()
+	if err != nil {
+		log.Fatalf("locking keychain: %v", err)
+	}
+	defer unlock()
+	c = readKeychain(c.file)
+	old, exists = c.keys[name]
+	if exists && !*addForce {
+		log.Fatalf("key %q already exists (use -force to replace it)", name)
+	}
+	if have, ok := c.findSecret(k.raw); ok && have != name {
+		if !*addForce {
+			log.Fatalf("%s has the same secret (use -force to add it anyway)", have)
+		}
+		log.Printf("warning: %s has the same secret", have)
+	}
+	if exists {
+		c.lines[old.line] = formatKey(name, k, counter)
+		c.save()
+		audit("add", name)
+		fmt.Fprintf(os.Stderr, "replaced %s\n", name)
+		return
+	}
+	warnName(name, c.issuers())
+	if c.encrypted() {
+		// can't append to ciphertext
+		c.lines = append(c.lines, formatKey(name, k, counter))
+		c.save()
+		audit("add", name)
+		fmt.Fprintf(os.Stderr, "added %s\n", name)
+		return
+	}
+	line := formatKey(name, k, counter)
+	if err := checkLines([]string{line}); err != nil {
+		log.Fatal(err)
+	}
+	line += "\n"
+
+	f, err := os.OpenFile(c.file, os.O_CREATE|os.O_RDWR|os.O_APPEND, keychainPerm().mode)
+	if err != nil {
+		log.Fatalf("opening keychain: %v", err)
+	}
+	// vital
+	if err := keychainPerm().apply(f); err != nil {
+		log.Fatalf("setting keychain permissions: %v", err)
+	}
+
+	if _, err := f.Write([]byte(line)); err != nil {
+		log.Fatalf("adding key: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("closing keychain while adding key: %v", err)
+	}
+	readKeychain(c.file).updateIntegrity()
+	audit("add", name)
+	fmt.Fprintf(os.Stderr, "added %s\n", name)
+}
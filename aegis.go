@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// Aegis Authenticator vault files, as described in
+// https://github.com/beemdevelopment/Aegis/blob/master/docs/vault.md.
+// Encrypted vaults hold a master key wrapped by one or more slots;
+// password slots derive their key with scrypt. The master key
+// decrypts the database with AES-256-GCM.
+
+type aegisVault struct {
+	Version int
+	Header  struct {
+		Slots  []aegisSlot
+		Params *aegisParams
+	}
+	DB json.RawMessage
+}
+
+type aegisParams struct {
+	Nonce string
+	Tag   string
+}
+
+type aegisSlot struct {
+	Type      int
+	Key       string
+	KeyParams aegisParams `json:"key_params"`
+	N, R, P   int
+	Salt      string
+}
+
+const aegisPasswordSlot = 1
+
+type aegisDB struct {
+	Entries []struct {
+		Type   string
+		Name   string
+		Issuer string
+		Info   struct {
+			Secret  string
+			Algo    string
+			Digits  int
+			Period  int
+			Counter uint64
+		}
+	}
+}
+
+// aesGCMOpen decrypts ciphertext, followed by the hex encoded tag,
+// with AES-GCM using the hex encoded nonce.
+func aesGCMOpen(key, ciphertext []byte, p aegisParams) ([]byte, error) {
+	nonce, err := hex.DecodeString(p.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := hex.DecodeString(p.Tag)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, append(append([]byte(nil), ciphertext...), tag...), nil)
+}
+
+// masterKey unwraps the master key of the vault with a password.
+func (v *aegisVault) masterKey(ctx context.Context, password string) ([]byte, error) {
+	tried := false
+	for _, s := range v.Header.Slots {
+		if s.Type != aegisPasswordSlot {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tried = true
+		salt, err := hex.DecodeString(s.Salt)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := hex.DecodeString(s.Key)
+		if err != nil {
+			return nil, err
+		}
+		kek, err := scrypt([]byte(password), salt, s.N, s.R, s.P, 32)
+		if err != nil {
+			return nil, err
+		}
+		if key, err := aesGCMOpen(kek, wrapped, s.KeyParams); err == nil {
+			return key, nil
+		}
+	}
+	if !tried {
+		return nil, errors.New("vault has no password slot")
+	}
+	return nil, errors.New("wrong password")
+}
+
+func importAegis(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var v aegisVault
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("not an Aegis vault: %v", err)
+	}
+	if v.Version != 1 {
+		return nil, fmt.Errorf("unsupported Aegis vault version %d", v.Version)
+	}
+	dbJSON := []byte(v.DB)
+	if v.Header.Params != nil {
+		var enc string
+		if err := json.Unmarshal(v.DB, &enc); err != nil {
+			return nil, fmt.Errorf("invalid encrypted database: %v", err)
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encrypted database: %v", err)
+		}
+		password, err := readPassword("Aegis vault password: ")
+		if err != nil {
+			return nil, err
+		}
+		p := startProgress("deriving key", 0)
+		key, err := v.masterKey(ctx, password)
+		p.finish()
+		if err != nil {
+			return nil, err
+		}
+		if dbJSON, err = aesGCMOpen(key, ciphertext, *v.Header.Params); err != nil {
+			return nil, fmt.Errorf("decrypting database: %v", err)
+		}
+	}
+	var db aegisDB
+	if err := json.Unmarshal(dbJSON, &db); err != nil {
+		return nil, fmt.Errorf("invalid database: %v", err)
+	}
+	var entries []entry
+	for _, a := range db.Entries {
+		name := entryName(a.Issuer, a.Name)
+		e, err := newEntry(name, a.Info.Secret, a.Type, a.Info.Algo, a.Info.Digits, a.Info.Period, a.Info.Counter)
+		if err != nil {
+			log.Printf("%s: skipped: %v", name, err)
+			continue
+		}
+		e.key.setIdentity(a.Issuer, a.Name)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
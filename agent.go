@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var cmdAgent = &command{
+	name:  "agent",
+	usage: "agent run | agent ping | agent status [-json]",
+	short: "run or query the gauth agent",
+	long: `Agent run starts the gauth agent in the foreground. The agent serves
+codes to other programs over a socket: $GAUTH_AGENT_SOCK, or
+gauth-agent.sock in $XDG_RUNTIME_DIR, or $HOME/.gauth-agent.sock.
+The socket gets the mode and group of the keychain.
+
+On Windows, the agent listens on the named pipe
+\\.\pipe\gauth-agent-%USERNAME% instead, which only the user and
+the system can open. $GAUTH_AGENT_SOCK can name another pipe, or the
+path of a unix socket, which Windows 10 and later support.
+
+Agent ping checks whether the agent is running, printing nothing.
+Agent status also describes it, as JSON with -json. Both exit with:
+
+	0  the agent is running and unlocked
+	1  the agent isn't running
+	2  an error, such as an unexpected answer or a usage error
+	3  the agent is running, but locked
+
+so shell prompts, status bars and "ExecStartPre=gauth agent ping"
+lines of systemd units can check it cheaply.
+
+The "agent-limit" lines of the configuration limit how many codes of a
+key the agent serves in a period, and "agent-confirm" has it ask, in
+a desktop dialog or on its terminal, before serving the codes of some
+keys or all of them:
+
+	agent-limit = 3/10m
+	agent-limit = 1/1h bank
+	agent-confirm = bank github
+
+A request which isn't answered in 20 seconds is denied.
+
+The agent reads the keychain as it starts, asking for the passphrase
+of an encrypted keychain on its terminal, and keeps the key, so the
+passphrase is typed once rather than for every code. It never asks
+while serving: "gauth unlock" gives it the passphrase again, and codes
+of OCRA keys and of keys with a passphrase of their own aren't served.
+It forgets the key when the session is locked or the system goes to
+sleep, which it learns from logind with dbus-monitor on Linux, and
+after the time "agent-cache" gives, if any:
+
+	agent-cache = 8h
+
+"Gauth lock" locks the agent, and so does "agent-idle-lock" after a
+time without codes served:
+
+	agent-idle-lock = 15m
+
+A locked agent forgets the keychain key, the integrity and audit MAC
+keys, which it reads again from the keyring, and a keychain read from
+stdin, which it can't read again: it serves no more codes from it and
+has to be restarted. Otherwise it serves the next code only once
+unlocked again: by "gauth unlock" for a keychain encrypted with a
+passphrase, or else by approving the code as for "agent-confirm".`,
+}
+
+var cmdLock = &command{
+	name:  "lock",
+	usage: "lock",
+	short: "lock the agent",
+	long: `Lock locks the running agent, which forgets the keychain key and
+needs "gauth unlock", or approval, before serving a code.`,
+}
+
+var cmdUnlock = &command{
+	name:  "unlock",
+	usage: "unlock",
+	short: "unlock the agent",
+	long: `Unlock asks for the passphrase of the encrypted keychain and gives it
+to the running agent, which unlocks the keychain with it and serves
+codes again.`,
+}
+
+var agentJSON = cmdAgent.flags.Bool("json", false, "print the status as JSON")
+
+// The exit statuses of agent ping and agent status.
+const (
+	agentOK         = 0
+	agentNotRunning = 1
+	agentError      = 2
+	agentLocked     = 3
+)
+
+func init() {
+	cmdAgent.run = runAgent
+	cmdAgent.programs = always
+	cmdLock.run = runLock
+	cmdUnlock.run = runUnlock
+}
+
+// agentStatus is the answer to a status request.
+type agentStatus struct {
+	Schema  int        `json:"schema"`
+	Running bool       `json:"running"`
+	Locked  bool       `json:"locked"`
+	PID     int        `json:"pid,omitempty"`
+	Started *time.Time `json:"started,omitempty"`
+	Socket  string     `json:"socket"`
+	Keys    int        `json:"keys"`
+}
+
+func runAgent(ctx context.Context, cmd *command, args []string) {
+	// misuse exits with agentError, so checks don't take it for a
+	// stopped agent
+	if len(args) == 0 {
+		cmd.printUsage()
+		os.Exit(agentError)
+	}
+	// flags may also follow the subcommand
+	cmd.flags.Parse(args[1:])
+	if cmd.flags.NArg() != 0 {
+		cmd.printUsage()
+		os.Exit(agentError)
+	}
+	switch args[0] {
+	case "run":
+		serveAgent()
+	case "ping":
+		st, err := queryAgent()
+		os.Exit(agentExit(st, err))
+	case "status":
+		st, err := queryAgent()
+		code := agentExit(st, err)
+		if code == agentError {
+			log.Print(err)
+			os.Exit(code)
+		}
+		if *agentJSON {
+			json.NewEncoder(os.Stdout).Encode(st)
+		} else if !st.Running {
+			fmt.Printf("agent not running (%s)\n", st.Socket)
+		} else {
+			state := "unlocked"
+			if st.Locked {
+				state = "locked"
+			}
+			fmt.Printf("agent running, %s, pid %d, %d keys, since %s (%s)\n",
+				state, st.PID, st.Keys, st.Started.Local().Format(time.RFC3339), st.Socket)
+		}
+		os.Exit(code)
+	default:
+		cmd.printUsage()
+		os.Exit(agentError)
+	}
+}
+
+func runLock(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 0 {
+		cmd.usageExit()
+	}
+	if _, err := agentRequest("lock"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runUnlock(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 0 {
+		cmd.usageExit()
+	}
+	if _, err := agentRequest("ping"); err != nil {
+		log.Fatal(err)
+	}
+	passphrase, err := readPassword("keychain passphrase: ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := agentRequest("unlock " + base64.StdEncoding.EncodeToString([]byte(passphrase))); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// agentExit returns the exit status describing the agent.
+func agentExit(st agentStatus, err error) int {
+	switch {
+	case err != nil:
+		return agentError
+	case !st.Running:
+		return agentNotRunning
+	case st.Locked:
+		return agentLocked
+	}
+	return agentOK
+}
+
+// agentRequest sends a request line to the agent and returns its answer.
+// It returns errAgentNotRunning if no agent listens on the socket.
+func agentRequest(req string) (string, error) {
+	conn, err := dialAgent(agentSocket(), time.Second)
+	if err != nil {
+		return "", errAgentNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := fmt.Fprintf(conn, "%s\n", req); err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("reading agent answer: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	if strings.HasPrefix(line, "ok ") {
+		return line[3:], nil
+	}
+	if strings.HasPrefix(line, "err ") {
+		return "", errors.New(line[4:])
+	}
+	return "", fmt.Errorf("unexpected agent answer %q", line)
+}
+
+var errAgentNotRunning = errors.New("agent not running")
+
+// queryAgent asks the agent for its status.
+func queryAgent() (agentStatus, error) {
+	st := agentStatus{Schema: schemaVersion, Socket: agentSocket()}
+	answer, err := agentRequest("status")
+	if err == errAgentNotRunning {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal([]byte(answer), &st); err != nil {
+		return st, fmt.Errorf("invalid agent status: %v", err)
+	}
+	return st, nil
+}
+
+// An agent serves codes over its socket. Requests are lines:
+//
+//	ping        answers "ok pong"
+//	status      answers "ok" and the agentStatus as JSON
+//	code name   answers "ok" and the current code of key name
+//	lock        locks the agent, answering "ok locked"
+//	unlock pass unlocks the keychain with passphrase pass, in base64,
+//	            answering "ok unlocked"
+//
+// Errors are answered with "err" and a message: handling a request
+// never exits nor prompts.
+type agent struct {
+	mu      sync.Mutex // serializes code generation
+	started time.Time
+	locked  bool
+	wiped   bool // the keychain read from stdin was wiped on locking
+	limits  rateLimiter
+
+	cacheTTL time.Duration // how long keychain keys are kept, 0 for ever
+	forgetAt *time.Timer   // forgets them, once there are some
+	idleLock time.Duration // how long the agent may go without codes, 0 for ever
+	idle     *time.Timer   // locks it then
+}
+
+func serveAgent() {
+	sock := agentSocket()
+	if _, err := agentRequest("ping"); err == nil {
+		log.Fatalf("an agent is already running on %s", sock)
+	}
+	ttl, idle := agentDuration("agent-cache"), agentDuration("agent-idle-lock")
+	// Unlock the keychain now, on the agent's terminal: requests are
+	// answered without prompting.
+	if memoryKeychain == nil {
+		if _, err := tryReadKeychain(keychainPath()); err != nil {
+			log.Printf("warning: %v; \"gauth unlock\" unlocks the agent", err)
+		}
+	}
+	errNoPrompt = errors.New(`the agent doesn't ask for passphrases: unlock it with "gauth unlock"`)
+	l, err := listenAgent(sock)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+	a := &agent{started: time.Now(), cacheTTL: ttl, idleLock: idle}
+	if idle > 0 {
+		a.idle = time.AfterFunc(idle, a.lock)
+	}
+	if err := watchSessionLock(a.forget); err != nil && conf.get("encrypt") != "" {
+		log.Printf("warning: the keychain key won't be forgotten when the session is locked: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "agent listening on %s\n", sock)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			// closed on a signal, which also removes the socket
+			return
+		}
+		go a.serve(conn)
+	}
+}
+
+// agentDuration returns the duration configured as key, 0 if none.
+func agentDuration(key string) time.Duration {
+	v := conf.get(key)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("%s: invalid %s %q, want a duration such as 8h", configPath(), key, v)
+	}
+	return d
+}
+
+func (a *agent) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	answer, err := a.handle(peerCaller(conn), strings.Fields(line))
+	if err != nil {
+		fmt.Fprintf(conn, "err %s\n", strings.Replace(err.Error(), "\n", " ", -1))
+		return
+	}
+	fmt.Fprintf(conn, "ok %s\n", answer)
+}
+
+func (a *agent) handle(caller auditCaller, req []string) (string, error) {
+	if len(req) == 0 {
+		return "", errors.New("empty request")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	switch {
+	case req[0] == "ping" && len(req) == 1:
+		return "pong", nil
+	case req[0] == "status" && len(req) == 1:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		st := agentStatus{
+			Schema:  schemaVersion,
+			Running: true,
+			Locked:  a.locked,
+			PID:     os.Getpid(),
+			Started: &a.started,
+			Socket:  agentSocket(),
+		}
+		if a.locked {
+			// Counting the keys would unlock the keychain.
+		} else if memoryKeychain != nil {
+			st.Keys = len(memoryKeychain.keys)
+		} else if c, err := tryReadKeychain(keychainPath()); err == nil {
+			st.Keys = len(c.keys)
+		}
+		data, err := json.Marshal(st)
+		return string(data), err
+	case req[0] == "code" && len(req) == 2:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.wiped {
+			return "", fmt.Errorf("the keychain read from %s was wiped when the agent locked: restart the agent", memoryKeychain.file)
+		}
+		k, err := federation(openBackends()).lookup(ctx, req[1])
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case k.ocra:
+			return "", fmt.Errorf("%s is an OCRA key, whose codes answer challenges: use \"gauth ocra -challenge ... %s\"", req[1], req[1])
+		case k.protected:
+			return "", fmt.Errorf("%s has a passphrase of its own, which the agent doesn't ask for", req[1])
+		}
+		if err := a.limits.allow(req[1], time.Now()); err != nil {
+			if err := tryAuditFor(caller, "limit", req[1]); err != nil {
+				return "", err
+			}
+			return "", err
+		}
+		// An encrypted keychain was unlocked by "gauth unlock", or else
+		// the lookup failed; others are unlocked by approving the code.
+		if a.locked && len(unwrapped) == 0 || needsApproval(req[1]) {
+			if err := approve(ctx, caller, req[1]); err != nil {
+				if err := tryAuditFor(caller, "deny", req[1]); err != nil {
+					return "", err
+				}
+				return "", err
+			}
+		}
+		code, err := k.source.code(ctx, req[1])
+		if err != nil {
+			return "", err
+		}
+		// No code goes out unaudited.
+		if err := tryAuditFor(caller, "code", req[1]); err != nil {
+			return "", err
+		}
+		recordUse(req[1])
+		a.unlocked()
+		return code, nil
+	case req[0] == "lock" && len(req) == 1:
+		a.lock()
+		return "locked", nil
+	case req[0] == "unlock" && len(req) == 2:
+		passphrase, err := base64.StdEncoding.DecodeString(req[1])
+		if err != nil {
+			return "", errors.New("invalid unlock request")
+		}
+		defer wipe(passphrase)
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if memoryKeychain != nil {
+			return "", errors.New("the agent's keychain was read from stdin and has no passphrase")
+		}
+		data, err := ioutil.ReadFile(keychainPath())
+		if err != nil {
+			return "", err
+		}
+		defer wipe(data)
+		if !isEncryptedKeychain(data) {
+			return "", errors.New("the keychain isn't encrypted: approve a code to unlock the agent")
+		}
+		if err := unlockKeychain(data, string(passphrase)); err != nil {
+			return "", err
+		}
+		a.unlocked()
+		return "unlocked", nil
+	}
+	return "", fmt.Errorf("invalid request %q", strings.Join(req, " "))
+}
+
+// unlocked unlocks the agent, with a.mu held, once it served a code or
+// was given the passphrase, and restarts its timers.
+func (a *agent) unlocked() {
+	if a.locked {
+		a.locked = false
+		fmt.Fprintln(os.Stderr, "unlocked")
+	}
+	if a.idle != nil {
+		a.idle.Reset(a.idleLock)
+	}
+	if a.cacheTTL > 0 && a.forgetAt == nil && len(unwrapped) > 0 {
+		a.forgetAt = time.AfterFunc(a.cacheTTL, a.forget)
+	}
+}
+
+// lock locks the agent and wipes the key material it holds: the
+// keychain key, the MAC keys and a keychain read from stdin.
+func (a *agent) lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.locked {
+		a.locked = true
+		fmt.Fprintln(os.Stderr, "locked")
+	}
+	a.forgetKeys()
+	wipe(integrityKey)
+	integrityKey = nil
+	wipe(auditKey)
+	auditKey = nil
+	if memoryKeychain != nil && !a.wiped {
+		memoryKeychain.wipeKeys()
+		a.wiped = true
+		fmt.Fprintf(os.Stderr, "wiped the keychain read from %s\n", memoryKeychain.file)
+	}
+}
+
+// forget wipes the keys of encrypted keychains the agent unlocked, so
+// the next code asks for the passphrase again.
+func (a *agent) forget() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.forgetKeys()
+}
+
+// forgetKeys is forget with a.mu held.
+func (a *agent) forgetKeys() {
+	if a.forgetAt != nil {
+		a.forgetAt.Stop()
+		a.forgetAt = nil
+	}
+	if len(unwrapped) > 0 {
+		forgetKeys()
+		fmt.Fprintln(os.Stderr, "forgot the keychain key")
+	}
+}
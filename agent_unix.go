@@ -0,0 +1,49 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// agentSocket returns the path of the agent's socket.
+func agentSocket() string {
+	if p := os.Getenv("GAUTH_AGENT_SOCK"); p != "" {
+		return p
+	}
+	sock := "gauth-agent" + profileSuffix() + ".sock"
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, sock)
+	}
+	return filepath.Join(os.Getenv("HOME"), "."+sock)
+}
+
+// listenAgent listens on the unix socket sock, replacing one left
+// behind by an agent which didn't exit cleanly; anything else there is
+// left alone. Closing the listener removes the socket.
+func listenAgent(sock string) (net.Listener, error) {
+	if fi, err := os.Lstat(sock); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", sock)
+		}
+		os.Remove(sock)
+	}
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+	if err := keychainPerm().applySocket(sock); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("setting socket permissions: %v", err)
+	}
+	return l, nil
+}
+
+func dialAgent(sock string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", sock, timeout)
+}
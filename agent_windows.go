@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	pipeAccessDuplex          = 0x3
+	fileFlagFirstPipeInstance = 0x80000
+	pipeTypeByte              = 0x0
+	pipeWait                  = 0x0
+	pipeRejectRemoteClients   = 0x8
+	pipeUnlimitedInstances    = 255
+	securityIdentification    = 0x10000
+	securitySqosPresent       = 0x100000
+	errorPipeBusy             = syscall.Errno(231)
+	errorPipeConnected        = syscall.Errno(535)
+	sddlRevision1             = 1
+)
+
+// pipePrefix starts the names of local named pipes.
+const pipePrefix = `\\.\pipe\`
+
+const (
+	pipeBufferSize = 4096
+	pipeWaitMillis = 1000 // to wait for a busy pipe
+)
+
+var (
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procCreateNamedPipeW = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+	procWaitNamedPipeW   = kernel32.NewProc("WaitNamedPipeW")
+	procLocalFree        = kernel32.NewProc("LocalFree")
+	procConvertSDDLToSD  = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+)
+
+// agentSocket returns the name of the agent's pipe, or the path of its
+// unix socket if $GAUTH_AGENT_SOCK names one.
+func agentSocket() string {
+	if p := os.Getenv("GAUTH_AGENT_SOCK"); p != "" {
+		return p
+	}
+	return pipePrefix + "gauth-agent-" + os.Getenv("USERNAME") + profileSuffix()
+}
+
+func isPipe(name string) bool {
+	return strings.HasPrefix(name, pipePrefix)
+}
+
+func listenAgent(name string) (net.Listener, error) {
+	if !isPipe(name) {
+		// the socket inherits the permissions of its directory
+		os.Remove(name)
+		return net.Listen("unix", name)
+	}
+	sa, free, err := ownerOnly()
+	if err != nil {
+		return nil, fmt.Errorf("making the pipe's security descriptor: %v", err)
+	}
+	l := &pipeListener{name: name, sa: sa, free: free}
+	// the first instance fails if another process owns the name
+	h, err := l.create(fileFlagFirstPipeInstance)
+	if err != nil {
+		free()
+		return nil, fmt.Errorf("creating pipe %s: %v", name, err)
+	}
+	l.next = h
+	return l, nil
+}
+
+func dialAgent(name string, timeout time.Duration) (net.Conn, error) {
+	if !isPipe(name) {
+		return net.DialTimeout("unix", name, timeout)
+	}
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+			syscall.OPEN_EXISTING, securitySqosPresent|securityIdentification, 0)
+		if err == nil {
+			return newPipeConn(h, name), nil
+		}
+		if err != errorPipeBusy || time.Now().After(deadline) {
+			return nil, err
+		}
+		// all instances are serving other clients
+		procWaitNamedPipeW.Call(uintptr(unsafe.Pointer(p)), pipeWaitMillis)
+	}
+}
+
+// ownerOnly returns security attributes granting full access to the
+// current user and the system only. free releases them.
+func ownerOnly() (*syscall.SecurityAttributes, func(), error) {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer token.Close()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return nil, nil, err
+	}
+	sid, err := user.User.Sid.String()
+	if err != nil {
+		return nil, nil, err
+	}
+	sddl, err := syscall.UTF16PtrFromString("D:P(A;;GA;;;SY)(A;;GA;;;" + sid + ")")
+	if err != nil {
+		return nil, nil, err
+	}
+	var sd uintptr
+	r, _, err := procConvertSDDLToSD.Call(uintptr(unsafe.Pointer(sddl)), sddlRevision1, uintptr(unsafe.Pointer(&sd)), 0)
+	if r == 0 {
+		return nil, nil, err
+	}
+	sa := &syscall.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(syscall.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}
+	return sa, func() { procLocalFree.Call(sd) }, nil
+}
+
+// pipeListener accepts connections on a named pipe. An instance of the
+// pipe always waits for the next client, so clients don't find the
+// pipe missing between connections.
+type pipeListener struct {
+	name string
+	sa   *syscall.SecurityAttributes
+	free func()
+
+	mu     sync.Mutex
+	next   syscall.Handle
+	closed bool
+}
+
+func (l *pipeListener) create(flags uint32) (syscall.Handle, error) {
+	p, err := syscall.UTF16PtrFromString(l.name)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	r, _, err := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(pipeAccessDuplex|flags), pipeTypeByte|pipeWait|pipeRejectRemoteClients,
+		pipeUnlimitedInstances, pipeBufferSize, pipeBufferSize, 0, uintptr(unsafe.Pointer(l.sa)))
+	if h := syscall.Handle(r); h != syscall.InvalidHandle {
+		return h, nil
+	}
+	return syscall.InvalidHandle, err
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	h := l.next
+	l.mu.Unlock()
+	r, _, err := procConnectNamedPipe.Call(uintptr(h), 0)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, errors.New("use of closed pipe listener")
+	}
+	if r == 0 && err != errorPipeConnected {
+		return nil, err
+	}
+	next, err := l.create(0)
+	if err != nil {
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+	l.next = next
+	return newPipeConn(h, l.name), nil
+}
+
+// Close stops the listener. It connects to the waiting instance of
+// the pipe to wake up Accept.
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+	if c, err := dialAgent(l.name, 0); err == nil {
+		c.Close()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	syscall.CloseHandle(l.next)
+	l.free()
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr(l.name) }
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is a connected pipe instance. Its I/O is synchronous,
+// so deadlines aren't supported.
+type pipeConn struct {
+	*os.File
+	h    syscall.Handle
+	addr pipeAddr
+}
+
+func newPipeConn(h syscall.Handle, name string) *pipeConn {
+	return &pipeConn{os.NewFile(uintptr(h), name), h, pipeAddr(name)}
+}
+
+// Close waits for the other end to read what was written,
+// which closing the pipe would otherwise discard.
+func (c *pipeConn) Close() error {
+	syscall.FlushFileBuffers(c.h)
+	return c.File.Close()
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *pipeConn) SetDeadline(t time.Time) error      { return errNoDeadline }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return errNoDeadline }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return errNoDeadline }
+
+var errNoDeadline = errors.New("pipe deadlines aren't supported")
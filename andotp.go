@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+)
+
+// andOTP backups are a JSON array of entries. Encrypted backups are
+//
+//	iterations (4 bytes) | salt (12 bytes) | nonce (12 bytes) | ciphertext
+//
+// with an AES-256-GCM key derived by PBKDF2-HMAC-SHA1. Backups of old
+// andOTP versions lack the first two fields and use SHA-256 of the
+// password as key.
+
+type andOTPEntry struct {
+	Secret    string
+	Issuer    string
+	Label     string
+	Digits    int
+	Type      string
+	Algorithm string
+	Period    int
+	Counter   uint64
+}
+
+func decryptAndOTP(data []byte, password string) ([]byte, error) {
+	open := func(key, nonce, ciphertext []byte) ([]byte, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		return gcm.Open(nil, nonce, ciphertext, nil)
+	}
+	if len(data) > 4+12+12+16 {
+		iter := binary.BigEndian.Uint32(data)
+		if iter > 0 && iter < 1<<24 {
+			key := pbkdf2([]byte(password), data[4:16], int(iter), 32, sha1.New)
+			if plain, err := open(key, data[16:28], data[28:]); err == nil {
+				return plain, nil
+			}
+		}
+	}
+	if len(data) > 12+16 {
+		key := sha256.Sum256([]byte(password))
+		if plain, err := open(key[:], data[:12], data[12:]); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, errors.New("wrong password or not an andOTP backup")
+}
+
+func importAndOTP(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		password, err := readPassword("andOTP backup password: ")
+		if err != nil {
+			return nil, err
+		}
+		p := startProgress("deriving key", 0)
+		data, err = decryptAndOTP(data, password)
+		p.finish()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var list []andOTPEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, errors.New("not an andOTP backup")
+	}
+	var entries []entry
+	for _, a := range list {
+		name := entryName(a.Issuer, a.Label)
+		if a.Algorithm == "" {
+			a.Algorithm = "SHA1"
+		}
+		e, err := newEntry(name, a.Secret, a.Type, a.Algorithm, a.Digits, a.Period, a.Counter)
+		if err != nil {
+			log.Printf("%s: skipped: %v", name, err)
+			continue
+		}
+		e.key.setIdentity(a.Issuer, a.Label)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
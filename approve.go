@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The agent may limit how often it serves the codes of a key, and ask
+// before serving them, so a compromised local process can't quietly
+// collect codes for every account:
+//
+//	agent-limit = 3/10m            at most 3 codes of each key in 10 minutes
+//	agent-limit = 1/1h bank        at most 1 an hour for key bank
+//	agent-confirm = all            ask before serving any code
+//	agent-confirm = bank github    ask before serving the codes of these
+//
+// The last "agent-limit" line naming a key applies to it, else the
+// last which names none.
+
+// An agentLimit allows n codes of a key per period.
+type agentLimit struct {
+	n      int
+	period time.Duration
+}
+
+// limitFor returns the limit of key name, and whether it has one.
+func limitFor(name string) (agentLimit, bool) {
+	var lim agentLimit
+	found, named := false, false
+	for _, line := range conf["agent-limit"] {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			log.Fatalf("%s: empty agent-limit", configPath())
+		}
+		l, err := parseAgentLimit(f[0])
+		if err != nil {
+			log.Fatalf("%s: agent-limit: %v", configPath(), err)
+		}
+		switch {
+		case len(f) == 1 && !named:
+			lim, found = l, true
+		case contains(f[1:], name):
+			lim, found, named = l, true, true
+		}
+	}
+	return lim, found
+}
+
+func parseAgentLimit(s string) (agentLimit, error) {
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return agentLimit{}, fmt.Errorf("%q isn't codes/period, such as 3/10m", s)
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n < 0 {
+		return agentLimit{}, fmt.Errorf("invalid number of codes %q", s[:i])
+	}
+	d, err := time.ParseDuration(s[i+1:])
+	if err != nil || d <= 0 {
+		return agentLimit{}, fmt.Errorf("invalid period %q", s[i+1:])
+	}
+	return agentLimit{n, d}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// A rateLimiter remembers when the agent served the codes of each key.
+type rateLimiter struct {
+	served map[string][]time.Time
+}
+
+// allow records a code of key name served now, or returns an error if
+// its limit doesn't allow one.
+func (r *rateLimiter) allow(name string, now time.Time) error {
+	lim, ok := limitFor(name)
+	if !ok {
+		return nil
+	}
+	if r.served == nil {
+		r.served = make(map[string][]time.Time)
+	}
+	times := r.served[name]
+	for len(times) > 0 && now.Sub(times[0]) >= lim.period {
+		times = times[1:]
+	}
+	if len(times) >= lim.n {
+		r.served[name] = times
+		if lim.n == 0 {
+			return fmt.Errorf("the agent doesn't serve codes of %s", name)
+		}
+		wait := times[0].Add(lim.period).Sub(now).Round(time.Second)
+		return fmt.Errorf("rate limit of %s reached, try again in %v", name, wait)
+	}
+	r.served[name] = append(times, now)
+	return nil
+}
+
+// needsApproval reports whether a code of key name needs approval.
+func needsApproval(name string) bool {
+	for _, line := range conf["agent-confirm"] {
+		f := strings.Fields(line)
+		if contains(f, "all") || contains(f, name) {
+			return true
+		}
+	}
+	return false
+}
+
+var errDenied = errors.New("denied")
+
+// approve asks whether caller may have a code of key name: in a
+// dialog on the desktop, or on the agent's terminal. It returns
+// errDenied if the answer is no, or none comes before ctx is done.
+func approve(ctx context.Context, caller auditCaller, name string) error {
+	question := fmt.Sprintf("Allow %s a code of %s?", describeCaller(caller), name)
+	var ok bool
+	var err error
+	if dialog := approvalDialog(question); dialog != nil {
+		ok, err = runDialog(ctx, dialog)
+	} else if isTerminal(os.Stdin.Fd()) {
+		ok = askTerminal(ctx, question)
+	} else {
+		err = errors.New("no desktop or terminal to ask for approval on")
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errDenied
+	}
+	return nil
+}
+
+// describeCaller names the client process of the agent for a human.
+func describeCaller(c auditCaller) string {
+	if c.PID == 0 {
+		return "a program"
+	}
+	s := fmt.Sprintf("process %d", c.PID)
+	if comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", c.PID)); err == nil {
+		s = fmt.Sprintf("%s (process %d)", strings.TrimSpace(string(comm)), c.PID)
+	}
+	if c.User != "" {
+		s += " of " + c.User
+	}
+	return s
+}
+
+// approvalDialog returns the command showing a yes/no dialog with the
+// question, or nil if there's no desktop or no way to show one.
+func approvalDialog(question string) []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"osascript", "-e", fmt.Sprintf(
+			`display dialog %q with title "gauth" buttons {"Deny", "Allow"} default button "Deny"`, question)}
+	case "windows":
+		return []string{"powershell", "-NoProfile", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms; " +
+				"if ([System.Windows.Forms.MessageBox]::Show('" + strings.Replace(question, "'", "''", -1) +
+				"', 'gauth', 'YesNo', 'Question', 'Button2') -ne 'Yes') { exit 1 }"}
+	}
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return nil
+	}
+	if _, err := exec.LookPath("zenity"); err == nil {
+		return []string{"zenity", "--question", "--title=gauth", "--default-cancel", "--text=" + question}
+	}
+	if _, err := exec.LookPath("kdialog"); err == nil {
+		return []string{"kdialog", "--title", "gauth", "--yesno", question}
+	}
+	return nil
+}
+
+// runDialog runs a dialog, which exits with 0 for yes. osascript
+// exits with 1 for Deny, as for a cancel.
+func runDialog(ctx context.Context, dialog []string) (bool, error) {
+	err := exec.CommandContext(ctx, dialog[0], dialog[1:]...).Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exit), ctx.Err() != nil:
+		return false, nil
+	}
+	return false, fmt.Errorf("%s: %v", dialog[0], err)
+}
+
+// terminalAnswers are the lines typed on the agent's terminal. A
+// single reader owns stdin, so that a prompt which timed out doesn't
+// leave a read behind to swallow the next answer.
+var (
+	terminalAnswers     chan string
+	terminalAnswersOnce sync.Once
+)
+
+// askTerminal asks question on the agent's terminal.
+func askTerminal(ctx context.Context, question string) bool {
+	terminalAnswersOnce.Do(func() {
+		terminalAnswers = make(chan string)
+		go func() {
+			s := bufio.NewScanner(os.Stdin)
+			for s.Scan() {
+				terminalAnswers <- s.Text()
+			}
+			close(terminalAnswers)
+		}()
+	})
+	// Drop lines typed while nothing was asked.
+	for drained := false; !drained; {
+		select {
+		case _, ok := <-terminalAnswers:
+			drained = !ok
+		default:
+			drained = true
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	select {
+	case text, ok := <-terminalAnswers:
+		text = strings.ToLower(strings.TrimSpace(text))
+		return ok && (text == "y" || text == "yes")
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr, "(timed out)")
+		return false
+	}
+}
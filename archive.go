@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+var cmdArchive = &command{
+	name:  "archive",
+	usage: "archive [-d] name...",
+	short: "hide keys without removing them",
+	long: `Archive hides the named keys, of accounts no longer in use, from
+list, search and the codes printed by show, or with -d brings them back.
+Archived keys keep their secrets and HOTP counters: "gauth name" still
+prints their codes, and list, search and show list them with -all.`,
+}
+
+var archiveDelete = cmdArchive.flags.Bool("d", false, "unarchive the keys")
+
+func init() {
+	cmdArchive.run = runArchive
+}
+
+func runArchive(ctx context.Context, cmd *command, args []string) {
+	if len(args) == 0 {
+		cmd.usageExit()
+	}
+	c := openKeychain()
+	for _, name := range args {
+		if _, ok := c.keys[name]; !ok {
+			fatalf(exitNotFound, "no such key %q", name)
+		}
+	}
+	value := time.Now().Format(dateFormat)
+	if *archiveDelete {
+		value = ""
+	}
+	c.update(func(c *Keychain) {
+		for _, name := range args {
+			k, ok := c.keys[name]
+			if !ok {
+				fatalf(exitNotFound, "no such key %q", name)
+			}
+			if *archiveDelete || k.attr("archived") == "" {
+				k.set("archived", value)
+			}
+			c.lines[k.line] = formatKey(name, k, k.count)
+			c.keys[name] = k
+		}
+	})
+	event := "archive"
+	if *archiveDelete {
+		event = "unarchive"
+	}
+	for _, name := range args {
+		audit(event, name)
+	}
+	if *archiveDelete {
+		fmt.Fprintf(os.Stderr, "unarchived %d keys\n", len(args))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "archived %d keys\n", len(args))
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+var cmdAudit = &command{
+	name:  "audit",
+	usage: "audit verify",
+	short: "check the audit log",
+	long: `If the configuration sets "audit = file", gauth appends a record to
+that file whenever it generates a code or changes the keychain. Each
+record says when, what happened, to which key, and who asked: the
+gauth command, the user, the process which ran gauth (or, for codes
+served by the agent, the client process) and its terminal.
+
+Each record carries a MAC of itself and the MAC of the previous one,
+so editing, removing or reordering records breaks the chain. The MAC
+key is kept in the system keyring, not in the log: it's created with
+the first record, and whoever can rewrite the log can't recompute the
+chain without it. Audit verify checks the chain and prints the MAC of
+the last record: keep it elsewhere to also detect records cut from
+the end of the log.`,
+}
+
+func init() {
+	cmdAudit.run = runAudit
+}
+
+// An auditRecord is a line of the audit log. Its MAC covers the
+// record's JSON encoding up to the mac field, which comes last.
+type auditRecord struct {
+	Schema int       `json:"schema"`
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Name   string    `json:"name,omitempty"`
+	auditCaller
+	Prev string `json:"prev"`
+}
+
+// An auditCaller describes who caused an audited event.
+type auditCaller struct {
+	Command string `json:"command,omitempty"`
+	User    string `json:"user,omitempty"`
+	PID     int    `json:"pid,omitempty"`
+	TTY     string `json:"tty,omitempty"`
+}
+
+// auditCommand is the name of the command being run, set by main.
+var auditCommand string
+
+// processCaller returns the caller of this gauth process:
+// its parent and the terminal of its stdin.
+func processCaller() auditCaller {
+	c := auditCaller{Command: auditCommand, User: currentUser(), PID: os.Getppid()}
+	if isTerminal(os.Stdin.Fd()) {
+		c.TTY = ttyName(os.Getpid(), 0)
+	}
+	return c
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// ttyName returns the terminal open as file descriptor fd of process
+// pid, if /proc tells.
+func ttyName(pid, fd int) string {
+	name, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", pid, fd))
+	if err != nil {
+		return ""
+	}
+	for _, prefix := range []string{"/dev/pts/", "/dev/tty", "/dev/console"} {
+		if strings.HasPrefix(name, prefix) {
+			return name
+		}
+	}
+	return ""
+}
+
+var macField = []byte(`,"mac":"`)
+
+// auditKeyName names the MAC key of the audit log of the profile in
+// use in the keyring.
+func auditKeyName() string {
+	return "audit" + profileSuffix()
+}
+
+// auditKey caches the MAC key of the audit log once it's known.
+var auditKey []byte
+
+// getAuditKey returns the MAC key of the audit log from the keyring,
+// creating it there if create is set and it's missing.
+func getAuditKey(create bool) ([]byte, error) {
+	if auditKey != nil {
+		return auditKey, nil
+	}
+	text, err := keyringGet(auditKeyName())
+	if err == errNoKeyring || err != nil && !create {
+		return nil, fmt.Errorf("the audit log's MAC key: %v", err)
+	}
+	var key []byte
+	if err == nil {
+		if key, err = hex.DecodeString(text); err != nil || len(key) == 0 {
+			return nil, errors.New("invalid audit MAC key in the keyring")
+		}
+	} else {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := keyringSet(auditKeyName(), hex.EncodeToString(key)); err != nil {
+			return nil, fmt.Errorf("storing the audit MAC key in the keyring: %v", err)
+		}
+	}
+	lockMemory(key)
+	auditKey = key
+	return key, nil
+}
+
+func auditPath() string {
+	return expandHome(conf.get("audit"))
+}
+
+// audit appends a record of event for key name to the audit log,
+// if one is configured.
+func audit(event, name string) {
+	auditFor(processCaller(), event, name)
+}
+
+// auditFor is audit for an event caused by caller.
+func auditFor(caller auditCaller, event, name string) {
+	if err := tryAuditFor(caller, event, name); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// tryAuditFor is auditFor returning its errors, for the agent.
+func tryAuditFor(caller auditCaller, event, name string) error {
+	file := auditPath()
+	if file == "" {
+		return nil
+	}
+	r := auditRecord{Schema: schemaVersion, Time: time.Now().UTC(), Event: event, Name: name, auditCaller: caller}
+	if err := appendAudit(file, r); err != nil {
+		return fmt.Errorf("writing audit log: %v", err)
+	}
+	return nil
+}
+
+func appendAudit(file string, r auditRecord) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	// Chain to the last record. The key is only created for the first
+	// one: a missing key doesn't go unnoticed later.
+	last, err := lastLine(f)
+	if err != nil {
+		return err
+	}
+	key, err := getAuditKey(len(last) == 0)
+	if err != nil {
+		return err
+	}
+	if len(last) > 0 {
+		_, sum, err := splitRecord(last)
+		if err != nil {
+			return fmt.Errorf("last record: %v", err)
+		}
+		r.Prev = sum
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line := append(body[:len(body)-1:len(body)-1], macField...)
+	line = append(line, hex.EncodeToString(mac(key, string(body)))...)
+	line = append(line, "\"}\n"...)
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	return err
+}
+
+// lastLine returns the last line of f.
+func lastLine(f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	var buf []byte
+	for off := size; off > 0; {
+		n := int64(4096)
+		if n > off {
+			n = off
+		}
+		off -= n
+		chunk := make([]byte, n)
+		if _, err := f.ReadAt(chunk, off); err != nil {
+			return nil, err
+		}
+		buf = append(chunk, buf...)
+		trimmed := bytes.TrimRight(buf, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+	}
+	return bytes.TrimRight(buf, "\n"), nil
+}
+
+// splitRecord returns the part of a record line covered by its MAC,
+// and the MAC.
+func splitRecord(line []byte) (body []byte, sum string, err error) {
+	i := bytes.LastIndex(line, macField)
+	if i < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, "", fmt.Errorf("malformed record")
+	}
+	body = append(line[:i:i], '}')
+	sum = string(line[i+len(macField) : len(line)-2])
+	return body, sum, nil
+}
+
+func runAudit(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 || args[0] != "verify" {
+		cmd.usageExit()
+	}
+	file := auditPath()
+	if file == "" {
+		log.Fatal("no audit log configured")
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	key, err := getAuditKey(false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	problems, n, last, err := verifyAudit(f, file, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range problems {
+		log.Print(p)
+	}
+	if len(problems) > 0 {
+		fatalf(exitVerifyFailed, "%s: %d of %d records failed verification", file, len(problems), n)
+	}
+	fmt.Printf("%d records ok, last MAC %s\n", n, last)
+}
+
+// verifyAudit checks the records of the audit log file, read from in,
+// against key and the chain. It returns a problem for each bad record,
+// the number of records and the MAC of the last one.
+func verifyAudit(in io.Reader, file string, key []byte) (problems []string, n int, last string, err error) {
+	s := bufio.NewScanner(in)
+	s.Buffer(nil, 1<<20)
+	prev := ""
+	for lineno := 1; s.Scan(); lineno++ {
+		body, sum, err := splitRecord(s.Bytes())
+		var r auditRecord
+		if err == nil {
+			err = json.Unmarshal(body, &r)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s:%d: %v", file, lineno, err))
+			prev = ""
+			continue
+		}
+		switch {
+		case !hmac.Equal([]byte(hex.EncodeToString(mac(key, string(body)))), []byte(sum)):
+			problems = append(problems, fmt.Sprintf("%s:%d: record was modified", file, lineno))
+		case r.Prev != prev:
+			problems = append(problems, fmt.Sprintf("%s:%d: chain broken: records before it were modified or removed", file, lineno))
+		}
+		prev = sum
+		n++
+	}
+	return problems, n, prev, s.Err()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestAudit writes an audit log of n records to a new file, with
+// a MAC key of its own rather than the keyring's.
+func writeTestAudit(t *testing.T, n int) (file string, lines [][]byte) {
+	t.Helper()
+	auditKey = bytes.Repeat([]byte{0x42}, 32)
+	t.Cleanup(func() { auditKey = nil })
+	file = filepath.Join(t.TempDir(), "audit.log")
+	for i := 0; i < n; i++ {
+		r := auditRecord{Schema: schemaVersion, Time: time.Unix(int64(1e9+i), 0).UTC(), Event: "code", Name: "github", auditCaller: auditCaller{Command: "show", User: "alice", PID: 100 + i}}
+		if err := appendAudit(file, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, bytes.SplitAfter(data, []byte("\n"))[:n]
+}
+
+func TestVerifyAudit(t *testing.T) {
+	file, lines := writeTestAudit(t, 4)
+	problems, n, last, err := verifyAudit(bytes.NewReader(bytes.Join(lines, nil)), file, auditKey)
+	if err != nil || len(problems) != 0 || n != 4 {
+		t.Fatalf("verifyAudit of the log = %q, %d, %v, want no problems with 4 records", problems, n, err)
+	}
+
+	// edit returns the lines of the log changed by f.
+	edit := func(f func(lines [][]byte) [][]byte) [][]byte {
+		copied := make([][]byte, len(lines))
+		for i, line := range lines {
+			copied[i] = append([]byte(nil), line...)
+		}
+		return f(copied)
+	}
+	tests := []struct {
+		what  string
+		lines [][]byte
+		bad   []int // the lines reported
+	}{
+		{"record edited", edit(func(l [][]byte) [][]byte {
+			l[1] = bytes.Replace(l[1], []byte(`"github"`), []byte(`"gitlab"`), 1)
+			return l
+		}), []int{2}},
+		{"records reordered", edit(func(l [][]byte) [][]byte {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}), []int{2, 3, 4}},
+		{"record deleted", edit(func(l [][]byte) [][]byte {
+			return append(l[:1], l[2:]...)
+		}), []int{2}},
+		{"first record deleted", edit(func(l [][]byte) [][]byte {
+			return l[1:]
+		}), []int{1}},
+		{"record cut short", edit(func(l [][]byte) [][]byte {
+			l[2] = append(l[2][:len(l[2])/2], '\n')
+			return l
+		}), []int{3, 4}},
+	}
+	for _, tt := range tests {
+		problems, _, _, err := verifyAudit(bytes.NewReader(bytes.Join(tt.lines, nil)), file, auditKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bad []int
+		for _, p := range problems {
+			rest := strings.TrimPrefix(p, file+":")
+			line, err := strconv.Atoi(rest[:strings.IndexByte(rest, ':')])
+			if err != nil {
+				t.Fatalf("%s: problem %q without a line", tt.what, p)
+			}
+			bad = append(bad, line)
+		}
+		if !reflect.DeepEqual(bad, tt.bad) {
+			t.Errorf("%s: verifyAudit reported %q, want lines %v", tt.what, problems, tt.bad)
+		}
+	}
+
+	// Records cut from the end leave a valid chain, whose last MAC
+	// differs from the one kept elsewhere.
+	problems, _, cut, err := verifyAudit(bytes.NewReader(bytes.Join(lines[:3], nil)), file, auditKey)
+	if err != nil || len(problems) != 0 || cut == last {
+		t.Errorf("verifyAudit of the log cut short = %q, last MAC %s, %v, want no problems and a last MAC other than %s", problems, cut, err, last)
+	}
+
+	// Another key fails every record.
+	problems, _, _, err = verifyAudit(bytes.NewReader(bytes.Join(lines, nil)), file, bytes.Repeat([]byte{1}, 32))
+	if err != nil || len(problems) != 4 || !strings.Contains(problems[0], "modified") {
+		t.Errorf("verifyAudit with another key = %q, %v, want 4 modified records", problems, err)
+	}
+}
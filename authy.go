@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// Authy has no export of its own. Its secrets are recovered by tools
+// such as authy-export, which write either otpauth URIs, one per line,
+// or a JSON list of the tokens of the desktop app:
+//
+//	[{"name": "GitHub: alice", "decryptedSeed": "...", "digits": 6}]
+//
+// The list may also be wrapped in an object, as {"accounts": [...]}.
+// Authy's own app tokens (Twilio, SendGrid and so on) use a proprietary
+// scheme and aren't exported by these tools.
+
+type authyToken struct {
+	Name            string
+	OriginalName    string
+	Issuer          string
+	OriginalIssuer  string
+	DecryptedSeed   string
+	DecryptedSecret string
+	Secret          string
+	Digits          int
+	Period          int
+}
+
+func importAuthy(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("otpauth://")) {
+		return importURIs(data), nil
+	}
+	var tokens []authyToken
+	if bytes.HasPrefix(data, []byte("{")) {
+		var wrapped struct {
+			Accounts            []authyToken
+			Tokens              []authyToken
+			AuthenticatorTokens []authyToken `json:"authenticator_tokens"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, errors.New("not an Authy export")
+		}
+		tokens = append(append(wrapped.Accounts, wrapped.Tokens...), wrapped.AuthenticatorTokens...)
+	} else if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, errors.New("not an Authy export")
+	}
+	var entries []entry
+	for _, t := range tokens {
+		name := t.OriginalName
+		if name == "" {
+			name = t.Name
+		}
+		issuer := t.OriginalIssuer
+		if issuer == "" {
+			issuer = t.Issuer
+		}
+		if i := strings.Index(name, ":"); i >= 0 && issuer == "" {
+			issuer, name = name[:i], name[i+1:]
+		}
+		issuer, account := strings.TrimSpace(issuer), strings.TrimSpace(name)
+		name = entryName(issuer, account)
+		secret := t.DecryptedSeed
+		if secret == "" {
+			secret = t.DecryptedSecret
+		}
+		if secret == "" {
+			secret = t.Secret
+		}
+		if t.Digits == 0 {
+			t.Digits = 6
+		}
+		if t.Period == 0 {
+			t.Period = 30
+		}
+		e, err := newEntry(name, secret, "totp", "SHA1", t.Digits, t.Period, 0)
+		if err != nil {
+			log.Printf("%s: skipped: %v", name, err)
+			continue
+		}
+		e.key.setIdentity(issuer, account)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
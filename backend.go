@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A backend stores keys and generates their codes. Several backends can
+// be configured with "backend" lines in the configuration file; list and
+// show search all of them, and a name resolves to the first backend that
+// has it: the local keychain, then the others in configuration order.
+// Commands which modify keys work on the local keychain, which is so
+// always searched. Backends which are slow to answer, such as network
+// ones, must give up when ctx is cancelled.
+type backend interface {
+	String() string
+	keys(ctx context.Context) ([]keyInfo, error)
+	code(ctx context.Context, name string) (string, error)
+}
+
+// keyInfo describes a key of a backend.
+type keyInfo struct {
+	name      string
+	hotp      bool // no current code: HOTP, and OCRA keys too
+	ocra      bool // codes answer challenges, see ocra.go
+	yandex    bool // codes are letters, see yandex.go
+	protected bool // codes need a passphrase of the key's own, see protect.go
+	digits    int
+	period    int    // TOTP time step in seconds, 0 if unknown
+	algorithm string // HMAC hash function, "" if unknown
+	counter   string // counter of HOTP keys, "" if unknown
+	epoch     int64  // Unix time the TOTP time steps start at on our clock
+	url       string // login page, "" if unknown
+	source    backend
+
+	issuer, account string // "" if unknown
+	tags            []string
+	note            string
+	favorite        bool
+	archived        bool // hidden from lists unless asked for
+
+	unverified bool // no code of the key was confirmed to work yet
+}
+
+// identity describes the issuer and account of k, such as
+// "GitHub: alice@example.com", or returns "" if both are unknown.
+func (k keyInfo) identity() string {
+	switch {
+	case k.issuer == "":
+		return k.account
+	case k.account == "":
+		return k.issuer
+	}
+	return k.issuer + ": " + k.account
+}
+
+// matches reports whether the name, issuer or account of k
+// contain query, ignoring case.
+func (k keyInfo) matches(query string) bool {
+	query = strings.ToLower(query)
+	for _, s := range []string{k.name, k.issuer, k.account} {
+		if strings.Contains(strings.ToLower(s), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// backendTypes maps the backend types of the configuration
+// to functions opening them from their arguments.
+var backendTypes = map[string]func(args []string) (backend, error){
+	"file": openFileBackend,
+}
+
+// openBackends returns the backends in order of precedence: the local
+// keychain, then the configured ones.
+func openBackends() []backend {
+	if memoryKeychain != nil {
+		return []backend{&fileBackend{path: memoryKeychain.file, c: memoryKeychain}}
+	}
+	local := &fileBackend{path: keychainPath()}
+	backends := []backend{local}
+	for _, line := range conf["backend"] {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			log.Fatalf("%s: empty backend", configPath())
+		}
+		if strings.HasPrefix(f[0], "ssh://") {
+			// The URL tells the type.
+			f = append([]string{"ssh"}, f...)
+		}
+		if networkBackends[f[0]] && offline() {
+			log.Fatalf("%s: backend %s uses the network, which offline mode forbids", configPath(), f[0])
+		}
+		open, ok := backendTypes[f[0]]
+		if !ok {
+			log.Fatalf("%s: unknown backend type %q", configPath(), f[0])
+		}
+		b, err := open(f[1:])
+		if err != nil {
+			log.Fatalf("%s: backend %s: %v", configPath(), f[0], err)
+		}
+		if fb, ok := b.(*fileBackend); ok && filepath.Clean(fb.path) == filepath.Clean(local.path) {
+			// The local keychain comes first already.
+			continue
+		}
+		backends = append(backends, b)
+	}
+	return backends
+}
+
+// federation searches a list of backends in order.
+type federation []backend
+
+// keys lists the keys of all backends, asking them concurrently.
+// A name in several backends is listed once, from the first of them.
+// Backends which fail are skipped, and reported by the error, along
+// with the keys of the others.
+func (f federation) keys(ctx context.Context) ([]keyInfo, error) {
+	p := startProgress("searching backends", len(f))
+	lists := make([][]keyInfo, len(f))
+	errs := make([]error, len(f))
+	var wg sync.WaitGroup
+	for i, b := range f {
+		wg.Add(1)
+		go func(i int, b backend) {
+			defer wg.Done()
+			defer p.add(1)
+			keys, err := b.keys(ctx)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %v", b, err)
+			}
+			for j := range keys {
+				keys[j].source = b
+			}
+			lists[i] = keys
+		}(i, b)
+	}
+	wg.Wait()
+	p.finish()
+	checkInterrupted(ctx, "search interrupted")
+
+	seen := make(map[string]bool)
+	var all []keyInfo
+	for _, keys := range lists {
+		for _, k := range keys {
+			if !seen[k.name] {
+				seen[k.name] = true
+				all = append(all, k)
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+	return all, backendErrors(errs)
+}
+
+// backendErrors returns an error reporting the backends which failed,
+// errs holding their errors, or nil if none did.
+func backendErrors(errs []error) error {
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// lookup returns the key name from the first backend which has it.
+// If none has it and some failed, the error reports them.
+func (f federation) lookup(ctx context.Context, name string) (keyInfo, error) {
+	var errs []error
+	for _, b := range f {
+		if fb, ok := b.(*fileBackend); ok {
+			// A key the index misses, maybe added outside gauth,
+			// is looked for in the whole keychain.
+			if k, ok := fb.lookupIndexed(name); ok {
+				k.source = b
+				return k, nil
+			}
+		}
+		keys, err := b.keys(ctx)
+		checkInterrupted(ctx, "search interrupted")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", b, err))
+			continue
+		}
+		for _, k := range keys {
+			if k.name == name {
+				k.source = b
+				return k, nil
+			}
+		}
+	}
+	if err := backendErrors(errs); err != nil {
+		return keyInfo{}, fmt.Errorf("no such key %q in the backends which answered; %v", name, err)
+	}
+	return keyInfo{}, fmt.Errorf("no such key %q", name)
+}
+
+// fileBackend is a keychain file.
+type fileBackend struct {
+	path string
+
+	once sync.Once
+	c    *Keychain
+	err  error // of reading c
+
+	// one holds the keys looked up through the index, without reading
+	// the keychain; reindex tells the index was found stale.
+	one     *Keychain
+	reindex bool
+}
+
+func openFileBackend(args []string) (backend, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("usage: file path")
+	}
+	return &fileBackend{path: expandHome(args[0])}, nil
+}
+
+func (b *fileBackend) String() string { return "file:" + b.path }
+
+func (b *fileBackend) keychain() *Keychain {
+	c, err := b.load()
+	if err != nil {
+		exitOn(err)
+	}
+	return c
+}
+
+// load is keychain returning its errors.
+func (b *fileBackend) load() (*Keychain, error) {
+	b.once.Do(func() {
+		if b.c == nil {
+			checkPerm(b.path)
+			c, err := tryReadKeychain(b.path)
+			if err == nil {
+				err = c.tryCheckIntegrity()
+			}
+			if err != nil {
+				b.err = err
+				return
+			}
+			b.c = c
+			if b.reindex {
+				b.c.writeIndex()
+			}
+		}
+	})
+	return b.c, b.err
+}
+
+// lookupIndexed looks key name up through the index of the keychain,
+// unless the keychain was read already, or its integrity or, in strict
+// mode, all its lines are checked, which needs all of it. ok is false
+// if the index doesn't have the key.
+func (b *fileBackend) lookupIndexed(name string) (k keyInfo, ok bool) {
+	if b.c != nil || integrityMode() != "" && b.path == keychainPath() || strict() {
+		return keyInfo{}, false
+	}
+	checkPerm(b.path)
+	c, _ := readIndexed(b.path, name)
+	if c == nil {
+		// The index is missing or stale, or misses the key, which
+		// may have been added by hand: the keychain is read whole,
+		// and indexed anew.
+		b.reindex = true
+		return keyInfo{}, false
+	}
+	if b.one == nil {
+		b.one = c
+	} else {
+		b.one.keys[name] = c.keys[name]
+	}
+	return c.keyInfos()[0], true
+}
+
+// keychainOf returns the keychain holding the named keys: the keys read
+// through the index, if they're all among them, or the whole keychain.
+func (b *fileBackend) keychainOf(names ...string) *Keychain {
+	if b.one == nil {
+		return b.keychain()
+	}
+	for _, name := range names {
+		if _, ok := b.one.keys[name]; !ok {
+			return b.keychain()
+		}
+	}
+	return b.one
+}
+
+func (b *fileBackend) keys(ctx context.Context) ([]keyInfo, error) {
+	c, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+	return c.keyInfos(), nil
+}
+
+// keyInfos describes the keys of c.
+func (c *Keychain) keyInfos() []keyInfo {
+	var keys []keyInfo
+	for name, k := range c.keys {
+		keys = append(keys, keyInfo{
+			name:       name,
+			hotp:       k.offset != 0 || k.attr("ocra") != "",
+			ocra:       k.attr("ocra") != "",
+			yandex:     k.attr("yandex") != "",
+			protected:  k.protected(),
+			digits:     k.digits,
+			period:     k.period(),
+			algorithm:  k.algorithm(),
+			counter:    counterValue(k.count),
+			epoch:      k.t0() - k.skew(),
+			url:        k.attr("url"),
+			issuer:     k.attr("issuer"),
+			account:    k.attr("account"),
+			tags:       k.tags(),
+			note:       k.attr("note"),
+			favorite:   k.attr("favorite") != "",
+			archived:   k.attr("archived") != "",
+			unverified: k.attr("verified") == "",
+		})
+	}
+	return keys
+}
+
+// counterValue returns the stored counter of an HOTP key without its
+// padding, or "" for a TOTP key.
+func counterValue(count string) string {
+	if count == "" {
+		return ""
+	}
+	if n := strings.TrimLeft(count, "0"); n != "" {
+		return n
+	}
+	return "0"
+}
+
+func (b *fileBackend) code(ctx context.Context, name string) (string, error) {
+	if b.one != nil {
+		if _, ok := b.one.keys[name]; ok {
+			return b.one.tryCode(name)
+		}
+	}
+	c, err := b.load()
+	if err != nil {
+		return "", err
+	}
+	return c.tryCode(name)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Before the keychain is rewritten, a copy of it is kept in a
+// directory next to it. The newest backups are kept; their number
+// is taken from $GAUTH_BACKUPS or the "backups" setting of the
+// configuration file (0 disables backups).
+const defaultBackups = 10
+
+func backupDir(file string) string {
+	return file + ".bak.d"
+}
+
+func backupRetention() int {
+	for _, v := range []string{os.Getenv("GAUTH_BACKUPS"), conf.get("backups")} {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultBackups
+}
+
+// backup snapshots file into its backup directory and removes
+// the oldest snapshots beyond the retention limit. With enc, the key
+// of the keychain about to be written, a plaintext snapshot is
+// encrypted, so that no plaintext is backed up once the keychain is
+// encrypted.
+func backup(file string, enc *keychainKey) error {
+	keep := backupRetention()
+	if keep == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lockMemory(data)
+	defer wipe(data)
+	if enc != nil && !isEncryptedKeychain(data) {
+		if data, err = enc.encrypt(data); err != nil {
+			return err
+		}
+	}
+	dir := backupDir(file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// vital
+	if err := keychainPerm().applyDir(dir); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, filepath.Base(file)+"-"+time.Now().UTC().Format("20060102T150405.000000000Z"))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := keychainPerm().apply(f); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	backups, err := listBackups(file)
+	if err != nil {
+		return err
+	}
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// rewriteBackups passes the plaintext of each backup of file through
+// edit, which returns the new plaintext, or nil to leave the backup as
+// it is. The new backup is encrypted with the key of the old one, or
+// with enc if the old one wasn't encrypted, and replaces it; the old
+// one is shredded. It's how secrets which changed their protection,
+// by "gauth encrypt" or "gauth protect", leave the backups too.
+func rewriteBackups(file string, enc *keychainKey, edit func(plain []byte) []byte) error {
+	backups, err := listBackups(file)
+	if err != nil {
+		return err
+	}
+	for _, name := range backups {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		lockMemory(data)
+		plain, key := data, enc
+		if isEncryptedKeychain(data) {
+			if key, plain, err = decryptKeychain(data); err != nil {
+				wipe(data)
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		text := edit(plain)
+		out := text
+		if text != nil && key != nil {
+			out, err = key.encrypt(text)
+			wipe(text)
+		}
+		wipe(plain)
+		wipe(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if out == nil {
+			continue
+		}
+		err = writeKeychainFile(name+".new", out)
+		wipe(out)
+		if err != nil {
+			return err
+		}
+		if err := shred(name); err != nil {
+			return err
+		}
+		if err := os.Rename(name+".new", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listBackups returns the backups of file, oldest first.
+func listBackups(file string) ([]string, error) {
+	dir := backupDir(file)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	prefix := filepath.Base(file) + "-"
+	var names []string
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() && strings.HasPrefix(fi.Name(), prefix) {
+			names = append(names, filepath.Join(dir, fi.Name()))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func backupError(err error) error {
+	return fmt.Errorf("backing up keychain: %v (set GAUTH_BACKUPS=0 to skip backups)", err)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var cmdBattlenet = &command{
+	name:  "battlenet",
+	usage: "battlenet attach [-token token] name",
+	short: "attach a Battle.net authenticator",
+	long: `Battlenet attach enrolls a new authenticator for a Battle.net account
+with Blizzard and adds it to the keychain as name, so that gauth gives
+the 8-digit codes Battle.net asks for instead of the Battle.net app.
+
+Blizzard hands authenticators out to logged-in accounts: attach opens
+its login page in the browser, which after logging in leads to an
+address on localhost which doesn't load, such as
+
+	http://localhost/?ST=US-0123456789abcdef-123456789
+
+Paste that address, or its ST token, when asked, or give it with
+-token. The serial and restore code of the authenticator are printed
+and kept in the note of the key: the restore code moves the
+authenticator to another app, so keep it somewhere safe too.
+
+Codes follow Blizzard's clock rather than the computer's: if they
+differ, the key records by how much in its skew attribute. An account
+which already has an authenticator has to remove it first.`,
+}
+
+var battlenetToken = cmdBattlenet.flags.String("token", "", "the login `token`, or the address holding it")
+
+// battlenetLoginURL is the page logging in to Battle.net which leads
+// to the token.
+const battlenetLoginURL = "https://account.battle.net/login/en/?ref=localhost"
+
+// A battlenetAuthenticator is an authenticator enrolled with Blizzard.
+type battlenetAuthenticator struct {
+	serial      string
+	restoreCode string
+	secret      []byte
+	skew        time.Duration // Blizzard's clock minus ours
+}
+
+// battlenetEnroll enrolls an authenticator for the account logged in
+// with token. Builds with the offline tag have none.
+var battlenetEnroll func(ctx context.Context, token string) (*battlenetAuthenticator, error)
+
+func init() {
+	cmdBattlenet.run = runBattlenet
+	cmdBattlenet.network = true
+}
+
+func runBattlenet(ctx context.Context, cmd *command, args []string) {
+	if len(args) == 0 || args[0] != "attach" {
+		cmd.usageExit()
+	}
+	// flags may also follow the subcommand
+	cmd.flags.Parse(args[1:])
+	if cmd.flags.NArg() != 1 {
+		cmd.usageExit()
+	}
+	name := cmd.flags.Arg(0)
+	if strings.ContainsAny(name, " \t\n") {
+		log.Fatal("spaces aren't allowed")
+	}
+	if offline() {
+		log.Fatal("attaching an authenticator uses the network, which offline mode forbids")
+	}
+	if battlenetEnroll == nil {
+		log.Fatal("this build of gauth can't attach authenticators (built with the offline tag)")
+	}
+	c := openKeychain()
+	c.checkWritable()
+	if _, ok := c.keys[name]; ok {
+		log.Fatalf("key %q already exists", name)
+	}
+	token := *battlenetToken
+	if token == "" {
+		if err := openBrowser(battlenetLoginURL); err != nil {
+			log.Printf("warning: opening the browser: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "log in at %s,\nthen paste the address it leads to\n", battlenetLoginURL)
+		var err error
+		if token, err = readPassword("address or token: "); err != nil {
+			log.Fatalf("reading token: %v", err)
+		}
+	}
+	token = battlenetLoginToken(token)
+	if token == "" {
+		log.Fatal("no token: paste the address with ST= after logging in")
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	a, err := battlenetEnroll(ctx, token)
+	checkInterrupted(ctx, "interrupted")
+	if err != nil {
+		log.Fatalf("attaching authenticator: %v", err)
+	}
+	defer wipe(a.secret)
+
+	k := Key{digits: 8, raw: a.secret, text: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(a.secret)}
+	k.set("issuer", "Battle.net")
+	k.set("account", a.serial)
+	k.set("note", fmt.Sprintf("serial %s, restore code %s", a.serial, a.restoreCode))
+	// Skews under 2 seconds are within the precision they're measured to.
+	if s := a.skew.Round(time.Second); s >= 2*time.Second || s <= -2*time.Second {
+		k.set("skew", fmt.Sprint(int64(s/time.Second)))
+	}
+	c.lines = append(c.lines, formatKey(name, k, ""))
+	c.save()
+	audit("add", name)
+	fmt.Fprintf(os.Stderr, "attached authenticator %s as %s\nrestore code: %s\n", a.serial, name, a.restoreCode)
+}
+
+// battlenetLoginToken extracts the token from the address the login
+// page led to, or returns s if it's the token itself.
+func battlenetLoginToken(s string) string {
+	s = strings.TrimSpace(s)
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		return u.Query().Get("ST")
+	}
+	return strings.TrimPrefix(s, "ST=")
+}
+
+// parseBattlenetSecret decodes the hex device secret of an authenticator.
+func parseBattlenetSecret(h string) ([]byte, error) {
+	secret, err := hex.DecodeString(h)
+	if err != nil || len(secret) == 0 {
+		return nil, fmt.Errorf("invalid device secret")
+	}
+	lockMemory(secret)
+	return secret, nil
+}
@@ -0,0 +1,106 @@
+//go:build !offline
+// +build !offline
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	battlenetEnroll = enrollBattlenet
+}
+
+var battlenetClient = &http.Client{Timeout: 30 * time.Second}
+
+const (
+	// battlenetClientID is the OAuth client of Blizzard's apps, which
+	// turns login tokens into access tokens.
+	battlenetClientID = "baedda12fe054e4abdfc3ad7bdea970a"
+	battlenetTokenURL = "https://oauth.battle.net/oauth/sso"
+	battlenetAuthURL  = "https://authenticator-rest-api.bnet-identity.blizzard.net/v1/authenticator"
+)
+
+// enrollBattlenet trades login token for an access token, with which
+// it attaches a new authenticator to the account.
+func enrollBattlenet(ctx context.Context, token string) (*battlenetAuthenticator, error) {
+	form := url.Values{
+		"client_id":  {battlenetClientID},
+		"grant_type": {"client_sso"},
+		"scope":      {"auth.authenticator"},
+		"token":      {token},
+	}
+	req, err := http.NewRequest("POST", battlenetTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var access struct {
+		Token string `json:"access_token"`
+	}
+	if _, err := battlenetDo(req, &access); err != nil {
+		return nil, err
+	}
+	if access.Token == "" {
+		return nil, errors.New("no access token in the login response; log in again for a new token")
+	}
+
+	if req, err = http.NewRequest("POST", battlenetAuthURL, nil); err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+access.Token)
+	var enrolled struct {
+		Serial       string `json:"serial"`
+		RestoreCode  string `json:"restoreCode"`
+		DeviceSecret string `json:"deviceSecret"`
+	}
+	sent := time.Now()
+	resp, err := battlenetDo(req, &enrolled)
+	if resp != nil && resp.StatusCode == http.StatusConflict {
+		return nil, errors.New("the account already has an authenticator; remove it first")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enrolled.Serial == "" || enrolled.RestoreCode == "" {
+		return nil, errors.New("no serial or restore code in the response")
+	}
+	secret, err := parseBattlenetSecret(enrolled.DeviceSecret)
+	if err != nil {
+		return nil, err
+	}
+	a := &battlenetAuthenticator{serial: enrolled.Serial, restoreCode: enrolled.RestoreCode, secret: secret}
+	// The Date header is to the second: compare it with the middle of
+	// the exchange.
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		a.skew = date.Sub(sent.Add(time.Since(sent) / 2))
+	}
+	return a, nil
+}
+
+// battlenetDo sends req and decodes its JSON response into v.
+// The response is also returned, its body closed.
+func battlenetDo(req *http.Request, v interface{}) (*http.Response, error) {
+	req.Header.Set("Accept", "application/json")
+	resp, err := battlenetClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return resp, cloudStatus(resp)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
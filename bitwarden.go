@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Bitwarden exports are JSON:
+//
+//	{"encrypted": false, "items": [{"name": "GitHub",
+//	  "login": {"username": "alice", "totp": "JBSWY3DPEHPK3PXP",
+//	  "uris": [{"uri": "https://github.com"}]}}]}
+//
+// The totp field holds either a bare base32 seed or an otpauth URI.
+// Items without it are passwords only and are left out. The first
+// web page of an item's uris becomes the url of its key. Encrypted
+// exports have to be exported again unencrypted.
+
+type bitwardenItem struct {
+	Name  string
+	Login *struct {
+		Username string
+		Totp     string
+		Uris     []struct{ URI string }
+	}
+}
+
+func importBitwarden(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var export struct {
+		Encrypted bool
+		Items     []bitwardenItem
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, errors.New("not a Bitwarden export")
+	}
+	if export.Encrypted {
+		return nil, errors.New("encrypted Bitwarden exports aren't supported, export to unencrypted JSON")
+	}
+	var entries []entry
+	without := 0
+	for _, item := range export.Items {
+		if item.Login == nil || item.Login.Totp == "" {
+			without++
+			continue
+		}
+		name := entryName(item.Name, item.Login.Username)
+		e, err := item.entry(name)
+		if err != nil {
+			log.Printf("%s: skipped: %v", name, err)
+			continue
+		}
+		for _, u := range item.Login.Uris {
+			if checkURL(u.URI) == nil {
+				e.key.set("url", u.URI)
+				break
+			}
+		}
+		entries = append(entries, e)
+	}
+	log.Printf("%d of %d items have a TOTP key", len(export.Items)-without, len(export.Items))
+	return entries, nil
+}
+
+// entry makes the entry of an item with a TOTP key.
+func (item bitwardenItem) entry(name string) (entry, error) {
+	totp := strings.TrimSpace(item.Login.Totp)
+	issuer, account := item.Name, item.Login.Username
+	if strings.HasPrefix(totp, "otpauth://") {
+		o, err := parseOtpauth(totp)
+		if err != nil {
+			return entry{name: name}, err
+		}
+		if o.account != "" || o.issuer != "" {
+			if o.issuer != "" {
+				issuer = o.issuer
+			}
+			account = o.account
+			name = entryName(issuer, account)
+		}
+		e, err := newEntry(name, o.secret, o.typ, o.algorithm, o.digits, o.period, o.counter)
+		e.key.setIdentity(issuer, account)
+		return e, err
+	}
+	if u, err := url.Parse(totp); err == nil && u.Scheme != "" {
+		return entry{name: name}, fmt.Errorf("unsupported %s key", u.Scheme)
+	}
+	e, err := newEntry(name, totp, "totp", "SHA1", 6, 30, 0)
+	e.key.setIdentity(issuer, account)
+	return e, err
+}
@@ -0,0 +1,8 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+// cgoBuild tells whether gauth is built with cgo, whose threads
+// Landlock can't restrict all at once (see sandbox.go).
+const cgoBuild = true
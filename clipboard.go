@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The clipboard is accessed natively where gauth can: over the Wayland
+// and X11 protocols, and through the system's API on macOS and Windows.
+// Otherwise it falls back to the usual command line tools.
+
+// A nativeClipboard reaches the clipboard without external tools.
+type nativeClipboard interface {
+	read() (string, error)
+	write(text string) error
+	clear() error
+}
+
+// A pasteWatcher is a nativeClipboard which can tell when its text is
+// pasted.
+type pasteWatcher interface {
+	// copyOnce serves text for a single paste, returning true once
+	// it's pasted, or false if something else takes the clipboard
+	// first. It stops when ctx is done.
+	copyOnce(ctx context.Context, text string) (bool, error)
+}
+
+// nativeClipboards open the native clipboards of the system, in order
+// of preference. Each returns an error saying what's missing if its
+// clipboard can't be used.
+var nativeClipboards []func() (nativeClipboard, error)
+
+func openNativeClipboard() (nativeClipboard, error) {
+	var msgs []string
+	for _, open := range nativeClipboards {
+		c, err := open()
+		if err == nil {
+			return c, nil
+		}
+		msgs = append(msgs, err.Error())
+	}
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	return nil, errors.New(strings.Join(msgs, "; "))
+}
+
+// clipboardError combines why the native clipboard and the tools
+// both failed.
+func clipboardError(native, tool error) error {
+	if native == nil {
+		return tool
+	}
+	return fmt.Errorf("%v; %v", native, tool)
+}
+
+// clipboardOwnerEnv is set for the copy of gauth which owns the
+// clipboard in the background, to the kind of clipboard.
+const clipboardOwnerEnv = "GAUTH_CLIPBOARD_OWNER"
+
+// clipboardOwners map the kinds of clipboards which empty when their
+// owner exits to functions which own them with text until something
+// else takes them. Those call ready once they own the clipboard.
+var clipboardOwners = map[string]func(text string, ready func(error)) error{}
+
+// ownClipboard runs the copy of gauth which owns the clipboard, with
+// the text read from stdin. It tells the gauth which started it on
+// stdout when it owns the clipboard, or why it can't.
+func ownClipboard(kind string) {
+	own := clipboardOwners[kind]
+	if own == nil {
+		log.Fatalf("unknown clipboard %q", kind)
+	}
+	text, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var once sync.Once
+	ready := func(err error) {
+		once.Do(func() {
+			msg := "ok"
+			if err != nil {
+				msg = err.Error()
+			}
+			fmt.Println(msg)
+			os.Stdout.Close()
+		})
+	}
+	ready(own(string(text), ready))
+}
+
+// clipboardClearEnv is set for the copy of gauth which clears the
+// clipboard in the background, to how long it waits first.
+const clipboardClearEnv = "GAUTH_CLIPBOARD_CLEAR"
+
+// copyCode copies code to the clipboard, and starts a copy of gauth in
+// the background which clears it after d, so that it's cleared even
+// though gauth has exited by then.
+func copyCode(code string, d time.Duration) error {
+	if err := writeClipboard(code); err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("clearing the clipboard later: %v", err)
+	}
+	// The code goes through a pipe, which holds it all, rather than
+	// being copied in by a goroutine which might not finish before
+	// gauth exits.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("clearing the clipboard later: %v", err)
+	}
+	defer r.Close()
+	_, err = io.WriteString(w, code)
+	w.Close()
+	if err != nil {
+		return fmt.Errorf("clearing the clipboard later: %v", err)
+	}
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), clipboardClearEnv+"="+d.String())
+	cmd.Stdin = r
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("clearing the clipboard later: %v", err)
+	}
+	cmd.Process.Release()
+	return nil
+}
+
+// clearClipboardLater runs the copy of gauth which copyCode starts: it
+// waits, then clears the clipboard if it still holds the code read
+// from stdin. Text copied since is left alone; on X11 and Wayland,
+// clearing the clipboard also ends the copy of gauth owning it.
+func clearClipboardLater(after string) {
+	d, err := time.ParseDuration(after)
+	if err != nil {
+		log.Fatal(err)
+	}
+	code, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	time.Sleep(d)
+	if text, err := readClipboard(); err != nil || text != string(code) {
+		return
+	}
+	if err := clearClipboard(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// clipTimeout returns how long copied codes stay in the clipboard.
+func clipTimeout() time.Duration {
+	v := conf.get("clip-timeout")
+	if v == "" {
+		return 30 * time.Second
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("%s: invalid clip-timeout %q, want a duration such as 30s", configPath(), v)
+	}
+	return d
+}
+
+// The tools, where there's no native clipboard. Each entry lists one
+// alternative: the first that is installed is used.
+var (
+	pasteCmds = [][]string{
+		{"wl-paste", "-n"},
+		{"xclip", "-o", "-selection", "clipboard"},
+		{"xsel", "-ob"},
+		{"pbpaste"},
+		{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"},
+	}
+	copyCmds = [][]string{
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard", "-i"},
+		{"xsel", "-ib"},
+		{"pbcopy"},
+		{"clip.exe"},
+	}
+	// copyOnceCmds serve the clipboard for a single paste and then exit,
+	// which tells when it was pasted.
+	copyOnceCmds = [][]string{
+		{"wl-copy", "--foreground", "--paste-once"},
+		{"xclip", "-selection", "clipboard", "-i", "-loops", "1", "-quiet"},
+	}
+	clearCmds = [][]string{
+		{"wl-copy", "--clear"},
+		{"xsel", "-bc"},
+		{"xclip", "-selection", "clipboard", "-i", os.DevNull},
+		{"pbcopy"},
+		{"powershell.exe", "-NoProfile", "-Command", "Set-Clipboard -Value $null"},
+	}
+)
+
+var errNoClipboard = errors.New("no clipboard tool found (install wl-clipboard, xclip or xsel)")
+
+// clipboardCmd returns the first of cmds whose program is installed.
+func clipboardCmd(cmds [][]string) ([]string, error) {
+	for _, args := range cmds {
+		if args[0] == "wl-paste" || args[0] == "wl-copy" {
+			if os.Getenv("WAYLAND_DISPLAY") == "" {
+				continue
+			}
+		}
+		if _, err := exec.LookPath(args[0]); err == nil {
+			return args, nil
+		}
+	}
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		return nil, errors.New("no clipboard tool found")
+	}
+	return nil, errNoClipboard
+}
+
+func readClipboard() (string, error) {
+	c, nerr := openNativeClipboard()
+	if c != nil {
+		text, err := c.read()
+		if err == nil {
+			return strings.TrimRight(text, "\r\n"), nil
+		}
+		nerr = err
+	}
+	args, err := clipboardCmd(pasteCmds)
+	if err != nil {
+		return "", clipboardError(nerr, err)
+	}
+	out, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(out, "\r\n")), nil
+}
+
+func writeClipboard(text string) error {
+	c, nerr := openNativeClipboard()
+	if c != nil {
+		if nerr = c.write(text); nerr == nil {
+			return nil
+		}
+	}
+	args, err := clipboardCmd(copyCmds)
+	if err != nil {
+		return clipboardError(nerr, err)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+func clearClipboard() error {
+	c, nerr := openNativeClipboard()
+	if c != nil {
+		if nerr = c.clear(); nerr == nil {
+			return nil
+		}
+	}
+	args, err := clipboardCmd(clearCmds)
+	if err != nil {
+		return clipboardError(nerr, err)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(nil)
+	return cmd.Run()
+}
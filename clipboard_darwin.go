@@ -0,0 +1,84 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework AppKit
+
+#import <AppKit/AppKit.h>
+#include <stdlib.h>
+#include <string.h>
+
+static char *pasteboardRead(void) {
+	@autoreleasepool {
+		NSString *s = [[NSPasteboard generalPasteboard] stringForType:NSPasteboardTypeString];
+		return s == nil ? NULL : strdup(s.UTF8String);
+	}
+}
+
+// pasteboardWrite replaces the pasteboard with text, marked concealed
+// and transient so that clipboard managers don't keep it.
+static int pasteboardWrite(const char *text) {
+	@autoreleasepool {
+		NSPasteboard *pb = [NSPasteboard generalPasteboard];
+		[pb clearContents];
+		if (![pb setString:[NSString stringWithUTF8String:text] forType:NSPasteboardTypeString]) {
+			return -1;
+		}
+		[pb setString:@"" forType:@"org.nspasteboard.ConcealedType"];
+		[pb setString:@"" forType:@"org.nspasteboard.TransientType"];
+		return 0;
+	}
+}
+
+static void pasteboardClear(void) {
+	@autoreleasepool {
+		[[NSPasteboard generalPasteboard] clearContents];
+	}
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+)
+
+func init() {
+	nativeClipboards = append(nativeClipboards, func() (nativeClipboard, error) {
+		return pasteboard{}, nil
+	})
+}
+
+// pasteboard is the general pasteboard of AppKit. Builds without cgo
+// use pbcopy and pbpaste instead.
+type pasteboard struct{}
+
+func (pasteboard) read() (string, error) {
+	s := C.pasteboardRead()
+	if s == nil {
+		return "", nil
+	}
+	defer C.free(unsafe.Pointer(s))
+	return C.GoString(s), nil
+}
+
+func (pasteboard) write(text string) error {
+	if strings.IndexByte(text, 0) >= 0 {
+		return errors.New("text with a NUL byte")
+	}
+	s := C.CString(text)
+	defer C.free(unsafe.Pointer(s))
+	if C.pasteboardWrite(s) != 0 {
+		return errors.New("writing the pasteboard failed")
+	}
+	return nil
+}
+
+func (pasteboard) clear() error {
+	C.pasteboardClear()
+	return nil
+}
@@ -0,0 +1,58 @@
+//go:build dragonfly || freebsd || linux || netbsd || openbsd
+// +build dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+func init() {
+	// Wayland first: its sessions may run X11 programs too, whose
+	// clipboard is only bridged to Wayland's.
+	nativeClipboards = append(nativeClipboards, openWaylandClipboard, openX11Clipboard)
+	clipboardOwners["wayland"] = ownWaylandClipboard
+	clipboardOwners["x11"] = ownX11Clipboard
+}
+
+// ownInBackground starts a copy of gauth which owns the clipboard of
+// kind with text until something else takes it, as wl-copy and xclip
+// do: Wayland and X11 clipboards empty when their owner exits. It
+// returns once the copy owns the clipboard.
+func ownInBackground(kind, text string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), clipboardOwnerEnv+"="+kind)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = w
+	detach(cmd)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("owning the clipboard: %v", err)
+	}
+	if line = strings.TrimSpace(line); line != "ok" {
+		return errors.New(line)
+	}
+	return nil
+}
@@ -0,0 +1,442 @@
+//go:build dragonfly || freebsd || linux || netbsd || openbsd
+// +build dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// The Wayland clipboard is reached through the data-control protocol,
+// as wl-clipboard does, which lets clients which have no window read
+// and set the selection: ext-data-control-v1, or its predecessor
+// wlr-data-control-unstable-v1, which wlroots compositors such as Sway
+// and KDE's support, and GNOME's doesn't. Both have the same requests
+// and events. Text goes through pipes passed over the socket.
+
+// errNoDataControl is returned when the compositor lacks the protocol.
+var errNoDataControl = errors.New("Wayland: the compositor doesn't support the data-control protocol")
+
+// Requests and events of the data-control objects, and wl_display's.
+const (
+	wlDisplaySync        = 0
+	wlDisplayGetRegistry = 1
+	wlDisplayError       = 0
+	wlRegistryBind       = 0
+	wlRegistryGlobal     = 0
+	wlCallbackDone       = 0
+
+	dcManagerCreateDataSource = 0
+	dcManagerGetDataDevice    = 1
+	dcDeviceSetSelection      = 0
+	dcDeviceDataOffer         = 0
+	dcDeviceSelection         = 1
+	dcSourceOffer             = 0
+	dcSourceSend              = 0
+	dcSourceCancelled         = 1
+	dcOfferReceive            = 0
+	dcOfferOffer              = 0
+)
+
+// textMimes are the types of text offered and looked for, preferred first.
+var textMimes = []string{"text/plain;charset=utf-8", "UTF8_STRING", "text/plain", "TEXT", "STRING"}
+
+type waylandClipboard struct{}
+
+func openWaylandClipboard() (nativeClipboard, error) {
+	if os.Getenv("WAYLAND_DISPLAY") == "" {
+		return nil, errors.New("Wayland: $WAYLAND_DISPLAY isn't set")
+	}
+	// Connecting tells whether the compositor supports the protocol.
+	w, err := dialWayland()
+	if err != nil {
+		return nil, err
+	}
+	w.Close()
+	return waylandClipboard{}, nil
+}
+
+// A wlConn is a connection to the compositor, with a data-control
+// device for the seat.
+type wlConn struct {
+	*net.UnixConn
+	buf     []byte
+	fds     []int // received, not yet taken
+	lastID  uint32
+	manager uint32
+	device  uint32
+}
+
+func dialWayland() (*wlConn, error) {
+	path := os.Getenv("WAYLAND_DISPLAY")
+	if !filepath.IsAbs(path) {
+		dir := os.Getenv("XDG_RUNTIME_DIR")
+		if dir == "" {
+			return nil, errors.New("Wayland: $XDG_RUNTIME_DIR isn't set")
+		}
+		path = filepath.Join(dir, path)
+	}
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("Wayland: %v", err)
+	}
+	w := &wlConn{UnixConn: conn, lastID: 1} // 1 is the display
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := w.setup(); err != nil {
+		conn.Close()
+		if err == errNoDataControl {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Wayland: %v", err)
+	}
+	return w, nil
+}
+
+// setup binds the data-control manager and the seat, and gets the
+// seat's device.
+func (w *wlConn) setup() error {
+	registry := w.newID()
+	if err := w.send(1, wlDisplayGetRegistry, wlArgs{}.uint(registry), nil); err != nil {
+		return err
+	}
+	type global struct {
+		name    uint32
+		iface   string
+		version uint32
+	}
+	var manager, seat global
+	err := w.roundTrip(func(obj uint32, op uint16, args []byte) {
+		if obj != registry || op != wlRegistryGlobal || len(args) < 12 {
+			return
+		}
+		g := global{name: binary.LittleEndian.Uint32(args), iface: wlString(args[4:])}
+		if n := 8 + (len(g.iface)+1+3)&^3; len(args) >= n+4 {
+			g.version = binary.LittleEndian.Uint32(args[n:])
+		}
+		switch g.iface {
+		case "ext_data_control_manager_v1":
+			manager = g
+		case "zwlr_data_control_manager_v1":
+			if manager.iface == "" {
+				manager = g
+			}
+		case "wl_seat":
+			if seat.iface == "" {
+				seat = g
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if manager.iface == "" {
+		return errNoDataControl
+	}
+	if seat.iface == "" {
+		return errors.New("no seat")
+	}
+	bind := func(g global) (uint32, error) {
+		id := w.newID()
+		return id, w.send(registry, wlRegistryBind, wlArgs{}.uint(g.name).string(g.iface).uint(1).uint(id), nil)
+	}
+	if w.manager, err = bind(manager); err != nil {
+		return err
+	}
+	seatID, err := bind(seat)
+	if err != nil {
+		return err
+	}
+	w.device = w.newID()
+	return w.send(w.manager, dcManagerGetDataDevice, wlArgs{}.uint(w.device).uint(seatID), nil)
+}
+
+func (w *wlConn) newID() uint32 {
+	w.lastID++
+	return w.lastID
+}
+
+// wlArgs encodes the arguments of a request.
+type wlArgs []byte
+
+func (a wlArgs) uint(v uint32) wlArgs {
+	return append(a, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (a wlArgs) string(s string) wlArgs {
+	a = a.uint(uint32(len(s) + 1))
+	a = append(append(a, s...), 0)
+	return append(a, make([]byte, -len(a)&3)...)
+}
+
+func wlString(b []byte) string {
+	if len(b) < 4 {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint32(b))
+	if n == 0 || len(b) < 4+n {
+		return ""
+	}
+	return string(b[4 : 4+n-1])
+}
+
+// send sends a request of object obj, passing the file f if not nil.
+func (w *wlConn) send(obj uint32, op uint16, args wlArgs, f *os.File) error {
+	b := make([]byte, 8, 8+len(args))
+	binary.LittleEndian.PutUint32(b, obj)
+	binary.LittleEndian.PutUint32(b[4:], uint32(8+len(args))<<16|uint32(op))
+	var oob []byte
+	if f != nil {
+		oob = syscall.UnixRights(int(f.Fd()))
+	}
+	_, _, err := w.WriteMsgUnix(append(b, args...), oob, nil)
+	return err
+}
+
+// next returns the next event: its object, opcode and arguments.
+func (w *wlConn) next() (uint32, uint16, []byte, error) {
+	for len(w.buf) < 8 || len(w.buf) < int(binary.LittleEndian.Uint32(w.buf[4:])>>16) {
+		b := make([]byte, 4096)
+		oob := make([]byte, syscall.CmsgSpace(4*28))
+		n, oobn, _, _, err := w.ReadMsgUnix(b, oob)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		msgs, _ := syscall.ParseSocketControlMessage(oob[:oobn])
+		for i := range msgs {
+			if fds, err := syscall.ParseUnixRights(&msgs[i]); err == nil {
+				w.fds = append(w.fds, fds...)
+			}
+		}
+		if n == 0 {
+			return 0, 0, nil, io.ErrUnexpectedEOF
+		}
+		w.buf = append(w.buf, b[:n]...)
+	}
+	obj, header := binary.LittleEndian.Uint32(w.buf), binary.LittleEndian.Uint32(w.buf[4:])
+	size := int(header >> 16)
+	if size < 8 {
+		return 0, 0, nil, errors.New("invalid message")
+	}
+	args := append([]byte(nil), w.buf[8:size]...)
+	w.buf = w.buf[size:]
+	if obj == 1 && uint16(header) == wlDisplayError && len(args) >= 8 {
+		return 0, 0, nil, fmt.Errorf("protocol error %d: %s", binary.LittleEndian.Uint32(args[4:]), wlString(args[8:]))
+	}
+	return obj, uint16(header), args, nil
+}
+
+// file takes the next file passed by the compositor.
+func (w *wlConn) file() (*os.File, error) {
+	if len(w.fds) == 0 {
+		return nil, errors.New("no file passed")
+	}
+	fd := w.fds[0]
+	w.fds = w.fds[1:]
+	return os.NewFile(uintptr(fd), "wayland"), nil
+}
+
+// roundTrip waits for the compositor to handle the requests sent,
+// passing the events which come meanwhile to handle.
+func (w *wlConn) roundTrip(handle func(obj uint32, op uint16, args []byte)) error {
+	callback := w.newID()
+	if err := w.send(1, wlDisplaySync, wlArgs{}.uint(callback), nil); err != nil {
+		return err
+	}
+	for {
+		obj, op, args, err := w.next()
+		if err != nil {
+			return err
+		}
+		if obj == callback && op == wlCallbackDone {
+			return nil
+		}
+		if handle != nil {
+			handle(obj, op, args)
+		}
+	}
+}
+
+func (waylandClipboard) read() (string, error) {
+	w, err := dialWayland()
+	if err != nil {
+		return "", err
+	}
+	defer w.Close()
+	// The device tells the current selection once created.
+	offers := make(map[uint32][]string)
+	var selection uint32
+	err = w.roundTrip(func(obj uint32, op uint16, args []byte) {
+		if len(args) < 4 {
+			return
+		}
+		switch {
+		case obj == w.device && op == dcDeviceDataOffer:
+			offers[binary.LittleEndian.Uint32(args)] = nil
+		case obj == w.device && op == dcDeviceSelection:
+			selection = binary.LittleEndian.Uint32(args)
+		case op == dcOfferOffer:
+			if mimes, ok := offers[obj]; ok {
+				offers[obj] = append(mimes, wlString(args))
+			}
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("Wayland: %v", err)
+	}
+	if selection == 0 {
+		return "", nil
+	}
+	mime := ""
+	for _, m := range textMimes {
+		for _, offered := range offers[selection] {
+			if mime == "" && m == offered {
+				mime = m
+			}
+		}
+	}
+	if mime == "" {
+		return "", errors.New("Wayland: the clipboard doesn't hold text")
+	}
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	err = w.send(selection, dcOfferReceive, wlArgs{}.string(mime), pw)
+	pw.Close()
+	if err != nil {
+		return "", fmt.Errorf("Wayland: %v", err)
+	}
+	// The owner writes the text, and closes the pipe.
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := ioutil.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("Wayland: reading the clipboard: %v", err)
+	}
+	return string(data), nil
+}
+
+func (waylandClipboard) write(text string) error {
+	return ownInBackground("wayland", text)
+}
+
+func (waylandClipboard) clear() error {
+	w, err := dialWayland()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	err = w.send(w.device, dcDeviceSetSelection, wlArgs{}.uint(0), nil)
+	if err == nil {
+		err = w.roundTrip(nil)
+	}
+	if err != nil {
+		return fmt.Errorf("Wayland: %v", err)
+	}
+	return nil
+}
+
+func (waylandClipboard) copyOnce(ctx context.Context, text string) (bool, error) {
+	w, err := dialWayland()
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		w.Close()
+	}()
+	pasted, err := w.own(text, true, func() {})
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	return pasted, err
+}
+
+// ownWaylandClipboard owns the clipboard for ownInBackground.
+func ownWaylandClipboard(text string, ready func(error)) error {
+	w, err := dialWayland()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = w.own(text, false, func() { ready(nil) })
+	return err
+}
+
+// own sets the selection to text, calls ready, and gives text to
+// whoever asks for it, until something else replaces the selection,
+// or until the first time if once is set. It reports whether the text
+// was given.
+func (w *wlConn) own(text string, once bool, ready func()) (bool, error) {
+	source := w.newID()
+	if err := w.send(w.manager, dcManagerCreateDataSource, wlArgs{}.uint(source), nil); err != nil {
+		return false, fmt.Errorf("Wayland: %v", err)
+	}
+	for _, m := range textMimes {
+		if err := w.send(source, dcSourceOffer, wlArgs{}.string(m), nil); err != nil {
+			return false, fmt.Errorf("Wayland: %v", err)
+		}
+	}
+	if err := w.send(w.device, dcDeviceSetSelection, wlArgs{}.uint(source), nil); err != nil {
+		return false, fmt.Errorf("Wayland: %v", err)
+	}
+	var events [][]byte
+	err := w.roundTrip(func(obj uint32, op uint16, args []byte) {
+		if obj == source {
+			events = append(events, append([]byte{byte(op)}, args...))
+		}
+	})
+	if err != nil {
+		return false, fmt.Errorf("Wayland: %v", err)
+	}
+	ready()
+	w.SetDeadline(time.Time{})
+	pasted := false
+	for {
+		var op uint16
+		if len(events) > 0 {
+			op = uint16(events[0][0])
+			events = events[1:]
+		} else {
+			obj, o, _, err := w.next()
+			if err != nil {
+				return pasted, fmt.Errorf("Wayland: %v", err)
+			}
+			if obj != source {
+				continue
+			}
+			op = o
+		}
+		switch op {
+		case dcSourceSend:
+			f, err := w.file()
+			if err != nil {
+				return pasted, fmt.Errorf("Wayland: %v", err)
+			}
+			// The mime type doesn't matter: the text is the same.
+			f.WriteString(text)
+			f.Close()
+			pasted = true
+			if once {
+				return true, nil
+			}
+		case dcSourceCancelled:
+			return pasted, nil
+		}
+	}
+}
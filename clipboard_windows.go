@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+)
+
+const (
+	cfUnicodeText = 13
+	gmemMoveable  = 0x2
+)
+
+var (
+	user32 = syscall.NewLazyDLL("user32.dll")
+
+	procOpenClipboard            = user32.NewProc("OpenClipboard")
+	procCloseClipboard           = user32.NewProc("CloseClipboard")
+	procEmptyClipboard           = user32.NewProc("EmptyClipboard")
+	procGetClipboardData         = user32.NewProc("GetClipboardData")
+	procSetClipboardData         = user32.NewProc("SetClipboardData")
+	procRegisterClipboardFormatW = user32.NewProc("RegisterClipboardFormatW")
+	procGlobalAlloc              = kernel32.NewProc("GlobalAlloc")
+	procGlobalFree               = kernel32.NewProc("GlobalFree")
+	procGlobalLock               = kernel32.NewProc("GlobalLock")
+	procGlobalUnlock             = kernel32.NewProc("GlobalUnlock")
+	procGlobalSize               = kernel32.NewProc("GlobalSize")
+	procRtlMoveMemory            = kernel32.NewProc("RtlMoveMemory")
+)
+
+func init() {
+	nativeClipboards = append(nativeClipboards, func() (nativeClipboard, error) {
+		return windowsClipboard{}, nil
+	})
+}
+
+// windowsClipboard is the clipboard of the Win32 API, which holds its
+// text after gauth exits.
+type windowsClipboard struct{}
+
+// openClipboard opens the clipboard on the calling thread, which has
+// to be locked. Other programs may hold it for a moment.
+func openClipboard() error {
+	var err error
+	for i := 0; i < 10; i++ {
+		r, _, e := procOpenClipboard.Call(0)
+		if r != 0 {
+			return nil
+		}
+		err = e
+		time.Sleep(20 * time.Millisecond)
+	}
+	return fmt.Errorf("opening the clipboard: %v", err)
+}
+
+func (windowsClipboard) read() (string, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := openClipboard(); err != nil {
+		return "", err
+	}
+	defer procCloseClipboard.Call()
+	h, _, _ := procGetClipboardData.Call(cfUnicodeText)
+	if h == 0 {
+		return "", nil // no text
+	}
+	p, _, err := procGlobalLock.Call(h)
+	if p == 0 {
+		return "", err
+	}
+	defer procGlobalUnlock.Call(h)
+	size, _, _ := procGlobalSize.Call(h)
+	u := make([]uint16, size/2+1)
+	procRtlMoveMemory.Call(uintptr(unsafe.Pointer(&u[0])), p, size&^1)
+	for i, c := range u {
+		if c == 0 {
+			u = u[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+// setClipboardData puts a copy of data in the open clipboard.
+func setClipboardData(format uintptr, data []byte) error {
+	h, _, err := procGlobalAlloc.Call(gmemMoveable, uintptr(len(data)))
+	if h == 0 {
+		return err
+	}
+	p, _, err := procGlobalLock.Call(h)
+	if p == 0 {
+		procGlobalFree.Call(h)
+		return err
+	}
+	procRtlMoveMemory.Call(p, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)))
+	procGlobalUnlock.Call(h)
+	// The clipboard owns the memory once set.
+	if r, _, err := procSetClipboardData.Call(format, h); r == 0 {
+		procGlobalFree.Call(h)
+		return err
+	}
+	return nil
+}
+
+func (windowsClipboard) write(text string) error {
+	u, err := syscall.UTF16FromString(text)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 2*len(u))
+	for i, c := range u {
+		data[2*i], data[2*i+1] = byte(c), byte(c>>8)
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := openClipboard(); err != nil {
+		return err
+	}
+	defer procCloseClipboard.Call()
+	if r, _, err := procEmptyClipboard.Call(); r == 0 {
+		return err
+	}
+	if err := setClipboardData(cfUnicodeText, data); err != nil {
+		return fmt.Errorf("writing the clipboard: %v", err)
+	}
+	// Keep codes out of the clipboard history and the cloud clipboard.
+	name, _ := syscall.UTF16PtrFromString("ExcludeClipboardContentFromMonitorProcessing")
+	if f, _, _ := procRegisterClipboardFormatW.Call(uintptr(unsafe.Pointer(name))); f != 0 {
+		setClipboardData(f, []byte{0})
+	}
+	return nil
+}
+
+func (windowsClipboard) clear() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := openClipboard(); err != nil {
+		return err
+	}
+	defer procCloseClipboard.Call()
+	if r, _, err := procEmptyClipboard.Call(); r == 0 {
+		return errors.New("clearing the clipboard: " + err.Error())
+	}
+	return nil
+}
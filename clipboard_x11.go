@@ -0,0 +1,495 @@
+//go:build dragonfly || freebsd || linux || netbsd || openbsd
+// +build dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The X11 clipboard is the CLIPBOARD selection, reached over the X
+// protocol on the display's unix socket. Only the few requests it
+// needs are implemented. Text is asked of the selection's owner by
+// converting the selection to a property of a window of gauth; owning
+// it means answering the conversions asked by others.
+
+// X11 request opcodes.
+const (
+	x11CreateWindow      = 1
+	x11ChangeProperty    = 18
+	x11GetProperty       = 20
+	x11InternAtom        = 16
+	x11SetSelectionOwner = 22
+	x11GetSelectionOwner = 23
+	x11ConvertSelection  = 24
+	x11SendEvent         = 25
+)
+
+// X11 event codes.
+const (
+	x11SelectionClear   = 29
+	x11SelectionRequest = 30
+	x11SelectionNotify  = 31
+)
+
+// Predefined atoms.
+const (
+	x11AtomAtom   = 4
+	x11AtomString = 31
+)
+
+// x11MaxText bounds the text gauth sends and receives in a single
+// property, which is what clipboard owners do for short text.
+const x11MaxText = 1 << 16
+
+type x11Clipboard struct{ display string }
+
+func openX11Clipboard() (nativeClipboard, error) {
+	d := os.Getenv("DISPLAY")
+	if d == "" {
+		return nil, errors.New("X11: $DISPLAY isn't set")
+	}
+	return x11Clipboard{d}, nil
+}
+
+// An x11Conn is a connection to an X server, with a window.
+type x11Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	root   uint32
+	window uint32
+	seq    uint16 // of the last request
+	events [][]byte
+	atoms  map[string]uint32
+}
+
+func dialX11(display string) (*x11Conn, error) {
+	i := strings.LastIndexByte(display, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("X11: invalid $DISPLAY %q", display)
+	}
+	host, num := display[:i], display[i+1:]
+	if j := strings.IndexByte(num, '.'); j >= 0 {
+		num = num[:j]
+	}
+	if _, err := strconv.Atoi(num); err != nil {
+		return nil, fmt.Errorf("X11: invalid $DISPLAY %q", display)
+	}
+	if host != "" && host != "unix" {
+		return nil, fmt.Errorf("X11: display %s isn't local", display)
+	}
+	path := "/tmp/.X11-unix/X" + num
+	var conn net.Conn
+	var err error
+	if runtime.GOOS == "linux" {
+		// Servers listen in the abstract namespace too.
+		conn, err = net.Dial("unix", "@"+path)
+	}
+	if conn == nil {
+		conn, err = net.Dial("unix", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("X11: %v", err)
+	}
+	x := &x11Conn{Conn: conn, r: bufio.NewReader(conn), atoms: make(map[string]uint32)}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := x.setup(num); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("X11: display %s: %v", display, err)
+	}
+	return x, nil
+}
+
+// setup sets the connection up, and creates the window.
+func (x *x11Conn) setup(num string) error {
+	name, data := x11Cookie(num)
+	req := []byte{'l', 0, 11, 0, 0, 0, byte(len(name)), byte(len(name) >> 8), byte(len(data)), byte(len(data) >> 8), 0, 0}
+	req = append(req, x11Pad(name)...)
+	req = append(req, x11Pad(data)...)
+	if _, err := x.Write(req); err != nil {
+		return err
+	}
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(x.r, head); err != nil {
+		return err
+	}
+	body := make([]byte, 4*int(binary.LittleEndian.Uint16(head[6:])))
+	if _, err := io.ReadFull(x.r, body); err != nil {
+		return err
+	}
+	switch head[0] {
+	case 0:
+		if n := int(head[1]); n <= len(body) {
+			body = body[:n]
+		}
+		return fmt.Errorf("connection refused: %s", strings.TrimSpace(string(body)))
+	case 2:
+		return errors.New("connection refused: more authentication is required")
+	}
+	if len(body) < 32 {
+		return errors.New("invalid setup")
+	}
+	base, mask := binary.LittleEndian.Uint32(body[4:]), binary.LittleEndian.Uint32(body[8:])
+	vendor, formats := int(binary.LittleEndian.Uint16(body[16:])), int(body[21])
+	screen := 32 + (vendor+3)&^3 + 8*formats
+	if len(body) < screen+4 {
+		return errors.New("invalid setup")
+	}
+	x.root = binary.LittleEndian.Uint32(body[screen:])
+	x.window = base | mask&-mask
+	// An InputOnly window of 1x1 at 0,0, never mapped.
+	return x.send(x11CreateWindow, 0, x11Words(x.window, x.root, 0, 1<<16|1, 2<<16, 0, 0))
+}
+
+// x11Cookie returns the MIT-MAGIC-COOKIE-1 of display num from the
+// Xauthority file, if there's one.
+func x11Cookie(num string) (name, data []byte) {
+	file := os.Getenv("XAUTHORITY")
+	if file == "" {
+		file = filepath.Join(os.Getenv("HOME"), ".Xauthority")
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil
+	}
+	host, _ := os.Hostname()
+	for len(b) >= 2 {
+		family := binary.BigEndian.Uint16(b)
+		b = b[2:]
+		// address, display number, name, data
+		var f [4][]byte
+		for i := range f {
+			if len(b) < 2 {
+				return nil, nil
+			}
+			n := 2 + int(binary.BigEndian.Uint16(b))
+			if len(b) < n {
+				return nil, nil
+			}
+			f[i], b = b[2:n], b[n:]
+		}
+		const local, wild = 256, 65535
+		if (family == local && string(f[0]) == host || family == wild) &&
+			(string(f[1]) == num || len(f[1]) == 0) && string(f[2]) == "MIT-MAGIC-COOKIE-1" {
+			return f[2], f[3]
+		}
+	}
+	return nil, nil
+}
+
+func x11Pad(b []byte) []byte {
+	return append(b, make([]byte, -len(b)&3)...)
+}
+
+func x11Words(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, w := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], w)
+	}
+	return b
+}
+
+func (x *x11Conn) send(op, data byte, body []byte) error {
+	b := append([]byte{op, data, 0, 0}, x11Pad(body)...)
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(b)/4))
+	x.seq++
+	_, err := x.Write(b)
+	return err
+}
+
+// next reads the next reply, event or error.
+func (x *x11Conn) next() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(x.r, b); err != nil {
+		return nil, err
+	}
+	if b[0] == 1 {
+		n := binary.LittleEndian.Uint32(b[4:])
+		if n > x11MaxText {
+			return nil, errors.New("reply too large")
+		}
+		more := make([]byte, 4*n)
+		if _, err := io.ReadFull(x.r, more); err != nil {
+			return nil, err
+		}
+		b = append(b, more...)
+	}
+	return b, nil
+}
+
+// roundTrip sends a request and returns its reply, keeping the events
+// which come first.
+func (x *x11Conn) roundTrip(op, data byte, body []byte) ([]byte, error) {
+	if err := x.send(op, data, body); err != nil {
+		return nil, err
+	}
+	for {
+		b, err := x.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case b[0] > 1:
+			x.events = append(x.events, b)
+		case binary.LittleEndian.Uint16(b[2:]) != x.seq:
+			// an error of an earlier request
+		case b[0] == 0:
+			return nil, fmt.Errorf("error %d of request %d", b[1], b[10])
+		default:
+			return b, nil
+		}
+	}
+}
+
+// event returns the next event. Errors of requests without replies,
+// such as writing to the window of a client which left, are ignored.
+func (x *x11Conn) event() ([]byte, error) {
+	if len(x.events) > 0 {
+		b := x.events[0]
+		x.events = x.events[1:]
+		return b, nil
+	}
+	for {
+		b, err := x.next()
+		if err != nil || b[0] > 1 {
+			return b, err
+		}
+	}
+}
+
+func (x *x11Conn) atom(name string) (uint32, error) {
+	if a, ok := x.atoms[name]; ok {
+		return a, nil
+	}
+	body := make([]byte, 4, 4+len(name))
+	binary.LittleEndian.PutUint16(body, uint16(len(name)))
+	b, err := x.roundTrip(x11InternAtom, 0, append(body, name...))
+	if err != nil {
+		return 0, err
+	}
+	a := binary.LittleEndian.Uint32(b[8:])
+	x.atoms[name] = a
+	return a, nil
+}
+
+// internAtoms interns names, in order.
+func (x *x11Conn) internAtoms(names ...string) ([]uint32, error) {
+	atoms := make([]uint32, len(names))
+	for i, name := range names {
+		a, err := x.atom(name)
+		if err != nil {
+			return nil, err
+		}
+		atoms[i] = a
+	}
+	return atoms, nil
+}
+
+func (x *x11Conn) owner(selection uint32) (uint32, error) {
+	b, err := x.roundTrip(x11GetSelectionOwner, 0, x11Words(selection))
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b[8:]), nil
+}
+
+func (c x11Clipboard) read() (string, error) {
+	x, err := dialX11(c.display)
+	if err != nil {
+		return "", err
+	}
+	defer x.Close()
+	a, err := x.internAtoms("CLIPBOARD", "UTF8_STRING", "INCR", "GAUTH_SELECTION")
+	if err != nil {
+		return "", fmt.Errorf("X11: %v", err)
+	}
+	clipboard, utf8, incr, prop := a[0], a[1], a[2], a[3]
+	if owner, err := x.owner(clipboard); err != nil {
+		return "", fmt.Errorf("X11: %v", err)
+	} else if owner == 0 {
+		return "", nil
+	}
+	for _, target := range []uint32{utf8, x11AtomString} {
+		if err := x.send(x11ConvertSelection, 0, x11Words(x.window, clipboard, target, prop, 0)); err != nil {
+			return "", fmt.Errorf("X11: %v", err)
+		}
+		var ev []byte
+		for ev == nil || ev[0]&0x7f != x11SelectionNotify {
+			if ev, err = x.event(); err != nil {
+				return "", fmt.Errorf("X11: %v", err)
+			}
+		}
+		if binary.LittleEndian.Uint32(ev[20:]) == 0 {
+			continue // refused
+		}
+		b, err := x.roundTrip(x11GetProperty, 1, x11Words(x.window, prop, 0, 0, x11MaxText/4))
+		if err != nil {
+			return "", fmt.Errorf("X11: %v", err)
+		}
+		if binary.LittleEndian.Uint32(b[8:]) == incr {
+			return "", errors.New("X11: the clipboard holds too much text")
+		}
+		n := int(binary.LittleEndian.Uint32(b[16:])) * int(b[1]) / 8
+		if 32+n > len(b) {
+			return "", errors.New("X11: invalid property")
+		}
+		return string(b[32 : 32+n]), nil
+	}
+	return "", errors.New("X11: the clipboard doesn't hold text")
+}
+
+func (c x11Clipboard) write(text string) error {
+	return ownInBackground("x11", text)
+}
+
+func (c x11Clipboard) clear() error {
+	x, err := dialX11(c.display)
+	if err != nil {
+		return err
+	}
+	defer x.Close()
+	clipboard, err := x.atom("CLIPBOARD")
+	if err == nil {
+		err = x.send(x11SetSelectionOwner, 0, x11Words(0, clipboard, 0))
+	}
+	if err == nil {
+		// Wait for it to be done.
+		_, err = x.owner(clipboard)
+	}
+	if err != nil {
+		return fmt.Errorf("X11: %v", err)
+	}
+	return nil
+}
+
+func (c x11Clipboard) copyOnce(ctx context.Context, text string) (bool, error) {
+	x, err := dialX11(c.display)
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		x.Close()
+	}()
+	pasted, err := x.own(text, true, func() {})
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	return pasted, err
+}
+
+// ownX11Clipboard owns the clipboard for ownInBackground.
+func ownX11Clipboard(text string, ready func(error)) error {
+	x, err := dialX11(os.Getenv("DISPLAY"))
+	if err != nil {
+		return err
+	}
+	defer x.Close()
+	_, err = x.own(text, false, func() { ready(nil) })
+	return err
+}
+
+// own takes the clipboard, calls ready, and gives text to whoever asks
+// for it, until something else takes the clipboard, or until the first
+// time if once is set. It reports whether the text was given.
+func (x *x11Conn) own(text string, once bool, ready func()) (bool, error) {
+	if len(text) > x11MaxText {
+		return false, errors.New("X11: too much text for the clipboard")
+	}
+	a, err := x.internAtoms("CLIPBOARD", "TARGETS", "UTF8_STRING", "TEXT", "text/plain;charset=utf-8", "text/plain")
+	if err != nil {
+		return false, fmt.Errorf("X11: %v", err)
+	}
+	clipboard, targets, utf8, textAtom := a[0], a[1], a[2], a[3]
+	texts := append([]uint32{x11AtomString}, a[2:]...)
+	if err := x.send(x11SetSelectionOwner, 0, x11Words(x.window, clipboard, 0)); err != nil {
+		return false, fmt.Errorf("X11: %v", err)
+	}
+	if owner, err := x.owner(clipboard); err != nil || owner != x.window {
+		return false, errors.New("X11: couldn't take the clipboard")
+	}
+	ready()
+	x.SetDeadline(time.Time{})
+	pasted := false
+	for {
+		ev, err := x.event()
+		if err != nil {
+			return pasted, fmt.Errorf("X11: %v", err)
+		}
+		switch ev[0] & 0x7f {
+		case x11SelectionClear:
+			if binary.LittleEndian.Uint32(ev[12:]) == clipboard {
+				return pasted, nil
+			}
+		case x11SelectionRequest:
+			when, requestor := binary.LittleEndian.Uint32(ev[4:]), binary.LittleEndian.Uint32(ev[12:])
+			selection, target, prop := binary.LittleEndian.Uint32(ev[16:]), binary.LittleEndian.Uint32(ev[20:]), binary.LittleEndian.Uint32(ev[24:])
+			if prop == 0 {
+				prop = target // an obsolete client
+			}
+			given := false
+			switch {
+			case selection != clipboard:
+				prop = 0
+			case target == targets:
+				err = x.changeProperty(requestor, prop, x11AtomAtom, 32, x11Words(append([]uint32{targets}, texts...)...))
+			case x11Has(texts, target):
+				typ := target
+				if typ == textAtom {
+					typ = utf8
+				}
+				err = x.changeProperty(requestor, prop, typ, 8, []byte(text))
+				given = true
+			default:
+				prop = 0 // refused
+			}
+			if err != nil {
+				return pasted, fmt.Errorf("X11: %v", err)
+			}
+			notify := make([]byte, 32)
+			notify[0] = x11SelectionNotify
+			copy(notify[4:], x11Words(when, requestor, selection, target, prop))
+			if err := x.send(x11SendEvent, 0, append(x11Words(requestor, 0), notify...)); err != nil {
+				return pasted, fmt.Errorf("X11: %v", err)
+			}
+			pasted = pasted || given
+			if once && given {
+				// Let the server handle it before leaving.
+				_, err := x.owner(clipboard)
+				return true, err
+			}
+		}
+	}
+}
+
+func (x *x11Conn) changeProperty(window, prop, typ uint32, format int, data []byte) error {
+	body := x11Words(window, prop, typ, uint32(format), uint32(len(data)*8/format))
+	return x.send(x11ChangeProperty, 0, append(body, data...))
+}
+
+func x11Has(atoms []uint32, a uint32) bool {
+	for _, b := range atoms {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
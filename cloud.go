@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var cmdCloud = &command{
+	name:  "cloud",
+	usage: "cloud push | pull",
+	short: "back the keychain up to cloud storage",
+	long: `Cloud keeps a copy of the keychain in object storage, as phone
+authenticator apps do, without trusting the provider with it: the copy
+is always encrypted here before it's uploaded. The storage is named by
+a "cloud" line in the configuration:
+
+	cloud = s3://bucket/gauth/keychain
+	cloud = gs://bucket/gauth/keychain
+	cloud = https://dav.example.com/remote.php/dav/files/me/gauth
+
+S3 credentials are taken from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY
+and $AWS_SESSION_TOKEN, the region from $AWS_REGION, and another
+S3-compatible service from $AWS_ENDPOINT_URL. Google Cloud Storage uses
+$GOOGLE_OAUTH_ACCESS_TOKEN, or asks gcloud for a token. An https URL
+is a WebDAV server; its user is given in the URL, and its password in
+$GAUTH_WEBDAV_PASSWORD.
+
+Cloud push uploads the keychain. An encrypted keychain (see "gauth help
+encrypt") is uploaded as it is, so pick a protector which works on
+every machine; any other is encrypted with a passphrase, as "gauth
+export -encrypt" does, which pull asks for.
+
+Cloud pull downloads the copy and replaces the keychain with it, backing
+it up first. Each side checks the copy's version (its ETag) against the
+one it last saw, so a push never overwrites changes pushed from another
+machine: it fails, and the changes have to be pulled first. If the
+keychain changed both here and in the cloud, pull leaves it alone and
+saves the cloud's copy next to it, for "gauth merge".`,
+}
+
+func init() {
+	cmdCloud.run = runCloud
+	cmdCloud.network = true
+	cmdCloud.programs = always
+}
+
+// A cloudStore holds the copy of the keychain in object storage.
+// Versions are opaque, such as ETags.
+type cloudStore interface {
+	String() string
+	// get returns the copy and its version, or errCloudMissing.
+	get(ctx context.Context) ([]byte, string, error)
+	// put replaces the copy if its version is still version, or
+	// stores it if version is "" and there's none, and returns the
+	// new version. It returns errCloudChanged otherwise.
+	put(ctx context.Context, data []byte, version string) (string, error)
+}
+
+var (
+	errCloudMissing = errors.New("no keychain there yet")
+	errCloudChanged = errors.New("changed since the last sync")
+)
+
+// cloudStores maps URL schemes to the stores they open.
+// Builds with the offline tag have none.
+var cloudStores = map[string]func(u *url.URL) (cloudStore, error){}
+
+func openCloudStore() cloudStore {
+	raw := conf.get("cloud")
+	if raw == "" {
+		log.Fatalf("no cloud storage: set \"cloud = URL\" in %s", configPath())
+	}
+	if offline() {
+		log.Fatalf("cloud storage uses the network, which offline mode forbids")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		log.Fatalf("%s: invalid cloud URL: %v", configPath(), err)
+	}
+	open, ok := cloudStores[u.Scheme]
+	if !ok {
+		log.Fatalf("%s: unknown cloud storage %q, want s3://, gs:// or https://", configPath(), u.Scheme+"://")
+	}
+	s, err := open(u)
+	if err != nil {
+		log.Fatalf("%s: %v", configPath(), err)
+	}
+	return s
+}
+
+// cloudStatePath returns the file recording the last sync: the version
+// of the cloud's copy and the SHA-256 of the keychain it matched.
+func cloudStatePath() string {
+	return keychainPath() + ".cloud"
+}
+
+// cloudConflictPath returns where pull saves the cloud's copy when
+// both it and the keychain changed.
+func cloudConflictPath() string {
+	return keychainPath() + ".cloud-conflict"
+}
+
+type cloudState struct {
+	version string
+	sum     string
+}
+
+func readCloudState() cloudState {
+	data, err := ioutil.ReadFile(cloudStatePath())
+	if err != nil {
+		return cloudState{}
+	}
+	f := strings.Fields(string(data))
+	if len(f) != 2 {
+		return cloudState{}
+	}
+	return cloudState{version: f[0], sum: f[1]}
+}
+
+func writeCloudState(s cloudState) {
+	if err := ioutil.WriteFile(cloudStatePath(), []byte(s.version+"\n"+s.sum+"\n"), 0600); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func runCloud(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	if memoryKeychain != nil {
+		log.Fatalf("the keychain read from %s can't be synced", memoryKeychain.file)
+	}
+	switch args[0] {
+	case "push":
+		cloudPush(ctx, openCloudStore())
+	case "pull":
+		cloudPull(ctx, openCloudStore())
+	default:
+		cmd.usageExit()
+	}
+}
+
+// cloudCheckConflict exits if a conflicting copy awaits merging.
+func cloudCheckConflict() {
+	if _, err := os.Stat(cloudConflictPath()); err == nil {
+		log.Fatalf("the cloud's copy of the keychain in %s conflicts with it: run \"gauth merge %[1]s\", remove it, then push", cloudConflictPath())
+	}
+}
+
+func cloudPush(ctx context.Context, s cloudStore) {
+	cloudCheckConflict()
+	data, err := ioutil.ReadFile(keychainPath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	lockMemory(data)
+	defer wipe(data)
+	st := readCloudState()
+	sum := checksum(data)
+	if st.version != "" && st.sum == sum {
+		fmt.Fprintln(os.Stderr, "the cloud's copy is up to date")
+		return
+	}
+	blob := data
+	if !isEncryptedKeychain(data) {
+		passphrase, err := readNewPassword("cloud passphrase: ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if passphrase == "" {
+			log.Fatal("empty passphrase")
+		}
+		if blob, err = seal(data, passphrase); err != nil {
+			log.Fatal(err)
+		}
+	}
+	version, err := s.put(ctx, blob, st.version)
+	if err == errCloudChanged {
+		log.Fatalf("%s %v: run \"gauth cloud pull\" first", s, err)
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", s, err)
+	}
+	writeCloudState(cloudState{version: version, sum: sum})
+	fmt.Fprintf(os.Stderr, "pushed the keychain to %s\n", s)
+}
+
+func cloudPull(ctx context.Context, s cloudStore) {
+	cloudCheckConflict()
+	blob, version, err := s.get(ctx)
+	if err == errCloudMissing {
+		log.Fatalf("%s: %v: run \"gauth cloud push\" first", s, err)
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", s, err)
+	}
+	lockMemory(blob)
+	defer wipe(blob)
+	st := readCloudState()
+	if version == st.version {
+		fmt.Fprintln(os.Stderr, "the keychain is up to date")
+		return
+	}
+	if !isEncryptedKeychain(blob) && !isSealed(blob) {
+		// gauth never uploads a keychain in the clear.
+		log.Fatalf("%s isn't encrypted: it wasn't pushed by gauth", s)
+	}
+	cur, err := ioutil.ReadFile(keychainPath())
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	if err == nil && checksum(cur) != st.sum && !bytes.Equal(cur, blob) {
+		if err := ioutil.WriteFile(cloudConflictPath(), blob, 0600); err != nil {
+			log.Fatal(err)
+		}
+		// Pushing the merged keychain may replace this version.
+		writeCloudState(cloudState{version: version, sum: st.sum})
+		log.Fatalf("the keychain changed both here and in the cloud since the last sync: the cloud's copy is in %s; run \"gauth merge %[1]s\", remove it, then push", cloudConflictPath())
+	}
+	data := blob
+	if isSealed(blob) {
+		passphrase, err := readPassword("cloud passphrase: ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if data, err = unseal(blob, passphrase); err != nil {
+			log.Fatal(err)
+		}
+		defer wipe(data)
+	}
+	userKeychain().checkWritable()
+	// Reading it checks it decrypts and parses.
+	c := decryptAndParse(keychainPath(), append([]byte(nil), data...))
+	if err := backup(keychainPath(), nil); err != nil {
+		log.Fatal(backupError(err))
+	}
+	if err := writeKeychainFile(keychainPath(), data); err != nil {
+		log.Fatal(err)
+	}
+	c.updateIntegrity()
+	writeCloudState(cloudState{version: version, sum: checksum(data)})
+	fmt.Fprintf(os.Stderr, "updated the keychain, %d keys\n", len(c.keys))
+}
@@ -0,0 +1,293 @@
+//go:build !offline
+// +build !offline
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+func init() {
+	cloudStores["s3"] = openS3Store
+	cloudStores["gs"] = openGCSStore
+	cloudStores["https"] = openWebDAVStore
+	cloudStores["http"] = openWebDAVStore
+}
+
+var cloudClient = &http.Client{Timeout: 30 * time.Second}
+
+// maxCloudSize bounds the copies downloaded.
+const maxCloudSize = 16 << 20
+
+// objectStore is a cloudStore reached over HTTP. The services differ in
+// how requests are authenticated and how versions are checked.
+type objectStore struct {
+	name string
+	url  string
+	// auth authenticates req, whose body is body.
+	auth func(req *http.Request, body []byte) error
+	// condition makes a put depend on the version, "" meaning
+	// there must be no copy yet.
+	condition func(h http.Header, version string)
+	// version returns the version of a response's object.
+	version func(h http.Header) string
+}
+
+func (s *objectStore) String() string { return s.name }
+
+func (s *objectStore) do(ctx context.Context, method string, body []byte, condition string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if method == "PUT" {
+		req.Header.Set("Content-Type", "application/octet-stream")
+		s.condition(req.Header, condition)
+	}
+	if err := s.auth(req, body); err != nil {
+		return nil, err
+	}
+	return cloudClient.Do(req)
+}
+
+// cloudStatus returns the error of an unexpected response.
+func cloudStatus(resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if m := strings.TrimSpace(string(msg)); m != "" {
+		return fmt.Errorf("%s: %s", resp.Status, m)
+	}
+	return errors.New(resp.Status)
+}
+
+func (s *objectStore) get(ctx context.Context) ([]byte, string, error) {
+	resp, err := s.do(ctx, "GET", nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", errCloudMissing
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", cloudStatus(resp)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCloudSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxCloudSize {
+		return nil, "", errors.New("copy too large")
+	}
+	return data, s.version(resp.Header), nil
+}
+
+func (s *objectStore) put(ctx context.Context, data []byte, version string) (string, error) {
+	resp, err := s.do(ctx, "PUT", data, version)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed, resp.StatusCode == http.StatusConflict:
+		return "", errCloudChanged
+	case resp.StatusCode/100 != 2:
+		return "", cloudStatus(resp)
+	}
+	if v := s.version(resp.Header); v != "" {
+		return v, nil
+	}
+	// Some WebDAV servers don't return the ETag of what was put.
+	resp, err = s.do(ctx, "HEAD", nil, "")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if v := s.version(resp.Header); v != "" {
+		return v, nil
+	}
+	return "", errors.New("the server returned no ETag")
+}
+
+func etag(h http.Header) string { return h.Get("ETag") }
+
+// ifMatch makes a put conditional with the standard headers, which
+// S3 and WebDAV servers understand.
+func ifMatch(h http.Header, version string) {
+	if version == "" {
+		h.Set("If-None-Match", "*")
+	} else {
+		h.Set("If-Match", version)
+	}
+}
+
+// openS3Store opens s3://bucket/key, on AWS or the S3-compatible
+// service at $AWS_ENDPOINT_URL.
+func openS3Store(u *url.URL) (cloudStore, error) {
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, errors.New("want s3://bucket/key")
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := "https://" + u.Host + ".s3." + region + ".amazonaws.com/" + awsEscape(key)
+	for _, env := range []string{"AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"} {
+		if e := os.Getenv(env); e != "" {
+			// Path-style, which S3-compatible services support.
+			endpoint = strings.TrimSuffix(e, "/") + "/" + awsEscape(u.Host) + "/" + awsEscape(key)
+			break
+		}
+	}
+	return &objectStore{
+		name:      "s3://" + u.Host + "/" + key,
+		url:       endpoint,
+		auth:      func(req *http.Request, body []byte) error { return signS3(req, body, region, time.Now()) },
+		condition: ifMatch,
+		version:   etag,
+	}, nil
+}
+
+// awsEscape escapes a path as AWS signatures expect.
+func awsEscape(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signS3 signs req with AWS Signature Version 4.
+func signS3(req *http.Request, body []byte, region string, now time.Time) error {
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return errors.New("no AWS credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("X-Amz-Security-Token") != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n\n", req.Method, req.URL.EscapedPath())
+	for _, h := range signed {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		fmt.Fprintf(&canonical, "%s:%s\n", h, strings.TrimSpace(v))
+	}
+	fmt.Fprintf(&canonical, "\n%s\n%s", strings.Join(signed, ";"), hex.EncodeToString(payload[:]))
+	hash := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request", toSign} {
+		m := hmac.New(sha256.New, key)
+		m.Write([]byte(part))
+		key = m.Sum(nil)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		id, scope, strings.Join(signed, ";"), key))
+	return nil
+}
+
+// openGCSStore opens gs://bucket/object, through the XML API of
+// Google Cloud Storage. Versions are object generations.
+func openGCSStore(u *url.URL) (cloudStore, error) {
+	object := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || object == "" {
+		return nil, errors.New("want gs://bucket/object")
+	}
+	api := &url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + u.Host + "/" + object}
+	return &objectStore{
+		name: "gs://" + u.Host + "/" + object,
+		url:  api.String(),
+		auth: func(req *http.Request, body []byte) error {
+			token, err := gcsToken()
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		},
+		condition: func(h http.Header, version string) {
+			if version == "" {
+				version = "0" // no live object
+			}
+			h.Set("X-Goog-If-Generation-Match", version)
+		},
+		version: func(h http.Header) string { return h.Get("X-Goog-Generation") },
+	}, nil
+}
+
+func gcsToken() (string, error) {
+	if t := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); t != "" {
+		return t, nil
+	}
+	out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+	if err != nil {
+		return "", errors.New("no Google Cloud token: set GOOGLE_OAUTH_ACCESS_TOKEN or run gcloud auth login")
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// openWebDAVStore opens the file at an http or https URL of a WebDAV
+// server, such as Nextcloud's, with basic authentication.
+func openWebDAVStore(u *url.URL) (cloudStore, error) {
+	if u.Host == "" || u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return nil, errors.New("want https://host/path/to/file")
+	}
+	var user, password string
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	if p := os.Getenv("GAUTH_WEBDAV_PASSWORD"); p != "" {
+		password = p
+	}
+	plain := *u
+	plain.User = nil
+	return &objectStore{
+		name: plain.String(),
+		url:  plain.String(),
+		auth: func(req *http.Request, body []byte) error {
+			if user != "" {
+				req.SetBasicAuth(user, password)
+			}
+			return nil
+		},
+		condition: ifMatch,
+		version:   etag,
+	}, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "os"
+
+// On a terminal, the codes printed by "gauth show" are colored: yellow
+// when they expire within 10 seconds, red within 5, and HOTP keys, whose
+// codes aren't generated, dimmed. -no-color, "color = no" in the
+// configuration or the NO_COLOR variable (see no-color.org) turn this
+// off.
+
+// coloring reports whether to color the output, given the -no-color
+// flag of the command.
+func coloring(noColor bool) bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && conf.get("color") != "no" && colorTerminal(os.Stdout.Fd())
+}
+
+const (
+	colorRed    = "31"
+	colorYellow = "33"
+	colorDim    = "2"
+)
+
+// paint returns s in color, an SGR parameter.
+func paint(s, color string) string {
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
+
+// expiryColor returns the color of a code valid for left more seconds,
+// or "" for the default.
+func expiryColor(left int64) string {
+	switch {
+	case left < 5:
+		return colorRed
+	case left < 10:
+		return colorYellow
+	}
+	return ""
+}
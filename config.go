@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The configuration file holds "key = value" lines. Blank lines and
+// lines starting with # are ignored. A key may be given several times,
+// as "backend" is:
+//
+//	# after the personal keychain, always searched first, the team's
+//	backend = file /srv/team/shared.gauth
+//	backend = vault https://vault.example.com totp
+//	backend = sops ~/infra/2fa.sops.yaml
+//	backend = ssh://vault.lan/~/.gauth
+//	backend = keyring
+//	backend = yubikey
+//	backups = 20
+//	sort = recent
+//	mode = 0640
+//	group = admins
+//	offline = yes
+//	readonly = yes
+//	strict = yes
+//	integrity = keyring
+//	encrypt = tpm 0,7
+//	sandbox = no
+//	agent-limit = 3/10m
+//	agent-confirm = bank github
+//	agent-cache = 8h
+//	agent-idle-lock = 15m
+//	cloud = s3://bucket/gauth/keychain
+//	notify = yes
+//	clip-timeout = 30s
+//	color = no
+//	after-code = logger -t gauth "code of $GAUTH_NAME"
+type config map[string][]string
+
+var conf = loadConfig()
+
+// configPath returns the location of the configuration file,
+// $GAUTH_CONFIG or $HOME/.gauth.conf.
+func configPath() string {
+	if p := os.Getenv("GAUTH_CONFIG"); p != "" {
+		return p
+	}
+	return filepath.Join(os.Getenv("HOME"), ".gauth"+profileSuffix()+".conf")
+}
+
+func loadConfig() config {
+	conf := make(config)
+	file := configPath()
+	f, err := os.Open(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+		return conf
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for lineno := 1; s.Scan(); lineno++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			log.Fatalf("%s:%d: expected key = value", file, lineno)
+		}
+		key := strings.TrimSpace(line[:i])
+		conf[key] = append(conf[key], strings.TrimSpace(line[i+1:]))
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return conf
+}
+
+// get returns the last value of key, or "" if it isn't set.
+func (c config) get(key string) string {
+	if v := c[key]; len(v) > 0 {
+		return v[len(v)-1]
+	}
+	return ""
+}
+
+// expandHome replaces a leading ~/ in path with the home directory.
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
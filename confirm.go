@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+var cmdConfirm = &command{
+	name:  "confirm",
+	usage: "confirm name...",
+	short: "mark keys as verified",
+	long: `Confirm records that a code of each named key was accepted by its
+site, so the key is known to be right. Keys which were never confirmed
+are flagged as unverified by list; imported keys, and keys added by
+hand, start out unverified.`,
+}
+
+func init() {
+	cmdConfirm.run = runConfirm
+}
+
+func runConfirm(ctx context.Context, cmd *command, args []string) {
+	if len(args) == 0 {
+		cmd.usageExit()
+	}
+	c := openKeychain()
+	for _, name := range args {
+		if _, ok := c.keys[name]; !ok {
+			fatalf(exitNotFound, "no such key %q", name)
+		}
+	}
+	for _, name := range args {
+		c.setVerified(name)
+	}
+	c.save()
+	for _, name := range args {
+		audit("confirm", name)
+	}
+	fmt.Fprintf(os.Stderr, "confirmed %d keys\n", len(args))
+}
+
+// setVerified marks key name as verified today. Call save to write the change.
+func (c *Keychain) setVerified(name string) {
+	k := c.keys[name]
+	k.set("verified", time.Now().Format(dateFormat))
+	c.lines[k.line] = formatKey(name, k, k.count)
+	c.keys[name] = k
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Generic CSV files are read with a column map given by -map, as
+//
+//	name=1,secret=3,digits=4
+//
+// Columns are numbered from 1, or named by the file's header row.
+// Without -map the header has to name the columns itself.
+// The fields are:
+//
+//	name       key name; or issuer and account, joined as issuer-account
+//	secret     base32 secret or otpauth URI (required)
+//	digits     6, 7 or 8 (default 6)
+//	period     TOTP period in seconds (default 30)
+//	algorithm  SHA1, SHA256 or SHA512 (default SHA1)
+//	type       totp or hotp (default totp)
+//	counter    HOTP counter (default 0)
+//	url        login page
+var csvFields = []string{"name", "issuer", "account", "secret", "digits", "period", "algorithm", "type", "counter", "url"}
+
+// csvMap parses a column map. It returns the map from field names
+// to column indexes, and whether the columns are named by a header.
+func csvMap(spec string, header []string) (map[string]int, bool, error) {
+	cols := make(map[string]int)
+	if spec == "" {
+		for i, h := range header {
+			h = strings.ToLower(strings.TrimSpace(h))
+			for _, f := range csvFields {
+				if h == f {
+					cols[f] = i
+				}
+			}
+		}
+		if _, ok := cols["secret"]; !ok {
+			return nil, false, errors.New("no secret column in the header, use -map")
+		}
+		return cols, true, nil
+	}
+	named := false
+	for _, m := range strings.Split(spec, ",") {
+		i := strings.Index(m, "=")
+		if i < 0 {
+			return nil, false, fmt.Errorf("-map: expected field=column, got %q", m)
+		}
+		field, col := strings.TrimSpace(m[:i]), strings.TrimSpace(m[i+1:])
+		known := false
+		for _, f := range csvFields {
+			known = known || f == field
+		}
+		if !known {
+			return nil, false, fmt.Errorf("-map: unknown field %q (known: %s)", field, strings.Join(csvFields, ", "))
+		}
+		if n, err := strconv.Atoi(col); err == nil {
+			if n < 1 {
+				return nil, false, fmt.Errorf("-map: invalid column %d", n)
+			}
+			cols[field] = n - 1
+			continue
+		}
+		named = true
+		found := false
+		for j, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), col) {
+				cols[field], found = j, true
+				break
+			}
+		}
+		if !found {
+			return nil, false, fmt.Errorf("-map: no column %q in the header", col)
+		}
+	}
+	if _, ok := cols["secret"]; !ok {
+		return nil, false, errors.New("-map: no secret column")
+	}
+	return cols, named, nil
+}
+
+func importCSV(ctx context.Context, file string) ([]entry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty file")
+	}
+	cols, header, err := csvMap(*importMap, records[0])
+	if err != nil {
+		return nil, err
+	}
+	start := 0
+	if header {
+		start = 1
+	}
+	var entries []entry
+	skipped := 0
+	for i := start; i < len(records); i++ {
+		e, err := csvEntry(records[i], cols)
+		if err != nil {
+			log.Printf("line %d: skipped: %v", i+1, err)
+			skipped++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	log.Printf("read %d rows, %d valid, %d skipped", len(records)-start, len(entries), skipped)
+	return entries, nil
+}
+
+// csvEntry makes the entry of a row.
+func csvEntry(rec []string, cols map[string]int) (entry, error) {
+	field := func(name, def string) string {
+		if i, ok := cols[name]; ok && i < len(rec) && strings.TrimSpace(rec[i]) != "" {
+			return strings.TrimSpace(rec[i])
+		}
+		return def
+	}
+	number := func(name, def string) (int, error) {
+		v := field(name, def)
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q", name, v)
+		}
+		return n, nil
+	}
+	name := field("name", "")
+	if name == "" {
+		name = entryName(field("issuer", ""), field("account", ""))
+	}
+	name = strings.Join(strings.Fields(name), "_")
+	secret := field("secret", "")
+	if secret == "" {
+		return entry{}, errors.New("no secret")
+	}
+	var e entry
+	if strings.HasPrefix(secret, "otpauth://") {
+		o, err := parseOtpauth(secret)
+		if err != nil {
+			return e, err
+		}
+		if name == "" {
+			name = entryName(o.issuer, o.account)
+		}
+		e, err = newEntry(name, o.secret, o.typ, o.algorithm, o.digits, o.period, o.counter)
+		if err != nil {
+			return e, err
+		}
+		e.key.setIdentity(o.issuer, o.account)
+	} else {
+		digits, err := number("digits", "6")
+		if err != nil {
+			return e, err
+		}
+		period, err := number("period", "30")
+		if err != nil {
+			return e, err
+		}
+		v := field("counter", "0")
+		counter, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return e, fmt.Errorf("invalid counter %q", v)
+		}
+		e, err = newEntry(name, secret, field("type", "totp"), field("algorithm", "SHA1"), digits, period, counter)
+		if err != nil {
+			return e, err
+		}
+	}
+	if u := field("url", ""); u != "" {
+		if err := checkURL(u); err != nil {
+			return e, err
+		}
+		e.key.set("url", u)
+	}
+	if issuer, account := field("issuer", ""), field("account", ""); issuer != "" || account != "" {
+		e.key.setIdentity(issuer, account)
+	}
+	return e, nil
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package main
+
+import "os/exec"
+
+// detach would start cmd out of the terminal's session; there's no
+// way to here.
+func detach(cmd *exec.Cmd) {}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var cmdDiff = &command{
+	name:  "diff",
+	usage: "diff file",
+	short: "compare the keychain with another one",
+	long: `Diff compares the keychain with another copy of it, such as one a
+file sync service left as a conflict, matching keys by their secret
+rather than their name. It prints a line for each difference:
+
+	+ name      the key is only in file
+	- name      the key is only in the keychain
+	= a b       the same secret is named a here and b in file
+	~ name      the key differs: digits, counter or attributes
+	! name      the name is used for different secrets
+
+and exits with status 1 if there's any, 0 if the keychains have the
+same keys. The file may be encrypted, or an encrypted backup.`,
+}
+
+var cmdMerge = &command{
+	name:  "merge",
+	usage: "merge file",
+	short: "merge another keychain into the keychain",
+	long: `Merge adds to the keychain the keys of file it doesn't have, as diff
+reports them with +, and takes the higher counter of HOTP keys which
+are in both. Conflicts are reported and left alone: names used for
+different secrets (!), and keys whose digits or attributes differ (~),
+and merge then exits with status 1.
+Keys named differently in file (=) keep their name here.`,
+}
+
+func init() {
+	cmdDiff.run = runDiff
+	cmdMerge.run = runMerge
+}
+
+// A keyDiff is a difference between the keychain and another one.
+type keyDiff struct {
+	op      byte   // +, -, =, ~ or !
+	name    string // name in the keychain, or in the other one for +
+	other   string // name in the other keychain for =
+	what    string // what differs for ~
+	counter string // the other, higher HOTP counter for ~, if it is
+	clash   bool   // for ~, whether more than the counter differs
+	e       entry  // the other keychain's entry for +
+}
+
+func (d keyDiff) String() string {
+	switch d.op {
+	case '=':
+		return fmt.Sprintf("= %s %s", d.name, d.other)
+	case '~':
+		return fmt.Sprintf("~ %s: %s", d.name, d.what)
+	case '!':
+		return fmt.Sprintf("! %s: different secrets", d.name)
+	}
+	return fmt.Sprintf("%c %s", d.op, d.name)
+}
+
+// diff compares c with the keychain file.
+func (c *Keychain) diff(file string) []keyDiff {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lockMemory(data)
+	defer wipe(data)
+	entries, err := keychainEntries(file, data)
+	if err != nil {
+		log.Fatalf("%s: %v", file, err)
+	}
+	var diffs []keyDiff
+	matched := make(map[string]bool)
+	for _, e := range entries {
+		name, ok := c.findSecret(e.key.raw)
+		if !ok {
+			if _, taken := c.keys[e.name]; taken {
+				diffs = append(diffs, keyDiff{op: '!', name: e.name})
+			} else {
+				diffs = append(diffs, keyDiff{op: '+', name: e.name, e: e})
+			}
+			continue
+		}
+		matched[name] = true
+		if name != e.name {
+			diffs = append(diffs, keyDiff{op: '=', name: name, other: e.name})
+			continue
+		}
+		if d, ok := c.compare(name, e); ok {
+			diffs = append(diffs, d)
+		}
+	}
+	for name := range c.keys {
+		if !matched[name] {
+			diffs = append(diffs, keyDiff{op: '-', name: name})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].name < diffs[j].name })
+	return diffs
+}
+
+// compare returns how key name differs from entry e of the same
+// secret, and whether it does.
+func (c *Keychain) compare(name string, e entry) (keyDiff, bool) {
+	k := c.keys[name]
+	var what []string
+	if k.digits != e.key.digits {
+		what = append(what, fmt.Sprintf("digits %d, %d in file", k.digits, e.key.digits))
+	}
+	attrs := make(map[string]bool)
+	for a := range k.attrs {
+		attrs[a] = true
+	}
+	for a := range e.key.attrs {
+		attrs[a] = true
+	}
+	var names []string
+	for a := range attrs {
+		if k.attr(a) != e.key.attr(a) {
+			names = append(names, a)
+		}
+	}
+	sort.Strings(names)
+	what = append(what, names...)
+	d := keyDiff{op: '~', name: name, clash: len(what) > 0}
+	mine, _ := strconv.ParseUint(k.count, 10, 64)
+	theirs, _ := strconv.ParseUint(e.counter, 10, 64)
+	if theirs != mine {
+		what = append(what, fmt.Sprintf("counter %d, %d in file", mine, theirs))
+	}
+	if theirs > mine {
+		d.counter = e.counter
+	}
+	if len(what) == 0 {
+		return d, false
+	}
+	d.what = strings.Join(what, ", ")
+	return d, true
+}
+
+func runDiff(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	diffs := openKeychain().diff(args[0])
+	for _, d := range diffs {
+		fmt.Println(isolate(d.String()))
+	}
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+}
+
+func runMerge(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	c := openKeychain()
+	c.checkWritable()
+	var added, updated, conflicts []string
+	for _, d := range c.diff(args[0]) {
+		switch {
+		case d.op == '+':
+			c.lines = append(c.lines, formatKey(d.name, d.e.key, d.e.counter))
+			added = append(added, d.name)
+		case d.op == '~':
+			if d.counter != "" {
+				k := c.keys[d.name]
+				c.lines[k.line] = formatKey(d.name, k, d.counter)
+				updated = append(updated, d.name)
+			}
+			if d.clash {
+				log.Printf("conflict: %s", isolate(d.String()))
+				conflicts = append(conflicts, d.name)
+			}
+		case d.op == '!':
+			log.Printf("conflict: %s", isolate(d.String()))
+			conflicts = append(conflicts, d.name)
+		}
+	}
+	if len(added)+len(updated) > 0 {
+		c.save()
+	}
+	for _, name := range added {
+		audit("merge", name)
+	}
+	for _, name := range updated {
+		audit("update", name)
+	}
+	fmt.Fprintf(os.Stderr, "merged %d new keys, updated %d counters, %d conflicts\n", len(added), len(updated), len(conflicts))
+	if len(conflicts) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+var cmdDoctor = &command{
+	name:  "doctor",
+	usage: "doctor [-fix]",
+	short: "check the keychain for problems",
+	long: `Doctor checks the keychain and reports what's wrong with it:
+
+	- lines which aren't valid keys, and why, at the column at fault,
+	  as reading the keychain reports them: a missing field, a wrong
+	  number of digits, a secret which isn't base32, an invalid
+	  attribute, a control character
+	- HOTP counters which aren't 20 digits long: gauth writes the
+	  counter in place, so a shorter one would corrupt the line
+	- carriage returns and extra spaces or tabs left by editors, which
+	  gauth reads past, but which are tidied away
+	- keys with the same name, of which only the last one is used
+	- a keychain readable by more users than configured
+	- a clock which seems wrong: TOTP codes depend on it
+
+It exits with status 3 if it found invalid lines, 4 if the clock
+seems wrong, and 1 for other problems, untidy lines included. With -fix, it offers to repair
+each problem which can be, one at a time; the keychain is backed up
+before it's rewritten. "gauth integrity verify" checks the
+keychain against its MACs.`,
+}
+
+var doctorFix = cmdDoctor.flags.Bool("fix", false, "offer to repair the problems which can be")
+
+func init() {
+	cmdDoctor.run = runDoctor
+	cmdDoctor.programs = always
+}
+
+// A problem is something wrong found by doctor.
+type problem struct {
+	line int    // index of the keychain line, -1 for others
+	col  int    // column at fault in the line, from 1, 0 if none
+	name string // the key of the line, if any
+	msg  string
+	// fix, if not nil, repairs the problem, described by fixMsg.
+	fix    func()
+	fixMsg string
+	status int // the exit status it calls for, exitFailure if 0
+}
+
+// doctorStatus returns the exit status for the problems left: that of
+// the keychain's invalid lines first, then that of the clock.
+func doctorStatus(problems []problem) int {
+	status := exitFailure
+	for _, p := range problems {
+		if p.status == exitInvalidKeychain {
+			return p.status
+		}
+		if p.status != 0 {
+			status = p.status
+		}
+	}
+	return status
+}
+
+func (p problem) String() string {
+	s := p.msg
+	if p.name != "" {
+		s = isolate(p.name) + ": " + s
+	}
+	switch {
+	case p.col > 0:
+		s = fmt.Sprintf("%s:%d:%d: %s", keychainPath(), p.line+1, p.col, s)
+	case p.line >= 0:
+		s = fmt.Sprintf("%s:%d: %s", keychainPath(), p.line+1, s)
+	}
+	return s
+}
+
+// diagnoseLine returns what's wrong with a keychain line: the error of
+// parseLine, if it refuses the line, and what makes it untidy. fixed is
+// the repaired line if every problem can be repaired.
+func diagnoseLine(line string) (msgs []string, err *lineError, fixed string, fixable bool) {
+	trimmed := strings.TrimRight(line, " \t\r")
+	if strings.HasSuffix(strings.TrimRight(line, " \t"), "\r") {
+		msgs = append(msgs, "ends with a carriage return")
+	}
+	f := strings.FieldsFunc(trimmed, func(r rune) bool { return r == ' ' || r == '\t' })
+	if len(f) == 0 {
+		return append(msgs, "holds only spaces"), nil, "", true
+	}
+	if strings.Join(f, " ") != trimmed || trimmed != strings.TrimRight(line, "\r") {
+		msgs = append(msgs, "has extra spaces or tabs")
+	}
+	fixed = strings.Join(f, " ")
+	if _, _, e := parseLine([]byte(line)); e != nil {
+		err = e.(*lineError)
+		msgs = append(msgs, err.msg)
+		// A short HOTP counter is the one error doctor repairs.
+		if len(f) < 4 || strings.Trim(f[3], "0123456789") != "" || len(f[3]) >= counterLen {
+			return msgs, err, "", false
+		}
+		f[3] = strings.Repeat("0", counterLen-len(f[3])) + f[3]
+		fixed = strings.Join(f, " ")
+		if _, _, e := parseLine([]byte(fixed)); e != nil {
+			return msgs, err, "", false
+		}
+	}
+	return msgs, err, fixed, true
+}
+
+// doctorLines checks the lines of c, repairing them in c.lines.
+func doctorLines(c *Keychain) []problem {
+	var problems []problem
+	names := make(map[string]int)
+	secrets := make(map[int]string)
+	for i, line := range c.lines {
+		if line == "" {
+			continue
+		}
+		name := ""
+		if f := strings.Fields(line); len(f) > 0 {
+			name = f[0]
+		}
+		msgs, err, fixed, fixable := diagnoseLine(line)
+		if err == nil && fixed != "" {
+			secret := strings.Fields(line)[2]
+			if prev, ok := names[name]; ok {
+				p := problem{line: prev, name: name, status: exitInvalidKeychain, msg: fmt.Sprintf("replaced by line %d, a key of the same name", i+1)}
+				if secrets[prev] == strings.ToUpper(secret) {
+					prev := prev
+					p.msg += " and secret"
+					p.fix = func() { c.lines[prev] = "" }
+					p.fixMsg = "remove the duplicate"
+				}
+				problems = append(problems, p)
+			}
+			names[name] = i
+			secrets[i] = strings.ToUpper(secret)
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		p := problem{line: i, name: name, msg: strings.Join(msgs, "; ")}
+		if err != nil {
+			p.col, p.status = err.col, exitInvalidKeychain
+		}
+		if fixable {
+			i := i
+			p.fix = func() { c.lines[i] = fixed }
+			p.fixMsg = "repair the line"
+			if fixed == "" {
+				p.fixMsg = "remove the line"
+			}
+		}
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+// doctorPerm checks the mode of the keychain file.
+func doctorPerm(file string) []problem {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil
+	}
+	want := keychainPerm().mode
+	if fi.Mode().Perm() == want {
+		return nil
+	}
+	return []problem{{
+		line: -1,
+		msg:  fmt.Sprintf("%s has mode %04o, not the configured %04o", file, fi.Mode().Perm(), want),
+		fix: func() {
+			if err := os.Chmod(file, want); err != nil {
+				log.Fatal(err)
+			}
+		},
+		fixMsg: fmt.Sprintf("set its mode to %04o", want),
+	}}
+}
+
+// clockFloor is a time the clock can't be before.
+var clockFloor = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// doctorClock looks for signs of a wrong clock.
+func doctorClock() []problem {
+	var problems []problem
+	now := time.Now()
+	if now.Before(clockFloor) {
+		problems = append(problems, problem{line: -1, status: exitClockSkew, msg: fmt.Sprintf("the clock reads %s, which is in the past: TOTP codes will be wrong", now.Format(time.RFC3339))})
+	}
+	// Files written by gauth can't have been written in the future.
+	for _, file := range []string{keychainPath(), usedPath(), auditPath()} {
+		if file == "" {
+			continue
+		}
+		if fi, err := os.Stat(file); err == nil && fi.ModTime().After(now.Add(time.Minute)) {
+			problems = append(problems, problem{line: -1, status: exitClockSkew, msg: fmt.Sprintf("%s was written at %s, after now: the clock may be behind", file, fi.ModTime().Format(time.RFC3339))})
+		}
+	}
+	if runtime.GOOS == "linux" {
+		out, err := exec.Command("timedatectl", "show", "-p", "NTPSynchronized", "--value").Output()
+		if err == nil && strings.TrimSpace(string(out)) == "no" {
+			problems = append(problems, problem{line: -1, status: exitClockSkew, msg: "the clock isn't synchronized over NTP (see timedatectl)"})
+		}
+	}
+	return problems
+}
+
+func runDoctor(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 0 {
+		cmd.usageExit()
+	}
+	if memoryKeychain != nil {
+		log.Fatalf("the keychain read from %s isn't a file to check", memoryKeychain.file)
+	}
+	file := keychainPath()
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "no keychain at %s\n", file)
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	lockMemory(data)
+	defer wipe(data)
+	plain := data
+	var enc *keychainKey
+	if isEncryptedKeychain(data) {
+		if enc, plain, err = decryptKeychain(data); err != nil {
+			fatalf(keychainErrorStatus(err), "%s: %v", file, err)
+		}
+		defer wipe(plain)
+	}
+	// Its keys let saving keep the counters advanced while -fix asks.
+	c, _ := scanKeychain(file, plain)
+	c.enc = enc
+
+	lines := doctorLines(c)
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].line < lines[j].line })
+	problems := append(doctorPerm(file), lines...)
+	problems = append(problems, doctorClock()...)
+	fixable := 0
+	for _, p := range problems {
+		if p.fix != nil {
+			fixable++
+		}
+		// -fix asks about the others.
+		if p.fix == nil || !*doctorFix {
+			fmt.Println(p)
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(os.Stderr, "no problems found")
+		return
+	}
+	if !*doctorFix {
+		if fixable > 0 {
+			fmt.Fprintf(os.Stderr, "%d of %d problems can be repaired: run \"gauth doctor -fix\"\n", fixable, len(problems))
+		}
+		os.Exit(doctorStatus(problems))
+	}
+	if fixable > 0 {
+		userKeychain().checkWritable()
+	}
+	fixed, rewrite := 0, false
+	var left []problem
+	for _, p := range problems {
+		if p.fix == nil || !confirm(fmt.Sprintf("%s: %s?", p, p.fixMsg)) {
+			left = append(left, p)
+			continue
+		}
+		p.fix()
+		fixed++
+		rewrite = rewrite || p.line >= 0
+	}
+	if rewrite {
+		c.save()
+	}
+	if fixed > 0 {
+		audit("repair", "")
+	}
+	fmt.Fprintf(os.Stderr, "repaired %d of %d problems\n", fixed, len(problems))
+	if len(left) > 0 {
+		os.Exit(doctorStatus(left))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var cmdEdit = &command{
+	name:  "edit",
+	usage: "edit [-note text] [-url url] [-issuer issuer] [-account account] name",
+	short: "change the details of a key",
+	long: `Edit changes the details recorded with the named key: its note, login
+page, issuer or account. Only the given flags change; an empty value,
+such as -note "", removes the detail. Notes are free text, such as
+"recovery codes in safe #2"; "gauth list -verbose" shows them.`,
+}
+
+func init() {
+	cmdEdit.flags.String("note", "", "set the note of the key to `text`")
+	cmdEdit.flags.String("url", "", "record `url` as the login page of the key")
+	cmdEdit.flags.String("issuer", "", "record `issuer` as the provider of the key")
+	cmdEdit.flags.String("account", "", "record `account` as the user of the key")
+	cmdEdit.run = runEdit
+}
+
+func runEdit(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	changes := make(map[string]string)
+	cmd.flags.Visit(func(f *flag.Flag) {
+		changes[f.Name] = strings.TrimSpace(f.Value.String())
+	})
+	if len(changes) == 0 {
+		log.Print("nothing to change")
+		cmd.usageExit()
+	}
+	if u := changes["url"]; u != "" {
+		if err := checkURL(u); err != nil {
+			log.Fatal(err)
+		}
+	}
+	name := args[0]
+	c := openKeychain()
+	if _, ok := c.keys[name]; !ok {
+		fatalf(exitNotFound, "no such key %q", name)
+	}
+	c.update(func(c *Keychain) {
+		k, ok := c.keys[name]
+		if !ok {
+			fatalf(exitNotFound, "no such key %q", name)
+		}
+		for attr, value := range changes {
+			k.set(attr, value)
+		}
+		c.lines[k.line] = formatKey(name, k, k.count)
+	})
+	audit("edit", name)
+	fmt.Fprintf(os.Stderr, "edited %s\n", name)
+}
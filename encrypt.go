@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+var cmdEncrypt = &command{
+	name:  "encrypt",
+	usage: "encrypt [-d]",
+	short: "encrypt the keychain at rest",
+	long: `Encrypt encrypts the keychain with a random key, protected as the
+"encrypt" line of the configuration says:
+
+	encrypt = passphrase    derive it from a passphrase, asked for each time
+	encrypt = tpm           seal it to the TPM of this machine
+	encrypt = tpm 0,7       and bind it to the values of PCRs 0 and 7
+	encrypt = touchid       keep it in the Secure Enclave, behind Touch ID (macOS)
+	encrypt = yubikey 2     derive it from the challenge-response of YubiKey slot 2
+	encrypt = fido2         derive it from the hmac-secret of a FIDO2 security key
+	encrypt = systemd-creds encrypt it with systemd-creds, under the host key or TPM
+
+From then on gauth decrypts the keychain whenever it reads it, and
+writes it, its backups included, encrypted; the backups taken before
+are encrypted too. Running encrypt again
+changes the key, for a new passphrase or other PCRs. -d decrypts the
+keychain back to plain text.
+
+With encryption configured, a new keychain is encrypted from the start.
+The YubiKey slot has to be programmed for HMAC-SHA1
+challenge-response ("ykman otp chalresp"), and ykman or ykchalresp
+installed. FIDO2 security keys are reached with the libfido2 tools.
+systemd-creds takes the key to use, auto, host, tpm2 or host+tpm2.
+Sealing to the TPM needs tpm2-tools; the keychain then only decrypts
+on this machine, and with PCRs only while they keep their values,
+which a firmware or bootloader update changes: keep a backup
+encrypted with a passphrase ("gauth export -encrypt") as well.`,
+}
+
+var encryptDecrypt = cmdEncrypt.flags.Bool("d", false, "decrypt the keychain to plain text")
+
+func init() {
+	cmdEncrypt.run = runEncrypt
+}
+
+// An encrypted keychain is
+//
+//	magic (8 bytes) | version (1) | protector length (1) | protector |
+//	wrapped key length (4) | wrapped key | nonce (12) | ciphertext
+//
+// The ciphertext is the keychain, sealed with AES-256-GCM under a random
+// key, which the protector named in the header wraps. Everything before
+// the ciphertext is authenticated along with it.
+
+var encryptedMagic = []byte("GAUTHKCE")
+
+const encryptedVersion = 1
+
+// A keyProtector wraps the key a keychain is encrypted with,
+// so only its owner can unwrap it.
+type keyProtector interface {
+	wrap(key []byte) ([]byte, error)
+	unwrap(wrapped []byte) ([]byte, error)
+}
+
+// keyProtectors maps the protectors of the configuration to functions
+// returning them from their arguments. Unwrapping passes no arguments:
+// what the protector needs is in the wrapped key.
+var keyProtectors = map[string]func(args []string) (keyProtector, error){
+	"passphrase": newPassphraseProtector,
+	"tpm":        newTPMProtector,
+}
+
+// A keychainKey is the key of an encrypted keychain.
+type keychainKey struct {
+	protector string
+	wrapped   []byte
+	key       []byte // in locked memory
+}
+
+// unwrapped caches the keys unwrapped so far, by wrapped key,
+// so reading the keychain again doesn't ask the protector again.
+var unwrapped = make(map[string][]byte)
+
+// forgetKeys wipes the keys unwrapped so far, so reading the keychain
+// again asks the protector again.
+func forgetKeys() {
+	for wrapped, key := range unwrapped {
+		wipe(key)
+		delete(unwrapped, wrapped)
+	}
+}
+
+// encryptSpec returns the fields of the "encrypt" configuration line.
+func encryptSpec() []string {
+	return strings.Fields(conf.get("encrypt"))
+}
+
+// newKeychainKey makes a random key and wraps it with the configured
+// protector.
+func newKeychainKey() (*keychainKey, error) {
+	spec := encryptSpec()
+	if len(spec) == 0 {
+		return nil, errors.New(`no "encrypt" line in the configuration`)
+	}
+	open, ok := keyProtectors[spec[0]]
+	if !ok {
+		var names []string
+		for name := range keyProtectors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown key protector %q (use %s)", spec[0], strings.Join(names, ", "))
+	}
+	p, err := open(spec[1:])
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	lockMemory(key)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	wrapped, err := p.wrap(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", spec[0], err)
+	}
+	unwrapped[string(wrapped)] = key
+	return &keychainKey{protector: spec[0], wrapped: wrapped, key: key}, nil
+}
+
+// isEncryptedKeychain reports whether data is an encrypted keychain.
+func isEncryptedKeychain(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// An invalidKeychain error tells that an encrypted keychain is
+// corrupted or of an unknown format, rather than that its key is
+// missing.
+type invalidKeychain struct{ error }
+
+// keychainErrorStatus returns the exit status for an error returned by
+// decryptKeychain.
+func keychainErrorStatus(err error) int {
+	if _, ok := err.(invalidKeychain); ok {
+		return exitInvalidKeychain
+	}
+	return exitFailure
+}
+
+// decryptKeychain decrypts an encrypted keychain, returning its key
+// and its contents in locked memory.
+func decryptKeychain(data []byte) (*keychainKey, []byte, error) {
+	k, header, err := parseEncrypted(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := header[len(header)-12:]
+	k.key = unwrapped[string(k.wrapped)]
+	if k.key == nil {
+		open, ok := keyProtectors[k.protector]
+		if !ok {
+			return nil, nil, fmt.Errorf("keychain encrypted with unknown key protector %q", k.protector)
+		}
+		p, err := open(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := p.unwrap(k.wrapped)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", k.protector, err)
+		}
+		lockMemory(key)
+		unwrapped[string(k.wrapped)] = key
+		k.key = key
+	}
+	aead, err := newGCM(k.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain := make([]byte, 0, len(data)-len(header))
+	lockMemory(plain[:cap(plain)])
+	plain, err = aead.Open(plain, nonce, data[len(header):], header)
+	if err != nil {
+		return nil, nil, invalidKeychain{errors.New("the encrypted keychain was modified")}
+	}
+	return k, plain, nil
+}
+
+// parseEncrypted parses the header of an encrypted keychain, returning
+// its key, still wrapped, and the header, which ends with the nonce.
+func parseEncrypted(data []byte) (*keychainKey, []byte, error) {
+	invalid := invalidKeychain{errors.New("invalid encrypted keychain")}
+	if len(data) < len(encryptedMagic)+2 {
+		return nil, nil, invalid
+	}
+	if v := data[len(encryptedMagic)]; v != encryptedVersion {
+		return nil, nil, invalidKeychain{fmt.Errorf("unsupported encrypted keychain version %d", v)}
+	}
+	rest := data[len(encryptedMagic)+1:]
+	n := int(rest[0])
+	if len(rest) < 1+n+4 {
+		return nil, nil, invalid
+	}
+	k := &keychainKey{protector: string(rest[1 : 1+n])}
+	rest = rest[1+n:]
+	m := binary.BigEndian.Uint32(rest)
+	if uint64(len(rest)) < 4+uint64(m)+12 {
+		return nil, nil, invalid
+	}
+	k.wrapped = append([]byte(nil), rest[4:4+m]...)
+	return k, data[:len(data)-len(rest)+int(4+m+12)], nil
+}
+
+// unlockKeychain unwraps the key of encrypted keychain data with
+// passphrase, given rather than asked for, and caches it as reading
+// the keychain would.
+func unlockKeychain(data []byte, passphrase string) error {
+	k, _, err := parseEncrypted(data)
+	if err != nil {
+		return err
+	}
+	if k.protector != "passphrase" {
+		return fmt.Errorf("the keychain key is protected by %s, not a passphrase", k.protector)
+	}
+	if unwrapped[string(k.wrapped)] != nil {
+		return nil
+	}
+	key, err := unseal(k.wrapped, passphrase)
+	if err != nil {
+		return checkDuress(passphrase)
+	}
+	lockMemory(key)
+	unwrapped[string(k.wrapped)] = key
+	return nil
+}
+
+// newGCM returns AES-256-GCM with key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt encrypts the contents of a keychain.
+func (k *keychainKey) encrypt(plain []byte) ([]byte, error) {
+	var header []byte
+	header = append(header, encryptedMagic...)
+	header = append(header, encryptedVersion, byte(len(k.protector)))
+	header = append(header, k.protector...)
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(k.wrapped)))
+	header = append(header, n[:]...)
+	header = append(header, k.wrapped...)
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	aead, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, plain, header), nil
+}
+
+// kekWrap encrypts key with AES-256-GCM under kek, a key derived from
+// a hardware token, and returns the nonce and the ciphertext.
+func kekWrap(kek, key []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, nil), nil
+}
+
+// kekUnwrap decrypts what kekWrap returned.
+func kekUnwrap(kek, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("invalid wrapped key")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, wrapped[:n], wrapped[n:], nil)
+}
+
+// encrypted reports whether c is written encrypted: it's encrypted
+// already, or it's a new keychain and encryption is configured.
+func (c *Keychain) encrypted() bool {
+	if c.enc != nil {
+		return true
+	}
+	if len(encryptSpec()) == 0 {
+		return false
+	}
+	_, err := os.Stat(c.file)
+	return os.IsNotExist(err)
+}
+
+func runEncrypt(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 0 {
+		cmd.usageExit()
+	}
+	c := openKeychain()
+	c.checkWritable()
+	if *encryptDecrypt {
+		if c.enc == nil {
+			log.Fatal("the keychain isn't encrypted")
+		}
+		c.enc = nil
+		c.save()
+		audit("decrypt", "")
+		fmt.Fprintln(os.Stderr, "decrypted the keychain")
+		return
+	}
+	k, err := newKeychainKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.enc = k
+	c.save()
+	// The backups, taken before, go under the same key.
+	if err := rewriteBackups(c.file, k, func(plain []byte) []byte {
+		return append([]byte(nil), plain...)
+	}); err != nil {
+		log.Fatalf("encrypting backups: %v", err)
+	}
+	audit("encrypt", "")
+	fmt.Fprintf(os.Stderr, "encrypted the keychain (%s)\n", k.protector)
+}
+
+// passphraseProtector wraps keys with a passphrase, as encrypted
+// backups are sealed.
+type passphraseProtector struct{}
+
+func newPassphraseProtector(args []string) (keyProtector, error) {
+	if len(args) != 0 {
+		return nil, errors.New("passphrase takes no arguments")
+	}
+	return passphraseProtector{}, nil
+}
+
+func (passphraseProtector) wrap(key []byte) ([]byte, error) {
+	passphrase, err := readNewPassword("new keychain passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if isDuress(passphrase) {
+		return nil, errors.New("the keychain passphrase can't be the duress passphrase")
+	}
+	return seal(key, passphrase)
+}
+
+func (passphraseProtector) unwrap(wrapped []byte) ([]byte, error) {
+	passphrase, err := readPassword("keychain passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	key, err := unseal(wrapped, passphrase)
+	if err != nil {
+		return nil, checkDuress(passphrase)
+	}
+	return key, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// newTestKeychainKey returns a key wrapped with passphrase, as the
+// passphrase protector wraps it, without asking for the passphrase.
+func newTestKeychainKey(t *testing.T, passphrase string) *keychainKey {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := seal(key, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &keychainKey{protector: "passphrase", wrapped: wrapped, key: key}
+}
+
+func TestEncryptedKeychain(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // no duress passphrase
+	defer forgetKeys()
+	plain := []byte("github 6 JBSWY3DPEHPK3PXP\n")
+	k := newTestKeychainKey(t, "correct horse")
+	data, err := k.encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncryptedKeychain(data) || bytes.Contains(data, plain) {
+		t.Fatalf("encrypt(%q) = %q, not encrypted", plain, data)
+	}
+	if err := unlockKeychain(data, "wrong horse"); err == nil {
+		t.Error("unlockKeychain with a wrong passphrase succeeded")
+	}
+	if err := unlockKeychain(data, "correct horse"); err != nil {
+		t.Fatalf("unlockKeychain: %v", err)
+	}
+	_, got, err := decryptKeychain(data)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("decryptKeychain = %q, %v, want %q", got, err, plain)
+	}
+}
+
+// TestEncryptedKeychainTampered changes each part of an encrypted
+// keychain, whose key is unwrapped already, so what's refused is the
+// change rather than the key.
+func TestEncryptedKeychainTampered(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	defer forgetKeys()
+	k := newTestKeychainKey(t, "correct horse")
+	data, err := k.encrypt([]byte("github 6 JBSWY3DPEHPK3PXP\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unlockKeychain(data, "correct horse"); err != nil {
+		t.Fatalf("unlockKeychain: %v", err)
+	}
+	_, header, err := parseEncrypted(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protector := len(encryptedMagic) + 2
+	tests := []struct {
+		what string
+		i    int
+	}{
+		{"version", len(encryptedMagic)},
+		{"protector", protector},
+		{"nonce", len(header) - 1},
+		{"ciphertext", len(header)},
+		{"tag", len(data) - 1},
+	}
+	for _, tt := range tests {
+		tampered := append([]byte(nil), data...)
+		tampered[tt.i] ^= 1
+		_, got, err := decryptKeychain(tampered)
+		if err == nil {
+			t.Errorf("decryptKeychain with the %s changed = %q, want an error", tt.what, got)
+			continue
+		}
+		if status := keychainErrorStatus(err); status != exitInvalidKeychain {
+			t.Errorf("decryptKeychain with the %s changed: %v, status %d, want %d", tt.what, err, status, exitInvalidKeychain)
+		}
+	}
+	// A changed wrapped key is another key, which the passphrase
+	// doesn't unwrap.
+	tampered := append([]byte(nil), data...)
+	tampered[protector+len(k.protector)+4+20] ^= 1
+	if err := unlockKeychain(tampered, "correct horse"); err == nil {
+		t.Error("unlockKeychain with the wrapped key changed succeeded")
+	}
+	if _, _, err := decryptKeychain(data[:len(header)-1]); err == nil {
+		t.Error("decryptKeychain of a cut header succeeded")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var cmdEnroll = &command{
+	name:  "enroll",
+	usage: "enroll [-bytes n] [-hotp] [-digits n] [-issuer issuer] [-account account] [-codes n] name",
+	short: "enroll a user's phone in a new key",
+	long: `Enroll sets up 2FA for a user as a service does: it generates a key
+as "gauth gen" does, shows its QR code for the user's phone to scan,
+and asks for the code the phone then shows. Only once the code is
+valid is the key added to the keychain, marked verified, so a phone
+which scanned the wrong QR code, or none, never leaves a key behind.
+
+-codes 2 asks for two consecutive codes, the second once the phone
+shows the next one, which also checks that the phone's clock keeps
+time, or that its counter advances. Each code may be typed three
+times; enroll then gives up and exits with status 5. The flags of gen
+choose the secret and the kind of key.`,
+}
+
+var (
+	enrollBytes   = cmdEnroll.flags.Int("bytes", 20, "generate a secret of `n` bytes")
+	enrollHotp    = cmdEnroll.flags.Bool("hotp", false, "generate an HOTP (counter-based) key")
+	enrollDigits  = cmdEnroll.flags.Int("digits", 6, "give codes of `n` digits, 6 to 8")
+	enrollIssuer  = cmdEnroll.flags.String("issuer", "", "record `issuer` as the provider of the key")
+	enrollAccount = cmdEnroll.flags.String("account", "", "record `account` as the user of the key")
+	enrollCodes   = cmdEnroll.flags.Int("codes", 1, "require `n` consecutive valid codes, 1 or 2")
+)
+
+func init() {
+	cmdEnroll.run = runEnroll
+}
+
+// enrollTries is how many times each code may be typed.
+const enrollTries = 3
+
+func runEnroll(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 || *enrollDigits < 6 || *enrollDigits > 8 || *enrollCodes < 1 || *enrollCodes > 2 {
+		cmd.usageExit()
+	}
+	name := args[0]
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		log.Fatal("spaces aren't allowed")
+	}
+	if err := checkCommandName(name); err != nil {
+		log.Fatal(err)
+	}
+	c := openKeychain()
+	c.checkWritable()
+	if _, ok := c.keys[name]; ok {
+		log.Fatalf("key %q already exists", name)
+	}
+	k, counter := generateKey(*enrollBytes, *enrollDigits, *enrollHotp)
+	defer wipe(k.raw)
+	k.setIdentity(*enrollIssuer, *enrollAccount)
+	uri, err := otpauthURI(name, k, counter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if isTerminal(os.Stdout.Fd()) {
+		writeQRCode(uri, "")
+	}
+	fmt.Println(uri)
+	fmt.Fprintln(os.Stderr, "scan the QR code, or enter the URI, in the authenticator app")
+
+	key := k.hmacKey()
+	defer wipe(key)
+	// codeAt returns the code of TOTP time step or HOTP counter n.
+	codeAt := func(n int64) string {
+		if *enrollHotp {
+			return fmt.Sprintf("%0*d", k.digits, genHOTP(k.hash(), key, uint64(n), k.digits))
+		}
+		return fmt.Sprintf("%0*d", k.digits, genTOTP(k.hash(), key, time.Unix(n*30, 0), 0, 30, k.digits))
+	}
+	var matched int64
+	for i := 0; i < *enrollCodes; i++ {
+		prompt := "code from the app: "
+		if i > 0 {
+			prompt = "next code from the app, once it changes: "
+		}
+		ok := false
+		for try := 0; try < enrollTries && !ok; try++ {
+			code, err := readCode(prompt)
+			if err != nil {
+				log.Fatalf("reading code: %v", err)
+			}
+			// The first code may be of the time step either side of
+			// now, or of one of the first counters, as apps count
+			// from 0 or 1; the next one follows it.
+			first, last := matched+1, matched+1
+			if i == 0 && *enrollHotp {
+				first, last = 0, 3
+			} else if i == 0 {
+				step := time.Now().Unix() / 30
+				first, last = step-1, step+1
+			}
+			for n := first; n <= last && !ok; n++ {
+				if codesEqual(codeAt(n), code) {
+					matched, ok = n, true
+				}
+			}
+			if !ok && i > 0 && codesEqual(codeAt(matched), code) {
+				fmt.Fprintln(os.Stderr, "that's the code typed already: wait for the next one")
+			} else if !ok {
+				fmt.Fprintln(os.Stderr, "that code isn't valid")
+			}
+		}
+		if !ok {
+			fatalf(exitVerifyFailed, "no valid code: %s wasn't added", name)
+		}
+	}
+
+	if *enrollHotp {
+		counter = fmt.Sprintf("%0*d", counterLen, matched)
+	}
+	k.set("verified", time.Now().Format(dateFormat))
+	if !*enrollHotp {
+		// gauth verify accepts no code the phone has shown yet.
+		k.set("accepted", strconv.FormatInt(matched, 10))
+	}
+	c.lines = append(c.lines, formatKey(name, k, counter))
+	c.save()
+	audit("add", name)
+	fmt.Fprintf(os.Stderr, "enrolled %s\n", name)
+}
+
+// readCode asks for a code on stderr and reads it from stdin, ignoring
+// spaces grouping its digits.
+func readCode(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.Map(checkSpace, line), nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+var cmdEnv = &command{
+	name:  "env",
+	usage: "env [-shell sh|fish|powershell] [-prefix prefix] name",
+	short: "print a code as shell variable assignments",
+	long: `Env prints the current code of the named key as shell commands setting
+environment variables, for login scripts:
+
+	eval $(gauth env vpn)
+
+sets OTP to the code and, for TOTP keys, OTP_EXPIRES to the Unix time
+at which it stops being valid. -prefix names the variables instead of
+OTP, and -shell selects the syntax: sh (export, the default), fish
+(set -gx) or powershell ($env:).`,
+}
+
+var (
+	envShell  = cmdEnv.flags.String("shell", "sh", "print commands for `shell`: sh, fish or powershell")
+	envPrefix = cmdEnv.flags.String("prefix", "OTP", "name the variables `prefix` and prefix_EXPIRES")
+)
+
+// envFormats render the assignments of variables, by shell.
+var envFormats = map[string]func(vars [][2]string) string{
+	"sh": func(vars [][2]string) string {
+		s := "export"
+		for _, v := range vars {
+			s += fmt.Sprintf(" %s=%s", v[0], v[1])
+		}
+		return s
+	},
+	"fish": func(vars [][2]string) string {
+		s := ""
+		for i, v := range vars {
+			if i > 0 {
+				s += "; "
+			}
+			s += fmt.Sprintf("set -gx %s %s", v[0], v[1])
+		}
+		return s
+	},
+	"powershell": func(vars [][2]string) string {
+		s := ""
+		for i, v := range vars {
+			if i > 0 {
+				s += "; "
+			}
+			s += fmt.Sprintf("$env:%s = %q", v[0], v[1])
+		}
+		return s
+	},
+}
+
+func init() {
+	cmdEnv.run = runEnv
+}
+
+func runEnv(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	format, ok := envFormats[*envShell]
+	if !ok {
+		log.Fatalf("unknown shell %q (use sh, fish or powershell)", *envShell)
+	}
+	if !isEnvName(*envPrefix) {
+		log.Fatalf("invalid variable name %q", *envPrefix)
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	name := args[0]
+	k, err := federation(openBackends()).resolve(ctx, name)
+	if err != nil {
+		fatal(exitNotFound, err)
+	}
+	fmt.Println(format(codeVars(*envPrefix, currentCode(ctx, k))))
+}
+
+// A timedCode is a code and the time it expires at,
+// which is zero for HOTP codes.
+type timedCode struct {
+	code    string
+	expires time.Time
+}
+
+// currentCode generates the current code of key k.
+func currentCode(ctx context.Context, k keyInfo) timedCode {
+	now := time.Now()
+	code, err := k.source.code(ctx, k.name)
+	checkInterrupted(ctx, "interrupted")
+	if err != nil {
+		log.Fatalf("%s: %v", k.source, err)
+	}
+	return usedCode(k, code, now)
+}
+
+// usedCode records that code of k, generated at now, was given out.
+func usedCode(k keyInfo, code string, now time.Time) timedCode {
+	audit("code", k.name)
+	recordUse(k.name)
+	c := timedCode{code: code}
+	if !k.hotp {
+		c.expires = k.stepEnd(now)
+	}
+	afterCode(k.name, code, c.expires)
+	return c
+}
+
+// codeExpires returns when the current code of k expires, or the zero
+// time for HOTP keys.
+func codeExpires(k keyInfo) time.Time {
+	if k.hotp {
+		return time.Time{}
+	}
+	return k.stepEnd(time.Now())
+}
+
+// stepEnd returns when the TOTP time step of k holding now ends.
+func (k keyInfo) stepEnd(now time.Time) time.Time {
+	period := int64(k.period)
+	if period <= 0 {
+		period = 30
+	}
+	t := now.Unix() - k.epoch
+	return time.Unix(k.epoch+(t/period+1)*period, 0)
+}
+
+// codeVars returns the variables holding code c: prefix,
+// and prefix_EXPIRES for TOTP codes.
+func codeVars(prefix string, c timedCode) [][2]string {
+	vars := [][2]string{{prefix, c.code}}
+	if !c.expires.IsZero() {
+		vars = append(vars, [2]string{prefix + "_EXPIRES", fmt.Sprint(c.expires.Unix())})
+	}
+	return vars
+}
+
+// isEnvName reports whether s can name an environment variable
+// in all supported shells.
+func isEnvName(s string) bool {
+	for i, r := range s {
+		if !(r == '_' || 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' || i > 0 && '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
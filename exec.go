@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+var cmdExec = &command{
+	name:  "exec",
+	usage: "exec [-prefix prefix] [-retry-status n] name command [arg...]",
+	short: "run a command with a code in its environment",
+	long: `Exec runs command with the current code of the named key in its
+environment, as OTP and, for TOTP keys, OTP_EXPIRES, the Unix time the
+code expires at (see "gauth help env"). -prefix names the variables
+instead of OTP. Exec exits with the status of the command.
+
+A code generated just before its time window ends may be rejected by
+the time the command uses it. With -retry-status, if the command exits
+with status n, meaning it was refused the code, exec waits for the
+next time window and runs the command once more with a fresh code.
+HOTP keys are retried with their next code right away.`,
+}
+
+var (
+	execPrefix = cmdExec.flags.String("prefix", "OTP", "name the variables `prefix` and prefix_EXPIRES")
+	execRetry  = cmdExec.flags.Int("retry-status", 0, "retry once with the next code if the command exits with status `n`")
+)
+
+func init() {
+	cmdExec.run = runExec
+	cmdExec.network = true
+	cmdExec.programs = always
+}
+
+func runExec(ctx context.Context, cmd *command, args []string) {
+	if len(args) < 2 {
+		cmd.usageExit()
+	}
+	if !isEnvName(*execPrefix) {
+		log.Fatalf("invalid variable name %q", *execPrefix)
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	name, argv := args[0], args[1:]
+	k, err := federation(openBackends()).resolve(ctx, name)
+	if err != nil {
+		fatal(exitNotFound, err)
+	}
+	c := currentCode(ctx, k)
+	status := run(argv, codeVars(*execPrefix, c))
+	if *execRetry != 0 && status == *execRetry {
+		if !k.hotp {
+			fmt.Fprintf(os.Stderr, "%s exited with status %d, retrying with the next code\n", argv[0], status)
+			select {
+			case <-time.After(time.Until(c.expires)):
+			case <-ctx.Done():
+			}
+		}
+		c = currentCode(ctx, k)
+		status = run(argv, codeVars(*execPrefix, c))
+	}
+	stop()
+	os.Exit(status)
+}
+
+// run runs argv with vars added to the environment
+// and returns its exit status.
+func run(argv []string, vars [][2]string) int {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = os.Environ()
+	for _, v := range vars {
+		cmd.Env = append(cmd.Env, v[0]+"="+v[1])
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return 0
+}
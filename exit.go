@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// gauth exits with a status telling scripts what went wrong:
+//
+//	1  any other error, or a misused command
+//	2  no such key, or a name matching several
+//	3  an invalid keychain, such as a corrupted encrypted one
+//	4  a clock which seems wrong, found by doctor
+//	5  a failed verification: a broken audit log, a keychain changed
+//	   outside gauth, or a code rejected by verify
+//	130  interrupted
+//
+// A few commands have statuses of their own for what they report:
+// paste, exec and agent ping and status (see their help).
+//
+// With -json-errors, given before the command, what gauth writes on
+// stderr through the log, errors and warnings, is written as JSON
+// objects instead, one per line (see "gauth schema error"):
+//
+//	{"schema":1,"kind":"not-found","status":2,"message":"no such key \"githb\"; did you mean github?"}
+
+// The exit statuses.
+const (
+	exitFailure         = 1
+	exitNotFound        = 2
+	exitInvalidKeychain = 3
+	exitClockSkew       = 4
+	exitVerifyFailed    = 5
+)
+
+// exitKinds name the exit statuses in JSON errors.
+var exitKinds = map[int]string{
+	exitFailure:         "error",
+	exitNotFound:        "not-found",
+	exitInvalidKeychain: "invalid-keychain",
+	exitClockSkew:       "clock-skew",
+	exitVerifyFailed:    "verify-failed",
+}
+
+// fatalStatus is the status of the error being logged by fatal.
+var fatalStatus int
+
+// A statusError is an error calling for an exit status other than 1,
+// returned where gauth mustn't exit, as in the agent.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e statusError) Error() string { return e.err.Error() }
+
+// exitOn is log.Fatal for err, exiting with its status.
+func exitOn(err error) {
+	status := exitFailure
+	if e, ok := err.(statusError); ok {
+		status = e.status
+	}
+	fatalStatus = status
+	log.Output(2, err.Error())
+	os.Exit(status)
+}
+
+// fatal is log.Fatal exiting with status.
+func fatal(status int, v ...interface{}) {
+	fatalStatus = status
+	log.Output(2, fmt.Sprint(v...))
+	os.Exit(status)
+}
+
+// fatalf is log.Fatalf exiting with status.
+func fatalf(status int, format string, v ...interface{}) {
+	fatalStatus = status
+	log.Output(2, fmt.Sprintf(format, v...))
+	os.Exit(status)
+}
+
+// An errorRecord is a message of gauth written with -json-errors.
+type errorRecord struct {
+	Schema  int    `json:"schema"`
+	Kind    string `json:"kind"`
+	Status  int    `json:"status,omitempty"`
+	Message string `json:"message"`
+}
+
+// jsonErrors is the log output with -json-errors.
+type jsonErrors struct{}
+
+func (jsonErrors) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(strings.TrimPrefix(string(p), "gauth: "), "\n")
+	r := errorRecord{Schema: schemaVersion, Kind: "error", Message: msg}
+	switch {
+	case fatalStatus != 0:
+		r.Kind, r.Status = exitKinds[fatalStatus], fatalStatus
+	case strings.HasPrefix(msg, "warning: "):
+		r.Kind, r.Message = "warning", strings.TrimPrefix(msg, "warning: ")
+	}
+	b, _ := json.Marshal(r)
+	if _, err := os.Stderr.Write(append(b, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+var cmdExport = &command{
+	name:  "export",
+	usage: "export [-o file | -encrypt file] [-shamir KofN] [name...] | export [-o file] -paper name | export [-o file.png] -qr name | export uris [-o file] [-force] [name...]",
+	short: "write keys as a keychain backup",
+	long: `Export writes the named keys, or all keys, in the keychain format,
+which "gauth import" reads back. The output contains the secrets
+in plain text: keep it as safe as the keychain itself.
+
+-encrypt writes the backup to file encrypted with a passphrase, which
+is asked for. The file is also protected against changes: import,
+which asks for the passphrase, refuses a modified backup. Such backups
+are suited to offline and off-site storage.
+
+-paper prints a backup of one key meant to be written down or printed:
+its secret spelled in words of the BIP 39 word list, which are easier
+to copy and type back than base32 and carry a checksum, along with the
+name and parameters of the key. "gauth import paper file" reads it back
+from the typed-in text.
+
+-qr shows one key as a QR code of its otpauth URI, for moving it to an
+authenticator app on a phone. It's drawn on the terminal, or written
+as a PNG image to the file given by -o or when stdout isn't a terminal.
+
+Export uris writes the named keys, or all keys, as otpauth URIs, one
+per line, for other tools to read or to enroll the keys elsewhere, as
+on an air-gapped machine. Keys which otpauth URIs can't describe, such
+as OCRA keys, are skipped with a warning. As the URIs hold the secrets
+in plain text, it asks for confirmation first, unless -force is given.
+
+-shamir KofN, as 3of5, splits the backup into N shares written to the
+files file.1 to file.N, named by -o or -encrypt. Any K of them recover
+the backup, with "gauth import -recover share...", while fewer reveal
+nothing about it. Combined with -encrypt, the encrypted backup is split,
+so recovering it also needs the passphrase.`,
+}
+
+var (
+	exportOut     = cmdExport.flags.String("o", "", "write to `file` instead of stdout")
+	exportEncrypt = cmdExport.flags.String("encrypt", "", "write a passphrase-encrypted backup to `file`")
+	exportPaper   = cmdExport.flags.Bool("paper", false, "write a paper backup of one key, spelled in words")
+	exportShamir  = cmdExport.flags.String("shamir", "", "split the backup into N shares, K of which recover it, given as `KofN`")
+	exportQR      = cmdExport.flags.Bool("qr", false, "show one key as a QR code for authenticator apps")
+	exportForce   = cmdExport.flags.Bool("force", false, "with uris, write the secrets without asking")
+)
+
+func init() {
+	cmdExport.run = runExport
+	cmdExport.writes = exportWrites
+}
+
+// exportWrites returns the files export writes, parsing the flags
+// which follow "uris" for the sandbox, as runExport does again.
+func exportWrites(args []string) []string {
+	if len(args) > 0 && args[0] == "uris" {
+		cmdExport.flags.Parse(args[1:])
+	}
+	return []string{*exportOut, *exportEncrypt}
+}
+
+func runExport(ctx context.Context, cmd *command, args []string) {
+	if len(args) > 0 && args[0] == "uris" {
+		// flags may also follow the subcommand
+		cmd.flags.Parse(args[1:])
+		if *exportEncrypt != "" || *exportPaper || *exportShamir != "" || *exportQR {
+			cmd.usageExit()
+		}
+		exportURIs(openKeychain(), cmd.flags.Args())
+		return
+	}
+	if *exportForce {
+		cmd.usageExit()
+	}
+	if *exportOut != "" && *exportEncrypt != "" || *exportPaper && (*exportEncrypt != "" || *exportShamir != "" || len(args) != 1) ||
+		*exportQR && (*exportPaper || *exportEncrypt != "" || *exportShamir != "" || len(args) != 1) {
+		cmd.usageExit()
+	}
+	var k, n int
+	if *exportShamir != "" {
+		var err error
+		if k, n, err = parseShamir(*exportShamir); err != nil {
+			log.Fatal(err)
+		}
+		if *exportOut == "" && *exportEncrypt == "" {
+			log.Fatal("-shamir needs the name of the share files, given by -o or -encrypt")
+		}
+	}
+	c := openKeychain()
+	names := args
+	if len(names) == 0 {
+		for name := range c.keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if _, ok := c.keys[name]; !ok {
+			fatalf(exitNotFound, "no such key %q", name)
+		}
+	}
+
+	if *exportQR {
+		exportQRCode(c, names[0])
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		k := c.keys[name]
+		if *exportPaper {
+			// Paper backups hold the secret itself.
+			if err := k.unlock(name); err != nil {
+				log.Fatal(err)
+			}
+			writePaper(&buf, name, k, k.count)
+			continue
+		}
+		fmt.Fprintln(&buf, formatKey(name, k, k.count))
+	}
+	data := buf.Bytes()
+	out := *exportOut
+	if *exportEncrypt != "" {
+		passphrase, err := readNewPassword("backup passphrase: ")
+		if err != nil {
+			log.Fatalf("reading passphrase: %v", err)
+		}
+		if passphrase == "" {
+			log.Fatal("empty passphrase")
+		}
+		if data, err = seal(data, passphrase); err != nil {
+			log.Fatalf("encrypting backup: %v", err)
+		}
+		out = *exportEncrypt
+	}
+	if *exportShamir != "" {
+		shares, err := split(data, k, n)
+		if err != nil {
+			log.Fatalf("splitting backup: %v", err)
+		}
+		for _, s := range shares {
+			writeBackup(fmt.Sprintf("%s.%d", out, s.x), []byte(s.String()))
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d shares to %s.1 to %s.%d; any %d of them recover the backup\n", n, out, out, n, k)
+		return
+	}
+	writeBackup(out, data)
+}
+
+// exportURIs writes the named keys, or all keys, as otpauth URIs.
+func exportURIs(c *Keychain, names []string) {
+	if len(names) == 0 {
+		for name := range c.keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if _, ok := c.keys[name]; !ok {
+			fatalf(exitNotFound, "no such key %q", name)
+		}
+	}
+	var buf bytes.Buffer
+	n := 0
+	for _, name := range names {
+		k := c.keys[name]
+		uri, err := otpauthURI(name, k, k.count)
+		if err != nil {
+			log.Printf("warning: skipping %s: %v", name, err)
+			continue
+		}
+		fmt.Fprintln(&buf, uri)
+		n++
+	}
+	if n == 0 {
+		log.Fatal("no keys to export")
+	}
+	if !*exportForce {
+		fmt.Fprintf(os.Stderr, "The URIs hold the secrets of %d keys in plain text: anyone who reads them can generate your codes.\n", n)
+		if !confirm("write them?") {
+			os.Exit(1)
+		}
+	}
+	writeBackup(*exportOut, buf.Bytes())
+	wipe(buf.Bytes())
+	if *exportOut != "" {
+		fmt.Fprintf(os.Stderr, "wrote %d keys to %s\n", n, *exportOut)
+	}
+}
+
+// exportQRCode shows key name as a QR code, or writes it as a PNG image.
+func exportQRCode(c *Keychain, name string) {
+	k := c.keys[name]
+	uri, err := otpauthURI(name, k, k.count)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	writeQRCode(uri, *exportOut)
+}
+
+// writeQRCode shows text as a QR code on the terminal, or writes it as
+// a PNG image to file, or to stdout if file is "" and isn't a terminal.
+func writeQRCode(text, file string) {
+	s, err := qr.encode(text)
+	if err != nil {
+		log.Fatalf("encoding QR code: %v", err)
+	}
+	if file == "" && isTerminal(os.Stdout.Fd()) {
+		if err := s.writeTerminal(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	var buf bytes.Buffer
+	if err := s.writePNG(&buf, 8); err != nil {
+		log.Fatalf("encoding QR code: %v", err)
+	}
+	writeBackup(file, buf.Bytes())
+}
+
+// writeBackup writes data to file, or to stdout if file is "".
+func writeBackup(file string, data []byte) {
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if file != "" {
+		var err error
+		f, err = os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalf("creating backup: %v", err)
+		}
+		// vital
+		f.Chmod(0600)
+		w = f
+	}
+	if _, err := w.Write(data); err != nil {
+		log.Fatalf("writing backup: %v", err)
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			log.Fatalf("closing backup: %v", err)
+		}
+	}
+}
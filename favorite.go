@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+var cmdFavorite = &command{
+	name:  "favorite",
+	usage: "favorite [-d] name...",
+	short: "mark keys as favorites",
+	long: `Favorite marks the named keys as favorites, or with -d unmarks them.
+List and show put favorites first when sorting with -sort favorites.`,
+}
+
+var favoriteDelete = cmdFavorite.flags.Bool("d", false, "unmark the keys")
+
+func init() {
+	cmdFavorite.run = runFavorite
+}
+
+func runFavorite(ctx context.Context, cmd *command, args []string) {
+	if len(args) == 0 {
+		cmd.usageExit()
+	}
+	c := openKeychain()
+	for _, name := range args {
+		if _, ok := c.keys[name]; !ok {
+			fatalf(exitNotFound, "no such key %q", name)
+		}
+	}
+	value := "yes"
+	if *favoriteDelete {
+		value = ""
+	}
+	c.update(func(c *Keychain) {
+		for _, name := range args {
+			k, ok := c.keys[name]
+			if !ok {
+				fatalf(exitNotFound, "no such key %q", name)
+			}
+			k.set("favorite", value)
+			c.lines[k.line] = formatKey(name, k, k.count)
+			c.keys[name] = k
+		}
+	})
+	for _, name := range args {
+		audit("favorite", name)
+	}
+	if *favoriteDelete {
+		fmt.Fprintf(os.Stderr, "unmarked %d keys\n", len(args))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "marked %d keys as favorites\n", len(args))
+}
+
+// sortOrders sort the keys listed by list and show, by name.
+// Ties are broken by key name.
+var sortOrders = map[string]func(keys []keyInfo) func(i, j int) bool{
+	"name": func(keys []keyInfo) func(i, j int) bool {
+		return func(i, j int) bool { return keys[i].name < keys[j].name }
+	},
+	"recent": func(keys []keyInfo) func(i, j int) bool {
+		used := readUsed()
+		return func(i, j int) bool {
+			ti, tj := used[keys[i].name], used[keys[j].name]
+			if !ti.Equal(tj) {
+				return ti.After(tj)
+			}
+			return keys[i].name < keys[j].name
+		}
+	},
+	"favorites": func(keys []keyInfo) func(i, j int) bool {
+		return func(i, j int) bool {
+			if keys[i].favorite != keys[j].favorite {
+				return keys[i].favorite
+			}
+			return keys[i].name < keys[j].name
+		}
+	},
+}
+
+// sortKeys sorts keys in order, or if it's "", in the order set by
+// the configuration's "sort" key, by name by default.
+func sortKeys(keys []keyInfo, order string) {
+	if order == "" {
+		order = conf.get("sort")
+	}
+	if order == "" {
+		order = "name"
+	}
+	less, ok := sortOrders[order]
+	if !ok {
+		log.Fatalf("unknown sort order %q (use name, recent or favorites)", order)
+	}
+	sort.SliceStable(keys, less(keys))
+}
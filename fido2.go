@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// fido2Protector wraps keys under the hmac-secret extension of CTAP2,
+// which any FIDO2 security key supports: the key computes an HMAC of a
+// salt with a secret bound to one of its credentials, and that HMAC
+// encrypts the key with AES-256-GCM. Wrapping makes a new credential for
+// the relying party "gauth"; both need a touch. The tools of libfido2,
+// fido2-token, fido2-cred and fido2-assert, talk to the security key:
+//
+//	encrypt = fido2 [device]
+//
+// The device, such as /dev/hidraw3, defaults to the first one found.
+//
+// The wrapped key is
+//
+//	credential ID length (2) | credential ID | salt (32) | nonce (12) | ciphertext
+type fido2Protector struct {
+	device string
+}
+
+const fido2RP = "gauth"
+
+func init() {
+	keyProtectors["fido2"] = newFIDO2Protector
+}
+
+func newFIDO2Protector(args []string) (keyProtector, error) {
+	switch len(args) {
+	case 0:
+		// Unwrapping, use the configured device.
+		if spec := encryptSpec(); len(spec) == 2 && spec[0] == "fido2" {
+			return fido2Protector{device: spec[1]}, nil
+		}
+		return fido2Protector{}, nil
+	case 1:
+		return fido2Protector{device: args[0]}, nil
+	}
+	return nil, errors.New("fido2 takes a device, such as /dev/hidraw3")
+}
+
+func (p fido2Protector) wrap(key []byte) ([]byte, error) {
+	dev, err := p.findDevice()
+	if err != nil {
+		return nil, err
+	}
+	userID := fido2Random(32)
+	if isTerminal(os.Stderr.Fd()) {
+		fmt.Fprintln(os.Stderr, "touch your security key to make a gauth credential")
+	}
+	out, err := fido2Tool("fido2-cred", []string{"-M", "-h", dev},
+		fido2Random(32), fido2RP, "gauth", userID)
+	if err != nil {
+		return nil, err
+	}
+	// client data hash, relying party, format, authenticator data,
+	// credential ID, ...
+	if len(out) < 5 {
+		return nil, errors.New("fido2-cred: unexpected output")
+	}
+	credID, err := base64.StdEncoding.DecodeString(out[4])
+	if err != nil || len(credID) > 0xffff {
+		return nil, errors.New("fido2-cred: invalid credential ID")
+	}
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	kek, err := fido2Secret(dev, credID, salt)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(kek)
+	ct, err := kekWrap(kek, key)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint16(len(credID)))
+	b.Write(credID)
+	b.Write(salt)
+	b.Write(ct)
+	return b.Bytes(), nil
+}
+
+func (p fido2Protector) unwrap(wrapped []byte) ([]byte, error) {
+	invalid := errors.New("invalid wrapped key")
+	if len(wrapped) < 2 {
+		return nil, invalid
+	}
+	n := int(binary.BigEndian.Uint16(wrapped))
+	if len(wrapped) < 2+n+32 {
+		return nil, invalid
+	}
+	credID, salt := wrapped[2:2+n], wrapped[2+n:2+n+32]
+	dev, err := p.findDevice()
+	if err != nil {
+		return nil, err
+	}
+	kek, err := fido2Secret(dev, credID, salt)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(kek)
+	key, err := kekUnwrap(kek, wrapped[2+n+32:])
+	if err != nil {
+		return nil, errors.New("the security key's secret doesn't decrypt the keychain")
+	}
+	return key, nil
+}
+
+// findDevice returns p.device, or the first security key found.
+func (p fido2Protector) findDevice() (string, error) {
+	if p.device != "" {
+		return p.device, nil
+	}
+	out, err := exec.Command("fido2-token", "-L").Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", errors.New("fido2-token not found (install the libfido2 tools)")
+	}
+	if err != nil {
+		return "", fmt.Errorf("fido2-token: %v", err)
+	}
+	// /dev/hidraw3: vendor=0x1050, product=0x0407 (Yubico YubiKey OTP+FIDO+CCID)
+	line, _, _ := bufio.NewReader(bytes.NewReader(out)).ReadLine()
+	if i := bytes.IndexByte(line, ':'); i > 0 {
+		return string(line[:i]), nil
+	}
+	return "", errors.New("no FIDO2 security key found")
+}
+
+// fido2Secret returns the hmac-secret of salt for the credential.
+func fido2Secret(dev string, credID, salt []byte) ([]byte, error) {
+	if isTerminal(os.Stderr.Fd()) {
+		fmt.Fprintln(os.Stderr, "touch your security key")
+	}
+	out, err := fido2Tool("fido2-assert", []string{"-G", "-h", dev},
+		fido2Random(32), fido2RP, base64.StdEncoding.EncodeToString(credID),
+		base64.StdEncoding.EncodeToString(salt))
+	if err != nil {
+		return nil, err
+	}
+	// The hmac-secret is the last line.
+	if len(out) < 5 {
+		return nil, errors.New("fido2-assert: unexpected output, does the key support hmac-secret?")
+	}
+	secret, err := base64.StdEncoding.DecodeString(out[len(out)-1])
+	if err != nil || len(secret) != 32 {
+		return nil, errors.New("fido2-assert: invalid hmac-secret")
+	}
+	return secret, nil
+}
+
+// fido2Random returns n random bytes in base64.
+func fido2Random(n int) string {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// fido2Tool runs a libfido2 tool with the input lines,
+// and returns the lines of its output.
+func fido2Tool(tool string, args []string, input ...string) ([]string, error) {
+	cmd := exec.Command(tool, args...)
+	cmd.Stdin = strings.NewReader(strings.Join(input, "\n") + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("%s not found (install the libfido2 tools)", tool)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", tool, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", tool, err)
+	}
+	return strings.Split(strings.TrimRight(string(out), "\n"), "\n"), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// FreeOTP+ backups are JSON:
+//
+//	{"tokens": [{"issuerExt": "GitHub", "label": "alice", "secret": [-12, 34, ...],
+//	  "algo": "SHA1", "digits": 6, "period": 30, "type": "TOTP", "counter": 0}]}
+//
+// FreeOTP keeps the same tokens in tokens.xml, an Android shared
+// preferences file holding one JSON token per <string> element.
+// Secrets are normally raw bytes, as signed Java bytes; some versions
+// and converters write them as base32 or hex strings instead.
+
+type freeOTPToken struct {
+	IssuerExt string
+	IssuerInt string
+	Label     string
+	Secret    json.RawMessage
+	Algo      string
+	Digits    int
+	Period    int
+	Type      string
+	Counter   uint64
+}
+
+// secret returns the base32 secret of t.
+func (t freeOTPToken) secret() (string, error) {
+	var s string
+	if err := json.Unmarshal(t.Secret, &s); err == nil {
+		if _, _, err := normalizeSecret(s); err == nil {
+			return s, nil
+		}
+		s, err := hexSecret(s)
+		if err != nil {
+			return "", errors.New("secret is neither base32 nor hex")
+		}
+		return s, nil
+	}
+	var b []int
+	if err := json.Unmarshal(t.Secret, &b); err != nil {
+		return "", errors.New("invalid secret")
+	}
+	raw := make([]byte, len(b))
+	for i, v := range b {
+		if v < -128 || v > 255 {
+			return "", errors.New("invalid secret")
+		}
+		raw[i] = byte(v)
+	}
+	return base32.StdEncoding.EncodeToString(raw), nil
+}
+
+func importFreeOTP(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []freeOTPToken
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		tokens, err = readFreeOTPXML(data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var backup struct {
+			Tokens []freeOTPToken
+		}
+		if err := json.Unmarshal(data, &backup); err != nil {
+			return nil, errors.New("not a FreeOTP backup")
+		}
+		tokens = backup.Tokens
+	}
+	var entries []entry
+	for _, t := range tokens {
+		issuer := t.IssuerExt
+		if issuer == "" {
+			issuer = t.IssuerInt
+		}
+		name := entryName(issuer, t.Label)
+		secret, err := t.secret()
+		if err != nil {
+			log.Printf("%s: skipped: %v", name, err)
+			continue
+		}
+		if t.Algo == "" {
+			t.Algo = "SHA1"
+		}
+		e, err := newEntry(name, secret, t.Type, t.Algo, t.Digits, t.Period, t.Counter)
+		if err != nil {
+			log.Printf("%s: skipped: %v", name, err)
+			continue
+		}
+		e.key.setIdentity(issuer, t.Label)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// readFreeOTPXML reads the tokens of FreeOTP's tokens.xml.
+func readFreeOTPXML(data []byte) ([]freeOTPToken, error) {
+	var prefs struct {
+		Strings []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"string"`
+	}
+	if err := xml.Unmarshal(data, &prefs); err != nil {
+		return nil, errors.New("not a FreeOTP tokens.xml")
+	}
+	var tokens []freeOTPToken
+	for _, s := range prefs.Strings {
+		if s.Name == "tokenOrder" {
+			continue
+		}
+		var t freeOTPToken
+		if err := json.Unmarshal([]byte(s.Value), &t); err != nil {
+			return nil, fmt.Errorf("token %s: %v", s.Name, err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
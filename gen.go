@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+)
+
+var cmdGen = &command{
+	name:  "gen",
+	usage: "gen [-bytes n] [-hotp] [-digits n] [-issuer issuer] [-account account] [-o file] name",
+	short: "generate a new key, as a service issuing it does",
+	long: `Gen generates a random secret, adds it to the keychain as key name,
+and prints its otpauth URI, preceded on a terminal by its QR code, so
+gauth can be the issuing side when setting up 2FA for a self-hosted
+service: scan the QR code with the phone, or hand the URI to the
+service, and check codes with "gauth verify".
+
+The secret has -bytes random bytes, 20 by default (160 bits, as RFC
+4226 recommends) and at least 16. -issuer and -account label the key
+in authenticator apps. -o writes the QR code as a PNG image to file
+instead of showing it. The URI holds the secret: keep it out of shell
+histories and logs.`,
+}
+
+var (
+	genBytes   = cmdGen.flags.Int("bytes", 20, "generate a secret of `n` bytes")
+	genHotp    = cmdGen.flags.Bool("hotp", false, "generate an HOTP (counter-based) key")
+	genDigits  = cmdGen.flags.Int("digits", 6, "give codes of `n` digits, 6 to 8")
+	genIssuer  = cmdGen.flags.String("issuer", "", "record `issuer` as the provider of the key")
+	genAccount = cmdGen.flags.String("account", "", "record `account` as the user of the key")
+	genOut     = cmdGen.flags.String("o", "", "write the QR code as a PNG image to `file`")
+)
+
+func init() {
+	cmdGen.run = runGen
+	cmdGen.writes = func([]string) []string { return []string{*genOut} }
+}
+
+func runGen(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 || *genDigits < 6 || *genDigits > 8 {
+		cmd.usageExit()
+	}
+	name := args[0]
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		log.Fatal("spaces aren't allowed")
+	}
+	if err := checkCommandName(name); err != nil {
+		log.Fatal(err)
+	}
+	c := openKeychain()
+	c.checkWritable()
+	if _, ok := c.keys[name]; ok {
+		log.Fatalf("key %q already exists", name)
+	}
+	k, counter := generateKey(*genBytes, *genDigits, *genHotp)
+	defer wipe(k.raw)
+	k.setIdentity(*genIssuer, *genAccount)
+	uri, err := otpauthURI(name, k, counter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.lines = append(c.lines, formatKey(name, k, counter))
+	c.save()
+	audit("add", name)
+	fmt.Fprintf(os.Stderr, "generated %s\n", name)
+	if *genOut != "" || isTerminal(os.Stdout.Fd()) {
+		writeQRCode(uri, *genOut)
+	}
+	fmt.Println(uri)
+}
+
+// generateKey returns a key with a random secret of n bytes, and the
+// counter to store it with, "" for TOTP keys.
+func generateKey(n, digits int, hotp bool) (Key, string) {
+	if n < 16 || n > 64 {
+		log.Fatalf("invalid secret length %d: give 16 to 64 bytes", n)
+	}
+	raw := make([]byte, n)
+	lockMemory(raw)
+	if _, err := rand.Read(raw); err != nil {
+		log.Fatalf("generating secret: %v", err)
+	}
+	k := Key{digits: digits, raw: raw, text: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)}
+	if hotp {
+		return k, strings.Repeat("0", counterLen)
+	}
+	return k, ""
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The "after-code" lines of the configuration are shell commands run
+// after each code gauth generates, printed, copied or handed to a
+// command, in the order given:
+//
+//	after-code = notify-send "2fa code for $GAUTH_NAME"
+//	after-code = logger -t gauth "code of $GAUTH_NAME used by $GAUTH_COMMAND"
+//
+// The code is written on the command's stdin and set in its environment
+// with the name of the key:
+//
+//	GAUTH_NAME     the name of the key
+//	GAUTH_CODE     the code
+//	GAUTH_EXPIRES  the Unix time the code expires at, "" for HOTP codes
+//	GAUTH_COMMAND  the gauth command, such as "show" or "paste"
+//
+// gauth waits for the commands, whose output goes to its stderr so as
+// not to mix with the codes printed; one which fails is reported and
+// the others still run. Like the other programs gauth runs, hooks
+// inherit its sandbox (see sandbox.go).
+
+// afterCode runs the after-code hooks for the code of key name,
+// expiring at expires unless it's zero.
+func afterCode(name, code string, expires time.Time) {
+	hooks := conf["after-code"]
+	if len(hooks) == 0 {
+		return
+	}
+	env := append(os.Environ(),
+		"GAUTH_NAME="+name,
+		"GAUTH_CODE="+code,
+		"GAUTH_COMMAND="+auditCommand)
+	if expires.IsZero() {
+		env = append(env, "GAUTH_EXPIRES=")
+	} else {
+		env = append(env, fmt.Sprintf("GAUTH_EXPIRES=%d", expires.Unix()))
+	}
+	for _, hook := range hooks {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", hook)
+		} else {
+			cmd = exec.Command("/bin/sh", "-c", hook)
+		}
+		cmd.Env = env
+		cmd.Stdin = strings.NewReader(code + "\n")
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("warning: after-code hook %q: %v", hook, err)
+		}
+	}
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var cmdImport = &command{
+	name:  "import",
+	usage: "import [-dry-run] [-prefix prefix] [-key-file file | -map map] [format] file | import [-dry-run] -scan dir | import [-dry-run] -recover share...",
+	short: "import keys from a backup or another authenticator",
+	long: `Import merges keys from a keychain backup, such as one written by
+"gauth export", encrypted or not, or from the export file of another authenticator
+app given its format:
+
+	aegis   Aegis Authenticator backup, plain or encrypted
+	andotp  andOTP backup, plain or password-encrypted
+	authy   Authy tokens recovered by authy-export, as otpauth URIs
+	        or JSON
+	freeotp FreeOTP+ JSON backup or FreeOTP tokens.xml
+	bitwarden
+	        Bitwarden JSON export (unencrypted)
+	1password
+	        1Password 1PUX or CSV export
+	keepass KeePass database, opened with keepassxc-cli, or its XML
+	        export; -key-file gives the key file of the database
+	2fas    2FAS Authenticator backup (without password)
+	2fa     the keychain of rsc/2fa, $HOME/.2fa, checked line by line:
+	        every line skipped is reported with the reason, and
+	        counters padded to the wrong length are fixed
+	otpauth text file of otpauth URIs, one per line, such as a WinAuth
+	        export, or of Google Authenticator otpauth-migration URIs
+	paper   paper backup written by "gauth export -paper", typed back
+	        in a file, or given on stdin as file -
+	csv     CSV file of any source, with the columns given by -map;
+	        see below
+
+-prefix puts prefix before the names of the imported keys, so keys
+from another keychain can be told apart, as "gauth import -prefix
+old- 2fa ~/.2fa" does.
+
+Keys already present are skipped. An entry whose name is taken by a
+different key is imported under the name with a suffix, as name-2.
+If an imported entry has the same
+secret as an existing key but different parameters (digits, period,
+algorithm or type changed by the provider), gauth reports it and
+offers to update the existing key.
+
+CSV files are read with a column map such as
+
+	gauth import -map name=1,secret=3,digits=4 csv seeds.csv
+
+naming the columns of the fields name (or issuer and account), secret,
+digits, period, algorithm, type (totp or hotp), counter and url. Columns
+are numbered from 1, or named by the header row, as in name=Title.
+Without -map, the header has to use the field names. The secret may be
+an otpauth URI. Invalid rows are reported with their line and skipped.
+
+With -recover, import combines the share files of a backup split by
+"gauth export -shamir" and imports the recovered backup. At least as
+many shares as the split's threshold are needed.
+
+With -scan, import searches the directory dir and its subdirectories
+for export files of the formats above, lists them with the number of
+keys they hold, and offers to import all of them at once. The
+passwords of encrypted files are asked for after confirming.
+
+With -dry-run, import only lists what it would do.`,
+}
+
+var (
+	importDryRun  = cmdImport.flags.Bool("dry-run", false, "list what would be imported without changing the keychain")
+	importScan    = cmdImport.flags.String("scan", "", "find and import the export files in `dir`")
+	importKeyFile = cmdImport.flags.String("key-file", "", "open KeePass databases with key `file`")
+	importMap     = cmdImport.flags.String("map", "", "read CSV files with the column `map`, as name=1,secret=3")
+	importRecover = cmdImport.flags.Bool("recover", false, "recover a backup split by export -shamir from the share files")
+	importPrefix  = cmdImport.flags.String("prefix", "", "put `prefix` before the names of the imported keys")
+)
+
+func init() {
+	cmdImport.run = runImport
+	cmdImport.programs = always
+}
+
+// An entry is a key read from a backup or another app's export.
+type entry struct {
+	name    string // suggested key name
+	key     Key
+	counter string // HOTP counter, "" for TOTP keys
+}
+
+// importers read export files, by format name.
+// Slow importers stop when ctx is cancelled.
+var importers = map[string]func(ctx context.Context, file string) ([]entry, error){
+	"gauth":     importKeychain,
+	"aegis":     importAegis,
+	"andotp":    importAndOTP,
+	"authy":     importAuthy,
+	"freeotp":   importFreeOTP,
+	"bitwarden": importBitwarden,
+	"1password": importOnePassword,
+	"keepass":   importKeePass,
+	"2fas":      importTwoFAS,
+	"otpauth":   importOtpauth,
+	"paper":     importPaper,
+	"csv":       importCSV,
+}
+
+func runImport(ctx context.Context, cmd *command, args []string) {
+	if strings.IndexFunc(*importPrefix, unicode.IsSpace) >= 0 {
+		log.Fatal("spaces aren't allowed")
+	}
+	if *importScan != "" {
+		if len(args) != 0 {
+			cmd.usageExit()
+		}
+		ctx, stop := interruptible(ctx)
+		defer stop()
+		runScan(ctx, *importScan)
+		return
+	}
+	if *importRecover {
+		if len(args) == 0 {
+			cmd.usageExit()
+		}
+		var shares []share
+		for _, file := range args {
+			s, err := readShare(file)
+			if err != nil {
+				log.Fatal(err)
+			}
+			shares = append(shares, s)
+		}
+		data, err := combine(shares)
+		if err != nil {
+			log.Fatalf("recovering backup: %v", err)
+		}
+		entries, err := keychainEntries("recovered backup", data)
+		if err != nil {
+			log.Fatalf("recovering backup: %v", err)
+		}
+		ctx, stop := interruptible(ctx)
+		defer stop()
+		openKeychain().merge(ctx, entries)
+		return
+	}
+	format := "gauth"
+	switch len(args) {
+	case 1:
+	case 2:
+		format = args[0]
+		args = args[1:]
+	default:
+		cmd.usageExit()
+	}
+	imp, ok := importers[format]
+	if !ok {
+		log.Fatalf("unknown import format %q", format)
+	}
+	if args[0] != "-" || format != "paper" {
+		if _, err := os.Stat(args[0]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	entries, err := imp(ctx, args[0])
+	checkInterrupted(ctx, "import interrupted, keychain unchanged")
+	if err != nil {
+		log.Fatalf("importing %s: %v", args[0], err)
+	}
+	openKeychain().merge(ctx, entries)
+}
+
+// importKeychain reads a keychain backup, asking for the passphrase
+// of encrypted ones.
+func importKeychain(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return keychainEntries(file, data)
+}
+
+// keychainEntries reads the keys of keychain data,
+// decrypting encrypted backups and keychains.
+func keychainEntries(file string, data []byte) ([]entry, error) {
+	if isEncryptedKeychain(data) {
+		var err error
+		if _, data, err = decryptKeychain(data); err != nil {
+			return nil, err
+		}
+		defer wipe(data)
+	}
+	if isSealed(data) {
+		passphrase, err := readPassword("backup passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if data, err = unseal(data, passphrase); err != nil {
+			return nil, err
+		}
+		lockMemory(data)
+		defer wipe(data)
+	}
+	b := parseKeychain(file, data)
+	var entries []entry
+	for name, k := range b.keys {
+		entries = append(entries, entry{name: name, key: k, counter: k.count})
+	}
+	return entries, nil
+}
+
+// importOtpauth reads a text file of otpauth URIs.
+func importOtpauth(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return importURIs(data), nil
+}
+
+// importURIs reads otpauth URIs, one per line, as well as the
+// otpauth-migration URIs of Google Authenticator exports.
+// Invalid lines are reported and skipped.
+func importURIs(data []byte) []entry {
+	var entries []entry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(line, "otpauth-migration:") {
+			keys, errs, err := parseMigration(line)
+			if err != nil {
+				log.Printf("line %d: skipped: %v", i+1, err)
+			}
+			for _, err := range errs {
+				log.Printf("line %d: %v, skipped", i+1, err)
+			}
+			entries = append(entries, keys...)
+			continue
+		}
+		o, err := parseOtpauth(line)
+		if err != nil {
+			log.Printf("line %d: skipped: %v", i+1, err)
+			continue
+		}
+		name := entryName(o.issuer, o.account)
+		e, err := newEntry(name, o.secret, o.typ, o.algorithm, o.digits, o.period, o.counter)
+		if err != nil {
+			log.Printf("%s: skipped: %v", name, err)
+			continue
+		}
+		e.key.setIdentity(o.issuer, o.account)
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// entryName makes a key name from the issuer and account of an entry.
+func entryName(issuer, account string) string {
+	name := issuer
+	if account != "" && !strings.EqualFold(account, issuer) {
+		if name != "" {
+			name += "-"
+		}
+		name += account
+	}
+	return strings.Join(strings.FieldsFunc(name, unicode.IsSpace), "_")
+}
+
+// newEntry validates the parameters of an imported key.
+// Its secret is base32; the counter is used for HOTP keys only.
+func newEntry(name, secret, typ, algorithm string, digits, period int, counter uint64) (entry, error) {
+	e := entry{name: name}
+	if name == "" {
+		return e, fmt.Errorf("entry has no name")
+	}
+	typ = strings.ToLower(typ)
+	if typ != "totp" && typ != "hotp" {
+		return e, fmt.Errorf("unsupported key type %s", typ)
+	}
+	var err error
+	e.key.text, e.key.raw, err = normalizeSecret(secret)
+	if err != nil {
+		return e, err
+	}
+	if digits < 6 || digits > 8 {
+		return e, fmt.Errorf("unsupported number of digits %d", digits)
+	}
+	e.key.digits = digits
+	algorithm = strings.ToUpper(algorithm)
+	if _, ok := hashes[algorithm]; !ok {
+		return e, fmt.Errorf("unsupported algorithm %s", algorithm)
+	}
+	e.key.setAlgorithm(algorithm)
+	if typ == "hotp" {
+		e.counter = fmt.Sprintf("%0*d", counterLen, counter)
+		return e, nil
+	}
+	if period <= 0 {
+		return e, fmt.Errorf("invalid period %d", period)
+	}
+	e.key.setPeriod(period)
+	return e, nil
+}
+
+// paramChanges describes how the parameters of entry e differ
+// from those of key k.
+func paramChanges(k Key, hotp bool, e entry) []string {
+	var changes []string
+	if k.digits != e.key.digits {
+		changes = append(changes, fmt.Sprintf("digits %d -> %d", k.digits, e.key.digits))
+	}
+	if eHotp := e.counter != ""; hotp != eHotp {
+		typ := map[bool]string{false: "TOTP", true: "HOTP"}
+		changes = append(changes, fmt.Sprintf("type %s -> %s", typ[hotp], typ[eHotp]))
+	} else if !hotp && k.period() != e.key.period() {
+		changes = append(changes, fmt.Sprintf("period %ds -> %ds", k.period(), e.key.period()))
+	}
+	if k.algorithm() != e.key.algorithm() {
+		changes = append(changes, fmt.Sprintf("algorithm %s -> %s", k.algorithm(), e.key.algorithm()))
+	}
+	for _, a := range codeAttrs {
+		was, now := k.attr(a), e.key.attr(a)
+		switch {
+		case was == now, a == "t0" && k.t0() == e.key.t0():
+		case a == "yandex":
+			changes = append(changes, "PIN") // not shown, it's a secret
+		default:
+			changes = append(changes, fmt.Sprintf("%s %q -> %q", a, was, now))
+		}
+	}
+	return changes
+}
+
+// codeAttrs are the attributes other than the digits, period and
+// algorithm which change the codes of a key, and which an import may
+// update. The skew isn't one: it's measured here, not given by the
+// provider.
+var codeAttrs = []string{"t0", "transform", "ocra", "yandex"}
+
+// addsIdentity reports whether entry e names the issuer or account
+// of key k, which has neither.
+func addsIdentity(k Key, e entry) bool {
+	return k.attr("issuer") == "" && k.attr("account") == "" &&
+		(e.key.attr("issuer") != "" || e.key.attr("account") != "")
+}
+
+// describe summarizes the parameters of a key.
+func describe(k Key, counter string) string {
+	if counter != "" {
+		n, _ := strconv.ParseUint(counter, 10, 64)
+		return fmt.Sprintf("HOTP %d digits %s counter %d", k.digits, k.algorithm(), n)
+	}
+	return fmt.Sprintf("TOTP %d digits %s %ds", k.digits, k.algorithm(), k.period())
+}
+
+// merge adds imported entries to the keychain.
+// Entries whose secret is already present are not duplicated; if their
+// parameters changed, the user is asked whether to update them. Their
+// issuer and account are filled in if the keychain lacks them.
+// The keychain is written once all entries are merged, so an
+// interrupted merge leaves it unchanged; the changes are then made to
+// the keychain as it is under the lock, and the merge fails if the
+// keys it changes were changed meanwhile.
+func (c *Keychain) merge(ctx context.Context, entries []entry) {
+	for i := range entries {
+		entries[i].name = *importPrefix + entries[i].name
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	added, updated, renamed, present := 0, 0, 0, 0
+	newNames := make(map[string]bool)
+	issuers := c.issuers()
+	newSecrets := make(map[string]bool)
+	var events [][2]string // audit records, written after saving
+	var changes []func(c *Keychain)
+	for _, e := range entries {
+		e := e
+		checkInterrupted(ctx, "import interrupted, keychain unchanged")
+		if newSecrets[string(e.key.raw)] {
+			continue
+		}
+		if have, ok := c.findSecret(e.key.raw); ok {
+			k := c.keys[have]
+			params := paramChanges(k, k.offset != 0, e)
+			if len(params) == 0 && addsIdentity(k, e) {
+				// a key imported before issuers and accounts were kept
+				if *importDryRun {
+					fmt.Printf("update\t%s\tissuer and account\n", have)
+					continue
+				}
+				changes = append(changes, func(c *Keychain) {
+					k := c.mergeTarget(have, e)
+					k.setIdentity(e.key.attr("issuer"), e.key.attr("account"))
+					c.lines[k.line] = formatKey(have, k, k.count)
+				})
+				events = append(events, [2]string{"update", have})
+				updated++
+				continue
+			}
+			if len(params) == 0 {
+				present++
+				continue
+			}
+			if *importDryRun {
+				fmt.Printf("update\t%s\t%s\n", have, strings.Join(params, ", "))
+				continue
+			}
+			log.Printf("%s: imported entry %q has the same secret but different parameters: %s",
+				have, e.name, strings.Join(params, ", "))
+			if !confirm(fmt.Sprintf("update parameters of %s?", have)) {
+				continue
+			}
+			changes = append(changes, func(c *Keychain) {
+				k := c.mergeTarget(have, e)
+				counter := k.count
+				if e.counter == "" {
+					counter = ""
+				} else if counter == "" {
+					counter = e.counter
+				}
+				k.digits = e.key.digits
+				k.setPeriod(e.key.period())
+				k.setAlgorithm(e.key.algorithm())
+				for _, a := range codeAttrs {
+					k.set(a, e.key.attr(a))
+				}
+				c.lines[k.line] = formatKey(have, k, counter)
+			})
+			events = append(events, [2]string{"update", have})
+			updated++
+			continue
+		}
+		if name := c.freeName(e.name, newNames); name != e.name {
+			if checkCommandName(e.name) != nil {
+				log.Printf("%s: name of a gauth command, imported as %s", e.name, name)
+			} else {
+				log.Printf("%s: name already used by a different key, imported as %s", e.name, name)
+			}
+			e.name = name
+			renamed++
+		}
+		newNames[e.name] = true
+		newSecrets[string(e.key.raw)] = true
+		warnName(e.name, issuers)
+		issuers[e.name] = e.key.attr("issuer")
+		if *importDryRun {
+			fmt.Printf("add\t%s\t%s\n", e.name, describe(e.key, e.counter))
+			continue
+		}
+		changes = append(changes, func(c *Keychain) {
+			if _, ok := c.keys[e.name]; ok {
+				log.Fatalf("%s was added to the keychain during the import: import again", e.name)
+			}
+			if have, ok := c.findSecret(e.key.raw); ok {
+				log.Fatalf("%s, with the secret of %s, was added to the keychain during the import: import again", have, e.name)
+			}
+			c.lines = append(c.lines, formatKey(e.name, e.key, e.counter))
+		})
+		events = append(events, [2]string{"import", e.name})
+		added++
+	}
+	if *importDryRun {
+		return
+	}
+	checkInterrupted(ctx, "import interrupted, keychain unchanged")
+	if len(changes) > 0 {
+		c.update(func(c *Keychain) {
+			for _, change := range changes {
+				change(c)
+			}
+		})
+	}
+	for _, ev := range events {
+		audit(ev[0], ev[1])
+	}
+	fmt.Fprintf(os.Stderr, "imported %d new keys, updated %d", added, updated)
+	if renamed > 0 {
+		fmt.Fprintf(os.Stderr, ", renamed %d", renamed)
+	}
+	if present > 0 {
+		fmt.Fprintf(os.Stderr, ", %d already present", present)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// mergeTarget returns key have of c, which merge changes for entry e,
+// failing if it's no longer the key of e's secret.
+func (c *Keychain) mergeTarget(have string, e entry) Key {
+	if name, ok := c.findSecret(e.key.raw); !ok || name != have {
+		log.Fatalf("%s was changed in the keychain during the import: import again", have)
+	}
+	return c.keys[have]
+}
+
+// freeName returns name, or if it's taken by an existing key, by
+// another imported entry or by a gauth command, name with the first
+// free suffix -2, -3 ...
+func (c *Keychain) freeName(name string, taken map[string]bool) string {
+	used := func(n string) bool {
+		_, ok := c.keys[n]
+		return ok || taken[n] || lookup(n) != nil
+	}
+	if !used(name) {
+		return name
+	}
+	for i := 2; ; i++ {
+		if n := fmt.Sprintf("%s-%d", name, i); !used(n) {
+			return n
+		}
+	}
+}
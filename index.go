@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The index of a keychain file, next to it in file.index, tells where
+// the line of each key starts, so that the code of one key of a
+// keychain of hundreds is read from its line alone. It holds lines
+//
+//	gauth-index 2
+//	size <n> <mtime> size and modification time, in nanoseconds,
+//	                 of the keychain indexed
+//	<name> <offset>  offset of the line of each key
+//
+// The index is rewritten with the keychain, and stamped anew when HOTP
+// counters are written in place. An index of a keychain of
+// another size or time, changed outside gauth, or whose offset doesn't
+// start the line of its key, is stale: the keychain is then read whole,
+// and the index written anew. A key the index hasn't is looked up in
+// the whole keychain too. Encrypted keychains have no index, which
+// would give their names away.
+const indexHeader = "gauth-index 2"
+
+func indexPath(keychain string) string {
+	return keychain + ".index"
+}
+
+// writeIndex writes the index of c, or removes the index if c can't
+// have one. Failing to is no error: lookups then read the keychain.
+func (c *Keychain) writeIndex() {
+	if c.memory || c.indexed {
+		return
+	}
+	file := indexPath(c.file)
+	if c.encrypted() || len(c.keys) == 0 {
+		os.Remove(file)
+		return
+	}
+	fi, err := os.Stat(c.file)
+	if err != nil {
+		return
+	}
+	starts := make([]int64, len(c.lines))
+	var offset int64
+	for i, line := range c.lines {
+		starts[i] = offset
+		offset += int64(len(line)) + 1
+	}
+	var names []string
+	for name := range c.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n%s\n", indexHeader, indexStamp(fi))
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s %d\n", name, starts[c.keys[name].line])
+	}
+	writeKeychainFile(file, buf.Bytes())
+}
+
+// indexStamp returns the line of the index telling which keychain,
+// described by fi, it indexes.
+func indexStamp(fi os.FileInfo) string {
+	return fmt.Sprintf("size %d %d", fi.Size(), fi.ModTime().UnixNano())
+}
+
+// restampIndex stamps the index of keychain file, indexing it as it
+// was before, described by was, as indexing it now, described by now,
+// after counters were written in place, which moves no line. A stale
+// index is left stale.
+func restampIndex(file string, was, now os.FileInfo) {
+	index := indexPath(file)
+	data, err := ioutil.ReadFile(index)
+	if err != nil {
+		return
+	}
+	lines := strings.SplitAfterN(string(data), "\n", 3)
+	if len(lines) < 3 || lines[0] != indexHeader+"\n" || lines[1] != indexStamp(was)+"\n" {
+		return
+	}
+	writeKeychainFile(index, []byte(lines[0]+indexStamp(now)+"\n"+lines[2]))
+}
+
+// readIndexed reads key name of keychain file through the index. It
+// returns a keychain of that key alone, with no lines to rewrite it,
+// or nil if the keychain has no such key. known is false if the index
+// is missing or stale.
+func readIndexed(file, name string) (c *Keychain, known bool) {
+	offset, known := indexOffset(file, name)
+	if !known || offset < 0 {
+		return nil, known
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	start := offset
+	if start > 0 {
+		// The byte before ends the previous line.
+		start--
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return nil, false
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, false
+	}
+	lockMemory(line)
+	defer wipe(line)
+	if offset > 0 {
+		if len(line) == 0 || line[0] != '\n' {
+			return nil, false
+		}
+		line = line[1:]
+	}
+	key, k, err := parseLine(bytes.TrimSuffix(line, []byte("\n")))
+	if err != nil || key != name {
+		return nil, false
+	}
+	if k.offset != 0 {
+		k.offset += int(offset)
+	}
+	return &Keychain{file: file, keys: map[string]Key{name: k}, indexed: true}, true
+}
+
+// indexOffset returns the offset of the line of key name in keychain
+// file, or -1 if the index has no such key. known is false if the
+// index is missing or stale.
+func indexOffset(file, name string) (offset int64, known bool) {
+	data, err := ioutil.ReadFile(indexPath(file))
+	if err != nil {
+		return 0, false
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return 0, false
+	}
+	lines := strings.Split(string(data), "\n")
+	if len(lines) < 2 || lines[0] != indexHeader || lines[1] != indexStamp(fi) {
+		return 0, false
+	}
+	for _, line := range lines[2:] {
+		f := strings.Fields(line)
+		if len(f) != 2 || f[0] != name {
+			continue
+		}
+		offset, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil || offset < 0 {
+			return 0, false
+		}
+		return offset, true
+	}
+	return -1, true
+}
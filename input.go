@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// A secretSource obtains the secret of a new key: either the secret
+// itself or an otpauth URI carrying it along with its parameters.
+// Whatever the source, the text goes through parseSecret.
+type secretSource interface {
+	readSecret(name string) (string, error)
+}
+
+// promptSource asks for the secret on the terminal. Unless show is set,
+// the typed secret isn't echoed and has to be entered twice.
+type promptSource struct {
+	show bool
+}
+
+func (p promptSource) readSecret(name string) (string, error) {
+	prompt := fmt.Sprintf("gauth key for %s: ", name)
+	if p.show || !isTerminal(os.Stdin.Fd()) {
+		fmt.Fprint(os.Stderr, prompt)
+		return stdin.ReadString('\n')
+	}
+	text, err := readHidden(prompt)
+	if err != nil {
+		return "", err
+	}
+	again, err := readHidden("confirm key: ")
+	if err != nil {
+		return "", err
+	}
+	if strings.ToUpper(strings.Map(checkSpace, text)) != strings.ToUpper(strings.Map(checkSpace, again)) {
+		return "", fmt.Errorf("keys don't match")
+	}
+	return text, nil
+}
+
+// errNoPrompt, once set, is returned by readPassword instead of
+// asking: the agent mustn't wait on a prompt while serving a request.
+var errNoPrompt error
+
+// readPassword prompts for a password, hiding it on a terminal.
+func readPassword(prompt string) (string, error) {
+	if errNoPrompt != nil {
+		return "", errNoPrompt
+	}
+	var text string
+	var err error
+	if isTerminal(os.Stdin.Fd()) {
+		text, err = readHidden(prompt)
+	} else {
+		fmt.Fprint(os.Stderr, prompt)
+		text, err = stdin.ReadString('\n')
+	}
+	if err != nil && text == "" {
+		return "", err
+	}
+	return strings.TrimRight(text, "\r\n"), nil
+}
+
+// readNewPassword asks for a new password. On a terminal it has to be
+// typed twice.
+func readNewPassword(prompt string) (string, error) {
+	text, err := readPassword(prompt)
+	if err != nil || !isTerminal(os.Stdin.Fd()) {
+		return text, err
+	}
+	again, err := readPassword("confirm: ")
+	if err != nil {
+		return "", err
+	}
+	if text != again {
+		return "", fmt.Errorf("passwords don't match")
+	}
+	return text, nil
+}
+
+// readHidden prompts for a line of terminal input without echoing it.
+func readHidden(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	restore, err := noEcho(os.Stdin.Fd())
+	if err != nil {
+		return "", err
+	}
+	// Restore the echo if interrupted.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	done := make(chan bool)
+	go func() {
+		select {
+		case <-sig:
+			restore()
+			fmt.Fprintln(os.Stderr)
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+	text, err := stdin.ReadString('\n')
+	close(done)
+	signal.Stop(sig)
+	restore()
+	fmt.Fprintln(os.Stderr)
+	return text, err
+}
+
+// stdinSource reads the secret from standard input without prompting.
+type stdinSource struct{}
+
+func (stdinSource) readSecret(string) (string, error) {
+	data, err := ioutil.ReadAll(stdin)
+	return wipeString(data), err
+}
+
+// fileSource reads the secret from a file.
+type fileSource string
+
+func (f fileSource) readSecret(string) (string, error) {
+	data, err := ioutil.ReadFile(string(f))
+	return wipeString(data), err
+}
+
+// clipboardSource takes the secret from the clipboard
+// and clears the clipboard right away.
+type clipboardSource struct{}
+
+func (clipboardSource) readSecret(string) (string, error) {
+	text, err := readClipboard()
+	if err != nil {
+		return "", err
+	}
+	if err := clearClipboard(); err != nil {
+		log.Printf("warning: clearing clipboard: %v", err)
+	}
+	return text, nil
+}
+
+// qrImageSource decodes a QR code image.
+type qrImageSource string
+
+func (f qrImageSource) readSecret(string) (string, error) {
+	return decodeQRFile(string(f))
+}
+
+// qrScreenSource decodes a QR code shown on the screen.
+type qrScreenSource struct{}
+
+func (qrScreenSource) readSecret(string) (string, error) {
+	return scanScreen()
+}
+
+// cameraSource decodes a QR code shown to the camera.
+type cameraSource struct{}
+
+func (cameraSource) readSecret(string) (string, error) {
+	return scanCamera()
+}
+
+// commandSource runs a shell command and takes the secret from its
+// output, so the secret never appears on the terminal or in the history.
+type commandSource string
+
+func (c commandSource) readSecret(string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", string(c))
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", string(c))
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("running %q: %v", string(c), err)
+	}
+	return wipeString(out), nil
+}
+
+// parseSecret validates and normalizes the text read from a secretSource.
+// It returns the key and, for HOTP keys, its initial counter.
+func parseSecret(text string) (Key, string, error) {
+	var k Key
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "otpauth://") {
+		o, err := parseOtpauth(text)
+		if err != nil {
+			return k, "", err
+		}
+		if _, ok := hashes[o.algorithm]; !ok {
+			return k, "", fmt.Errorf("unsupported algorithm %s", o.algorithm)
+		}
+		if o.digits < 6 || o.digits > 8 {
+			return k, "", fmt.Errorf("unsupported number of digits %d", o.digits)
+		}
+		k.setAlgorithm(o.algorithm)
+		k.setPeriod(o.period)
+		k.setIdentity(o.issuer, o.account)
+		if o.t0 != 0 {
+			k.set("t0", strconv.FormatInt(o.t0, 10))
+		}
+		k.digits = o.digits
+		text = o.secret
+		var counter string
+		if o.typ == "hotp" {
+			counter = fmt.Sprintf("%0*d", counterLen, o.counter)
+		}
+		k.text, k.raw, err = normalizeSecret(text)
+		return k, counter, err
+	}
+	var err error
+	k.digits = 6
+	k.text, k.raw, err = normalizeSecret(text)
+	return k, "", err
+}
+
+// normalizeSecret decodes a base32 secret, ignoring case, padding and
+// the spaces and dashes which group its letters, and returns it in the
+// form it's stored in: upper case, without padding.
+func normalizeSecret(text string) (string, []byte, error) {
+	text = strings.Map(func(r rune) rune {
+		if r == '-' {
+			return -1
+		}
+		return checkSpace(r)
+	}, text)
+	raw, err := decodeKey(text)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid key: %v", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw), raw, nil
+}
+
+// hexSecret converts a hex secret, as some vendors hand out, to base32.
+// Spaces, colons and a 0x prefix are ignored.
+func hexSecret(text string) (string, error) {
+	text = strings.Map(func(r rune) rune {
+		if r == ':' {
+			return -1
+		}
+		return checkSpace(r)
+	}, text)
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X")
+	raw, err := hex.DecodeString(text)
+	if err != nil || len(raw) == 0 {
+		return "", errors.New("invalid hex key")
+	}
+	defer wipe(raw)
+	return base32.StdEncoding.EncodeToString(raw), nil
+}
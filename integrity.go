@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var cmdIntegrity = &command{
+	name:  "integrity",
+	usage: "integrity init | verify | update",
+	short: "detect changes made to the keychain outside gauth",
+	long: `If the configuration sets "integrity = keyring" or "integrity =
+passphrase", gauth keeps a MAC of every key of the keychain in
+$HOME/.gauth.mac, updates it whenever it changes the keychain, and
+checks it whenever it reads the keychain. A key changed, added or
+removed by anything else is reported, and gauth refuses to go on.
+
+The MAC key is kept in the system keyring (secret-tool or the macOS
+keychain), or derived from a passphrase which is asked for each time.
+HOTP counters aren't covered, since they change with every code.
+
+Integrity init creates the MAC key and the MACs of the keys now in
+the keychain. Integrity verify checks the keychain and reports what
+changed. Integrity update accepts the keychain as it is, after
+editing it by hand.`,
+}
+
+func init() {
+	cmdIntegrity.run = runIntegrity
+}
+
+// The MAC file holds lines
+//
+//	gauth-integrity 1
+//	salt <base64>       (passphrase mode)
+//	check <base64>      MAC of a fixed text, which tells a wrong key
+//	key <name> <base64> MAC of each key, without its counter
+//	all <base64>        MAC of the lines above
+const integrityHeader = "gauth-integrity 1"
+
+// integrityKeyName names the MAC key of the profile in use in the keyring.
+func integrityKeyName() string {
+	return "integrity" + profileSuffix()
+}
+
+func integrityMode() string {
+	return conf.get("integrity")
+}
+
+func integrityPath(keychain string) string {
+	return keychain + ".mac"
+}
+
+// A macFile is the parsed MAC file of a keychain.
+type macFile struct {
+	salt  []byte
+	check []byte
+	keys  map[string][]byte
+	all   []byte
+	body  []byte // the lines covered by all
+}
+
+func readMACFile(file string) (*macFile, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &macFile{keys: make(map[string][]byte)}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; s.Scan(); lineno++ {
+		line := s.Text()
+		f := strings.Fields(line)
+		bad := fmt.Errorf("%s:%d: invalid line", file, lineno)
+		if lineno == 1 {
+			if line != integrityHeader {
+				return nil, fmt.Errorf("%s: not a gauth integrity file", file)
+			}
+		} else if len(f) == 2 || len(f) == 3 && f[0] == "key" {
+			v, err := base64.StdEncoding.DecodeString(f[len(f)-1])
+			if err != nil {
+				return nil, bad
+			}
+			switch f[0] {
+			case "salt":
+				m.salt = v
+			case "check":
+				m.check = v
+			case "key":
+				m.keys[f[1]] = v
+			case "all":
+				m.all = v
+				continue
+			default:
+				return nil, bad
+			}
+		} else {
+			return nil, bad
+		}
+		m.body = append(m.body, line...)
+		m.body = append(m.body, '\n')
+	}
+	if m.check == nil || m.all == nil {
+		return nil, fmt.Errorf("%s: truncated", file)
+	}
+	return m, nil
+}
+
+func mac(key []byte, text string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(text))
+	return h.Sum(nil)
+}
+
+const integrityCheckText = "gauth integrity key"
+
+// integrityKey caches the MAC key once it's known.
+var integrityKey []byte
+
+// getIntegrityKey returns the MAC key, from the keyring or derived
+// from the passphrase with salt. If m is not nil, the key must match
+// its check value.
+func getIntegrityKey(salt []byte, m *macFile) ([]byte, error) {
+	if integrityKey != nil {
+		return integrityKey, nil
+	}
+	var key []byte
+	switch mode := integrityMode(); mode {
+	case "keyring":
+		text, err := keyringGet(integrityKeyName())
+		if err != nil {
+			return nil, err
+		}
+		if key, err = hex.DecodeString(text); err != nil {
+			return nil, errors.New("invalid MAC key in the keyring")
+		}
+	case "passphrase":
+		passphrase, err := readPassword("integrity passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if key, err = scrypt([]byte(passphrase), salt, 1<<15, 8, 1, 32); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown integrity mode %q (use keyring or passphrase)", mode)
+	}
+	if m != nil && !hmac.Equal(mac(key, integrityCheckText), m.check) {
+		if integrityMode() == "passphrase" {
+			return nil, errors.New("wrong integrity passphrase")
+		}
+		return nil, errors.New("the MAC key in the keyring doesn't match the integrity file")
+	}
+	lockMemory(key)
+	integrityKey = key
+	return key, nil
+}
+
+// integrityDiff compares the keys of c with their MACs in m, returning
+// descriptions of the differences.
+func (c *Keychain) integrityDiff(key []byte, m *macFile) []string {
+	var diffs []string
+	if !hmac.Equal(mac(key, string(m.body)), m.all) {
+		diffs = append(diffs, "the integrity file was modified")
+	}
+	var names []string
+	for name := range c.keys {
+		names = append(names, name)
+	}
+	for name := range m.keys {
+		if _, ok := c.keys[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		k, ok := c.keys[name]
+		want, known := m.keys[name]
+		switch {
+		case !ok:
+			diffs = append(diffs, name+" removed")
+		case !known:
+			diffs = append(diffs, name+" added")
+		case !hmac.Equal(mac(key, formatKey(name, k, "")), want):
+			diffs = append(diffs, name+" changed")
+		}
+	}
+	return diffs
+}
+
+// checkIntegrity exits, reporting the changes, if the user's keychain
+// was changed outside gauth.
+func (c *Keychain) checkIntegrity() {
+	if err := c.tryCheckIntegrity(); err != nil {
+		exitOn(err)
+	}
+}
+
+// tryCheckIntegrity is checkIntegrity returning the changes as an error.
+func (c *Keychain) tryCheckIntegrity() error {
+	if integrityMode() == "" || c.memory || c.file != keychainPath() {
+		return nil
+	}
+	m, err := readMACFile(integrityPath(c.file))
+	if os.IsNotExist(err) {
+		if len(c.keys) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%s is missing: run \"gauth integrity init\" to create it", integrityPath(c.file))
+	}
+	if err != nil {
+		return err
+	}
+	key, err := getIntegrityKey(m.salt, m)
+	if err != nil {
+		return fmt.Errorf("checking keychain integrity: %v", err)
+	}
+	if diffs := c.integrityDiff(key, m); len(diffs) > 0 {
+		return statusError{exitVerifyFailed, fmt.Errorf("the keychain was changed outside gauth: %s\nrun \"gauth integrity update\" if these changes are yours", strings.Join(diffs, ", "))}
+	}
+	return nil
+}
+
+// updateIntegrity writes the MACs of the keys of c, after gauth
+// changed the keychain.
+func (c *Keychain) updateIntegrity() {
+	if err := c.tryUpdateIntegrity(); err != nil {
+		exitOn(err)
+	}
+}
+
+// tryUpdateIntegrity is updateIntegrity returning its errors.
+func (c *Keychain) tryUpdateIntegrity() error {
+	if integrityMode() == "" || c.memory || c.file != keychainPath() {
+		return nil
+	}
+	m, err := readMACFile(integrityPath(c.file))
+	if os.IsNotExist(err) {
+		return nil // not set up yet with "gauth integrity init"
+	}
+	if err != nil {
+		return err
+	}
+	key, err := getIntegrityKey(m.salt, m)
+	if err != nil {
+		log.Printf("warning: keychain integrity not updated: %v", err)
+		return nil
+	}
+	if err := c.writeMACFile(key, m.salt); err != nil {
+		return fmt.Errorf("writing integrity file: %v", err)
+	}
+	return nil
+}
+
+func (c *Keychain) writeMACFile(key, salt []byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, integrityHeader)
+	if salt != nil {
+		fmt.Fprintf(&buf, "salt %s\n", base64.StdEncoding.EncodeToString(salt))
+	}
+	fmt.Fprintf(&buf, "check %s\n", base64.StdEncoding.EncodeToString(mac(key, integrityCheckText)))
+	var names []string
+	for name := range c.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "key %s %s\n", name, base64.StdEncoding.EncodeToString(mac(key, formatKey(name, c.keys[name], ""))))
+	}
+	fmt.Fprintf(&buf, "all %s\n", base64.StdEncoding.EncodeToString(mac(key, buf.String())))
+
+	file := integrityPath(c.file)
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func runIntegrity(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	if integrityMode() == "" {
+		log.Fatal("no integrity mode is configured: set \"integrity = keyring\" or \"integrity = passphrase\"")
+	}
+	checkPerm(keychainPath())
+	c := readKeychain(keychainPath())
+	file := integrityPath(c.file)
+	switch args[0] {
+	case "init":
+		key := make([]byte, 32)
+		var salt []byte
+		switch integrityMode() {
+		case "keyring":
+			if _, err := rand.Read(key); err != nil {
+				log.Fatal(err)
+			}
+			if err := keyringSet(integrityKeyName(), hex.EncodeToString(key)); err != nil {
+				log.Fatalf("storing the MAC key in the keyring: %v", err)
+			}
+		case "passphrase":
+			salt = make([]byte, 16)
+			if _, err := rand.Read(salt); err != nil {
+				log.Fatal(err)
+			}
+			passphrase, err := readNewPassword("integrity passphrase: ")
+			if err != nil {
+				log.Fatalf("reading passphrase: %v", err)
+			}
+			if passphrase == "" {
+				log.Fatal("empty passphrase")
+			}
+			if key, err = scrypt([]byte(passphrase), salt, 1<<15, 8, 1, 32); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatalf("unknown integrity mode %q (use keyring or passphrase)", integrityMode())
+		}
+		if err := c.writeMACFile(key, salt); err != nil {
+			log.Fatalf("writing integrity file: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "recorded the MACs of %d keys in %s\n", len(c.keys), file)
+	case "verify", "update":
+		m, err := readMACFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		key, err := getIntegrityKey(m.salt, m)
+		if err != nil {
+			log.Fatal(err)
+		}
+		diffs := c.integrityDiff(key, m)
+		if args[0] == "update" {
+			if err := c.writeMACFile(key, m.salt); err != nil {
+				log.Fatalf("writing integrity file: %v", err)
+			}
+			for _, d := range diffs {
+				fmt.Fprintf(os.Stderr, "accepted: %s\n", d)
+			}
+			fmt.Fprintf(os.Stderr, "recorded the MACs of %d keys in %s\n", len(c.keys), file)
+			return
+		}
+		for _, d := range diffs {
+			fmt.Println(d)
+		}
+		if len(diffs) > 0 {
+			os.Exit(exitVerifyFailed)
+		}
+		fmt.Printf("ok: %d keys unchanged\n", len(c.keys))
+	default:
+		cmd.usageExit()
+	}
+}
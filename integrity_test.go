@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestIntegrityDiff edits a keychain by hand after its MACs were
+// written, as someone with access to the file would.
+func TestIntegrityDiff(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	saved := conf
+	conf = config{"integrity": {"passphrase"}}
+	integrityKey = bytes.Repeat([]byte{0x42}, 32)
+	defer func() { conf, integrityKey = saved, nil }()
+
+	file := keychainPath()
+	lines := []string{
+		"bank 6 JBSWY3DPEHPK3PXP 00000000000000000042",
+		"github 6 JBSWY3DPEHPK3PXQ",
+		"vpn 8 JBSWY3DPEHPK3PXR issuer=ACME",
+	}
+	c, _ := scanKeychain(file, []byte(strings.Join(lines, "\n")+"\n"))
+	if err := c.writeMACFile(integrityKey, []byte("salt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.tryCheckIntegrity(); err != nil {
+		t.Fatalf("tryCheckIntegrity of the keychain as written: %v", err)
+	}
+
+	tests := []struct {
+		what  string
+		lines []string
+		diffs []string
+	}{
+		{"a counter advanced", []string{
+			"bank 6 JBSWY3DPEHPK3PXP 00000000000000000043",
+			lines[1],
+			lines[2],
+		}, nil},
+		{"digits edited", []string{lines[0], "github 8 JBSWY3DPEHPK3PXQ", lines[2]}, []string{"github changed"}},
+		{"secret edited", []string{lines[0], "github 6 JBSWY3DPEHPK3PXA", lines[2]}, []string{"github changed"}},
+		{"attribute edited", []string{lines[0], lines[1], "vpn 8 JBSWY3DPEHPK3PXR issuer=Evil"}, []string{"vpn changed"}},
+		{"key removed", []string{lines[0], lines[2]}, []string{"github removed"}},
+		{"key added", append([]string{"evil 6 JBSWY3DPEHPK3PXS"}, lines...), []string{"evil added"}},
+		{"key renamed", []string{lines[0], "gitlab 6 JBSWY3DPEHPK3PXQ", lines[2]}, []string{"github removed", "gitlab added"}},
+	}
+	m, err := readMACFile(integrityPath(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		c, _ := scanKeychain(file, []byte(strings.Join(tt.lines, "\n")+"\n"))
+		if diffs := c.integrityDiff(integrityKey, m); !reflect.DeepEqual(diffs, tt.diffs) {
+			t.Errorf("%s: integrityDiff = %q, want %q", tt.what, diffs, tt.diffs)
+		}
+		err := c.tryCheckIntegrity()
+		if status, ok := err.(statusError); len(tt.diffs) > 0 && (!ok || status.status != exitVerifyFailed) {
+			t.Errorf("%s: tryCheckIntegrity = %v, want a failed verification", tt.what, err)
+		} else if len(tt.diffs) == 0 && err != nil {
+			t.Errorf("%s: tryCheckIntegrity = %v, want no error", tt.what, err)
+		}
+	}
+
+	// Recomputing a key's MAC without the MAC key breaks the file's own.
+	data, err := ioutil.ReadFile(integrityPath(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(data, []byte("key github "))
+	data[i+len("key github ")] ^= 1
+	if err := ioutil.WriteFile(integrityPath(file), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = readMACFile(integrityPath(file)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"the integrity file was modified", "github changed"}
+	if diffs := c.integrityDiff(integrityKey, m); !reflect.DeepEqual(diffs, want) {
+		t.Errorf("integrityDiff with the MAC file edited = %q, want %q", diffs, want)
+	}
+}
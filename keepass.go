@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// KeePass databases (.kdbx) are decrypted by keepassxc-cli, which asks
+// for the password itself:
+//
+//	keepassxc-cli export --format xml [-k keyfile] db.kdbx
+//
+// An XML export made beforehand can be imported as well. KeePassXC keeps
+// the key of an entry in its otp attribute, as an otpauth URI. Older
+// plugins such as KeeOtp and KeeTrayTOTP used "TOTP Seed" and
+// "TOTP Settings" ("period;digits") attributes, which are read too.
+
+// kdbxMagic starts every KeePass 2 database.
+var kdbxMagic = []byte{0x03, 0xd9, 0xa2, 0x9a}
+
+type keepassGroup struct {
+	Name    string
+	Groups  []keepassGroup `xml:"Group"`
+	Entries []struct {
+		Strings []struct {
+			Key   string
+			Value string
+		} `xml:"String"`
+	} `xml:"Entry"`
+}
+
+func importKeePass(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, kdbxMagic) {
+		args := []string{"export", "--format", "xml"}
+		if *importKeyFile != "" {
+			args = append(args, "-k", *importKeyFile)
+		}
+		cmd := exec.CommandContext(ctx, "keepassxc-cli", append(args, file)...)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		if data, err = cmd.Output(); err != nil {
+			if errors.Is(err, exec.ErrNotFound) {
+				return nil, errors.New("keepassxc-cli not found, install KeePassXC or import an XML export")
+			}
+			return nil, fmt.Errorf("keepassxc-cli: %v", err)
+		}
+	}
+	var db struct {
+		XMLName xml.Name       `xml:"KeePassFile"`
+		Groups  []keepassGroup `xml:"Root>Group"`
+	}
+	if err := xml.Unmarshal(data, &db); err != nil {
+		return nil, errors.New("not a KeePass database or XML export")
+	}
+	var entries []entry
+	var walk func(g keepassGroup)
+	walk = func(g keepassGroup) {
+		if g.Name == "Recycle Bin" {
+			return
+		}
+		for _, e := range g.Entries {
+			attrs := make(map[string]string)
+			for _, s := range e.Strings {
+				attrs[s.Key] = s.Value
+			}
+			if a, ok := keepassEntry(attrs); ok {
+				entries = append(entries, a)
+			}
+		}
+		for _, sub := range g.Groups {
+			walk(sub)
+		}
+	}
+	for _, g := range db.Groups {
+		walk(g)
+	}
+	return entries, nil
+}
+
+// keepassEntry makes the entry of a KeePass entry with an OTP key.
+func keepassEntry(attrs map[string]string) (entry, bool) {
+	title, user, site := attrs["Title"], attrs["UserName"], attrs["URL"]
+	otp := attrs["otp"]
+	if strings.HasPrefix(otp, "otpauth://") {
+		entries := appendOTP(nil, title, user, site, otp)
+		if len(entries) == 0 {
+			return entry{}, false
+		}
+		return entries[0], true
+	}
+	secret, algorithm := attrs["TOTP Seed"], "SHA1"
+	period, digits := 30, 6
+	if s := strings.Split(attrs["TOTP Settings"], ";"); len(s) >= 2 {
+		period, _ = strconv.Atoi(s[0])
+		digits, _ = strconv.Atoi(s[1])
+	}
+	if otp != "" {
+		// KeeOtp settings, which KeePassXC reads as well:
+		// key=...&step=30&size=6&otpHashMode=Sha256
+		q, err := url.ParseQuery(otp)
+		if err != nil {
+			log.Printf("%s: skipped: invalid otp attribute", entryName(title, user))
+			return entry{}, false
+		}
+		secret = q.Get("key")
+		if v := q.Get("step"); v != "" {
+			period, _ = strconv.Atoi(v)
+		}
+		if v := q.Get("size"); v != "" {
+			digits, _ = strconv.Atoi(v)
+		}
+		if v := q.Get("otpHashMode"); v != "" {
+			algorithm = v
+		}
+	}
+	if secret == "" {
+		return entry{}, false
+	}
+	name := entryName(title, user)
+	e, err := newEntry(name, secret, "totp", algorithm, digits, period, 0)
+	if err != nil {
+		log.Printf("%s: skipped: %v", name, err)
+		return e, false
+	}
+	e.key.setIdentity(title, user)
+	if checkURL(site) == nil {
+		e.key.set("url", site)
+	}
+	return e, true
+}
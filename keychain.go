@@ -0,0 +1,749 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base32"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keychain is a file format storage.
+type Keychain struct {
+	file  string
+	keys  map[string]Key
+	lines []string     // raw lines, kept for rewriting
+	enc   *keychainKey // key of an encrypted keychain
+
+	memory  bool // read from stdin, never written
+	indexed bool // holds keys read through the index only
+}
+
+// Key describes `keys` in Keychain
+type Key struct {
+	raw    []byte            // decoded secret, in locked memory
+	text   string            // secret as stored
+	digits int               // length
+	offset int               // counter offset
+	count  string            // counter of HOTP keys, as stored
+	line   int               // index in lines
+	attrs  map[string]string // optional attributes
+
+	unlocked bool // raw is the opened secret of a protected key
+}
+
+const counterLen = 20
+
+// dateFormat is the format of dates in attributes.
+const dateFormat = "2006-01-02"
+
+// A keychain line is
+//
+//	name digits secret [counter] [attr=value...]
+//
+// Attributes hold the parameters of keys which differ from the defaults
+// of Google Authenticator, such as period=60 or algorithm=SHA256, and
+// transform=sha1 for providers which hash the secret into the HMAC key.
+// verified=date records when a code of the key was confirmed to work.
+// issuer and account name the provider and the user of the key, as the
+// labels of otpauth URIs do, to tell apart keys of the same provider.
+// tags=a,b groups keys, as set by "gauth tag", and note=text is free
+// text about the key. favorite=yes marks favorites, and archived=date
+// hides keys no longer in use since date. ocra=suite makes the key an
+// OCRA key, whose counter, if its suite has one, is that of the next
+// response. yandex=pin makes it a Yandex.Key key, see yandex.go.
+// skew=seconds is how far ahead of the computer's clock the provider's
+// is, as "gauth battlenet attach" measures, and t0=seconds the Unix time
+// at which the TOTP time steps of the key start, 0 by default (T0 in
+// RFC 6238), as a few legacy tokens have otherwise.
+// accepted=step is the last TOTP time step "gauth verify" accepted a
+// code of, so it's never accepted again.
+// recovery=a,b,~c holds the recovery codes of the key, those used
+// starting with ~, see recovery.go.
+// protect=passphrase means the secret is sealed with a passphrase of
+// the key's own, see protect.go.
+// Their values are escaped as in URL queries. Attributes gauth doesn't
+// know are kept as they are.
+
+// attrCheckers validate the values of known attributes.
+var attrCheckers = map[string]func(string) bool{
+	"period": func(v string) bool {
+		n, err := strconv.Atoi(v)
+		return err == nil && n > 0
+	},
+	"algorithm": func(v string) bool {
+		_, ok := hashes[v]
+		return ok
+	},
+	"verified": func(v string) bool {
+		_, err := time.Parse(dateFormat, v)
+		return err == nil
+	},
+	"archived": func(v string) bool {
+		_, err := time.Parse(dateFormat, v)
+		return err == nil
+	},
+	"transform": func(v string) bool {
+		_, err := parseTransform(v)
+		return err == nil
+	},
+	"skew": func(v string) bool {
+		_, err := strconv.Atoi(v)
+		return err == nil
+	},
+	"accepted": func(v string) bool {
+		_, err := strconv.ParseInt(v, 10, 64)
+		return err == nil
+	},
+	"protect": func(v string) bool {
+		return v == "passphrase"
+	},
+	"t0": func(v string) bool {
+		_, err := strconv.ParseInt(v, 10, 64)
+		return err == nil
+	},
+}
+
+// parseAttr parses a name=value field.
+func parseAttr(f []byte) (name, value string, ok bool) {
+	i := bytes.IndexByte(f, '=')
+	if i <= 0 {
+		return "", "", false
+	}
+	name = string(f[:i])
+	value, err := url.QueryUnescape(string(f[i+1:]))
+	if err != nil {
+		return "", "", false
+	}
+	if check := attrCheckers[name]; check != nil && !check(value) {
+		return "", "", false
+	}
+	return name, value, true
+}
+
+func (k Key) attr(name string) string {
+	return k.attrs[name]
+}
+
+// set sets attribute name, or removes it if value is "".
+// The attributes are copied, so other copies of k don't change.
+func (k *Key) set(name, value string) {
+	attrs := make(map[string]string, len(k.attrs)+1)
+	for n, v := range k.attrs {
+		attrs[n] = v
+	}
+	if value == "" {
+		delete(attrs, name)
+	} else {
+		attrs[name] = value
+	}
+	k.attrs = attrs
+}
+
+// period returns the TOTP time step in seconds.
+func (k Key) period() int {
+	if n, err := strconv.Atoi(k.attr("period")); err == nil {
+		return n
+	}
+	return 30
+}
+
+// now returns the time of the provider's clock, which is skew
+// seconds ahead of the computer's.
+func (k Key) now() time.Time {
+	return time.Now().Add(time.Duration(k.skew()) * time.Second)
+}
+
+// skew returns how many seconds the provider's clock is ahead.
+func (k Key) skew() int64 {
+	n, _ := strconv.ParseInt(k.attr("skew"), 10, 64)
+	return n
+}
+
+// t0 returns the Unix time at which the TOTP time steps start.
+func (k Key) t0() int64 {
+	n, _ := strconv.ParseInt(k.attr("t0"), 10, 64)
+	return n
+}
+
+// algorithm returns the name of the HMAC hash function.
+func (k Key) algorithm() string {
+	if a := k.attr("algorithm"); a != "" {
+		return a
+	}
+	return "SHA1"
+}
+
+// setPeriod and setAlgorithm store the parameter,
+// leaving defaults implicit.
+func (k *Key) setPeriod(period int) {
+	if period == 30 {
+		k.set("period", "")
+		return
+	}
+	k.set("period", strconv.Itoa(period))
+}
+
+func (k *Key) setAlgorithm(algorithm string) {
+	if algorithm == "SHA1" {
+		algorithm = ""
+	}
+	k.set("algorithm", algorithm)
+}
+
+// setIdentity sets the issuer and account attributes,
+// removing those which are empty.
+func (k *Key) setIdentity(issuer, account string) {
+	k.set("issuer", strings.TrimSpace(issuer))
+	k.set("account", strings.TrimSpace(account))
+}
+
+// keychainPath returns the location of the user's keychain,
+// that of the profile in use.
+func keychainPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".gauth"+profileSuffix())
+}
+
+// memoryKeychain is the keychain read from stdin with -stdin-keychain
+// or from a systemd credential (see systemd.go), in place of the
+// user's keychain and all backends. Commands which would change it fail.
+var memoryKeychain *Keychain
+
+// wipeKeys wipes the secrets of c and drops its keys, for a keychain
+// which is kept in memory only.
+func (c *Keychain) wipeKeys() {
+	for _, k := range c.keys {
+		wipe(k.raw)
+	}
+	c.keys = make(map[string]Key)
+	c.lines = nil
+}
+
+// readStdinKeychain reads the keychain from stdin.
+func readStdinKeychain() *Keychain {
+	data, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		log.Fatalf("reading keychain from stdin: %v", err)
+	}
+	lockMemory(data)
+	c := decryptAndParse("stdin", data)
+	c.memory = true
+	return c
+}
+
+// checkWritable exits if c can't be changed.
+func (c *Keychain) checkWritable() {
+	if why := c.readOnlyReason(); why != "" {
+		log.Fatalf("the keychain is read-only: %s", why)
+	}
+}
+
+// openKeychain reads the user's keychain.
+func openKeychain() *Keychain {
+	if memoryKeychain != nil {
+		return memoryKeychain
+	}
+	checkPerm(keychainPath())
+	c := readKeychain(keychainPath())
+	c.checkIntegrity()
+	return c
+}
+
+// Read line by line into memory
+// handling key length and validity
+func readKeychain(file string) *Keychain {
+	c, err := tryReadKeychain(file)
+	if err != nil {
+		exitOn(err)
+	}
+	return c
+}
+
+// tryReadKeychain is readKeychain returning its errors.
+func tryReadKeychain(file string) (*Keychain, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tryParseKeychain(file, nil)
+		}
+		return nil, err
+	}
+	lockMemory(data)
+	return tryDecryptAndParse(file, data)
+}
+
+// decryptAndParse parses keychain data read from file, decrypting
+// it if it's encrypted, and wipes it.
+func decryptAndParse(file string, data []byte) *Keychain {
+	c, err := tryDecryptAndParse(file, data)
+	if err != nil {
+		exitOn(err)
+	}
+	return c
+}
+
+// tryDecryptAndParse is decryptAndParse returning its errors.
+func tryDecryptAndParse(file string, data []byte) (*Keychain, error) {
+	defer wipe(data)
+	if !isEncryptedKeychain(data) {
+		return tryParseKeychain(file, data)
+	}
+	k, plain, err := decryptKeychain(data)
+	if err != nil {
+		return nil, statusError{keychainErrorStatus(err), fmt.Errorf("%s: %v", file, err)}
+	}
+	defer wipe(plain)
+	c, err := tryParseKeychain(file, plain)
+	if err != nil {
+		return nil, err
+	}
+	c.enc = k
+	return c, nil
+}
+
+// strictFlag is set by the -strict flag.
+var strictFlag bool
+
+// strict reports whether invalid keychain lines are fatal, with
+// -strict or "strict = yes" in the configuration, for keychains
+// written by automation, where such a line is a bug to stop at.
+func strict() bool {
+	return strictFlag || conf.get("strict") == "yes"
+}
+
+// parseKeychain parses the contents of keychain file, reporting its
+// invalid lines, or failing on them in strict mode.
+// The keychain doesn't refer to data, which the caller can wipe.
+func parseKeychain(file string, data []byte) *Keychain {
+	c, err := tryParseKeychain(file, data)
+	if err != nil {
+		exitOn(err)
+	}
+	return c
+}
+
+// tryParseKeychain is parseKeychain returning the error of strict mode.
+func tryParseKeychain(file string, data []byte) (*Keychain, error) {
+	c, problems := scanKeychain(file, data)
+	for _, p := range problems {
+		log.Print(p)
+	}
+	if len(problems) > 0 && strict() {
+		return nil, statusError{exitInvalidKeychain, fmt.Errorf("%s: %d invalid or duplicate lines, which strict mode forbids", file, len(problems))}
+	}
+	return c, nil
+}
+
+// scanKeychain parses the contents of keychain file, a line at a time,
+// in place, returning the problems of its lines with the column at
+// fault. Invalid lines are kept as they are, so that rewriting the
+// keychain never drops them.
+func scanKeychain(file string, data []byte) (*Keychain, []string) {
+	c := &Keychain{
+		file: file,
+		keys: make(map[string]Key),
+	}
+
+	var problems []string
+	for start, i := 0, 0; start < len(data); i++ {
+		end := len(data)
+		if n := bytes.IndexByte(data[start:], '\n'); n >= 0 {
+			end = start + n
+		}
+		c.lines = append(c.lines, string(data[start:end]))
+		name, k, err := parseLine(data[start:end])
+		switch {
+		case err != nil && end == len(data):
+			problems = append(problems, fmt.Sprintf("%s:%d:%v; the file ends within this line, as if cut short", c.file, i+1, err))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s:%d:%v", c.file, i+1, err))
+		case name != "":
+			if k.offset != 0 {
+				k.offset += start
+			}
+			if prev, ok := c.keys[name]; ok {
+				problems = append(problems, fmt.Sprintf("%s:%d: duplicate key %q replaces line %d", c.file, i+1, name, prev.line+1))
+			}
+			k.line = i
+			c.keys[name] = k
+		}
+		start = end + 1
+	}
+	return c, problems
+}
+
+// maxLineLen bounds keychain lines, well above those of keys with long
+// notes or many recovery codes, so that a corrupted file of one huge
+// line fails fast.
+const maxLineLen = 64 << 10
+
+// A lineError is an error in a keychain line, at column col, counted
+// in bytes from 1.
+type lineError struct {
+	col int
+	msg string
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("%d: %s", e.col, e.msg)
+}
+
+// parseLine parses a keychain line, returning the name of its key, or
+// "" for a blank line. Fields are separated by spaces or tabs; trailing
+// spaces and carriage returns, as editors leave them, are ignored.
+// Attributes gauth doesn't know, such as those of later versions, are
+// kept, and written back with the key. The counter offset of HOTP keys
+// is relative to the line. Errors never quote the secret.
+func parseLine(line []byte) (string, Key, error) {
+	if len(line) > maxLineLen {
+		return "", Key{}, &lineError{maxLineLen + 1, fmt.Sprintf("line longer than %d bytes", maxLineLen)}
+	}
+	return parseFields(line)
+}
+
+// parseFields is parseLine for a line of any length.
+func parseFields(line []byte) (string, Key, error) {
+	var k Key
+	type field struct {
+		text []byte
+		col  int // from 1
+	}
+	line = bytes.TrimRight(line, " \t\r")
+	for i, b := range line {
+		switch {
+		case b == 0:
+			return "", k, &lineError{i + 1, "NUL byte"}
+		case b < ' ' && b != '\t' || b == 0x7f:
+			return "", k, &lineError{i + 1, fmt.Sprintf("control character %#02x", b)}
+		}
+	}
+	var f []field
+	for i := 0; i < len(line); {
+		j := i
+		for j < len(line) && line[j] != ' ' && line[j] != '\t' {
+			j++
+		}
+		if j > i {
+			f = append(f, field{line[i:j], i + 1})
+		}
+		i = j + 1
+	}
+	switch len(f) {
+	case 0:
+		return "", k, nil
+	case 1:
+		return "", k, &lineError{len(line) + 1, "missing number of digits"}
+	case 2:
+		return "", k, &lineError{len(line) + 1, "missing secret"}
+	}
+	if d := f[1].text; len(d) != 1 || d[0] < '6' || '8' < d[0] {
+		return "", k, &lineError{f[1].col, fmt.Sprintf("invalid number of digits %q, want 6, 7 or 8", d)}
+	}
+	k.digits = int(f[1].text[0] - '0')
+	// Found here rather than by decodeKey, whose upper-casing moves the
+	// columns after non-ASCII bytes.
+	if i := bytes.IndexFunc(bytes.TrimRight(f[2].text, "="), notBase32); i >= 0 {
+		return "", k, &lineError{f[2].col + i, "invalid base32 character in the secret"}
+	}
+	raw, err := decodeKey(string(f[2].text))
+	if e, ok := err.(base32.CorruptInputError); ok {
+		return "", k, &lineError{f[2].col + int(e), "invalid base32 character in the secret"}
+	} else if err != nil {
+		return "", k, &lineError{f[2].col, fmt.Sprintf("invalid secret: %v", err)}
+	}
+	lockMemory(raw)
+	k.raw = raw
+	k.text = string(f[2].text)
+	attrs := f[3:]
+	if len(attrs) > 0 && len(bytes.Trim(attrs[0].text, "0123456789")) == 0 {
+		a := attrs[0]
+		if len(a.text) != counterLen {
+			return "", k, &lineError{a.col, fmt.Sprintf("HOTP counter of %d digits, want %d: \"gauth doctor\" repairs it", len(a.text), counterLen)}
+		}
+		if _, err := strconv.ParseUint(string(a.text), 10, 64); err != nil {
+			return "", k, &lineError{a.col, "HOTP counter out of range"}
+		}
+		k.offset = a.col - 1
+		k.count = string(a.text)
+		attrs = attrs[1:]
+	}
+	for _, a := range attrs {
+		name, value, ok := parseAttr(a.text)
+		if !ok {
+			i := bytes.IndexByte(a.text, '=')
+			switch {
+			case i <= 0:
+				return "", k, &lineError{a.col, "invalid field, want name=value"}
+			case attrCheckers[string(a.text[:i])] != nil:
+				return "", k, &lineError{a.col + i + 1, fmt.Sprintf("invalid %s", a.text[:i])}
+			default:
+				return "", k, &lineError{a.col + i + 1, fmt.Sprintf("invalid escaping in %s", a.text[:i])}
+			}
+		}
+		if k.attrs == nil {
+			k.attrs = make(map[string]string)
+		}
+		k.attrs[name] = value
+	}
+	return string(f[0].text), k, nil
+}
+
+// notBase32 reports whether r isn't a base32 letter, in either case.
+func notBase32(r rune) bool {
+	return !('A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' || '2' <= r && r <= '7')
+}
+
+// checkLines returns an error if a key line of lines is too long to be
+// read back, as escaping the attributes of a long one may make it.
+func checkLines(lines []string) error {
+	for _, line := range lines {
+		if len(line) <= maxLineLen {
+			continue
+		}
+		if name, _, err := parseFields([]byte(line)); err == nil && name != "" {
+			return fmt.Errorf("the line of key %s would be longer than %d bytes, too long to read back: shorten its note", name, maxLineLen)
+		}
+	}
+	return nil
+}
+
+// format renders k as a keychain line.
+func formatKey(name string, k Key, counter string) string {
+	line := fmt.Sprintf("%s %d %s", name, k.digits, k.text)
+	if counter != "" {
+		line += " " + counter
+	}
+	var names []string
+	for n := range k.attrs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		line += " " + n + "=" + url.QueryEscape(k.attrs[n])
+	}
+	return line
+}
+
+// remove drops the line of key name. Call save to write the change.
+func (c *Keychain) remove(name string) {
+	c.lines[c.keys[name].line] = ""
+	delete(c.keys, name)
+}
+
+// save rewrites the keychain file from c.lines and reloads it.
+// The new contents are written to a temporary file which then
+// replaces the keychain, so a failed write never truncates it.
+// Empty lines, including removed keys, are dropped.
+// The previous contents are backed up first.
+// Encrypted keychains are written encrypted with the same key.
+func (c *Keychain) save() {
+	if err := c.trySave(); err != nil {
+		exitOn(err)
+	}
+}
+
+// trySave is save returning its errors.
+func (c *Keychain) trySave() error {
+	if c.indexed {
+		panic("saving a keychain read through its index")
+	}
+	if why := c.readOnlyReason(); why != "" {
+		return fmt.Errorf("the keychain is read-only: %s", why)
+	}
+	if err := checkLines(c.lines); err != nil {
+		return err
+	}
+	unlock, err := lockKeychain()
+	if err != nil {
+		return fmt.Errorf("locking keychain: %v", err)
+	}
+	defer unlock()
+	c.keepCounters()
+	if c.encrypted() && c.enc == nil {
+		k, err := newKeychainKey()
+		if err != nil {
+			return fmt.Errorf("encrypting keychain: %v", err)
+		}
+		c.enc = k
+	}
+	if err := backup(c.file, c.enc); err != nil {
+		return backupError(err)
+	}
+	plain := keychainText(c.lines)
+	defer wipe(plain)
+	data := plain
+	if c.enc != nil {
+		if data, err = c.enc.encrypt(data); err != nil {
+			return fmt.Errorf("encrypting keychain: %v", err)
+		}
+	}
+	if err := writeKeychainFile(c.file, data); err != nil {
+		return err
+	}
+	saved, err := tryReadKeychain(c.file)
+	if err != nil {
+		return err
+	}
+	*c = *saved
+	if err := c.tryUpdateIntegrity(); err != nil {
+		return err
+	}
+	c.writeIndex()
+	return nil
+}
+
+// keepCounters carries into c.lines the HOTP counters advanced in the
+// keychain file since c was read, by codes shown meanwhile, so that
+// saving c doesn't give out their codes again. Lines whose counter c
+// changed itself are left alone. The caller holds the keychain lock.
+func (c *Keychain) keepCounters() {
+	data, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		return
+	}
+	lockMemory(data)
+	defer wipe(data)
+	plain := data
+	if isEncryptedKeychain(data) {
+		if _, plain, err = decryptKeychain(data); err != nil {
+			return
+		}
+		defer wipe(plain)
+	}
+	fresh, _ := scanKeychain(c.file, plain)
+	for name, k := range c.keys {
+		now, ok := fresh.keys[name]
+		if k.offset == 0 || !ok || now.offset == 0 || now.text != k.text || now.count <= k.count {
+			continue
+		}
+		line := c.lines[k.line]
+		n, lk, err := parseLine([]byte(line))
+		if err != nil || n != name || lk.offset == 0 || lk.count != k.count {
+			continue
+		}
+		c.lines[k.line] = line[:lk.offset] + now.count + line[lk.offset+counterLen:]
+	}
+}
+
+// update reads the keychain again under the keychain lock, applies
+// change to that fresh copy and saves it, so that HOTP counters
+// advanced and keys added since c was read aren't undone. c is then
+// the saved keychain.
+func (c *Keychain) update(change func(fresh *Keychain)) {
+	c.checkWritable()
+	unlock, err := lockKeychain()
+	if err != nil {
+		log.Fatalf("locking keychain: %v", err)
+	}
+	defer unlock()
+	fresh := readKeychain(c.file)
+	change(fresh)
+	fresh.save()
+	*c = *fresh
+}
+
+func keychainLockPath() string {
+	return keychainPath() + ".lock"
+}
+
+// lockKeychain takes a lock for changing the keychain, waiting for it,
+// and returns the function releasing it. The keychain itself is
+// replaced on every write, so the lock is a file of its own,
+// $HOME/.gauth.lock, which stays. A process holding the lock may take
+// it again, as saving the keychain does.
+func lockKeychain() (func(), error) {
+	keychainLock.Lock()
+	defer keychainLock.Unlock()
+	if keychainLock.held > 0 {
+		keychainLock.held++
+		return releaseKeychain, nil
+	}
+	f, err := os.OpenFile(keychainLockPath(), os.O_CREATE|os.O_RDWR, keychainPerm().mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	keychainLock.f = f
+	keychainLock.held = 1
+	return releaseKeychain, nil
+}
+
+// keychainLock is the lock file taken by lockKeychain, and how many
+// times the process took it.
+var keychainLock struct {
+	sync.Mutex
+	f    *os.File
+	held int
+}
+
+func releaseKeychain() {
+	keychainLock.Lock()
+	defer keychainLock.Unlock()
+	if keychainLock.held--; keychainLock.held == 0 {
+		unlockFile(keychainLock.f)
+		keychainLock.f.Close()
+	}
+}
+
+// keychainText returns the keychain of lines, dropping empty ones.
+func keychainText(lines []string) []byte {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// writeKeychainFile replaces the keychain file with data, through a
+// temporary file, so a failed write never truncates it.
+func writeKeychainFile(file string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return fmt.Errorf("writing keychain: %v", err)
+	}
+	// vital
+	if err := keychainPerm().apply(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("setting keychain permissions: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("writing keychain: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("closing keychain while writing: %v", err)
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("replacing keychain: %v", err)
+	}
+	return nil
+}
+
+// findSecret returns the name of the key whose secret is raw.
+func (c *Keychain) findSecret(raw []byte) (string, bool) {
+	for name, k := range c.keys {
+		if bytes.Equal(k.raw, raw) {
+			return name, true
+		}
+	}
+	return "", false
+}
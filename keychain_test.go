@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lineSeeds are keychain lines, valid or not, to start fuzzing from.
+var lineSeeds = []string{
+	"",
+	"   \t",
+	"github 6 JBSWY3DPEHPK3PXP",
+	"github 6 JBSWY3DPEHPK3PXP\r",
+	"github 6 JBSWY3DPEHPK3PXP  \t",
+	"github\t8\tJBSWY3DPEHPK3PXP",
+	"bank 6 JBSWY3DPEHPK3PXP 00000000000000000042",
+	"bank 6 JBSWY3DPEHPK3PXP 42",
+	"bank 6 JBSWY3DPEHPK3PXP 99999999999999999999",
+	"vpn 7 JBSWY3DPEHPK3PXP issuer=ACME note=a%20b%0Ac url=https%3A%2F%2Fexample.com",
+	"vpn 6 JBSWY3DPEHPK3PXP period=60 algorithm=SHA256 t0=1262304000",
+	"vpn 6 JBSWY3DPEHPK3PXP period=x",
+	"vpn 6 JBSWY3DPEHPK3PXP note=%zz",
+	"vpn 6 JBSWY3DPEHPK3PXP =x",
+	"vpn 6 JBSWY3DPEHPK3PXP attr",
+	"oc 6 JBSWY3DPEHPK3PXP 00000000000000000000 ocra=OCRA-1:HOTP-SHA1-6:QN08",
+	"nul 6 JBSWY3DP\x00EHPK3PXP",
+	"ctl 6 JBSWY3DPEHPK3PXP\x1b[31m",
+	"cr 6 JBSW\rY3DPEHPK3PXP",
+	"del 6 JBSWY3DPEHPK3PXP\x7f",
+	"name",
+	"name 6",
+	"name 5 JBSWY3DPEHPK3PXP",
+	"name 66 JBSWY3DPEHPK3PXP",
+	"name 6 JBSW1Y3DPEHPK3PXP",
+	"name 6 jbswy3dpehpk3pxp====",
+	"name 6 ====",
+	"name 6 JBSWY3DP\xffEHPK3PXP",
+	"name 6 ıııııııııııı1",
+	"name 6 ı2\xb1",
+	"n\xe2\x80\x8be 6 JBSWY3DPEHPK3PXP",
+	"long 6 JBSWY3DPEHPK3PXP note=" + strings.Repeat("x", maxLineLen),
+}
+
+func FuzzParseLine(f *testing.F) {
+	for _, s := range lineSeeds {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, line []byte) {
+		name, k, err := parseLine(line)
+		if err != nil {
+			e, ok := err.(*lineError)
+			if !ok {
+				t.Fatalf("parseLine(%q) error %v is a %T, want *lineError", line, err, err)
+			}
+			if e.col < 1 || e.col > len(line)+1 {
+				t.Fatalf("parseLine(%q) error at column %d, out of the line", line, e.col)
+			}
+			if strings.HasPrefix(e.msg, "invalid base32") && strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz234567", rune(line[e.col-1])) {
+				t.Fatalf("parseLine(%q) error %q at column %d, a valid character", line, e.msg, e.col)
+			}
+			return
+		}
+		if name == "" {
+			if len(bytes.Trim(line, " \t\r")) != 0 {
+				t.Fatalf("parseLine(%q) found no key in a line which isn't blank", line)
+			}
+			return
+		}
+		if k.offset != 0 && string(line[k.offset:k.offset+counterLen]) != k.count {
+			t.Fatalf("parseLine(%q) counter at offset %d, want %q", line, k.offset, k.count)
+		}
+		// A key written back reads the same, unless escaping made its
+		// line too long, which saving refuses.
+		out := formatKey(name, k, k.count)
+		if len(out) > maxLineLen {
+			if checkLines([]string{out}) == nil {
+				t.Fatalf("checkLines accepts the line of %d bytes formatted from %q", len(out), line)
+			}
+			return
+		}
+		name2, k2, err := parseLine([]byte(out))
+		if err != nil {
+			t.Fatalf("parseLine(%q), formatted from %q: %v", out, line, err)
+		}
+		if name2 != name || k2.digits != k.digits || k2.text != k.text || !bytes.Equal(k2.raw, k.raw) ||
+			k2.count != k.count || len(k2.attrs)+len(k.attrs) > 0 && !reflect.DeepEqual(k2.attrs, k.attrs) {
+			t.Fatalf("parseLine(%q) = %q %+v, formatted from %q = %q %+v", out, name2, k2, line, name, k)
+		}
+	})
+}
+
+func FuzzScanKeychain(f *testing.F) {
+	f.Add([]byte(""))
+	f.Add([]byte(strings.Join(lineSeeds, "\n")))
+	f.Add([]byte("a 6 JBSWY3DPEHPK3PXP\nb 6 JBSWY3DPEHPK3PXP 00000000000000000001\n"))
+	f.Add([]byte("a 6 JBSWY3DPEHPK3PXP\r\na 6 JBSWY3DPEHPK3PXP\r\n"))
+	f.Add([]byte("b 6 JBSWY3DPEHPK3PXP 0000000000"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c, _ := scanKeychain("fuzz", data)
+		want := bytes.Count(data, []byte("\n"))
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			want++
+		}
+		if len(c.lines) != want {
+			t.Fatalf("scanKeychain(%q) has %d lines, want %d", data, len(c.lines), want)
+		}
+		for name, k := range c.keys {
+			if k.line < 0 || k.line >= len(c.lines) || !strings.HasPrefix(strings.TrimLeft(c.lines[k.line], " \t"), name) {
+				t.Fatalf("scanKeychain(%q): key %q at line %d of %q", data, name, k.line, c.lines)
+			}
+			if k.offset != 0 && string(data[k.offset:k.offset+counterLen]) != k.count {
+				t.Fatalf("scanKeychain(%q): counter of %q at offset %d, want %q", data, name, k.offset, k.count)
+			}
+		}
+	})
+}
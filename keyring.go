@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The system keyring is reached through its command line tools:
+// secret-tool (libsecret) on Linux and the BSDs, security on macOS.
+// Secrets are stored under the service "gauth" and a name. It holds
+// the integrity key (see integrity.go), the MAC key of the audit log
+// (see audit.go), and can hold the keychain too.
+
+var errNoKeyring = errors.New("no keyring tool found (install libsecret-tools for secret-tool)")
+
+// keyringGet returns the secret stored under name.
+func keyringGet(name string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", "gauth", "-a", name, "-w")
+	case "windows":
+		return "", errors.New("the keyring isn't supported on Windows")
+	default:
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return "", errNoKeyring
+		}
+		cmd = exec.Command("secret-tool", "lookup", "service", "gauth", "name", name)
+	}
+	out, err := cmd.Output()
+	if err != nil || len(out) == 0 {
+		return "", errors.New("no " + name + " in the keyring")
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// keyringSet stores secret under name, replacing any previous one.
+func keyringSet(name, secret string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "add-generic-password", "-U", "-s", "gauth", "-a", name, "-w", secret)
+	case "windows":
+		return errors.New("the keyring isn't supported on Windows")
+	default:
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return errNoKeyring
+		}
+		cmd = exec.Command("secret-tool", "store", "--label", "gauth "+name, "service", "gauth", "name", name)
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// keyringBackend is a keychain kept in the system keyring, as the
+// secret item, "keychain" unless given:
+//
+//	backend = keyring [item]
+//
+// The keychain lines are stored in base64, since the keyring tools
+// don't keep secrets of several lines as they are.
+type keyringBackend struct {
+	item string
+
+	mu sync.Mutex
+	c  *Keychain
+}
+
+func init() {
+	backendTypes["keyring"] = openKeyringBackend
+}
+
+func openKeyringBackend(args []string) (backend, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("usage: keyring [item]")
+	}
+	b := &keyringBackend{item: "keychain" + profileSuffix()}
+	if len(args) == 1 {
+		b.item = args[0]
+	}
+	return b, nil
+}
+
+func (b *keyringBackend) String() string { return "keyring:" + b.item }
+
+func (b *keyringBackend) keychain(ctx context.Context) (*Keychain, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.c != nil {
+		return b.c, nil
+	}
+	text, err := keyringGet(b.item)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keychain in the keyring item %s", b.item)
+	}
+	c, err := tryParseKeychain(b.String(), data)
+	wipe(data)
+	if err != nil {
+		return nil, err
+	}
+	b.c = c
+	b.c.memory = true
+	return b.c, nil
+}
+
+// store replaces the keychain in the keyring with lines.
+func (b *keyringBackend) store(ctx context.Context, lines []string) error {
+	data := keychainText(lines)
+	defer wipe(data)
+	if err := keyringSet(b.item, base64.StdEncoding.EncodeToString(data)); err != nil {
+		return err
+	}
+	c, err := tryParseKeychain(b.String(), data)
+	if err != nil {
+		return err
+	}
+	b.c = c
+	b.c.memory = true
+	return nil
+}
+
+func (b *keyringBackend) keys(ctx context.Context) ([]keyInfo, error) {
+	c, err := b.keychain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.keyInfos(), nil
+}
+
+func (b *keyringBackend) code(ctx context.Context, name string) (string, error) {
+	c, err := b.keychain(ctx)
+	if err != nil {
+		return "", err
+	}
+	k, ok := c.keys[name]
+	if !ok {
+		return "", fmt.Errorf("no such key %q", name)
+	}
+	if k.offset == 0 {
+		return c.tryCode(name)
+	}
+	if err := k.unlock(name); err != nil {
+		return "", err
+	}
+	// Store the advanced counter before handing out the code.
+	n, err := strconv.ParseUint(k.count, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid key counter for %q", name)
+	}
+	n++
+	lines := append([]string(nil), c.lines...)
+	lines[k.line] = formatKey(name, k, fmt.Sprintf("%0*d", counterLen, n))
+	if err := b.store(ctx, lines); err != nil {
+		return "", fmt.Errorf("storing counter: %v", err)
+	}
+	key := k.hmacKey()
+	defer wipe(key)
+	return fmt.Sprintf("%0*d", k.digits, genHOTP(k.hash(), key, n, k.digits)), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// genericNames are key names too vague to tell which account they're for.
+var genericNames = map[string]bool{
+	"test": true, "otp": true, "totp": true, "hotp": true, "2fa": true,
+	"mfa": true, "key": true, "code": true, "token": true, "auth": true,
+	"login": true, "account": true, "default": true, "new": true, "temp": true,
+}
+
+// foldName reduces a name to its lower-case letters and digits,
+// so names which only differ in case or punctuation fold alike.
+func foldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// checkCommandName returns an error if name is that of a gauth command,
+// which "gauth name" runs rather than showing the code of the key.
+func checkCommandName(name string) error {
+	if lookup(name) != nil {
+		return fmt.Errorf("%s is a gauth command, which \"gauth %s\" would run rather than show the key's code", name, name)
+	}
+	return nil
+}
+
+// lintName returns warnings about the name of a new key which is easily
+// confused, given the issuers of the other keys by name.
+func lintName(name string, issuers map[string]string) []string {
+	var warnings []string
+	if lookup(name) != nil {
+		warnings = append(warnings, fmt.Sprintf("is a gauth command, so \"gauth %s\" runs it: show the code with \"gauth show %s\"", name, name))
+	}
+	folded := foldName(name)
+	if genericNames[folded] {
+		warnings = append(warnings, "is a generic name, which won't tell which account the key is for")
+	}
+	var others []string
+	for other := range issuers {
+		others = append(others, other)
+	}
+	sort.Strings(others)
+	for _, other := range others {
+		if other == name {
+			continue
+		}
+		if foldName(other) == folded {
+			warnings = append(warnings, fmt.Sprintf("differs from key %s only in case or punctuation", other))
+		} else if issuer := issuers[other]; issuer != "" && foldName(issuer) == folded {
+			warnings = append(warnings, fmt.Sprintf("is the issuer of key %s, so it may be mistaken for it", other))
+		}
+	}
+	return warnings
+}
+
+// issuers returns the issuers of the keys of c by name.
+func (c *Keychain) issuers() map[string]string {
+	issuers := make(map[string]string, len(c.keys))
+	for name, k := range c.keys {
+		issuers[name] = k.attr("issuer")
+	}
+	return issuers
+}
+
+// warnName logs the warnings of lintName.
+func warnName(name string, issuers map[string]string) {
+	for _, w := range lintName(name, issuers) {
+		log.Printf("warning: %s: %s", name, w)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cmdList = &command{
+	name:  "list",
+	usage: "list [-long | -verbose] [-all] [-tag tag] [-sort order] [query]",
+	short: "list key names",
+	long: `List prints the names of the keys of all configured backends.
+With a query, it prints only the keys whose name, issuer or account
+contains it, ignoring case. -long prints a line of columns for each
+key: its name, issuer and account, type (TOTP, HOTP, OCRA or Yandex),
+digits, algorithm, period or HOTP counter (#n), tags, whether it was
+verified and the backend it comes from, with "-" for what a backend
+doesn't tell. A list printed to a terminal shows the issuer and
+account too. -verbose also prints when a code of
+each key was last shown, and its notes (see "gauth help edit"), below
+it, which helps find stale keys and the one actually in use among
+look-alikes. -tag lists only the keys
+with the tag (see "gauth help tag"). Archived keys (see "gauth help
+archive") are listed only with -all.
+
+-sort orders the keys by name (the default), by the time they were
+last used (recent), or with favorites first (favorites; see "gauth help
+favorite"). The "sort" key of the configuration changes the default.
+
+Keys no code of which was confirmed to work (see "gauth help confirm")
+are flagged as unverified, by -long and when printing to a terminal.`,
+}
+
+var (
+	listLong    = cmdList.flags.Bool("long", false, "also print the type, parameters, tags and backend of each key")
+	listVerbose = cmdList.flags.Bool("verbose", false, "like -long, and also print when the keys were last used and their notes")
+	listAll     = cmdList.flags.Bool("all", false, "also list archived keys")
+	listTag     = cmdList.flags.String("tag", "", "list only the keys tagged `tag`")
+	listSort    = cmdList.flags.String("sort", "", "sort the keys by `order`: name, recent or favorites")
+)
+
+func init() {
+	cmdList.run = runList
+}
+
+func runList(ctx context.Context, cmd *command, args []string) {
+	if len(args) > 1 {
+		cmd.usageExit()
+	}
+	if *listVerbose {
+		*listLong = true
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	query := ""
+	if len(args) == 1 {
+		query = args[0]
+	}
+	federation(openBackends()).list(ctx, query)
+}
+
+// dump 2fa list
+func (f federation) list(ctx context.Context, query string) {
+	all, err := f.keys(ctx)
+	if err != nil {
+		// List the keys of the backends which answered, then fail.
+		defer exitOn(err)
+	}
+	var keys []keyInfo
+	for _, k := range all {
+		if k.matches(query) && k.hasTag(*listTag) && (*listAll || !k.archived) {
+			keys = append(keys, k)
+		}
+	}
+	sortKeys(keys, *listSort)
+	terminal := isTerminal(os.Stdout.Fd())
+	if !*listLong && !terminal {
+		for _, k := range keys {
+			fmt.Println(k.name)
+		}
+		return
+	}
+	var used map[string]time.Time
+	if *listVerbose {
+		used = readUsed()
+	}
+	max, maxID := 0, 0
+	for _, k := range keys {
+		if w := displayWidth(k.name); max < w {
+			max = w
+		}
+		if w := displayWidth(k.identity()); maxID < w {
+			maxID = w
+		}
+	}
+	// The columns of -long, aligned.
+	var rows [][]string
+	var widths []int
+	if *listLong {
+		for _, k := range keys {
+			rows = append(rows, k.columns())
+		}
+		widths = columnWidths(rows)
+	}
+	for i, k := range keys {
+		if !*listLong {
+			line := padRight(isolate(k.name), max)
+			if maxID > 0 {
+				line += "  " + padRight(isolate(k.identity()), maxID)
+			}
+			if k.unverified {
+				line += "  (unverified)"
+			}
+			fmt.Println(strings.TrimRight(line, " "))
+			continue
+		}
+		fmt.Println(strings.Join(alignColumns(rows[i], widths), "  "))
+		if *listVerbose {
+			fmt.Printf("    %s\n", lastUsed(used[k.name], time.Now()))
+		}
+		if *listVerbose && k.note != "" {
+			for _, line := range strings.Split(k.note, "\n") {
+				fmt.Printf("    %s\n", isolate(line))
+			}
+		}
+	}
+}
+
+// columns describes k in the columns of list -long: its name, issuer
+// and account, type, digits, algorithm, period or counter, tags, status
+// and backend. Unknown properties are "-".
+func (k keyInfo) columns() []string {
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	typ, digits, algorithm, step := "TOTP", "-", orDash(k.algorithm), "-"
+	switch {
+	case k.ocra:
+		// The suite holds the algorithm.
+		typ, algorithm = "OCRA", "-"
+	case k.hotp:
+		typ = "HOTP"
+	case k.yandex:
+		typ, algorithm = "Yandex", "SHA256"
+	}
+	if k.digits > 0 {
+		digits = strconv.Itoa(k.digits)
+	}
+	if k.counter != "" {
+		step = "#" + k.counter
+	} else if !k.hotp && k.period > 0 {
+		step = strconv.Itoa(k.period) + "s"
+	}
+	status := "verified"
+	if k.unverified {
+		status = "unverified"
+	}
+	return []string{isolate(k.name), isolate(orDash(k.identity())), typ, digits, algorithm, step, isolate(orDash(strings.Join(k.tags, ","))), status, k.source.String()}
+}
+
+// columnWidths returns the width of each column of rows.
+func columnWidths(rows [][]string) []int {
+	var widths []int
+	for _, row := range rows {
+		for i, s := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := displayWidth(s); widths[i] < w {
+				widths[i] = w
+			}
+		}
+	}
+	return widths
+}
+
+// alignColumns pads the columns of row to widths, but the last.
+func alignColumns(row []string, widths []int) []string {
+	out := make([]string, len(row))
+	for i, s := range row {
+		if i < len(row)-1 {
+			s = padRight(s, widths[i])
+		}
+		out[i] = s
+	}
+	return out
+}
+
+// lastUsed describes t, the time a key was last used, as of now.
+func lastUsed(t, now time.Time) string {
+	if t.IsZero() {
+		return "never used"
+	}
+	// calendar days, so that yesterday evening is yesterday
+	y, m, d := t.Local().Date()
+	then := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	y, m, d = now.Local().Date()
+	days := int(time.Date(y, m, d, 0, 0, 0, 0, time.Local).Sub(then).Hours()+12) / 24
+	ago := fmt.Sprintf("%d days ago", days)
+	switch {
+	case days <= 0:
+		ago = "today"
+	case days == 1:
+		ago = "yesterday"
+	}
+	return fmt.Sprintf("last used %s (%s)", t.Local().Format("2006-01-02 15:04"), ago)
+}
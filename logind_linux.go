@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// watchSessionLock calls forget whenever logind locks a session or puts
+// the system to sleep. dbus-monitor relays its signals from the system
+// bus.
+func watchSessionLock(forget func()) error {
+	cmd := exec.Command("dbus-monitor", "--system",
+		"type='signal',interface='org.freedesktop.login1.Session',member='Lock'",
+		"type='signal',interface='org.freedesktop.login1.Manager',member='PrepareForSleep'")
+	// It goes when the agent does.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		s := bufio.NewScanner(out)
+		for s.Scan() {
+			line := s.Text()
+			if strings.HasPrefix(line, "signal ") && (strings.Contains(line, "member=Lock") || strings.Contains(line, "member=PrepareForSleep")) {
+				forget()
+			}
+		}
+		cmd.Wait()
+	}()
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// watchSessionLock calls forget whenever the session is locked, which
+// only logind on Linux tells.
+func watchSessionLock(forget func()) error {
+	return errors.New("session locks aren't watched on this system")
+}
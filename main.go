@@ -2,25 +2,208 @@
 //
 // Usage:
 //
-//	gauth -add [-7] [-8] [-hotp] name
-//	gauth -list
-//	gauth name
+//	gauth add [-force] [-hotp | -ocra suite | -yandex] [-hex] [-transform t] [-t0 time] [-url url] [-issuer issuer] [-account account] [-note text] [-show-input | -stdin | -file file | -secret-cmd command | -clipboard | -qr image | -qr-screen | -camera] name
+//	gauth gen [-bytes n] [-hotp] [-digits n] [-issuer issuer] [-account account] [-o file] name
+//	gauth enroll [-bytes n] [-hotp] [-digits n] [-issuer issuer] [-account account] [-codes n] name
+//	gauth rm [-f] name...
+//	gauth edit [-note text] [-url url] [-issuer issuer] [-account account] name
+//	gauth list [-long | -verbose] [-all] [-tag tag] [-sort order] [query]
+//	gauth search [-regexp] [-codes] [-all] query
+//	gauth show [-remaining] [-long] [-json | -clip] [-group n] [-no-color] [-all] [-sort order] [-tag tag | -stdin | name...]
+//	gauth open [-notify] name
+//	gauth paste [-notify] [-min-validity seconds] [-timeout duration] name
+//	gauth tui
+//	gauth ocra -challenge q [-password pin] [-session hex] name
+//	gauth battlenet attach [-token token] name
+//	gauth env [-shell sh|fish|powershell] [-prefix prefix] name
+//	gauth exec [-prefix prefix] [-retry-status n] name command [arg...]
+//	gauth confirm name...
+//	gauth verify [-skew n] [-lookahead n] name code
+//	gauth tag [-d] name [tag...]
+//	gauth favorite [-d] name...
+//	gauth recovery add name [code...] | recovery show [-all] name | recovery use name [code]
+//	gauth archive [-d] name...
+//	gauth import [-dry-run] [-prefix prefix] [-key-file file | -map map] [format] file
+//	gauth import [-dry-run] -scan dir
+//	gauth import [-dry-run] -recover share...
+//	gauth export [-o file | -encrypt file] [-shamir KofN] [name...]
+//	gauth export [-o file] -paper name
+//	gauth export [-o file.png] -qr name
+//	gauth export uris [-o file] [-force] [name...]
+//	gauth audit verify
+//	gauth integrity init | verify | update
+//	gauth encrypt [-d]
+//	gauth protect [-d] name
+//	gauth restore [-f] [n]
+//	gauth doctor [-fix]
+//	gauth wipe [-f] | wipe -duress
+//	gauth migrate -from backend -to backend
+//	gauth profiles
+//	gauth sync [-force] init [remote] | push | pull
+//	gauth cloud push | pull
+//	gauth diff file
+//	gauth merge file
+//	gauth agent run | ping | status [-json]
+//	gauth lock
+//	gauth unlock
+//	gauth schema [name]
+//	gauth version
+//	gauth help [command]
+//	gauth [-remaining] name...
+//	gauth [-stdin-keychain] [-offline] [-readonly] [-strict] [-json-errors] [-profile name] command [arguments]
 //
-// To add a new key to keychain use "gauth -add name", where name is a given name.
+// To add a new key to keychain use "gauth add name", where name is a given name.
 // It'll prompt a 2fa key from stdin
 // 2fa keys are case-insensitive strings [A-Z2-7].
+// On a terminal the key isn't echoed and has to be typed twice;
+// -show-input echoes it and asks once.
+// Instead of prompting, add can read the key from stdin (-stdin), a file
+// (-file), the output of a command such as "gpg -d seed.gpg" (-secret-cmd),
+// the clipboard (-clipboard, cleared right after), or a QR code
+// in an image (-qr), on the screen (-qr-screen) or shown to the camera
+// (-camera). QR codes are decoded in pure Go, or with the zbar tools in
+// builds with the zbar tag; the camera always needs zbarcam.
 //
 // Default generation algorithm is time based auth codes
 // (TOTP - the same as Google Authenticator)
 //
 // There is also EXPERIMENTAL support of counter based auth codes (HOTP).
 //
+// To remove keys use "gauth rm name".
 //
-// To list all names in the keychain use "gauth -list"
+// To list all names in the keychain use "gauth list". Keys are flagged
+// as unverified until "gauth confirm name" records that their code
+// worked.
 //
-// To print certain 2fa auth code use "gauth name"
+// "gauth recovery add name" keeps the recovery codes of a key with it;
+// "gauth recovery use name" hands out one and marks it used.
 //
-// If no arguments are provided, gauth prints all 2fa TOTP auth codes.
+// "gauth verify name code" checks a code the way a server does, for
+// services which validate their users' codes with gauth: it exits with
+// status 5 unless the code is valid, and accepts each code only once.
+// "gauth gen name" issues the key for such a service: it generates a
+// random secret, adds it, and prints its otpauth URI and QR code.
+// "gauth enroll name" adds it only once the user's phone gives a valid
+// code, or two consecutive ones with -codes 2.
+//
+// To print certain 2fa auth code use "gauth show name", or just "gauth name".
+// Several names print their codes in order, one per line, or as JSON
+// with -json; with -stdin the names are read from stdin.
+// With -remaining gauth also tells, in the language of the current
+// locale, how many seconds the code stays valid.
+//
+// If no arguments are provided, gauth prints all 2fa TOTP auth codes,
+// on a terminal each with the seconds it stays valid, in yellow and
+// red when about to expire unless -no-color or $NO_COLOR is set.
+//
+// "gauth open name" copies the code to the clipboard and opens the
+// login page of the key, its url attribute, in the browser.
+// "gauth paste name" copies the code and waits until it's pasted,
+// exiting with status 2 if it expires first. With -notify, or
+// "notify = yes" in the configuration, both raise desktop
+// notifications naming the key. The clipboard is reached without
+// clipboard tools where gauth can: over the Wayland and X11 protocols,
+// and through the system's clipboard on macOS and Windows.
+//
+// The "after-code" lines of the configuration are commands run after
+// each code gauth generates, given the key name and the code in
+// $GAUTH_NAME and $GAUTH_CODE and on stdin; see hooks.go.
+//
+// "gauth add -ocra suite name" adds an OCRA (RFC 6287) challenge-response
+// key, and "gauth ocra -challenge q name" prints its response to a
+// challenge, as banking tokens ask for. "gauth add -yandex name" adds
+// a Yandex.Key key, asking for its PIN. "gauth battlenet attach name"
+// enrolls a new Battle.net authenticator with Blizzard and adds it.
+//
+// "gauth tui" shows the keys full-screen with their live codes, for
+// searching them, copying codes with Enter, and adding, editing and
+// deleting keys.
+//
+// To back up keys use "gauth export -o file", or "gauth export -encrypt
+// file" for a passphrase-encrypted backup; "gauth export uris" writes
+// them as otpauth URIs for other tools. To re-import keys from
+// a backup use "gauth import file". Keys already present are skipped.
+// If a backup entry has the same secret as an existing key but different
+// parameters (digits, period, algorithm or type changed by the provider),
+// gauth reports it and offers to update the existing key. "gauth import
+// format file" imports the export file of another authenticator; see
+// "gauth help import" for the supported formats.
+//
+// Before the keychain is rewritten, by rm or import, the previous version
+// is copied to $HOME/.gauth.bak.d. The 10 newest copies are kept; set
+// $GAUTH_BACKUPS to keep another number of them, or 0 to disable backups.
+// "gauth restore" rolls the keychain back to one of them.
+//
+// Settings are read from $HOME/.gauth.conf, or the file named by
+// $GAUTH_CONFIG. Its "backend" lines configure several key stores:
+// list and show search all of them, and a name resolves to the first
+// backend which has it. See backend.go for the available backends.
+//
+// With "audit = file" in the configuration, generated codes and keychain
+// changes are recorded in a hash-chained log; "gauth audit verify"
+// checks that it wasn't tampered with.
+//
+// With "integrity = keyring" or "integrity = passphrase", gauth keeps
+// HMACs of the keys in $HOME/.gauth.mac after "gauth integrity init",
+// and refuses to read a keychain changed outside gauth.
+//
+// With -stdin-keychain, given before the command, gauth reads the
+// keychain from stdin instead, for example decrypted by sops or age in
+// a pipe, and never writes it: the keychain exists only in memory,
+// configured backends are ignored, and commands which would change it
+// (including showing HOTP codes) fail. Services started by systemd
+// with "LoadCredentialEncrypted=gauth.keychain" read the keychain from
+// that credential the same way.
+//
+// gauth exits with status 2 for unknown keys, 3 for invalid keychains,
+// 4 for a wrong clock and 5 for failed verifications, see exit.go;
+// with -json-errors, given before the command, it writes its errors as
+// JSON.
+//
+// With -readonly, or "readonly = yes" in the configuration, gauth never
+// writes the keychain: TOTP codes work, while HOTP codes and commands
+// which change keys fail with an error before anything is written. It
+// applies by itself when the keychain isn't writable, as on a
+// read-only filesystem.
+//
+// Invalid keychain lines are reported, with their line and column, and
+// skipped. With -strict, or "strict = yes" in the configuration, gauth
+// exits with status 3 instead, for keychains written by automation.
+//
+// On Linux and OpenBSD, gauth sandboxes itself once its arguments are
+// parsed: it can't open network sockets, nor write outside the places
+// it keeps its files, nor run programs unless the command or the
+// configuration needs them (see sandbox.go). Commands which need the
+// network aren't sandboxed, and "sandbox = no" in the configuration
+// turns it off.
+//
+// With an "encrypt" line in the configuration, "gauth encrypt" encrypts
+// the keychain with a key protected by a passphrase, sealed to the TPM,
+// kept in the Secure Enclave of a Mac behind Touch ID, derived from a
+// YubiKey or a FIDO2 security key, or encrypted by systemd-creds.
+// "gauth protect name" seals a single key with a passphrase of its
+// own, asked for whenever a code of that key is.
+//
+// With -profile name, or $GAUTH_PROFILE, gauth uses the keychain
+// $HOME/.gauth-name and the configuration $HOME/.gauth-name.conf
+// instead, to keep work and personal seeds apart; "gauth profiles"
+// lists the profiles.
+//
+// "gauth sync" keeps the keychain in a git repository, $HOME/.gauth.sync,
+// and pushes and pulls it to share it between machines. "gauth cloud"
+// backs it up to S3, Google Cloud Storage or WebDAV, encrypted first.
+//
+// "gauth wipe", or "gauth -wipe", overwrites and deletes the keychain
+// and its backups. With a passphrase-encrypted keychain, "gauth wipe
+// -duress" sets a passphrase which does the same when it's given.
+//
+// With -offline, or "offline = yes" in the configuration, gauth makes
+// no network connections; builds with the offline tag leave the network
+// code out altogether. "gauth version" tells which applies.
+//
+// Every command has its own flags, described by "gauth help command".
+// The flags of older gauth versions, "gauth -add name", "gauth -list"
+// and "gauth -import file", are still accepted.
 //
 // IMPORTANT NOTE:
 // TOTP auth codes are derived from key hash and current time.
@@ -37,7 +220,7 @@
 //
 // Add it to 2fa under the name google, typing the secret at the prompt:
 //
-//	$ gauth -add google
+//	$ gauth add google
 //	gauth key for google: <secret>
 //	$
 //
@@ -51,269 +234,316 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/base32"
-	"encoding/binary"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
-	"time"
-	"unicode"
 )
 
-// Keychain is a file format storage.
-type Keychain struct {
-	file string
-	data []byte
-	keys map[string]Key
-}
+// A command is a gauth subcommand.
+type command struct {
+	name  string
+	usage string // usage line, without the program name
+	short string // one-line description for the command list
+	long  string // description for "gauth help name"
+	flags flag.FlagSet
+	run   func(ctx context.Context, cmd *command, args []string)
 
-// Key describes `keys` in Keychain
-type Key struct {
-	raw    []byte
-	digits int // length
-	offset int // counter offset
+	network bool // needs the network, so isn't sandboxed
+	// programs, if not nil, tells whether the command runs programs
+	// once its flags are parsed, which the sandbox then allows.
+	programs func() bool
+	// writes, if not nil, returns the files the command writes
+	// outside the keychain's directory, given its arguments.
+	writes func(args []string) []string
 }
 
-const counterLen = 20
+// commands lists the subcommands in the order help shows them.
+var commands = []*command{
+	cmdAdd,
+	cmdGen,
+	cmdEnroll,
+	cmdRm,
+	cmdEdit,
+	cmdList,
+	cmdSearch,
+	cmdShow,
+	cmdOpen,
+	cmdPaste,
+	cmdOcra,
+	cmdBattlenet,
+	cmdTUI,
+	cmdEnv,
+	cmdExec,
+	cmdConfirm,
+	cmdVerify,
+	cmdMigrate,
+	cmdTag,
+	cmdFavorite,
+	cmdRecovery,
+	cmdArchive,
+	cmdImport,
+	cmdExport,
+	cmdAudit,
+	cmdIntegrity,
+	cmdEncrypt,
+	cmdProtect,
+	cmdRestore,
+	cmdDoctor,
+	cmdWipe,
+	cmdProfiles,
+	cmdSync,
+	cmdCloud,
+	cmdDiff,
+	cmdMerge,
+	cmdAgent,
+	cmdLock,
+	cmdUnlock,
+	cmdSchema,
+	cmdVersion,
+	cmdHelp,
+}
 
-var (
-	flagAdd  = flag.Bool("add", false, "add a key")
-	flagList = flag.Bool("list", false, "list keys")
-	flagHotp = flag.Bool("hotp", false, "add key as HOTP (counter-based) key")
-)
+var cmdHelp = &command{
+	name:  "help",
+	usage: "help [command]",
+	short: "describe a command",
+}
 
-func help() {
-	fmt.Fprintf(os.Stderr, "usage:\n")
-	fmt.Fprintf(os.Stderr, "\t%s -add [-hotp] keyname\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "\t%s -list\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "\t%s keyname\n", os.Args[0])
-	os.Exit(1)
+func init() {
+	cmdHelp.run = runHelp
 }
 
-// Read line by line into memory
-// handling key length and validity
-func readKeychain(file string) *Keychain {
-	c := &Keychain{
-		file: file,
-		keys: make(map[string]Key),
-	}
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return c
-		}
-		log.Fatal(err)
-	}
-	c.data = data
+var stdin = bufio.NewReader(os.Stdin)
 
-	lines := bytes.SplitAfter(data, []byte("\n"))
-	offset := 0
-	for i, line := range lines {
-		lineno := i + 1
-		offset += len(line)
-		f := bytes.Split(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
-		if len(f) == 1 && len(f[0]) == 0 {
-			continue
+func lookup(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
 		}
-		if len(f) >= 3 && len(f[1]) == 1 && '6' <= f[1][0] && f[1][0] <= '8' {
-			var k Key
-			name := string(f[0])
-			k.digits = int(f[1][0] - '0')
-			raw, err := decodeKey(string(f[2]))
-			if err == nil {
-				k.raw = raw
-				if len(f) == 3 {
-					c.keys[name] = k
-					continue
-				}
-				if len(f) == 4 && len(f[3]) == counterLen {
-					_, err := strconv.ParseUint(string(f[3]), 10, 64)
-					// even in case of err handle counter and pass it further
-					if err == nil {
-						k.offset = offset - counterLen
-						if line[len(line)-1] == '\n' {
-							k.offset--
-						}
-						c.keys[name] = k
-						continue
-					}
-				}
-			}
-		}
-		log.Printf("%s:%d: invalid key", c.file, lineno)
 	}
-	return c
+	return nil
 }
 
-// dump 2fa list
-func (c *Keychain) list() {
-	var names []string
-	for name := range c.keys {
-		names = append(names, name)
+func (cmd *command) printUsage() {
+	fmt.Fprintf(os.Stderr, "usage: %s %s\n", os.Args[0], cmd.usage)
+	if cmd.long != "" {
+		fmt.Fprintf(os.Stderr, "\n%s\n", cmd.long)
 	}
-	sort.Strings(names)
-	for _, name := range names {
-		fmt.Println(name)
+	hasFlags := false
+	cmd.flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintf(os.Stderr, "\nflags:\n")
+		cmd.flags.PrintDefaults()
 	}
 }
 
-func checkSpace(r rune) rune {
-	if unicode.IsSpace(r) {
-		return -1
+func (cmd *command) usageExit() {
+	cmd.printUsage()
+	os.Exit(1)
+}
+
+func help() {
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	fmt.Fprintf(os.Stderr, "\t%s [-stdin-keychain] [-offline] [-readonly] [-strict] [-json-errors] [-profile name] command [arguments]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\t%s [-remaining] keyname...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-8s %s\n", cmd.name, cmd.short)
 	}
-	return r
+	fmt.Fprintf(os.Stderr, "\n-stdin-keychain reads the keychain from stdin and keeps it in memory only.\n")
+	fmt.Fprintf(os.Stderr, "-offline forbids all network connections.\n")
+	fmt.Fprintf(os.Stderr, "-readonly never writes the keychain; HOTP codes fail.\n")
+	fmt.Fprintf(os.Stderr, "-strict fails on invalid keychain lines rather than skipping them.\n")
+	fmt.Fprintf(os.Stderr, "-json-errors writes errors and warnings as JSON.\n")
+	fmt.Fprintf(os.Stderr, "-profile uses another keychain and configuration (see \"%s help profiles\").\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\nRun \"%s help command\" for details.\n", os.Args[0])
+	os.Exit(1)
 }
 
-// handle flag conflicts and verify key validity
-func (c *Keychain) add(name string) {
-	size := 6
-	fmt.Fprintf(os.Stderr, "gauth key for %s: ", name)
-	text, err := bufio.NewReader(os.Stdin).ReadString('\n')
-	if err != nil {
-		log.Fatalf("error reading key: %v", err)
+func runHelp(ctx context.Context, cmd *command, args []string) {
+	if len(args) == 0 {
+		help()
 	}
-	text = strings.Map(checkSpace, text)
-	if _, err := decodeKey(text); err != nil {
-		log.Fatalf("invalid key: %v", err)
+	if len(args) != 1 || lookup(args[0]) == nil {
+		cmd.usageExit()
 	}
+	lookup(args[0]).printUsage()
+}
 
-	line := fmt.Sprintf("%s %d %s", name, size, text)
-	if *flagHotp {
-		line += " " + strings.Repeat("0", 20)
+// confirm asks a yes/no question on stderr and reads the answer from stdin.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	text, err := stdin.ReadString('\n')
+	if err != nil && text == "" {
+		return false
 	}
-	line += "\n"
+	text = strings.ToLower(strings.TrimSpace(text))
+	return text == "y" || text == "yes"
+}
 
-	f, err := os.OpenFile(c.file, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
-	if err != nil {
-		log.Fatalf("opening keychain: %v", err)
+// dispatch returns the command of a command line and its arguments:
+// the command its first word names, else show, as "gauth name" shows
+// the code of key name. shadowed reports that the command's name is
+// also that of a key, according to hasKey, which keys named before
+// the command was added may be.
+func dispatch(args []string, hasKey func(name string) bool) (cmd *command, rest []string, shadowed bool) {
+	if len(args) > 0 {
+		if c := lookup(args[0]); c != nil {
+			return c, args[1:], hasKey(c.name)
+		}
 	}
-	// vital
-	f.Chmod(0600)
+	return cmdShow, args, false
+}
 
-	if _, err := f.Write([]byte(line)); err != nil {
-		log.Fatalf("adding key: %v", err)
+// knownKey reports whether the user's keychain has a key name, as far
+// as it can be told without asking for a passphrase: an encrypted
+// keychain is taken to have none.
+func knownKey(name string) bool {
+	if memoryKeychain != nil {
+		_, ok := memoryKeychain.keys[name]
+		return ok
 	}
-	if err := f.Close(); err != nil {
-		log.Fatalf("closing keychain while adding key: %v", err)
+	file := keychainPath()
+	if offset, known := indexOffset(file, name); known {
+		return offset >= 0
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil || isEncryptedKeychain(data) {
+		return false
 	}
+	lockMemory(data)
+	defer wipe(data)
+	c, _ := scanKeychain(file, data)
+	defer c.wipeKeys()
+	_, ok := c.keys[name]
+	return ok
 }
 
-func (c *Keychain) code(name string) string {
-	k, ok := c.keys[name]
-	if !ok {
-		log.Fatalf("no such key %q", name)
-	}
-	var code int
-	if k.offset != 0 {
-		n, err := strconv.ParseUint(string(c.data[k.offset:k.offset+counterLen]), 10, 64)
-		if err != nil {
-			log.Fatalf("invalid key counter for %q (%q)", name, c.data[k.offset:k.offset+counterLen])
-		}
-		n++
-		code = genHOTP(k.raw, n, k.digits)
-		f, err := os.OpenFile(c.file, os.O_RDWR, 0600)
-		if err != nil {
-			log.Fatalf("opening keychain: %v", err)
-		}
-		if _, err := f.WriteAt([]byte(fmt.Sprintf("%0*d", counterLen, n)), int64(k.offset)); err != nil {
-			log.Fatalf("updating keychain: %v", err)
+// legacyModes maps the mode flags of the old flag-only interface to commands.
+// "-ocra name -challenge q" is the form OCRA users know from other tools.
+var legacyModes = map[string]bool{"add": true, "list": true, "import": true, "wipe": true, "ocra": true, "verify": true}
+
+// globalFlags are the flags which apply to every command, given before
+// it. Profile takes a value.
+var globalFlags = map[string]bool{"stdin-keychain": true, "offline": true, "readonly": true, "strict": true, "json-errors": true, "profile": true}
+
+// legacyArgs splits the global flags off the leading flags of a command
+// line, and rewrites an old flag-only command line, such as
+// "-add -hotp name" or "-import file", into its subcommand form: so
+// "-readonly -list" lists read-only, as "-readonly list" does.
+func legacyArgs(args []string) (globals, rest []string) {
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
 		}
-		if err := f.Close(); err != nil {
-			log.Fatalf("closing keychain while updating keychain: %v", err)
+		name := strings.TrimLeft(arg, "-")
+		switch {
+		case name == "profile":
+			if i+1 == len(args) {
+				// No value: an invalid flag of the command.
+				rest = append(rest, arg)
+				continue
+			}
+			globals = append(globals, arg, args[i+1])
+			i++
+		case globalFlags[name] || strings.HasPrefix(name, "profile="):
+			globals = append(globals, arg)
+		default:
+			rest = append(rest, arg)
 		}
-	} else {
-		// Time-based key.
-		code = genTOTP(k.raw, time.Now(), k.digits)
 	}
-	return fmt.Sprintf("%0*d", k.digits, code)
-}
-
-func (c *Keychain) print(name string) {
-	fmt.Printf("%s\n", c.code(name))
+	rest = append(rest, args[i:]...)
+	return globals, legacyMode(rest)
 }
 
-func (c *Keychain) printAll() {
-	var names []string
-	max := 0
-	maxDigits := 0
-	for name, k := range c.keys {
-		names = append(names, name)
-		if max < len(name) {
-			max = len(name)
+// legacyMode moves the mode flag of an old flag-only command line,
+// args without its global flags, to the front as a command.
+func legacyMode(args []string) []string {
+	for i, arg := range args {
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
 		}
-		if max < k.digits {
-			max = k.digits
+		name := strings.TrimLeft(arg, "-")
+		value := ""
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value = name[:j], name[j+1:]
 		}
-	}
-	sort.Strings(names)
-	for _, name := range names {
-		k := c.keys[name]
-		code := strings.Repeat("-", k.digits)
-		if k.offset == 0 {
-			code = c.code(name)
+		if !legacyModes[name] {
+			continue
 		}
-		fmt.Printf("%-*s\t%s\n", maxDigits, code, name)
-	}
-}
-
-func decodeKey(key string) ([]byte, error) {
-	return base32.StdEncoding.DecodeString(strings.ToUpper(key))
-}
-
-func genTOTP(key []byte, t time.Time, digits int) int {
-	return genHOTP(key, uint64(t.UnixNano())/30e9, digits)
-}
-
-func genHOTP(key []byte, counter uint64, digits int) int {
-	h := hmac.New(sha1.New, key)
-	binary.Write(h, binary.BigEndian, counter)
-	sum := h.Sum(nil)
-	v := binary.BigEndian.Uint32(sum[sum[len(sum)-1]&0x0F:]) & 0x7FFFFFFF
-	d := uint32(1)
-	for i := 0; i < digits && i < 8; i++ {
-		d *= 10
+		out := append([]string{name}, args[:i]...)
+		out = append(out, args[i+1:]...)
+		if value != "" {
+			out = append(out, value)
+		}
+		return out
 	}
-	return int(v % d)
+	return args
 }
 
 func main() {
 	log.SetPrefix("gauth: ")
 	log.SetFlags(0)
-	flag.Usage = help
-	flag.Parse()
-
-	k := readKeychain(filepath.Join(os.Getenv("HOME"), ".gauth"))
 
-	if *flagList {
-		if flag.NArg() != 0 {
-			help()
-		}
-		k.list()
+	if kind := os.Getenv(clipboardOwnerEnv); kind != "" {
+		ownClipboard(kind)
 		return
 	}
-	if flag.NArg() == 0 && !*flagAdd {
-		k.printAll()
+	if after := os.Getenv(clipboardClearEnv); after != "" {
+		clearClipboardLater(after)
 		return
 	}
-	if flag.NArg() != 1 {
-		help()
+
+	globals, args := legacyArgs(os.Args[1:])
+	stdinFlag := false
+	for ; len(globals) > 0; globals = globals[1:] {
+		name := strings.TrimLeft(globals[0], "-")
+		if name == "stdin-keychain" {
+			stdinFlag = true
+		} else if name == "offline" {
+			offlineFlag = true
+		} else if name == "readonly" {
+			readonlyFlag = true
+		} else if name == "strict" {
+			strictFlag = true
+		} else if name == "json-errors" {
+			log.SetOutput(jsonErrors{})
+		} else if name == "profile" {
+			profile = globals[1]
+			globals = globals[1:]
+		} else if strings.HasPrefix(name, "profile=") {
+			profile = name[len("profile="):]
+		}
 	}
-	name := flag.Arg(0)
-	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
-		log.Fatal("spaces aren't allowed")
+	if profile != "" {
+		// The configuration is the profile's.
+		checkProfile(profile)
+		conf = loadConfig()
 	}
-	if *flagAdd {
-		k.add(name)
-		return
+	if stdinFlag {
+		memoryKeychain = readStdinKeychain()
+	}
+	if memoryKeychain == nil {
+		memoryKeychain = readCredentialKeychain()
+	}
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		help()
+	}
+	cmd, args, shadowed := dispatch(args, knownKey)
+	if shadowed {
+		log.Printf("warning: %s is both a gauth command and the name of a key: \"gauth show %s\" shows the key's code", cmd.name, cmd.name)
 	}
-	k.print(name)
+	cmd.flags.Init(cmd.name, flag.ExitOnError)
+	cmd.flags.Usage = cmd.printUsage
+	cmd.flags.Parse(args)
+	auditCommand = cmd.name
+	args = cmd.flags.Args()
+	sandbox(cmd, args)
+	cmd.run(context.Background(), cmd, args)
 }
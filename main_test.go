@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLegacyArgs(t *testing.T) {
+	tests := []struct {
+		args, globals, rest []string
+	}{
+		{[]string{"list"}, nil, []string{"list"}},
+		{[]string{"-list"}, nil, []string{"list"}},
+		{[]string{"-add", "-hotp", "name"}, nil, []string{"add", "-hotp", "name"}},
+		{[]string{"-hotp", "-add", "name"}, nil, []string{"add", "-hotp", "name"}},
+		{[]string{"-import=file"}, nil, []string{"import", "file"}},
+		{[]string{"-ocra", "name", "-challenge", "q"}, nil, []string{"ocra", "name", "-challenge", "q"}},
+		{[]string{"-readonly", "list"}, []string{"-readonly"}, []string{"list"}},
+		{[]string{"-readonly", "name"}, []string{"-readonly"}, []string{"name"}},
+		{[]string{"-remaining", "-readonly", "name"}, []string{"-readonly"}, []string{"-remaining", "name"}},
+		{[]string{"-list", "-readonly"}, []string{"-readonly"}, []string{"list"}},
+		{[]string{"-add", "-strict", "-hotp", "name"}, []string{"-strict"}, []string{"add", "-hotp", "name"}},
+		{[]string{"-profile", "work", "-offline", "-list"}, []string{"-profile", "work", "-offline"}, []string{"list"}},
+		{[]string{"--profile=work", "--list"}, []string{"--profile=work"}, []string{"list"}},
+		{[]string{"-readonly=true", "list"}, nil, []string{"-readonly=true", "list"}},
+		{[]string{"-profile"}, nil, []string{"-profile"}},
+		{[]string{"--", "-readonly"}, nil, []string{"--", "-readonly"}},
+	}
+	for _, tt := range tests {
+		globals, rest := legacyArgs(tt.args)
+		if !reflect.DeepEqual(globals, tt.globals) || !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("legacyArgs(%q) = %q, %q, want %q, %q", tt.args, globals, rest, tt.globals, tt.rest)
+		}
+	}
+}
+
+// TestLegacyArgsGlobals checks every global flag before every legacy mode.
+func TestLegacyArgsGlobals(t *testing.T) {
+	globals := [][]string{
+		{"-stdin-keychain"},
+		{"-offline"},
+		{"-readonly"},
+		{"-strict"},
+		{"-json-errors"},
+		{"-profile", "x"},
+		{"-profile=x"},
+		{"-readonly", "-stdin-keychain", "-profile=x"},
+	}
+	modes := [][]string{
+		{"-list"},
+		{"-add", "name"},
+		{"-add", "-hotp", "name"},
+		{"-import", "file"},
+		{"-import=file"},
+		{"-wipe"},
+		{"-ocra", "name", "-challenge", "q"},
+		{"-verify", "name", "123456"},
+	}
+	for _, g := range globals {
+		for _, m := range modes {
+			args := append(append([]string{}, g...), m...)
+			_, want := legacyArgs(m)
+			gotGlobals, got := legacyArgs(args)
+			if !reflect.DeepEqual(gotGlobals, g) || !reflect.DeepEqual(got, want) {
+				t.Errorf("legacyArgs(%q) = %q, %q, want %q, %q", args, gotGlobals, got, g, want)
+			}
+			if strings.HasPrefix(got[0], "-") || lookup(got[0]) == nil {
+				t.Errorf("legacyArgs(%q) gives no command: %q", args, got)
+			}
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	keys := map[string]bool{"github": true, "sync": true}
+	hasKey := func(name string) bool { return keys[name] }
+	tests := []struct {
+		args     []string
+		cmd      string
+		rest     []string
+		shadowed bool
+	}{
+		{nil, "show", nil, false},
+		{[]string{"github"}, "show", []string{"github"}, false},
+		{[]string{"list"}, "list", []string{}, false},
+		{[]string{"sync"}, "sync", []string{}, true},
+		{[]string{"sync", "push"}, "sync", []string{"push"}, true},
+		{[]string{"show", "sync"}, "show", []string{"sync"}, false},
+		{[]string{"-remaining", "sync"}, "show", []string{"-remaining", "sync"}, false},
+	}
+	for _, tt := range tests {
+		cmd, rest, shadowed := dispatch(tt.args, hasKey)
+		if cmd.name != tt.cmd || !reflect.DeepEqual(rest, tt.rest) || shadowed != tt.shadowed {
+			t.Errorf("dispatch(%q) = %s, %q, %v, want %s, %q, %v", tt.args, cmd.name, rest, shadowed, tt.cmd, tt.rest, tt.shadowed)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxCandidates is the number of keys listed when a name is ambiguous.
+const maxCandidates = 8
+
+// resolve is lookup for names typed by the user. A name which isn't
+// a key resolves to the only key it equals or else is a prefix of,
+// ignoring case.
+// If it's the prefix of several keys, or of none, the error lists the
+// keys it may have meant: those it's a prefix of, or failing that,
+// those within a typo or two of it, such as github for githbu.
+func (f federation) resolve(ctx context.Context, name string) (keyInfo, error) {
+	k, err := f.lookup(ctx, name)
+	if err == nil {
+		return k, nil
+	}
+	// The error of lookup reports the backends which failed.
+	keys, _ := f.keys(ctx)
+	var prefixed, equal []keyInfo
+	for _, k := range keys {
+		if strings.HasPrefix(strings.ToLower(k.name), strings.ToLower(name)) {
+			prefixed = append(prefixed, k)
+		}
+		if strings.EqualFold(k.name, name) {
+			equal = append(equal, k)
+		}
+	}
+	if len(equal) == 1 {
+		prefixed = equal
+	}
+	if len(prefixed) == 1 {
+		fmt.Fprintf(os.Stderr, "using key %s\n", prefixed[0].name)
+		return prefixed[0], nil
+	}
+	if len(prefixed) > 1 {
+		return keyInfo{}, fmt.Errorf("ambiguous key name %q, matching %s", name, candidates(prefixed))
+	}
+	var near []keyInfo
+	for d := 1; d <= maxTypos(name) && len(near) == 0; d++ {
+		for _, k := range keys {
+			if editDistance(strings.ToLower(name), strings.ToLower(k.name)) == d {
+				near = append(near, k)
+			}
+		}
+	}
+	if len(near) > 0 {
+		return keyInfo{}, fmt.Errorf("no such key %q; did you mean %s?", name, candidates(near))
+	}
+	return keyInfo{}, err
+}
+
+// maxTypos returns how many typos a name may have to be suggested.
+func maxTypos(name string) int {
+	if n := len([]rune(name)); n < 6 {
+		return 1
+	}
+	return 2
+}
+
+// candidates lists the names of keys, up to maxCandidates of them.
+func candidates(keys []keyInfo) string {
+	var names []string
+	for i, k := range keys {
+		if i == maxCandidates {
+			names = append(names, fmt.Sprintf("and %d more", len(keys)-i))
+			break
+		}
+		names = append(names, k.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// editDistance returns the number of insertions, deletions, substitutions
+// and transpositions of adjacent characters turning a into b (the optimal
+// string alignment distance).
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			n := d[i-1][j-1] + cost
+			if d[i-1][j]+1 < n {
+				n = d[i-1][j] + 1
+			}
+			if d[i][j-1]+1 < n {
+				n = d[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] && d[i-2][j-2]+1 < n {
+				n = d[i-2][j-2] + 1
+			}
+			d[i][j] = n
+		}
+	}
+	return d[len(s)][len(t)]
+}
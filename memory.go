@@ -0,0 +1,23 @@
+package main
+
+// Secrets are kept out of swap and wiped after use where gauth can:
+// the keychain file is read into a locked buffer which is wiped once
+// it's parsed, the decoded secrets of keys are locked, and the HMAC
+// keys and other buffers derived from them are wiped when done with.
+// Go strings can't be wiped, so secrets stay in []byte where they're
+// handled in bulk; the lines of the keychain kept for rewriting it
+// and the secrets typed in are strings, and live until gauth exits.
+
+// wipe overwrites b with zeros.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// wipeString returns b as a string, and wipes b.
+func wipeString(b []byte) string {
+	s := string(b)
+	wipe(b)
+	return s
+}
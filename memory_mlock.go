@@ -0,0 +1,14 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package main
+
+import "syscall"
+
+// lockMemory keeps the pages of b from being swapped out. It's best
+// effort: the limit of locked memory (ulimit -l) may be too low.
+func lockMemory(b []byte) {
+	if len(b) > 0 {
+		syscall.Mlock(b)
+	}
+}
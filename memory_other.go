@@ -0,0 +1,7 @@
+//go:build !darwin && !linux && !windows
+// +build !darwin,!linux,!windows
+
+package main
+
+// lockMemory does nothing: the syscall package has no mlock here.
+func lockMemory(b []byte) {}
@@ -0,0 +1,13 @@
+package main
+
+import "unsafe"
+
+var procVirtualLock = kernel32.NewProc("VirtualLock")
+
+// lockMemory keeps the pages of b from being paged out. It's best
+// effort: the working set of the process limits how much is locked.
+func lockMemory(b []byte) {
+	if len(b) > 0 {
+		procVirtualLock.Call(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cmdMigrate = &command{
+	name:  "migrate",
+	usage: "migrate -from backend -to backend",
+	short: "move keys from one backend to another",
+	long: `Migrate copies all keys of a backend to another, with their HOTP
+counters and attributes, such as from the keychain file to the system
+keyring:
+
+	gauth migrate -from file -to keyring
+
+The backends are given as in the "backend" lines of the configuration:
+"file" alone is the keychain, and "keyring" alone the keychain in the
+system keyring. Backends holding keychains can be migrated: file,
+keyring, sops and ssh, whose keychain has to exist already. Vault and
+YubiKey backends never give out their secrets.
+
+Keys the destination already has stop the migration before anything is
+written. Once the keys are copied, the destination is read again and a
+code of each key is generated from both sides: only if they all agree
+does migrate offer to remove the keys from the source. Backups of a
+source keychain file still hold them.`,
+}
+
+var (
+	migrateFrom = cmdMigrate.flags.String("from", "", "copy the keys of `backend`")
+	migrateTo   = cmdMigrate.flags.String("to", "", "copy the keys to `backend`")
+)
+
+func init() {
+	cmdMigrate.run = runMigrate
+	// Its backends may use the network, or files anywhere.
+	cmdMigrate.network = true
+	cmdMigrate.programs = always
+	cmdMigrate.writes = func([]string) []string {
+		var files []string
+		for _, spec := range []string{*migrateFrom, *migrateTo} {
+			if f := strings.Fields(spec); len(f) == 2 && f[0] == "file" {
+				files = append(files, expandHome(f[1]))
+			}
+		}
+		return files
+	}
+}
+
+// A keychainStore is a backend holding a keychain which gauth can
+// rewrite, so migrate can move keys in and out of it.
+type keychainStore interface {
+	keychain(ctx context.Context) (*Keychain, error)
+	// store replaces the keychain with lines.
+	store(ctx context.Context, lines []string) error
+}
+
+// fileStore is the keychainStore of a file backend.
+type fileStore struct{ b *fileBackend }
+
+func (s fileStore) keychain(ctx context.Context) (*Keychain, error) {
+	return s.b.keychain(), nil
+}
+
+func (s fileStore) store(ctx context.Context, lines []string) error {
+	c := s.b.keychain()
+	c.lines = lines
+	c.save()
+	return nil
+}
+
+// openStore opens the backend of spec, a backend line of the
+// configuration, as a keychainStore.
+func openStore(spec string) (backend, keychainStore) {
+	f := strings.Fields(spec)
+	switch {
+	case len(f) == 0:
+		log.Fatal("empty backend")
+	case len(f) == 1 && f[0] == "file":
+		f = append(f, keychainPath())
+	case strings.HasPrefix(f[0], "ssh://"):
+		f = append([]string{"ssh"}, f...)
+	}
+	if networkBackends[f[0]] && offline() {
+		log.Fatalf("backend %s uses the network, which offline mode forbids", f[0])
+	}
+	open, ok := backendTypes[f[0]]
+	if !ok {
+		log.Fatalf("unknown backend type %q", f[0])
+	}
+	b, err := open(f[1:])
+	if err != nil {
+		log.Fatalf("backend %s: %v", f[0], err)
+	}
+	s, ok := storeOf(b)
+	if !ok {
+		log.Fatalf("%s doesn't give out its secrets, so its keys can't be migrated", b)
+	}
+	return b, s
+}
+
+// storeOf returns b as a keychainStore, if it holds a keychain.
+func storeOf(b backend) (keychainStore, bool) {
+	if fb, ok := b.(*fileBackend); ok {
+		return fileStore{fb}, true
+	}
+	s, ok := b.(keychainStore)
+	return s, ok
+}
+
+func runMigrate(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 0 || *migrateFrom == "" || *migrateTo == "" {
+		cmd.usageExit()
+	}
+	from, src := openStore(*migrateFrom)
+	to, dst := openStore(*migrateTo)
+	if from.String() == to.String() {
+		log.Fatal("the source and the destination are the same")
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	sc, err := src.keychain(ctx)
+	checkInterrupted(ctx, "interrupted")
+	if err != nil {
+		log.Fatalf("%s: %v", from, err)
+	}
+	if len(sc.keys) == 0 {
+		log.Fatalf("%s has no keys", from)
+	}
+	var names []string
+	for name := range sc.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dc, err := dst.keychain(ctx)
+	checkInterrupted(ctx, "interrupted")
+	var lines []string
+	if err != nil {
+		// A keyring item which doesn't exist yet.
+		if _, ok := to.(*keyringBackend); !ok {
+			log.Fatalf("%s: %v", to, err)
+		}
+	} else {
+		for _, name := range names {
+			if _, ok := dc.keys[name]; ok {
+				log.Fatalf("%s already has a key %s: nothing was copied", to, name)
+			}
+		}
+		lines = append(lines, dc.lines...)
+	}
+	for _, name := range names {
+		k := sc.keys[name]
+		lines = append(lines, formatKey(name, k, k.count))
+	}
+	if err := dst.store(ctx, lines); err != nil {
+		log.Fatalf("%s: storing the keys: %v", to, err)
+	}
+	fmt.Fprintf(os.Stderr, "copied %d keys from %s to %s\n", len(names), from, to)
+
+	// Check the keys as read back by a fresh backend.
+	_, check := openStore(*migrateTo)
+	cc, err := check.keychain(ctx)
+	checkInterrupted(ctx, "interrupted")
+	if err != nil {
+		fatalf(exitVerifyFailed, "%s: reading the keys back: %v", to, err)
+	}
+	now := time.Now()
+	for _, name := range names {
+		k, ok := cc.keys[name]
+		if !ok {
+			fatalf(exitVerifyFailed, "%s: %s is missing after copying; %s is unchanged", to, name, from)
+		}
+		want := sc.keys[name]
+		if checkCode(want, want.count, now) != checkCode(k, k.count, now) || formatKey(name, k, k.count) != formatKey(name, want, want.count) {
+			fatalf(exitVerifyFailed, "%s: %s differs after copying; %s is unchanged", to, name, from)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "checked the codes of %d keys in %s\n", len(names), to)
+	for _, name := range names {
+		audit("migrate", name)
+	}
+
+	if !confirm(fmt.Sprintf("remove the %d keys from %s?", len(names), from)) {
+		return
+	}
+	sc, err = src.keychain(ctx)
+	if err != nil {
+		log.Fatalf("%s: %v", from, err)
+	}
+	for _, name := range names {
+		if k, ok := sc.keys[name]; ok {
+			sc.lines[k.line] = ""
+		}
+	}
+	if err := src.store(ctx, sc.lines); err != nil {
+		log.Fatalf("%s: removing the keys: %v", from, err)
+	}
+	for _, name := range names {
+		audit("remove", name)
+	}
+	fmt.Fprintf(os.Stderr, "removed %d keys from %s\n", len(names), from)
+}
+
+// checkCode generates a code of k, with counter for HOTP keys, without
+// advancing the counter, to compare copies of a key. OCRA keys, whose
+// codes need a challenge, have none, and neither have protected keys,
+// which are compared sealed.
+func checkCode(k Key, counter string, t time.Time) string {
+	if k.attr("ocra") != "" || k.protected() {
+		return ""
+	}
+	if pin := k.attr("yandex"); pin != "" {
+		return yandexCode(k.raw, pin, t)
+	}
+	key := k.hmacKey()
+	defer wipe(key)
+	if counter != "" {
+		n, _ := strconv.ParseUint(counter, 10, 64)
+		return fmt.Sprintf("%0*d", k.digits, genHOTP(k.hash(), key, n+1, k.digits))
+	}
+	return fmt.Sprintf("%0*d", k.digits, genTOTP(k.hash(), key, t, k.t0(), k.period(), k.digits))
+}
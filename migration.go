@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Google Authenticator exports its keys as QR codes holding
+//
+//	otpauth-migration://offline?data=...
+//
+// URIs, whose data is a base64 protocol buffer message:
+//
+//	message MigrationPayload {
+//		repeated OtpParameters otp_parameters = 1;
+//		...
+//	}
+//	message OtpParameters {
+//		bytes secret = 1;
+//		string name = 2;
+//		string issuer = 3;
+//		Algorithm algorithm = 4; // 1 SHA1, 2 SHA256, 3 SHA512, 4 MD5
+//		DigitCount digits = 5;   // 1 six, 2 eight
+//		OtpType type = 6;        // 1 HOTP, 2 TOTP
+//		int64 counter = 7;
+//	}
+//
+// Unset enums mean the defaults: SHA1, six digits, TOTP.
+
+// parseMigration returns the keys of an otpauth-migration URI.
+// Keys which can't be imported are reported with their error.
+func parseMigration(uri string) ([]entry, []error, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme != "otpauth-migration" {
+		return nil, nil, errors.New("not an otpauth-migration URI")
+	}
+	data := strings.Map(func(r rune) rune {
+		// lenient about the URL-safe alphabet and lost escaping
+		switch r {
+		case '-':
+			return '+'
+		case '_':
+			return '/'
+		case ' ':
+			return '+'
+		}
+		return r
+	}, u.Query().Get("data"))
+	payload, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		if payload, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "=")); err != nil {
+			return nil, nil, fmt.Errorf("invalid migration data: %v", err)
+		}
+	}
+	var entries []entry
+	var errs []error
+	err = walkProto(payload, func(field int, v uint64, b []byte) error {
+		if field != 1 || b == nil {
+			return nil
+		}
+		e, err := parseOtpParameters(b)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", e.name, err))
+			return nil
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid migration data: %v", err)
+	}
+	return entries, errs, nil
+}
+
+func parseOtpParameters(msg []byte) (entry, error) {
+	var (
+		secret       []byte
+		name, issuer string
+		algorithm    = "SHA1"
+		digits       = 6
+		typ          = "totp"
+		counter      uint64
+		badAlgorithm bool
+	)
+	err := walkProto(msg, func(field int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			secret = b
+		case 2:
+			name = string(b)
+		case 3:
+			issuer = string(b)
+		case 4:
+			switch v {
+			case 2:
+				algorithm = "SHA256"
+			case 3:
+				algorithm = "SHA512"
+			case 4:
+				badAlgorithm = true
+			}
+		case 5:
+			if v == 2 {
+				digits = 8
+			}
+		case 6:
+			if v == 1 {
+				typ = "hotp"
+			}
+		case 7:
+			counter = v
+		}
+		return nil
+	})
+	if i := strings.Index(name, ":"); i >= 0 {
+		if issuer == "" {
+			issuer = name[:i]
+		}
+		name = name[i+1:]
+	}
+	issuer, name = strings.TrimSpace(issuer), strings.TrimSpace(name)
+	e := entry{name: entryName(issuer, name)}
+	if err != nil {
+		return e, err
+	}
+	if badAlgorithm {
+		return e, errors.New("unsupported algorithm MD5")
+	}
+	e, err = newEntry(e.name, base32.StdEncoding.EncodeToString(secret), typ, algorithm, digits, 30, counter)
+	e.key.setIdentity(issuer, name)
+	return e, err
+}
+
+// walkProto calls f for each field of a protocol buffer message:
+// with the value of varint fields, or the bytes of length-delimited ones.
+// Fixed-size fields are skipped.
+func walkProto(msg []byte, f func(field int, v uint64, b []byte) error) error {
+	for len(msg) > 0 {
+		key, n := protoVarint(msg)
+		if n == 0 {
+			return errors.New("truncated message")
+		}
+		msg = msg[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := protoVarint(msg)
+			if n == 0 {
+				return errors.New("truncated message")
+			}
+			msg = msg[n:]
+			if err := f(field, v, nil); err != nil {
+				return err
+			}
+		case 1:
+			if len(msg) < 8 {
+				return errors.New("truncated message")
+			}
+			msg = msg[8:]
+		case 2:
+			l, n := protoVarint(msg)
+			if n == 0 || uint64(len(msg)-n) < l {
+				return errors.New("truncated message")
+			}
+			b := msg[n : n+int(l)]
+			msg = msg[n+int(l):]
+			if err := f(field, 0, b); err != nil {
+				return err
+			}
+		case 5:
+			if len(msg) < 4 {
+				return errors.New("truncated message")
+			}
+			msg = msg[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", key&7)
+		}
+	}
+	return nil
+}
+
+// protoVarint decodes a varint, returning its length, or 0 if it's invalid.
+func protoVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
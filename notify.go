@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// With -notify, or "notify = yes" in the configuration, "gauth open"
+// and "gauth paste" raise a desktop notification naming the key whose
+// code was copied, never the code, and paste another when the code
+// leaves the clipboard.
+
+// notifying reports whether to raise notifications, given the -notify
+// flag of the command.
+func notifying(flag bool) bool {
+	return flag || conf.get("notify") == "yes"
+}
+
+// notificationCmd returns the command raising a notification, or nil
+// if there's no desktop or no way to raise one.
+func notificationCmd(title, body string) []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"osascript", "-e", fmt.Sprintf("display notification %q with title %q", body, title)}
+	case "windows":
+		quote := func(s string) string { return "'" + strings.Replace(s, "'", "''", -1) + "'" }
+		return []string{"powershell", "-NoProfile", "-Command",
+			"$m = [Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime]; " +
+				"$t = $m::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02); " +
+				"$x = $t.GetElementsByTagName('text'); " +
+				"[void]$x.Item(0).AppendChild($t.CreateTextNode(" + quote(title) + ")); " +
+				"[void]$x.Item(1).AppendChild($t.CreateTextNode(" + quote(body) + ")); " +
+				// Toasts need an application; PowerShell's is registered.
+				`$m::CreateToastNotifier('{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe').Show([Windows.UI.Notifications.ToastNotification]::new($t))`}
+	}
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return nil
+	}
+	if _, err := exec.LookPath("notify-send"); err == nil {
+		return []string{"notify-send", "-a", "gauth", title, body}
+	}
+	return nil
+}
+
+// notify raises a notification, without waiting for it.
+func notify(title, body string) {
+	args := notificationCmd(title, body)
+	if args == nil {
+		log.Printf("warning: no desktop notifications (install notify-send)")
+		return
+	}
+	if err := exec.Command(args[0], args[1:]...).Start(); err != nil {
+		log.Printf("warning: notification: %v", err)
+	}
+}
+
+// notifyCopied tells that the code of name, expiring at expires unless
+// it's zero, was copied.
+func notifyCopied(name string, expires time.Time) {
+	body := "Copied the code of " + name
+	if !expires.IsZero() {
+		body += fmt.Sprintf(", valid until %s", expires.Format("15:04:05"))
+	}
+	notify("gauth", body)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cmdOcra = &command{
+	name:  "ocra",
+	usage: "ocra -challenge q [-password pin] [-session hex] name",
+	short: "answer a challenge with an OCRA key",
+	long: `Ocra prints the response of an OCRA key (RFC 6287) to a challenge,
+as banking tokens and transaction signing ask for. Flags may also
+follow the name, so "gauth -ocra name -challenge 12345678" works too.
+
+The key's suite, added with "gauth add -ocra suite name", says what
+the response covers:
+
+	OCRA-1:HOTP-SHA1-6:QN08
+	OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1
+	OCRA-1:HOTP-SHA512-8:QN08-T1M
+
+The challenge is -challenge, in the suite's format: N for decimal
+digits, A for text and H for hex digits. For mutual challenge-response,
+give both sides' challenges one after the other. With P in the suite
+the response also covers a PIN, given with -password or asked for;
+with S, the session information given in hex with -session; with T,
+the current time in the suite's steps. With C, the counter of the key
+is used and advanced, like an HOTP counter; the counter of an OCRA key
+is that of its next response, 0 at first.`,
+}
+
+var (
+	ocraChallenge = cmdOcra.flags.String("challenge", "", "the challenge `q`")
+	ocraPassword  = cmdOcra.flags.String("password", "", "the `pin` of suites with P")
+	ocraSession   = cmdOcra.flags.String("session", "", "the session information of suites with S, in `hex`")
+)
+
+func init() {
+	cmdOcra.run = runOcra
+	attrCheckers["ocra"] = func(v string) bool {
+		_, err := parseOCRASuite(v)
+		return err == nil
+	}
+}
+
+// An ocraSuite is the parsed suite of an OCRA key.
+type ocraSuite struct {
+	text    string
+	hash    func() hash.Hash
+	digits  int
+	counter bool             // C: a counter
+	qFormat byte             // the format of the challenge: A, N or H
+	qMax    int              // its length, for one side
+	pHash   func() hash.Hash // P: the hash of a PIN, or nil
+	sLen    int              // S: the length of the session information, or 0
+	step    int64            // T: the time step in seconds, or 0
+}
+
+// parseOCRASuite parses an OCRA suite, such as
+// "OCRA-1:HOTP-SHA1-6:C-QN08-PSHA1".
+func parseOCRASuite(text string) (*ocraSuite, error) {
+	invalid := fmt.Errorf("invalid OCRA suite %q", text)
+	parts := strings.Split(text, ":")
+	if len(parts) != 3 || parts[0] != "OCRA-1" {
+		return nil, invalid
+	}
+	s := &ocraSuite{text: text}
+	f := strings.Split(parts[1], "-")
+	if len(f) != 3 || f[0] != "HOTP" || hashes[f[1]] == nil {
+		return nil, invalid
+	}
+	s.hash = hashes[f[1]]
+	n, err := strconv.Atoi(f[2])
+	if err != nil {
+		return nil, invalid
+	}
+	// The keychain holds codes of 6 to 8 digits; OCRA allows 0 and 4 to 10.
+	if n < 6 || n > 8 {
+		return nil, fmt.Errorf("OCRA suite %q: gauth supports responses of 6 to 8 digits", text)
+	}
+	s.digits = n
+	f = strings.Split(parts[2], "-")
+	if len(f) > 0 && f[0] == "C" {
+		s.counter = true
+		f = f[1:]
+	}
+	if len(f) == 0 || len(f[0]) != 4 || f[0][0] != 'Q' || strings.IndexByte("ANH", f[0][1]) < 0 {
+		return nil, invalid
+	}
+	s.qFormat = f[0][1]
+	if s.qMax, err = strconv.Atoi(f[0][2:]); err != nil || s.qMax < 4 || s.qMax > 64 {
+		return nil, invalid
+	}
+	f = f[1:]
+	if len(f) > 0 && strings.HasPrefix(f[0], "P") {
+		if s.pHash = hashes[f[0][1:]]; s.pHash == nil {
+			return nil, invalid
+		}
+		f = f[1:]
+	}
+	if len(f) > 0 && strings.HasPrefix(f[0], "S") {
+		if len(f[0]) != 4 {
+			return nil, invalid
+		}
+		if s.sLen, err = strconv.Atoi(f[0][1:]); err != nil || s.sLen == 0 {
+			return nil, invalid
+		}
+		f = f[1:]
+	}
+	if len(f) > 0 && strings.HasPrefix(f[0], "T") && len(f[0]) > 2 {
+		v, unit := f[0][1:len(f[0])-1], f[0][len(f[0])-1]
+		units := map[byte]int64{'S': 1, 'M': 60, 'H': 3600}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || units[unit] == 0 {
+			return nil, invalid
+		}
+		s.step = int64(n) * units[unit]
+		f = f[1:]
+	}
+	if len(f) != 0 {
+		return nil, invalid
+	}
+	return s, nil
+}
+
+// An ocraInput holds the values a response covers besides the suite.
+type ocraInput struct {
+	counter   uint64
+	challenge string
+	password  string
+	session   string // hex
+	time      time.Time
+}
+
+// challengeBytes encodes challenge q as the 128 bytes of the data input.
+// Mutual challenge-response concatenates the challenges of both sides,
+// so q may be longer than the suite's length.
+func (s *ocraSuite) challengeBytes(q string) ([]byte, error) {
+	if len(q) < 4 {
+		return nil, errors.New("the challenge has to be at least 4 characters long")
+	}
+	var h string
+	switch s.qFormat {
+	case 'N':
+		n, ok := new(big.Int).SetString(q, 10)
+		if !ok || strings.IndexFunc(q, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+			return nil, errors.New("the challenge has to be decimal digits")
+		}
+		h = strings.ToUpper(n.Text(16))
+	case 'A':
+		h = hex.EncodeToString([]byte(q))
+	case 'H':
+		h = q
+	}
+	if len(h) > 256 {
+		return nil, errors.New("the challenge is too long")
+	}
+	// The hex digits are left-aligned, an odd one included.
+	h += strings.Repeat("0", 256-len(h))
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return nil, errors.New("the challenge has to be hex digits")
+	}
+	return b, nil
+}
+
+// response computes the response of key for in.
+func (s *ocraSuite) response(key []byte, in ocraInput) (string, error) {
+	msg := append([]byte(s.text), 0)
+	if s.counter {
+		msg = append(msg, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(msg[len(msg)-8:], in.counter)
+	}
+	q, err := s.challengeBytes(in.challenge)
+	if err != nil {
+		return "", err
+	}
+	msg = append(msg, q...)
+	if s.pHash != nil {
+		h := s.pHash()
+		h.Write([]byte(in.password))
+		msg = h.Sum(msg)
+	}
+	if s.sLen > 0 {
+		session, err := hex.DecodeString(strings.Repeat("0", len(in.session)%2) + in.session)
+		if err != nil {
+			return "", errors.New("the session information has to be hex digits")
+		}
+		if len(session) > s.sLen {
+			return "", fmt.Errorf("the session information is longer than %d bytes", s.sLen)
+		}
+		msg = append(msg, make([]byte, s.sLen-len(session))...)
+		msg = append(msg, session...)
+	}
+	if s.step > 0 {
+		msg = append(msg, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(msg[len(msg)-8:], uint64(in.time.Unix()/s.step))
+	}
+	h := hmac.New(s.hash, key)
+	h.Write(msg)
+	return fmt.Sprintf("%0*d", s.digits, truncateHMAC(h.Sum(nil), s.digits)), nil
+}
+
+func runOcra(ctx context.Context, cmd *command, args []string) {
+	if len(args) == 0 {
+		cmd.usageExit()
+	}
+	// flags may also follow the name
+	name := args[0]
+	cmd.flags.Parse(args[1:])
+	if cmd.flags.NArg() != 0 || *ocraChallenge == "" {
+		cmd.usageExit()
+	}
+	c := openKeychain()
+	k, ok := c.keys[name]
+	if !ok {
+		fatalf(exitNotFound, "no such key %q", name)
+	}
+	if k.attr("ocra") == "" {
+		log.Fatalf("%s isn't an OCRA key", name)
+	}
+	s, err := parseOCRASuite(k.attr("ocra"))
+	if err != nil {
+		// checked when the keychain is read
+		log.Fatal(err)
+	}
+	in := ocraInput{challenge: *ocraChallenge, password: *ocraPassword, session: *ocraSession, time: time.Now()}
+	if s.pHash != nil && in.password == "" {
+		if in.password, err = readPassword("PIN: "); err != nil {
+			log.Fatalf("reading PIN: %v", err)
+		}
+	}
+	if s.sLen > 0 && in.session == "" {
+		log.Fatalf("the suite of %s covers session information: give it with -session", name)
+	}
+	if s.counter {
+		if why := c.readOnlyReason(); why != "" {
+			log.Fatalf("%s has a counter, which can't be stored in a read-only keychain: %s", name, why)
+		}
+	}
+	if err := k.unlock(name); err != nil {
+		log.Fatal(err)
+	}
+	var code string
+	respond := func() {
+		key := k.hmacKey()
+		code, err = s.response(key, in)
+		wipe(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if s.counter {
+		// The response covers the stored counter, which then moves on.
+		c.advanceCounters([]string{name}, func(_ int, n uint64) {
+			in.counter = n - 1
+			respond()
+		})
+	} else {
+		respond()
+	}
+	audit("code", name)
+	recordUse(name)
+	afterCode(name, code, time.Time{})
+	fmt.Println(code)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The keys of the test vectors of RFC 6287, appendix C.
+var (
+	ocraKey20 = []byte("12345678901234567890")
+	ocraKey32 = []byte("12345678901234567890123456789012")
+	ocraKey64 = []byte(strings.Repeat("1234567890", 6) + "1234")
+)
+
+func TestOCRAResponse(t *testing.T) {
+	// 0x132d0b6 minutes, the time of the vectors with T1M.
+	vectorTime := time.Unix(0x132d0b6*60, 0)
+	tests := []struct {
+		suite string
+		key   []byte
+		in    ocraInput
+		want  string
+	}{
+		{"OCRA-1:HOTP-SHA1-6:QN08", ocraKey20, ocraInput{challenge: "00000000"}, "237653"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", ocraKey20, ocraInput{challenge: "11111111"}, "243178"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", ocraKey20, ocraInput{challenge: "22222222"}, "653583"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", ocraKey20, ocraInput{challenge: "33333333"}, "740991"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", ocraKey20, ocraInput{challenge: "44444444"}, "608993"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", ocraKey20, ocraInput{challenge: "55555555"}, "388898"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", ocraKey20, ocraInput{challenge: "66666666"}, "816933"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", ocraKey20, ocraInput{challenge: "77777777"}, "224598"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", ocraKey20, ocraInput{challenge: "88888888"}, "750600"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", ocraKey20, ocraInput{challenge: "99999999"}, "294470"},
+
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", ocraKey32, ocraInput{counter: 0, challenge: "12345678", password: "1234"}, "65347737"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", ocraKey32, ocraInput{counter: 1, challenge: "12345678", password: "1234"}, "86775851"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", ocraKey32, ocraInput{counter: 2, challenge: "12345678", password: "1234"}, "78192410"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", ocraKey32, ocraInput{counter: 3, challenge: "12345678", password: "1234"}, "71565254"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", ocraKey32, ocraInput{counter: 4, challenge: "12345678", password: "1234"}, "10104329"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", ocraKey32, ocraInput{counter: 5, challenge: "12345678", password: "1234"}, "65983500"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", ocraKey32, ocraInput{counter: 6, challenge: "12345678", password: "1234"}, "70069104"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", ocraKey32, ocraInput{counter: 7, challenge: "12345678", password: "1234"}, "91771096"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", ocraKey32, ocraInput{counter: 8, challenge: "12345678", password: "1234"}, "75011558"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", ocraKey32, ocraInput{counter: 9, challenge: "12345678", password: "1234"}, "08522129"},
+
+		{"OCRA-1:HOTP-SHA256-8:QN08-PSHA1", ocraKey32, ocraInput{challenge: "00000000", password: "1234"}, "83238735"},
+		{"OCRA-1:HOTP-SHA256-8:QN08-PSHA1", ocraKey32, ocraInput{challenge: "11111111", password: "1234"}, "01501458"},
+		{"OCRA-1:HOTP-SHA256-8:QN08-PSHA1", ocraKey32, ocraInput{challenge: "22222222", password: "1234"}, "17957585"},
+		{"OCRA-1:HOTP-SHA256-8:QN08-PSHA1", ocraKey32, ocraInput{challenge: "33333333", password: "1234"}, "86776967"},
+		{"OCRA-1:HOTP-SHA256-8:QN08-PSHA1", ocraKey32, ocraInput{challenge: "44444444", password: "1234"}, "86807031"},
+
+		{"OCRA-1:HOTP-SHA512-8:C-QN08", ocraKey64, ocraInput{counter: 0, challenge: "00000000"}, "07016083"},
+		{"OCRA-1:HOTP-SHA512-8:C-QN08", ocraKey64, ocraInput{counter: 1, challenge: "11111111"}, "63947962"},
+		{"OCRA-1:HOTP-SHA512-8:C-QN08", ocraKey64, ocraInput{counter: 2, challenge: "22222222"}, "70123924"},
+		{"OCRA-1:HOTP-SHA512-8:C-QN08", ocraKey64, ocraInput{counter: 3, challenge: "33333333"}, "25341727"},
+		{"OCRA-1:HOTP-SHA512-8:C-QN08", ocraKey64, ocraInput{counter: 4, challenge: "44444444"}, "33203315"},
+
+		{"OCRA-1:HOTP-SHA512-8:QN08-T1M", ocraKey64, ocraInput{challenge: "00000000", time: vectorTime}, "95209754"},
+		{"OCRA-1:HOTP-SHA512-8:QN08-T1M", ocraKey64, ocraInput{challenge: "11111111", time: vectorTime}, "55907591"},
+		{"OCRA-1:HOTP-SHA512-8:QN08-T1M", ocraKey64, ocraInput{challenge: "22222222", time: vectorTime}, "22048402"},
+		{"OCRA-1:HOTP-SHA512-8:QN08-T1M", ocraKey64, ocraInput{challenge: "33333333", time: vectorTime}, "24218844"},
+		{"OCRA-1:HOTP-SHA512-8:QN08-T1M", ocraKey64, ocraInput{challenge: "44444444", time: vectorTime}, "36209546"},
+
+		// Mutual challenge-response: the server's and the client's responses.
+		{"OCRA-1:HOTP-SHA256-8:QA08", ocraKey32, ocraInput{challenge: "CLI22220SRV11110"}, "28247970"},
+		{"OCRA-1:HOTP-SHA256-8:QA08", ocraKey32, ocraInput{challenge: "CLI22221SRV11111"}, "01984843"},
+		{"OCRA-1:HOTP-SHA256-8:QA08", ocraKey32, ocraInput{challenge: "SRV11110CLI22220"}, "15510767"},
+		{"OCRA-1:HOTP-SHA256-8:QA08", ocraKey32, ocraInput{challenge: "SRV11111CLI22221"}, "90175646"},
+	}
+	for _, tt := range tests {
+		s, err := parseOCRASuite(tt.suite)
+		if err != nil {
+			t.Fatalf("parseOCRASuite(%q): %v", tt.suite, err)
+		}
+		got, err := s.response(tt.key, tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("%s response to %+v = %q, %v, want %q", tt.suite, tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseOCRASuite(t *testing.T) {
+	tests := []struct {
+		suite string
+		ok    bool
+	}{
+		{"OCRA-1:HOTP-SHA1-6:QN08", true},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", true},
+		{"OCRA-1:HOTP-SHA512-8:QN08-T1M", true},
+		{"OCRA-1:HOTP-SHA1-6:QA10-S064", true},
+		{"OCRA-1:HOTP-SHA1-6:QH40-PSHA256-T30S", true},
+		{"OCRA-2:HOTP-SHA1-6:QN08", false},
+		{"OCRA-1:HOTP-MD5-6:QN08", false},
+		{"OCRA-1:HOTP-SHA1-4:QN08", false},
+		{"OCRA-1:HOTP-SHA1-6:QX08", false},
+		{"OCRA-1:HOTP-SHA1-6:QN03", false},
+		{"OCRA-1:HOTP-SHA1-6:QN08-T0M", false},
+		{"OCRA-1:HOTP-SHA1-6:QN08-PSHA1-C", false},
+		{"OCRA-1:HOTP-SHA1-6", false},
+	}
+	for _, tt := range tests {
+		if _, err := parseOCRASuite(tt.suite); (err == nil) != tt.ok {
+			t.Errorf("parseOCRASuite(%q) error = %v, want ok %v", tt.suite, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+// In offline mode gauth makes no network connections: backends which
+// use the network are refused and "gauth open" doesn't start the
+// browser. It's on with -offline, with "offline = yes" in the
+// configuration, or always in builds with the offline tag, which
+// leave the network code out of the binary:
+//
+//	go build -tags offline
+//
+// "gauth version" tells which applies.
+
+// offlineFlag is set by the -offline flag.
+var offlineFlag bool
+
+// networkBackends are the backend types which use the network.
+// sops may reach a cloud KMS to decrypt its files.
+var networkBackends = map[string]bool{
+	"vault": true,
+	"sops":  true,
+	"ssh":   true,
+}
+
+// offline reports whether network connections are forbidden.
+func offline() bool {
+	return offlineBuild || offlineFlag || conf.get("offline") == "yes"
+}
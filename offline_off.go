@@ -0,0 +1,6 @@
+//go:build !offline
+// +build !offline
+
+package main
+
+const offlineBuild = false
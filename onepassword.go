@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// 1Password exports either a 1PUX file, a zip archive whose export.data
+// is JSON:
+//
+//	{"accounts": [{"vaults": [{"items": [{"overview": {"title": "GitHub",
+//	  "url": "https://github.com"}, "details": {"loginFields": [...],
+//	  "sections": [{"fields": [{"value": {"totp": "otpauth://..."}}]}]}}]}]}]}
+//
+// or CSV, with a header naming the columns: Title, Url, Username,
+// OTPAuth and others. One-time password fields hold otpauth URIs or
+// bare base32 seeds.
+
+type onePasswordItem struct {
+	Item     *onePasswordItem // items of older 1PUX versions are wrapped
+	State    string
+	Overview struct {
+		Title string
+		URL   string
+	}
+	Details struct {
+		LoginFields []struct {
+			Designation string
+			Value       string
+		}
+		Sections []struct {
+			Fields []struct {
+				Value struct {
+					Totp string
+				}
+			}
+		}
+	}
+}
+
+func importOnePassword(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return import1PUX(data)
+	}
+	return import1PasswordCSV(data)
+}
+
+func import1PUX(data []byte) ([]entry, error) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var export struct {
+		Accounts []struct {
+			Vaults []struct {
+				Items []onePasswordItem
+			}
+		}
+	}
+	found := false
+	for _, f := range z.File {
+		if f.Name != "export.data" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(r).Decode(&export)
+		r.Close()
+		if err != nil {
+			return nil, errors.New("not a 1PUX export: " + err.Error())
+		}
+		found = true
+	}
+	if !found {
+		return nil, errors.New("not a 1PUX export: no export.data")
+	}
+	var entries []entry
+	for _, a := range export.Accounts {
+		for _, v := range a.Vaults {
+			for _, item := range v.Items {
+				if item.Item != nil {
+					item = *item.Item
+				}
+				if item.State == "archived" {
+					continue
+				}
+				var user string
+				for _, f := range item.Details.LoginFields {
+					if f.Designation == "username" {
+						user = f.Value
+					}
+				}
+				for _, s := range item.Details.Sections {
+					for _, f := range s.Fields {
+						if f.Value.Totp != "" {
+							entries = appendOTP(entries, item.Overview.Title, user, item.Overview.URL, f.Value.Totp)
+						}
+					}
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+func import1PasswordCSV(data []byte) ([]entry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, errors.New("not a 1Password export")
+	}
+	col := make(map[string]int)
+	for i, h := range records[0] {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	otp, ok := col["otpauth"]
+	if !ok {
+		if otp, ok = col["one-time password"]; !ok {
+			return nil, errors.New("not a 1Password export: no OTPAuth column")
+		}
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+	var entries []entry
+	for _, rec := range records[1:] {
+		if otp >= len(rec) || rec[otp] == "" {
+			continue
+		}
+		if field(rec, "archived") == "true" {
+			continue
+		}
+		entries = appendOTP(entries, field(rec, "title"), field(rec, "username"), field(rec, "url"), rec[otp])
+	}
+	return entries, nil
+}
+
+// appendOTP appends the entry of a one-time password field of a
+// password manager item, an otpauth URI or a base32 seed.
+// Invalid fields are reported and skipped.
+func appendOTP(entries []entry, title, user, site, otp string) []entry {
+	name := entryName(title, user)
+	issuer, account := title, user
+	var e entry
+	var err error
+	if strings.HasPrefix(otp, "otpauth://") {
+		var o *otpauth
+		if o, err = parseOtpauth(otp); err == nil {
+			e, err = newEntry(name, o.secret, o.typ, o.algorithm, o.digits, o.period, o.counter)
+			if o.issuer != "" {
+				issuer = o.issuer
+			}
+			if o.account != "" {
+				account = o.account
+			}
+		}
+	} else {
+		e, err = newEntry(name, otp, "totp", "SHA1", 6, 30, 0)
+	}
+	if err != nil {
+		log.Printf("%s: skipped: %v", name, err)
+		return entries
+	}
+	e.key.setIdentity(issuer, account)
+	if checkURL(site) == nil {
+		e.key.set("url", site)
+	}
+	return append(entries, e)
+}
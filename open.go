@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+var cmdOpen = &command{
+	name:  "open",
+	usage: "open [-notify] name",
+	short: "copy a code to the clipboard and open the login page",
+	long: `Open copies the current code of the named key to the clipboard and
+opens the key's login page in the default browser, ready to paste.
+
+The login page is the url attribute of the key, set with "gauth add
+-url" or taken from imports which have one, such as Bitwarden's.
+Keys without it only have their code copied, as do all keys in offline
+mode.
+
+With -notify, or "notify = yes" in the configuration, a desktop
+notification tells whose code was copied, and until when it's valid.`,
+}
+
+var openNotify = cmdOpen.flags.Bool("notify", false, "raise a desktop notification for the copied code")
+
+func init() {
+	cmdOpen.run = runOpen
+	cmdOpen.network = true
+	cmdOpen.programs = always
+}
+
+func runOpen(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	name := args[0]
+	k, err := federation(openBackends()).resolve(ctx, name)
+	if err != nil {
+		fatal(exitNotFound, err)
+	}
+	name = k.name
+	c := currentCode(ctx, k)
+	if err := writeClipboard(c.code); err != nil {
+		log.Fatalf("copying code: %v", err)
+	}
+	if notifying(*openNotify) {
+		notifyCopied(name, c.expires)
+	}
+	if k.url == "" {
+		fmt.Fprintf(os.Stderr, "copied code of %s; it has no login URL\n", name)
+		return
+	}
+	if offline() {
+		fmt.Fprintf(os.Stderr, "copied code of %s; not opening %s in offline mode\n", name, k.url)
+		return
+	}
+	if err := openBrowser(k.url); err != nil {
+		log.Fatalf("opening %s: %v", k.url, err)
+	}
+	fmt.Fprintf(os.Stderr, "copied code of %s, opening %s\n", name, k.url)
+}
+
+// checkURL reports whether u can be opened as a login page.
+// Only web pages are, so a key can't make gauth run other handlers.
+func checkURL(u string) error {
+	p, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if p.Scheme != "https" && p.Scheme != "http" || p.Host == "" {
+		return fmt.Errorf("login URL %q isn't a web page", u)
+	}
+	return nil
+}
+
+// openBrowser opens u in the default browser.
+func openBrowser(u string) error {
+	if err := checkURL(u); err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hashes are the HMAC hash functions of RFC 6238.
+var hashes = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+func (k Key) hash() func() hash.Hash {
+	return hashes[k.algorithm()]
+}
+
+// A transform derives the HMAC key from the shared secret, for providers
+// which don't use the secret as it is. It's one of
+//
+//	none        the secret itself (the default)
+//	sha1, md5   the digest of the secret
+//	truncate:N  the first N bytes of the secret
+func parseTransform(t string) (func([]byte) []byte, error) {
+	switch t {
+	case "", "none":
+		return func(b []byte) []byte { return b }, nil
+	case "sha1":
+		return func(b []byte) []byte { s := sha1.Sum(b); return s[:] }, nil
+	case "md5":
+		return func(b []byte) []byte { s := md5.Sum(b); return s[:] }, nil
+	}
+	if strings.HasPrefix(t, "truncate:") {
+		n, err := strconv.Atoi(t[len("truncate:"):])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid transform %q", t)
+		}
+		return func(b []byte) []byte {
+			if len(b) > n {
+				return b[:n]
+			}
+			return b
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown transform %q (use none, sha1, md5 or truncate:N)", t)
+}
+
+// hmacKey returns the HMAC key of k, its secret after the transform,
+// in a new buffer for the caller to wipe.
+func (k Key) hmacKey() []byte {
+	if k.protected() {
+		panic("HMAC key of a protected key which wasn't unlocked")
+	}
+	f, err := parseTransform(k.attr("transform"))
+	if err != nil {
+		// checked when the keychain is read
+		panic(err)
+	}
+	return append([]byte(nil), f(k.raw)...)
+}
+
+// decodeKey decodes a base32 secret, ignoring case and padding. As in
+// Google Authenticator, bits left over past the last whole byte are
+// dropped, so secrets of any length decode.
+func decodeKey(key string) ([]byte, error) {
+	key = strings.ToUpper(strings.TrimRight(key, "="))
+	n := len(key) * 5 / 8
+	if n == 0 {
+		return nil, errors.New("empty secret")
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(key[:(n*8+4)/5])
+}
+
+// genTOTP returns the TOTP code at time t of steps of period seconds
+// from Unix time t0.
+func genTOTP(h func() hash.Hash, key []byte, t time.Time, t0 int64, period, digits int) int {
+	return genHOTP(h, key, uint64((t.Unix()-t0)/int64(period)), digits)
+}
+
+func genHOTP(hash func() hash.Hash, key []byte, counter uint64, digits int) int {
+	h := hmac.New(hash, key)
+	binary.Write(h, binary.BigEndian, counter)
+	return truncateHMAC(h.Sum(nil), digits)
+}
+
+// truncateHMAC is the dynamic truncation of RFC 4226, turning an HMAC
+// into a code of digits digits.
+func truncateHMAC(sum []byte, digits int) int {
+	v := binary.BigEndian.Uint32(sum[sum[len(sum)-1]&0x0F:]) & 0x7FFFFFFF
+	d := uint32(1)
+	for i := 0; i < digits && i < 8; i++ {
+		d *= 10
+	}
+	return int(v % d)
+}
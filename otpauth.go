@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// An otpauth is a key in the otpauth URI format used by QR codes:
+//
+//	otpauth://totp/Issuer:account?secret=...&issuer=Issuer&digits=6
+type otpauth struct {
+	typ       string // "totp", "hotp" or "yaotp", for Yandex.Key
+	issuer    string
+	account   string
+	secret    string
+	algorithm string
+	digits    int
+	period    int
+	t0        int64 // a gauth extension, see keychain.go
+	counter   uint64
+}
+
+func parseOtpauth(s string) (*otpauth, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "otpauth" {
+		return nil, fmt.Errorf("not an otpauth URI")
+	}
+	o := &otpauth{
+		typ:       strings.ToLower(u.Host),
+		algorithm: "SHA1",
+		digits:    6,
+		period:    30,
+	}
+	switch o.typ {
+	case "totp", "hotp":
+	case "yaotp":
+		o.algorithm, o.digits = "SHA256", 8
+	default:
+		return nil, fmt.Errorf("unknown otpauth type %q", u.Host)
+	}
+	label := strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(label, ":"); i >= 0 {
+		o.issuer, label = strings.TrimSpace(label[:i]), label[i+1:]
+	}
+	o.account = strings.TrimSpace(label)
+
+	q := u.Query()
+	o.secret = q.Get("secret")
+	if o.secret == "" {
+		return nil, fmt.Errorf("otpauth URI has no secret")
+	}
+	if v := q.Get("issuer"); v != "" {
+		o.issuer = v
+	}
+	if v := q.Get("algorithm"); v != "" {
+		o.algorithm = strings.ToUpper(v)
+	}
+	if v := q.Get("digits"); v != "" {
+		if o.digits, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid digits %q", v)
+		}
+	}
+	if v := q.Get("period"); v != "" {
+		if o.period, err = strconv.Atoi(v); err != nil || o.period <= 0 {
+			return nil, fmt.Errorf("invalid period %q", v)
+		}
+	}
+	if v := q.Get("t0"); v != "" {
+		if o.t0, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid t0 %q", v)
+		}
+	}
+	if v := q.Get("counter"); v != "" {
+		if o.counter, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid counter %q", v)
+		}
+	}
+	return o, nil
+}
+
+// otpauthURI returns the otpauth URI of key name, which authenticator
+// apps read from QR codes. counter is the stored counter of HOTP keys.
+// The label is the issuer and account of the key, or its name.
+func otpauthURI(name string, k Key, counter string) (string, error) {
+	switch {
+	case k.attr("transform") != "":
+		return "", errors.New("keys with a transform can't be written as otpauth URIs")
+	case k.attr("ocra") != "":
+		return "", errors.New("OCRA keys can't be written as otpauth URIs")
+	case k.attr("yandex") != "":
+		return "", errors.New("Yandex.Key keys can't be written as otpauth URIs")
+	case k.protected():
+		return "", errors.New("keys protected by a passphrase of their own can't be written as otpauth URIs")
+	}
+	q := url.Values{}
+	q.Set("secret", base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(k.raw))
+	label := name
+	if issuer, account := k.attr("issuer"), k.attr("account"); account != "" {
+		label = account
+		if issuer != "" {
+			label = issuer + ":" + account
+		}
+	}
+	if issuer := k.attr("issuer"); issuer != "" {
+		q.Set("issuer", issuer)
+	}
+	if a := k.algorithm(); a != "SHA1" {
+		q.Set("algorithm", a)
+	}
+	if k.digits != 6 {
+		q.Set("digits", strconv.Itoa(k.digits))
+	}
+	typ := "totp"
+	if counter != "" {
+		typ = "hotp"
+		n, err := strconv.ParseUint(counter, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid counter %q", counter)
+		}
+		q.Set("counter", strconv.FormatUint(n, 10))
+	} else {
+		if p := k.period(); p != 30 {
+			q.Set("period", strconv.Itoa(p))
+		}
+		if t0 := k.attr("t0"); t0 != "" {
+			q.Set("t0", t0)
+		}
+	}
+	u := url.URL{Scheme: "otpauth", Host: typ, Path: "/" + label, RawQuery: q.Encode()}
+	return u.String(), nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Paper backups spell a secret in words of the BIP 39 word list, each
+// standing for 11 bits, which are much easier to copy by hand and type
+// back than base32. The first word gives the length of the secret in
+// bytes; the others hold the secret followed by the first bits of its
+// SHA-256 digest, at least 8 of them, up to the next word boundary. A
+// wrong or missing word is thus almost always detected. As in BIP 39,
+// the first four letters of a word are enough to tell it.
+//
+// The backup also lists the name and parameters of the key:
+//
+//	name: GitHub-alice
+//	key: TOTP 6 digits SHA1 30s
+//	words:
+//	 1 ability   2 ...
+
+// paperWords spells secret in words.
+func paperWords(secret []byte) []string {
+	n := len(secret)
+	c := 8 + (11-(8*n+8)%11)%11
+	sum := sha256.Sum256(secret)
+	bits := append(append([]byte(nil), secret...), sum[:(c+7)/8]...)
+	words := []string{bip39Words[n]}
+	for i := 0; i < 8*n+c; i += 11 {
+		v := 0
+		for j := i; j < i+11; j++ {
+			v = v<<1 | int(bits[j/8]>>(7-uint(j%8))&1)
+		}
+		words = append(words, bip39Words[v])
+	}
+	return words
+}
+
+// wordIndex returns the index of w in the word list,
+// accepting its first four letters.
+func wordIndex(w string) (int, bool) {
+	w = strings.ToLower(w)
+	for i, word := range bip39Words {
+		if w == word || len(w) >= 4 && strings.HasPrefix(word, w) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// paperSecret reads the secret spelled by words.
+func paperSecret(words []string) ([]byte, error) {
+	if len(words) < 2 {
+		return nil, errors.New("too few words")
+	}
+	idx := make([]int, len(words))
+	for i, w := range words {
+		var ok bool
+		if idx[i], ok = wordIndex(w); !ok {
+			return nil, fmt.Errorf("word %d, %q, isn't in the word list", i+1, w)
+		}
+	}
+	n := idx[0]
+	c := 8 + (11-(8*n+8)%11)%11
+	if want := 1 + (8*n+c)/11; len(words) != want {
+		return nil, fmt.Errorf("a %d byte secret takes %d words, got %d", n, want, len(words))
+	}
+	bits := make([]byte, (8*n+c+7)/8+2)
+	for i, v := range idx[1:] {
+		for j := 0; j < 11; j++ {
+			if v>>(10-uint(j))&1 != 0 {
+				k := 11*i + j
+				bits[k/8] |= 1 << (7 - uint(k%8))
+			}
+		}
+	}
+	secret := bits[:n]
+	sum := sha256.Sum256(secret)
+	for k := 0; k < c; k++ {
+		if bits[n+k/8]>>(7-uint(k%8))&1 != sum[k/8]>>(7-uint(k%8))&1 {
+			return nil, errors.New("checksum mismatch: a word is wrong, missing or out of order")
+		}
+	}
+	return secret, nil
+}
+
+// writePaper writes the paper backup of key name.
+func writePaper(w io.Writer, name string, k Key, counter string) {
+	fmt.Fprintf(w, "gauth paper backup\n\n")
+	fmt.Fprintf(w, "name: %s\n", name)
+	fmt.Fprintf(w, "key: %s\n", describe(k, counter))
+	if t := k.attr("transform"); t != "" {
+		fmt.Fprintf(w, "transform: %s\n", t)
+	}
+	fmt.Fprintf(w, "words:\n")
+	words := paperWords(k.raw)
+	for i, word := range words {
+		if i%4 == 3 || i == len(words)-1 {
+			fmt.Fprintf(w, "%3d %s\n", i+1, word)
+		} else {
+			fmt.Fprintf(w, "%3d %-8s  ", i+1, word)
+		}
+	}
+}
+
+// importPaper reads a paper backup, typed back in a file,
+// or from stdin if file is "-".
+func importPaper(ctx context.Context, file string) ([]entry, error) {
+	var r io.Reader = stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	} else if isTerminal(os.Stdin.Fd()) {
+		fmt.Fprintln(os.Stderr, "type the backup, with the name:, key: and words: lines, then end with Ctrl-D:")
+	}
+	var name, desc, transform string
+	var words []string
+	inWords := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		field := func(prefix string) (string, bool) {
+			if strings.HasPrefix(strings.ToLower(line), prefix) {
+				return strings.TrimSpace(line[len(prefix):]), true
+			}
+			return "", false
+		}
+		if v, ok := field("name:"); ok {
+			name = v
+		} else if v, ok := field("key:"); ok {
+			desc = v
+		} else if v, ok := field("transform:"); ok {
+			transform = v
+		} else if _, ok := field("words:"); ok {
+			inWords = true
+		} else if inWords {
+			for _, f := range strings.Fields(line) {
+				if _, err := strconv.Atoi(f); err != nil {
+					words = append(words, f)
+				}
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if name == "" || desc == "" || len(words) == 0 {
+		return nil, errors.New("not a paper backup: need name:, key: and words: lines")
+	}
+	secret, err := paperSecret(words)
+	if err != nil {
+		return nil, err
+	}
+	e, err := parseDescription(name, desc, secret)
+	if err != nil {
+		return nil, err
+	}
+	if transform != "" {
+		if _, err := parseTransform(transform); err != nil {
+			return nil, err
+		}
+		e.key.set("transform", transform)
+	}
+	return []entry{e}, nil
+}
+
+// parseDescription makes the entry of a key from the text of describe:
+// "TOTP 6 digits SHA1 30s" or "HOTP 6 digits SHA1 counter 5".
+func parseDescription(name, desc string, secret []byte) (entry, error) {
+	f := strings.Fields(desc)
+	bad := fmt.Errorf("invalid key description %q", desc)
+	if len(f) != 5 && len(f) != 6 || f[2] != "digits" {
+		return entry{}, bad
+	}
+	digits, err := strconv.Atoi(f[1])
+	if err != nil {
+		return entry{}, bad
+	}
+	period, counter := 30, uint64(0)
+	switch strings.ToUpper(f[0]) {
+	case "TOTP":
+		if len(f) != 5 || !strings.HasSuffix(f[4], "s") {
+			return entry{}, bad
+		}
+		if period, err = strconv.Atoi(strings.TrimSuffix(f[4], "s")); err != nil {
+			return entry{}, bad
+		}
+	case "HOTP":
+		if len(f) != 6 || f[4] != "counter" {
+			return entry{}, bad
+		}
+		if counter, err = strconv.ParseUint(f[5], 10, 64); err != nil {
+			return entry{}, bad
+		}
+	}
+	return newEntry(name, base32.StdEncoding.EncodeToString(secret), f[0], f[3], digits, period, counter)
+}
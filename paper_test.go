@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPaperRoundTrip(t *testing.T) {
+	for _, n := range []int{1, 5, 10, 16, 20, 32, 64} {
+		secret := make([]byte, n)
+		for i := range secret {
+			secret[i] = byte(i*37 + n)
+		}
+		words := paperWords(secret)
+		got, err := paperSecret(words)
+		if err != nil || !bytes.Equal(got, secret) {
+			t.Errorf("paperSecret(paperWords(%x)) = %x, %v", secret, got, err)
+		}
+		// The first four letters of each word are enough.
+		short := make([]string, len(words))
+		for i, w := range words {
+			if len(w) > 4 {
+				w = w[:4]
+			}
+			short[i] = w
+		}
+		if got, err := paperSecret(short); err != nil || !bytes.Equal(got, secret) {
+			t.Errorf("paperSecret(%q) = %x, %v, want %x", short, got, err, secret)
+		}
+	}
+}
+
+// TestPaperMistakes checks that the checksum catches a wrong, missing or
+// swapped word.
+func TestPaperMistakes(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	words := paperWords(secret)
+	// edit returns words changed by f, leaving words alone.
+	edit := func(f func(w []string) []string) []string {
+		return f(append([]string(nil), words...))
+	}
+	mistakes := map[string][]string{
+		"missing word": edit(func(w []string) []string { return append(w[:3], w[4:]...) }),
+		"extra word":   edit(func(w []string) []string { return append(w, "abandon") }),
+		"unknown word": edit(func(w []string) []string { w[2] = "gauth"; return w }),
+		"wrong word": edit(func(w []string) []string {
+			i, _ := wordIndex(w[5])
+			w[5] = bip39Words[(i+1)%len(bip39Words)]
+			return w
+		}),
+		"too few words": words[:1],
+	}
+	if words[2] != words[3] {
+		mistakes["swapped words"] = edit(func(w []string) []string { w[2], w[3] = w[3], w[2]; return w })
+	}
+	for what, words := range mistakes {
+		if got, err := paperSecret(words); err == nil {
+			t.Errorf("paperSecret with %s = %x, want an error", what, got)
+		}
+	}
+}
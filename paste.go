@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var cmdPaste = &command{
+	name:  "paste",
+	usage: "paste [-notify] [-min-validity seconds] [-timeout duration] name",
+	short: "copy a code to the clipboard until it's pasted",
+	long: `Paste copies the current code of the named key to the clipboard and
+waits until it's pasted or expires, then removes it from the clipboard.
+A TOTP code valid for less than -min-validity seconds is skipped for
+the next one, so there's time to paste it. HOTP codes don't expire and
+are waited for until -timeout.
+
+Pasting is detected on X11, and on Wayland where the compositor
+supports the data-control protocol, or with wl-copy; note that
+clipboard managers, which read the clipboard as soon as it changes,
+count as pasting. Elsewhere paste only notices the code being replaced
+in the clipboard, and otherwise waits for it to expire.
+
+The exit status tells wrapper scripts what happened:
+
+	0  the code was pasted
+	2  the code expired, or -timeout passed, before it was pasted:
+	   run paste again for a fresh code
+	3  something else was copied over the code before it was pasted
+
+Other errors exit with status 1.
+
+With -notify, or "notify = yes" in the configuration, desktop
+notifications tell whose code was copied, and when it was pasted or
+expired and so left the clipboard.`,
+}
+
+var (
+	pasteMinValidity = cmdPaste.flags.Int("min-validity", 5, "wait for the next code if the current one expires in less than `seconds`")
+	pasteTimeout     = cmdPaste.flags.Duration("timeout", time.Minute, "how long to wait for an HOTP code to be pasted")
+	pasteNotify      = cmdPaste.flags.Bool("notify", false, "raise desktop notifications for the copied code")
+)
+
+// The exit statuses of paste besides 0 for a pasted code.
+const (
+	pasteExpired  = 2
+	pasteReplaced = 3
+)
+
+func init() {
+	cmdPaste.run = runPaste
+	cmdPaste.programs = always
+}
+
+func runPaste(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	k, err := federation(openBackends()).resolve(ctx, args[0])
+	if err != nil {
+		fatal(exitNotFound, err)
+	}
+	if !k.hotp {
+		now := time.Now()
+		left := k.stepEnd(now).Sub(now)
+		if left < time.Duration(*pasteMinValidity)*time.Second {
+			fmt.Fprintf(os.Stderr, "waiting %d seconds for the next code...\n", int(left.Seconds()+0.5))
+			select {
+			case <-time.After(left):
+			case <-ctx.Done():
+				checkInterrupted(ctx, "interrupted")
+			}
+		}
+	}
+	c := currentCode(ctx, k)
+	deadline := c.expires
+	if deadline.IsZero() {
+		deadline = time.Now().Add(*pasteTimeout)
+	}
+	fmt.Fprintf(os.Stderr, "copied code of %s, waiting for it to be pasted\n", k.name)
+	notifications := notifying(*pasteNotify)
+	if notifications {
+		notifyCopied(k.name, c.expires)
+	}
+	switch status := pasteCode(ctx, c.code, deadline); status {
+	case 0:
+		fmt.Fprintf(os.Stderr, "pasted\n")
+		if notifications {
+			notify("gauth", "The code of "+k.name+" was pasted and left the clipboard")
+		}
+	case pasteExpired:
+		fmt.Fprintf(os.Stderr, "the code expired before it was pasted\n")
+		if notifications {
+			notify("gauth", "The code of "+k.name+" expired and was removed from the clipboard")
+		}
+		os.Exit(status)
+	case pasteReplaced:
+		fmt.Fprintf(os.Stderr, "the clipboard was replaced before the code was pasted\n")
+		os.Exit(status)
+	}
+}
+
+// pasteCode puts code in the clipboard until it's pasted or deadline
+// passes, and returns the exit status of paste. The code is taken out
+// of the clipboard in the end, unless something else replaced it.
+func pasteCode(ctx context.Context, code string, deadline time.Time) int {
+	if c, err := openNativeClipboard(); c != nil && err == nil {
+		if w, ok := c.(pasteWatcher); ok {
+			return watchPaste(ctx, w, code, deadline)
+		}
+		return pollPaste(ctx, code, deadline)
+	}
+	args, err := clipboardCmd(copyOnceCmds)
+	if err != nil {
+		return pollPaste(ctx, code, deadline)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(code)
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("copying code: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Fatalf("copying code: %v", err)
+		}
+		// the copy also ends when something else takes the clipboard
+		if text, err := readClipboard(); err == nil && text != "" && text != code {
+			return pasteReplaced
+		}
+		return 0
+	case <-timer.C:
+		// the clipboard empties when its owner exits
+		cmd.Process.Kill()
+		<-done
+		return pasteExpired
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		checkInterrupted(ctx, "interrupted")
+	}
+	return 0
+}
+
+// watchPaste serves code from a native clipboard for a single paste.
+func watchPaste(ctx context.Context, w pasteWatcher, code string, deadline time.Time) int {
+	wctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	pasted, err := w.copyOnce(wctx, code)
+	switch {
+	case ctx.Err() != nil:
+		checkInterrupted(ctx, "interrupted")
+	case wctx.Err() != nil:
+		// the clipboard empties when its owner lets go
+		return pasteExpired
+	case err != nil:
+		log.Fatalf("copying code: %v", err)
+	case !pasted:
+		return pasteReplaced
+	}
+	return 0
+}
+
+// pollPaste copies code to the clipboard where pasting can't be
+// detected, watching only for it being replaced.
+func pollPaste(ctx context.Context, code string, deadline time.Time) int {
+	if err := writeClipboard(code); err != nil {
+		log.Fatalf("copying code: %v", err)
+	}
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if text, err := readClipboard(); err == nil && text != code {
+				return pasteReplaced
+			}
+		case <-timer.C:
+			if text, err := readClipboard(); err != nil || text == code {
+				clearClipboard()
+			}
+			return pasteExpired
+		case <-ctx.Done():
+			if text, err := readClipboard(); err != nil || text == code {
+				clearClipboard()
+			}
+			checkInterrupted(ctx, "interrupted")
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// peerCaller returns the client process at the other end of an agent
+// connection, as the kernel vouches for it.
+func peerCaller(conn net.Conn) auditCaller {
+	caller := auditCaller{Command: "agent"}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return caller
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return caller
+	}
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return caller
+	}
+	caller.PID = int(cred.Pid)
+	caller.User = strconv.Itoa(int(cred.Uid))
+	if u, err := user.LookupId(caller.User); err == nil {
+		caller.User = u.Username
+	}
+	caller.TTY = ttyName(caller.PID, 0)
+	return caller
+}
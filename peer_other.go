@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "net"
+
+// peerCaller returns the client process at the other end of an agent
+// connection, which only Linux tells.
+func peerCaller(conn net.Conn) auditCaller {
+	return auditCaller{Command: "agent"}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// The keychain, the files gauth keeps next to it and the socket of
+// the agent are private to their owner. On hosts where several administrators share a keychain
+// they can be given to a group instead:
+//
+//	mode = 0640
+//	group = admins
+//
+// Directories get the matching search bits, and the setgid bit if a
+// group is set, so files created in them by other tools stay in it.
+// Access for others can't be granted.
+type filePerm struct {
+	mode os.FileMode
+	gid  int // -1 to keep the group
+}
+
+var (
+	permOnce sync.Once
+	perm     filePerm
+)
+
+// keychainPerm returns the permissions of the keychain files.
+// The settings are validated when first needed.
+func keychainPerm() filePerm {
+	permOnce.Do(func() { perm = loadPerm() })
+	return perm
+}
+
+// loadPerm reads and validates the mode and group settings.
+func loadPerm() filePerm {
+	p := filePerm{mode: 0600, gid: -1}
+	if v := conf.get("mode"); v != "" {
+		n, err := strconv.ParseUint(v, 8, 32)
+		switch {
+		case err != nil || n&^0777 != 0:
+			log.Fatalf("%s: invalid mode %q", configPath(), v)
+		case n&0600 != 0600:
+			log.Fatalf("%s: mode %s: the owner must be able to read and write", configPath(), v)
+		case n&0007 != 0:
+			log.Fatalf("%s: mode %s: others can't be given access to keys", configPath(), v)
+		}
+		p.mode = os.FileMode(n)
+	}
+	if v := conf.get("group"); v != "" {
+		if runtime.GOOS == "windows" {
+			log.Fatalf("%s: group isn't supported on Windows", configPath())
+		}
+		g, err := user.LookupGroup(v)
+		if err != nil {
+			log.Fatalf("%s: %v", configPath(), err)
+		}
+		if p.gid, err = strconv.Atoi(g.Gid); err != nil {
+			log.Fatalf("%s: group %s: invalid gid %q", configPath(), v, g.Gid)
+		}
+	}
+	return p
+}
+
+// dirMode returns the mode of directories holding files of mode p.
+func (p filePerm) dirMode() os.FileMode {
+	m := p.mode | (p.mode&0444)>>2
+	if p.gid >= 0 {
+		m |= os.ModeSetgid
+	}
+	return m
+}
+
+// apply sets the mode and group of the open file f.
+func (p filePerm) apply(f *os.File) error {
+	if err := f.Chmod(p.mode); err != nil {
+		return err
+	}
+	if p.gid >= 0 {
+		return f.Chown(-1, p.gid)
+	}
+	return nil
+}
+
+// applyDir sets the mode and group of directory dir.
+func (p filePerm) applyDir(dir string) error {
+	if err := os.Chmod(dir, p.dirMode()); err != nil {
+		return err
+	}
+	if p.gid >= 0 {
+		return os.Chown(dir, -1, p.gid)
+	}
+	return nil
+}
+
+// applySocket sets the mode and group of the unix socket sock.
+// Connecting needs write permission, so the group gets it along with
+// read permission.
+func (p filePerm) applySocket(sock string) error {
+	mode := p.mode | (p.mode&0040)>>1
+	if err := os.Chmod(sock, mode); err != nil {
+		return err
+	}
+	if p.gid >= 0 {
+		return os.Chown(sock, -1, p.gid)
+	}
+	return nil
+}
+
+// checkPerm warns if file is accessible to more users than configured.
+func checkPerm(file string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return
+	}
+	if extra := fi.Mode().Perm() &^ keychainPerm().mode; extra != 0 {
+		log.Printf("warning: %s has mode %04o, more permissive than the configured %04o",
+			file, fi.Mode().Perm(), keychainPerm().mode)
+	}
+}
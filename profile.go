@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+var cmdProfiles = &command{
+	name:  "profiles",
+	usage: "profiles",
+	short: "list keychain profiles",
+	long: `Profiles lists the keychain profiles, marking the one in use with *.
+
+A profile is a keychain of its own, with its own configuration, for
+seeds which have to stay apart, such as work and personal ones. It's
+picked with -profile, given before the command, or $GAUTH_PROFILE:
+
+	gauth -profile work add vpn
+
+The keychain of profile work is $HOME/.gauth-work, and its
+configuration $HOME/.gauth-work.conf, which may set other backends,
+encryption and so on. Everything gauth keeps next to the keychain,
+backups included, and the agent's socket are the profile's own too.
+The default profile is the keychain $HOME/.gauth. A profile exists
+once its keychain or configuration does.`,
+}
+
+func init() {
+	cmdProfiles.run = runProfiles
+}
+
+// profile is the keychain profile in use, "" for the default one.
+var profile = os.Getenv("GAUTH_PROFILE")
+
+// checkProfile exits if name isn't a valid profile name.
+func checkProfile(name string) {
+	if name == "" {
+		log.Fatal("empty profile name")
+	}
+	for _, r := range name {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_') {
+			log.Fatalf("invalid profile name %q: use letters, digits, - and _", name)
+		}
+	}
+}
+
+// profileSuffix returns the suffix of the files of the profile in use,
+// which follows ".gauth" in their names.
+func profileSuffix() string {
+	if profile == "" || profile == "default" {
+		return ""
+	}
+	return "-" + profile
+}
+
+// profiles returns the names of the existing profiles, sorted.
+func profiles() []string {
+	names := map[string]bool{"default": true}
+	infos, err := ioutil.ReadDir(os.Getenv("HOME"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, fi := range infos {
+		rest := strings.TrimPrefix(fi.Name(), ".gauth-")
+		if rest == fi.Name() {
+			continue
+		}
+		// .gauth-work, .gauth-work.conf, but not .gauth-agent.sock
+		if !strings.Contains(rest, ".") && fi.Mode().IsRegular() {
+			names[rest] = true
+		} else if name := strings.TrimSuffix(rest, ".conf"); name != rest && !strings.Contains(name, ".") {
+			names[name] = true
+		}
+	}
+	var list []string
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func runProfiles(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 0 {
+		cmd.usageExit()
+	}
+	current := profile
+	if current == "" {
+		current = "default"
+	}
+	for _, name := range profiles() {
+		mark := " "
+		if name == current {
+			mark = "*"
+		}
+		fmt.Printf("%s %s\n", mark, name)
+	}
+}
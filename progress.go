@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// interruptible returns a context cancelled by the first Ctrl-C, so long
+// operations can stop cleanly. A second Ctrl-C kills gauth right away.
+func interruptible(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		select {
+		case <-sig:
+			signal.Stop(sig)
+			fmt.Fprintf(os.Stderr, "\ninterrupted, stopping (press Ctrl-C again to force)\n")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
+	}
+}
+
+// checkInterrupted exits with msg if ctx was cancelled.
+func checkInterrupted(ctx context.Context, msg string) {
+	if ctx.Err() != nil {
+		log.Print(msg)
+		os.Exit(130)
+	}
+}
+
+// A progress shows the advance of a long operation on stderr. It's only
+// drawn if stderr is a terminal and the operation lasts long enough to
+// notice. A nil *progress does nothing.
+type progress struct {
+	label string
+	total int // 0 if unknown
+
+	mu    sync.Mutex
+	done  int
+	shown bool
+	stop  chan bool
+	wg    sync.WaitGroup
+}
+
+const progressDelay = 300 * time.Millisecond
+
+func startProgress(label string, total int) *progress {
+	if !isTerminal(os.Stderr.Fd()) {
+		return nil
+	}
+	p := &progress{label: label, total: total, stop: make(chan bool)}
+	p.wg.Add(1)
+	go p.loop()
+	return p
+}
+
+func (p *progress) loop() {
+	defer p.wg.Done()
+	select {
+	case <-time.After(progressDelay):
+	case <-p.stop:
+		return
+	}
+	spin := `|/-\`
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for i := 0; ; i++ {
+		p.mu.Lock()
+		if p.total > 0 {
+			fmt.Fprintf(os.Stderr, "\r%s %d/%d %c", p.label, p.done, p.total, spin[i%len(spin)])
+		} else {
+			fmt.Fprintf(os.Stderr, "\r%s %c", p.label, spin[i%len(spin)])
+		}
+		p.shown = true
+		p.mu.Unlock()
+		select {
+		case <-t.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// add records n more finished steps.
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.done += n
+	p.mu.Unlock()
+}
+
+// finish stops the progress and erases it.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+	if p.shown {
+		fmt.Fprintf(os.Stderr, "\r\033[K")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"os"
+)
+
+var cmdProtect = &command{
+	name:  "protect",
+	usage: "protect [-d] name",
+	short: "protect a key with a passphrase of its own",
+	long: `Protect seals the secret of key name with a passphrase of its own, for
+keys worth more than the rest, such as those of a bank or a domain
+registrar. Its codes then need the passphrase each time, even when the
+keychain is unlocked or decrypted: gauth asks for it only when a code
+of that key is asked for by name, and lists of codes, such as those of
+"gauth show" and "gauth tui", leave it out. -d removes the protection.
+
+The secret is sealed as encrypted backups are, and stays sealed in
+exports and migrations. The backups of the keychain taken before are
+rewritten with the secret sealed as well. Paper backups ask for the passphrase, and
+export uris leaves protected keys out.`,
+}
+
+var protectRemove = cmdProtect.flags.Bool("d", false, "remove the protection")
+
+func init() {
+	cmdProtect.run = runProtect
+}
+
+func runProtect(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	name := args[0]
+	c := openKeychain()
+	c.checkWritable()
+	k, ok := c.keys[name]
+	if !ok {
+		fatalf(exitNotFound, "no such key %q", name)
+	}
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	if *protectRemove {
+		if !k.protected() {
+			log.Fatalf("%s isn't protected", name)
+		}
+		if err := k.unlock(name); err != nil {
+			log.Fatal(err)
+		}
+		k.text = enc.EncodeToString(k.raw)
+		k.set("protect", "")
+		c.lines[k.line] = formatKey(name, k, k.count)
+		c.save()
+		audit("unprotect", name)
+		fmt.Fprintf(os.Stderr, "removed the passphrase of %s\n", name)
+		return
+	}
+	if k.protected() {
+		log.Fatalf("%s is protected already", name)
+	}
+	passphrase, err := readNewPassword(fmt.Sprintf("new passphrase of %s: ", name))
+	if err != nil {
+		log.Fatalf("reading passphrase: %v", err)
+	}
+	sealed, err := seal(k.raw, passphrase)
+	if err != nil {
+		log.Fatalf("sealing %s: %v", name, err)
+	}
+	raw := k.raw
+	k.text = enc.EncodeToString(sealed)
+	k.set("protect", "passphrase")
+	c.lines[k.line] = formatKey(name, k, k.count)
+	c.save()
+	// The backups, taken before, hold the secret unsealed.
+	if err := rewriteBackups(c.file, nil, func(plain []byte) []byte {
+		return sealBackup(plain, name, raw, k.text)
+	}); err != nil {
+		log.Fatalf("sealing %s in the backups: %v", name, err)
+	}
+	audit("protect", name)
+	fmt.Fprintf(os.Stderr, "protected %s with a passphrase\n", name)
+}
+
+// sealBackup returns the keychain text plain with the secret raw of
+// key name replaced by its sealed text, or nil if plain doesn't hold it.
+func sealBackup(plain []byte, name string, raw []byte, text string) []byte {
+	b, _ := scanKeychain("", plain)
+	changed := false
+	for i, line := range b.lines {
+		n, k, err := parseLine([]byte(line))
+		if err != nil || n != name || !bytes.Equal(k.raw, raw) {
+			continue
+		}
+		k.text = text
+		k.set("protect", "passphrase")
+		b.lines[i] = formatKey(name, k, k.count)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return keychainText(b.lines)
+}
+
+// protected reports whether the secret of k is sealed with a
+// passphrase of its own. Its raw secret is the sealed one until
+// unlock opens it.
+func (k Key) protected() bool {
+	return k.attr("protect") != "" && !k.unlocked
+}
+
+// unlock asks for the passphrase of protected key name and opens its
+// secret. Other keys are left as they are. k stays sealed as stored:
+// formatKey writes the sealed text still.
+func (k *Key) unlock(name string) error {
+	if !k.protected() {
+		return nil
+	}
+	passphrase, err := readPassword(fmt.Sprintf("passphrase of %s: ", name))
+	if err != nil {
+		return fmt.Errorf("reading passphrase: %v", err)
+	}
+	raw, err := unseal(k.raw, passphrase)
+	if err != nil {
+		return fmt.Errorf("%s: wrong passphrase", name)
+	}
+	lockMemory(raw)
+	k.raw = raw
+	k.unlocked = true
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A qrEngine decodes and encodes QR codes. The pure Go engine is the
+// default, so QR codes work in static builds without other tools; the
+// zbar build tag selects zbar's decoder, which copes better with
+// blurry photos and large screenshots.
+type qrEngine interface {
+	decode(file string) (string, error)
+	encode(text string) (*qrSymbol, error)
+}
+
+// goQR is the pure Go QR engine.
+type goQR struct{}
+
+func (goQR) decode(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %v", file, err)
+	}
+	text, err := decodeQR(img)
+	if err == errNoQR {
+		return "", fmt.Errorf("no QR code found in %s", file)
+	}
+	return text, err
+}
+
+func (goQR) encode(text string) (*qrSymbol, error) {
+	return encodeQR(text)
+}
+
+// decodeQRFile returns the text of the QR code in an image file.
+func decodeQRFile(file string) (string, error) {
+	return qr.decode(file)
+}
+
+// qrQuiet is the width in modules of the light margin around QR codes.
+const qrQuiet = 4
+
+// writeTerminal draws s on a terminal, two rows of modules per line of
+// half blocks, in black on white whatever the colors of the terminal.
+func (s *qrSymbol) writeTerminal(w io.Writer) error {
+	dark := func(x, y int) bool {
+		x, y = x-qrQuiet, y-qrQuiet
+		return x >= 0 && y >= 0 && x < s.size && y < s.size && s.dark[y][x]
+	}
+	n := s.size + 2*qrQuiet
+	var b strings.Builder
+	for y := 0; y < n; y += 2 {
+		last := ""
+		for x := 0; x < n; x++ {
+			// the upper half is the foreground, the lower the background
+			fg, bg := 97, 107
+			if dark(x, y) {
+				fg = 30
+			}
+			if dark(x, y+1) {
+				bg = 40
+			}
+			if esc := fmt.Sprintf("\x1b[%d;%dm", fg, bg); esc != last {
+				b.WriteString(esc)
+				last = esc
+			}
+			b.WriteString("▀")
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writePNG writes s as a PNG image with scale pixels per module.
+func (s *qrSymbol) writePNG(w io.Writer, scale int) error {
+	n := (s.size + 2*qrQuiet) * scale
+	img := image.NewGray(image.Rect(0, 0, n, n))
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			mx, my := x/scale-qrQuiet, y/scale-qrQuiet
+			c := color.Gray{0xff}
+			if mx >= 0 && my >= 0 && mx < s.size && my < s.size && s.dark[my][mx] {
+				c = color.Gray{0}
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// screenshotCmds capture the screen into the file given as last argument.
+var screenshotCmds = [][]string{
+	{"grim"},
+	{"gnome-screenshot", "-f"},
+	{"spectacle", "-b", "-n", "-o"},
+	{"maim"},
+	{"import", "-window", "root"},
+	{"screencapture", "-x"},
+}
+
+// scanScreen decodes a QR code currently shown on the screen.
+func scanScreen() (string, error) {
+	f, err := ioutil.TempFile("", "gauth-screen*.png")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	for _, args := range screenshotCmds {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		argv := append(append([]string(nil), args[1:]...), f.Name())
+		if err := exec.Command(args[0], argv...).Run(); err != nil {
+			return "", fmt.Errorf("taking screenshot: %v", err)
+		}
+		return decodeQRFile(f.Name())
+	}
+	return "", errors.New("no screenshot tool found (install grim, maim or imagemagick)")
+}
+
+// scanCamera waits for a QR code shown to the camera.
+func scanCamera() (string, error) {
+	if _, err := exec.LookPath("zbarcam"); err != nil {
+		return "", errors.New("scanning with a camera needs zbarcam (install zbar-tools)")
+	}
+	fmt.Fprintf(os.Stderr, "show the QR code to the camera...\n")
+	cmd := exec.Command("zbarcam", "--raw", "--nodisplay", "-1")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("scanning QR code: %v", err)
+	}
+	return firstLine(string(out)), nil
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,6 @@
+//go:build !zbar
+// +build !zbar
+
+package main
+
+var qr qrEngine = goQR{}
@@ -0,0 +1,26 @@
+//go:build zbar
+// +build zbar
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+var qr qrEngine = zbarQR{}
+
+// zbarQR decodes QR codes with zbarimg, falling back to the pure Go
+// decoder where zbar isn't installed. zbar doesn't encode.
+type zbarQR struct{ goQR }
+
+func (z zbarQR) decode(file string) (string, error) {
+	if _, err := exec.LookPath("zbarimg"); err != nil {
+		return z.goQR.decode(file)
+	}
+	out, err := exec.Command("zbarimg", "--raw", "-q", file).Output()
+	if err != nil {
+		return "", fmt.Errorf("no QR code found in %s", file)
+	}
+	return firstLine(string(out)), nil
+}
@@ -0,0 +1,323 @@
+package main
+
+// The QR code structure shared by the encoder and the decoder, after
+// ISO/IEC 18004: the layout of function patterns, error correction
+// blocks and Reed-Solomon arithmetic.
+
+// The error correction levels are numbered by their format bits.
+const (
+	qrLevelM = 0
+	qrLevelL = 1
+	qrLevelH = 2
+	qrLevelQ = 3
+)
+
+// qrECCPerBlock and qrBlocks give the error correction codewords of each
+// block and the number of blocks, by level (L, M, Q, H) and version.
+var (
+	qrECCPerBlock = [4][41]int{
+		{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+		{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	}
+	qrBlocks = [4][41]int{
+		{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+		{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+		{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+		{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+	}
+)
+
+// qrTableLevel maps the format bits of a level to its row in the tables.
+var qrTableLevel = [4]int{qrLevelM: 1, qrLevelL: 0, qrLevelH: 3, qrLevelQ: 2}
+
+// qrSize returns the number of modules on a side of a version.
+func qrSize(version int) int {
+	return 17 + 4*version
+}
+
+// qrCodewords returns the number of codewords of a version.
+func qrCodewords(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n / 8
+}
+
+// qrDataCodewords returns the number of data codewords of a version
+// at an error correction level.
+func qrDataCodewords(version, level int) int {
+	l := qrTableLevel[level]
+	return qrCodewords(version) - qrECCPerBlock[l][version]*qrBlocks[l][version]
+}
+
+// qrAlignment returns the coordinates of the rows and columns
+// of the centers of the alignment patterns of a version.
+func qrAlignment(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, qrSize(version)-7; i > 0; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+// qrFormatBits returns the 15 format bits of a level and mask,
+// with their BCH error correction.
+func qrFormatBits(level, mask int) int {
+	data := level<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// qrVersionBits returns the 18 version bits of versions 7 and up.
+func qrVersionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1f25
+	}
+	return version<<12 | rem
+}
+
+// qrMask reports whether mask inverts the module at column x, row y.
+func qrMask(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	}
+	return ((x+y)%2+x*y%3)%2 == 0
+}
+
+// A qrSymbol is the matrix of modules of a QR code, indexed by row
+// and column. Dark modules are true. function marks the modules of
+// the function patterns, which don't hold data.
+type qrSymbol struct {
+	version  int
+	size     int
+	dark     [][]bool
+	function [][]bool
+}
+
+// newQRSymbol returns a symbol of version with its function
+// patterns drawn, and room left for the format and version bits.
+func newQRSymbol(version int) *qrSymbol {
+	size := qrSize(version)
+	s := &qrSymbol{version: version, size: size}
+	s.dark = make([][]bool, size)
+	s.function = make([][]bool, size)
+	for y := range s.dark {
+		s.dark[y] = make([]bool, size)
+		s.function[y] = make([]bool, size)
+	}
+	for i := 0; i < size; i++ {
+		s.set(6, i, i%2 == 0)
+		s.set(i, 6, i%2 == 0)
+	}
+	s.finder(3, 3)
+	s.finder(size-4, 3)
+	s.finder(3, size-4)
+	align := qrAlignment(version)
+	for i, x := range align {
+		for j, y := range align {
+			// skip the corners of the finder patterns
+			if i == 0 && j == 0 || i == 0 && j == len(align)-1 || i == len(align)-1 && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					s.set(x+dx, y+dy, max2(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	s.setFormat(0)
+	if version >= 7 {
+		s.setVersion()
+	}
+	return s
+}
+
+// set sets the function module at column x, row y.
+func (s *qrSymbol) set(x, y int, dark bool) {
+	s.dark[y][x] = dark
+	s.function[y][x] = true
+}
+
+// finder draws a finder pattern and its separator around the center x, y.
+func (s *qrSymbol) finder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			if xx, yy := x+dx, y+dy; 0 <= xx && xx < s.size && 0 <= yy && yy < s.size {
+				d := max2(abs(dx), abs(dy))
+				s.set(xx, yy, d != 2 && d != 4)
+			}
+		}
+	}
+}
+
+// formatPositions returns the positions of the two copies of format bit i.
+func (s *qrSymbol) formatPositions(i int) (x1, y1, x2, y2 int) {
+	switch {
+	case i < 6:
+		x1, y1 = 8, i
+	case i < 8:
+		x1, y1 = 8, i+1
+	case i == 8:
+		x1, y1 = 7, 8
+	default:
+		x1, y1 = 14-i, 8
+	}
+	if i < 8 {
+		x2, y2 = s.size-1-i, 8
+	} else {
+		x2, y2 = 8, s.size-15+i
+	}
+	return
+}
+
+// setFormat draws the format bits, and the dark module next to them.
+func (s *qrSymbol) setFormat(bits int) {
+	for i := 0; i < 15; i++ {
+		x1, y1, x2, y2 := s.formatPositions(i)
+		s.set(x1, y1, bits>>i&1 != 0)
+		s.set(x2, y2, bits>>i&1 != 0)
+	}
+	s.set(8, s.size-8, true)
+}
+
+// versionPositions returns the positions of the two copies of version bit i.
+func (s *qrSymbol) versionPositions(i int) (x1, y1, x2, y2 int) {
+	a, b := s.size-11+i%3, i/3
+	return a, b, b, a
+}
+
+func (s *qrSymbol) setVersion() {
+	bits := qrVersionBits(s.version)
+	for i := 0; i < 18; i++ {
+		x1, y1, x2, y2 := s.versionPositions(i)
+		s.set(x1, y1, bits>>i&1 != 0)
+		s.set(x2, y2, bits>>i&1 != 0)
+	}
+}
+
+// dataPositions calls f with the positions of the data modules,
+// in the order codeword bits are placed in them.
+func (s *qrSymbol) dataPositions(f func(x, y int)) {
+	for right := s.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for v := 0; v < s.size; v++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := v
+				if (right+1)&2 == 0 {
+					y = s.size - 1 - v
+				}
+				if !s.function[y][x] {
+					f(x, y)
+				}
+			}
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max2(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// rsExp and rsLog are the exponential and logarithm tables of the
+// GF(256) of QR codes, with the polynomial x^8 + x^4 + x^3 + x^2 + 1
+// and generator 2. shamir.go has its own field.
+var rsExp, rsLog = func() (exp [510]byte, log [256]byte) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = byte(x), byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	return
+}()
+
+func rsMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return rsExp[int(rsLog[a])+int(rsLog[b])]
+}
+
+func rsDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return rsExp[int(rsLog[a])+255-int(rsLog[b])]
+}
+
+// rsGenerator returns the Reed-Solomon generator polynomial of degree n,
+// with roots 1, α, ..., α^(n-1), highest coefficient first.
+func rsGenerator(n int) []byte {
+	g := []byte{1}
+	for i := 0; i < n; i++ {
+		next := make([]byte, len(g)+1)
+		for j, c := range g {
+			next[j] ^= c
+			next[j+1] ^= rsMul(c, rsExp[i])
+		}
+		g = next
+	}
+	return g
+}
+
+// rsECC returns the n error correction codewords of data.
+func rsECC(data []byte, n int) []byte {
+	g := rsGenerator(n)
+	rem := make([]byte, n)
+	for _, d := range data {
+		factor := d ^ rem[0]
+		copy(rem, rem[1:])
+		rem[n-1] = 0
+		for i := 0; i < n; i++ {
+			rem[i] ^= rsMul(g[i+1], factor)
+		}
+	}
+	return rem
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+var qrTexts = []string{
+	"a",
+	"otpauth://totp/ACME:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=ACME",
+	"otpauth://hotp/bank?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&counter=42&digits=8&algorithm=SHA256",
+	// long enough for the version bits, from version 7 on
+	"otpauth-migration://offline?data=" + strings.Repeat("CjEKCkhlbGxvId6tvu8SGFRlc3Q", 6),
+	"ünicode, read as UTF-8 when it is",
+}
+
+// TestQRRoundTrip encodes texts as QR codes, draws them as PNG images
+// and decodes them back.
+func TestQRRoundTrip(t *testing.T) {
+	for _, text := range qrTexts {
+		s, err := encodeQR(text)
+		if err != nil {
+			t.Fatalf("encodeQR(%q): %v", text, err)
+		}
+		if got, err := s.decode(); err != nil || got != text {
+			t.Errorf("decoding the symbol of %q = %q, %v", text, got, err)
+		}
+		var buf bytes.Buffer
+		if err := s.writePNG(&buf, 4); err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := decodeQR(img); err != nil || got != text {
+			t.Errorf("decoding the image of %q (version %d) = %q, %v", text, s.version, got, err)
+		}
+	}
+}
+
+// TestQRErrorCorrection damages symbols within what level M corrects.
+func TestQRErrorCorrection(t *testing.T) {
+	for _, text := range qrTexts {
+		s, err := encodeQR(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Flip a few data modules, which spoils at most as many
+		// codewords, fewer than a block corrects.
+		n := 0
+		s.dataPositions(func(x, y int) {
+			if n < 3 && (x+y)%7 == 0 {
+				s.dark[y][x] = !s.dark[y][x]
+				n++
+			}
+		})
+		if got, err := s.decode(); err != nil || got != text {
+			t.Errorf("decoding the damaged symbol of %q = %q, %v", text, got, err)
+		}
+	}
+}
+
+func TestEncodeQRTooLong(t *testing.T) {
+	if _, err := encodeQR(strings.Repeat("x", 3000)); err == nil {
+		t.Error("encodeQR of 3000 bytes succeeded, want an error")
+	}
+}
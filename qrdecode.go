@@ -0,0 +1,842 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var errNoQR = errors.New("no QR code found")
+
+// decodeQR returns the text of a QR code in img. The image is turned
+// into black and white with a global threshold and then, if that
+// fails, with a local one, for photos with uneven lighting.
+func decodeQR(img image.Image) (string, error) {
+	g := newGrayImage(img)
+	err := errNoQR
+	for _, bin := range []func(*grayImage) *bitImage{globalThreshold, localThreshold} {
+		var text string
+		if text, err = bin(g).decodeQR(); err == nil {
+			return text, nil
+		}
+	}
+	return "", err
+}
+
+// grayImage holds the luminance of an image.
+type grayImage struct {
+	w, h int
+	pix  []uint8
+}
+
+func newGrayImage(img image.Image) *grayImage {
+	b := img.Bounds()
+	g := &grayImage{w: b.Dx(), h: b.Dy(), pix: make([]uint8, b.Dx()*b.Dy())}
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			r, gr, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			// transparent pixels are light, as on a white page
+			lum := (299*r + 587*gr + 114*bl) / 1000
+			lum = lum*a/0xffff + (0xffff - a)
+			g.pix[y*g.w+x] = uint8(lum >> 8)
+		}
+	}
+	return g
+}
+
+// bitImage is a black and white image; true pixels are dark.
+type bitImage struct {
+	w, h int
+	pix  []bool
+}
+
+func (b *bitImage) at(x, y int) bool {
+	if x < 0 || y < 0 || x >= b.w || y >= b.h {
+		return false
+	}
+	return b.pix[y*b.w+x]
+}
+
+// globalThreshold splits the pixels at the threshold which best
+// separates their two brightest classes (Otsu's method).
+func globalThreshold(g *grayImage) *bitImage {
+	var hist [256]int
+	for _, p := range g.pix {
+		hist[p]++
+	}
+	total, sum := len(g.pix), 0.0
+	for i, n := range hist {
+		sum += float64(i * n)
+	}
+	best, threshold := 0.0, 128
+	sumB, wB := 0.0, 0
+	for t := 0; t < 256; t++ {
+		wB += hist[t]
+		if wB == 0 {
+			continue
+		}
+		wF := total - wB
+		if wF == 0 {
+			break
+		}
+		sumB += float64(t * hist[t])
+		mB, mF := sumB/float64(wB), (sum-sumB)/float64(wF)
+		if v := float64(wB) * float64(wF) * (mB - mF) * (mB - mF); v > best {
+			best, threshold = v, t
+		}
+	}
+	b := &bitImage{w: g.w, h: g.h, pix: make([]bool, len(g.pix))}
+	for i, p := range g.pix {
+		b.pix[i] = int(p) <= threshold
+	}
+	return b
+}
+
+// localThreshold makes pixels dark if they're darker than the average
+// of their surroundings.
+func localThreshold(g *grayImage) *bitImage {
+	w, h := g.w, g.h
+	integral := make([]int, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		row := 0
+		for x := 0; x < w; x++ {
+			row += int(g.pix[y*w+x])
+			integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + row
+		}
+	}
+	r := w
+	if h < r {
+		r = h
+	}
+	r /= 16
+	if r < 4 {
+		r = 4
+	}
+	b := &bitImage{w: w, h: h, pix: make([]bool, len(g.pix))}
+	for y := 0; y < h; y++ {
+		y0, y1 := y-r, y+r+1
+		if y0 < 0 {
+			y0 = 0
+		}
+		if y1 > h {
+			y1 = h
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := x-r, x+r+1
+			if x0 < 0 {
+				x0 = 0
+			}
+			if x1 > w {
+				x1 = w
+			}
+			sum := integral[y1*(w+1)+x1] - integral[y0*(w+1)+x1] - integral[y1*(w+1)+x0] + integral[y0*(w+1)+x0]
+			n := (x1 - x0) * (y1 - y0)
+			b.pix[y*w+x] = int(g.pix[y*w+x])*n*100 < sum*85
+		}
+	}
+	return b
+}
+
+type point struct{ x, y float64 }
+
+func (p point) dist(q point) float64 {
+	return math.Hypot(p.x-q.x, p.y-q.y)
+}
+
+// A finder is a candidate finder pattern: its center, the size of its
+// modules and how many scans found it.
+type finder struct {
+	point
+	module float64
+	count  int
+}
+
+// decodeQR finds the finder patterns of a QR code in b and decodes it,
+// trying the likeliest combinations of them first.
+func (b *bitImage) decodeQR() (string, error) {
+	finders := b.findFinders()
+	sort.Slice(finders, func(i, j int) bool { return finders[i].count > finders[j].count })
+	if len(finders) > 12 {
+		finders = finders[:12]
+	}
+	type triple struct {
+		tl, tr, bl finder
+		score      float64
+	}
+	var triples []triple
+	for i := range finders {
+		for j := i + 1; j < len(finders); j++ {
+			for k := j + 1; k < len(finders); k++ {
+				if t, score, ok := orient(finders[i], finders[j], finders[k]); ok {
+					triples = append(triples, triple{t[0], t[1], t[2], score})
+				}
+			}
+		}
+	}
+	sort.Slice(triples, func(i, j int) bool { return triples[i].score < triples[j].score })
+	err := errNoQR
+	for _, t := range triples {
+		var text string
+		if text, err = b.decodeAt(t.tl, t.tr, t.bl); err == nil {
+			return text, nil
+		}
+	}
+	return "", err
+}
+
+// orient arranges three finder patterns as the top left, top right and
+// bottom left corners of a symbol, if they can be. The score is lower
+// the closer they are to a right isosceles triangle of equal modules.
+func orient(a, b, c finder) ([3]finder, float64, bool) {
+	ab, ac, bc := a.dist(b.point), a.dist(c.point), b.dist(c.point)
+	// the top left corner is opposite the longest side
+	switch {
+	case ab >= ac && ab >= bc:
+		a, c = c, a
+	case ac >= ab && ac >= bc:
+		a, b = b, a
+	}
+	if (b.x-a.x)*(c.y-a.y)-(b.y-a.y)*(c.x-a.x) < 0 {
+		b, c = c, b
+	}
+	ab, ac, bc = a.dist(b.point), a.dist(c.point), b.dist(c.point)
+	minModule := math.Min(a.module, math.Min(b.module, c.module))
+	maxModule := math.Max(a.module, math.Max(b.module, c.module))
+	if maxModule > 1.5*minModule || ab < 10*minModule || ac < 10*minModule {
+		return [3]finder{}, 0, false
+	}
+	sides := math.Abs(ab-ac) / math.Max(ab, ac)
+	angle := math.Abs(math.Hypot(ab, ac)-bc) / bc
+	if sides > 0.3 || angle > 0.2 {
+		return [3]finder{}, 0, false
+	}
+	return [3]finder{a, b, c}, sides + angle + (maxModule-minModule)/maxModule, true
+}
+
+// findFinders scans the rows of b for runs of dark, light, dark, light
+// and dark pixels in the proportions 1:1:3:1:1 of a finder pattern, and
+// confirms them by scanning across.
+func (b *bitImage) findFinders() []finder {
+	var finders []finder
+	for y := 0; y < b.h; y++ {
+		var runs [5]int
+		state := 0 // index in runs of the current run
+		for x := 0; x <= b.w; x++ {
+			dark := b.at(x, y) && x < b.w
+			if dark == (state%2 == 0) {
+				runs[state]++
+				continue
+			}
+			if state < 4 {
+				if runs[0] > 0 {
+					state++
+					runs[state] = 1
+				}
+				continue
+			}
+			// a light pixel ends the last run
+			if isFinderRuns(runs) {
+				center := float64(x-runs[4]-runs[3]) - float64(runs[2])/2
+				if f, ok := b.confirmFinder(center, float64(y), runs); ok {
+					finders = addFinder(finders, f)
+				}
+			}
+			copy(runs[:], runs[2:])
+			runs[3], runs[4] = 1, 0
+			state = 3
+		}
+	}
+	return finders
+}
+
+// isFinderRuns reports whether runs are in the proportions 1:1:3:1:1.
+func isFinderRuns(runs [5]int) bool {
+	total := 0
+	for _, n := range runs {
+		if n == 0 {
+			return false
+		}
+		total += n
+	}
+	if total < 7 {
+		return false
+	}
+	m := float64(total) / 7
+	tolerance := m / 2
+	return math.Abs(m-float64(runs[0])) < tolerance &&
+		math.Abs(m-float64(runs[1])) < tolerance &&
+		math.Abs(3*m-float64(runs[2])) < 3*tolerance &&
+		math.Abs(m-float64(runs[3])) < tolerance &&
+		math.Abs(m-float64(runs[4])) < tolerance
+}
+
+// confirmFinder scans the column and then the row through a candidate
+// center, returning the refined finder pattern if both show one.
+func (b *bitImage) confirmFinder(cx, cy float64, runs [5]int) (finder, bool) {
+	total := 0
+	for _, n := range runs {
+		total += n
+	}
+	y, vTotal, ok := b.crossCheck(int(cx), int(cy), 0, 1, total)
+	if !ok || math.Abs(float64(vTotal-total)) >= float64(2*total) {
+		return finder{}, false
+	}
+	x, hTotal, ok := b.crossCheck(int(cx), int(y), 1, 0, total)
+	if !ok {
+		return finder{}, false
+	}
+	return finder{point{x, y}, float64(hTotal+vTotal) / 14, 1}, true
+}
+
+// crossCheck measures the runs of a finder pattern through x, y along
+// the direction dx, dy, returning the coordinate of their center on
+// that axis and their total length.
+func (b *bitImage) crossCheck(x, y, dx, dy, expected int) (float64, int, bool) {
+	var runs [5]int
+	limit := 2 * expected
+	i := 0
+	step := func(k int) (int, int) { return x + k*dx, y + k*dy }
+	for ; b.at(step(-i)) && runs[2] <= limit; i++ {
+		runs[2]++
+	}
+	if runs[2] == 0 {
+		return 0, 0, false
+	}
+	for ; !b.at(step(-i)) && b.inside(step(-i)) && runs[1] <= limit; i++ {
+		runs[1]++
+	}
+	for ; b.at(step(-i)) && runs[0] <= limit; i++ {
+		runs[0]++
+	}
+	j := 1
+	for ; b.at(step(j)) && runs[2] <= limit; j++ {
+		runs[2]++
+	}
+	for ; !b.at(step(j)) && b.inside(step(j)) && runs[3] <= limit; j++ {
+		runs[3]++
+	}
+	for ; b.at(step(j)) && runs[4] <= limit; j++ {
+		runs[4]++
+	}
+	if !isFinderRuns(runs) {
+		return 0, 0, false
+	}
+	end := j - runs[4] - runs[3]
+	center := float64(end) - float64(runs[2])/2
+	total := runs[0] + runs[1] + runs[2] + runs[3] + runs[4]
+	pos := x
+	if dy != 0 {
+		pos = y
+	}
+	return float64(pos) + center, total, true
+}
+
+func (b *bitImage) inside(x, y int) bool {
+	return x >= 0 && y >= 0 && x < b.w && y < b.h
+}
+
+// addFinder merges f into a finder pattern found before at the same
+// place, or adds it.
+func addFinder(finders []finder, f finder) []finder {
+	for i, g := range finders {
+		if g.dist(f.point) < 2*g.module && math.Abs(g.module-f.module) < g.module/2 {
+			n := float64(g.count)
+			finders[i] = finder{
+				point{(g.x*n + f.x) / (n + 1), (g.y*n + f.y) / (n + 1)},
+				(g.module*n + f.module) / (n + 1),
+				g.count + 1,
+			}
+			return finders
+		}
+	}
+	return append(finders, f)
+}
+
+// decodeAt decodes the symbol with the given finder patterns. The
+// version is estimated from their distance and checked against the
+// version bits of large symbols.
+func (b *bitImage) decodeAt(tl, tr, bl finder) (string, error) {
+	module := moduleSize(tl, tr, bl)
+	modules := (tl.dist(tr.point)+tl.dist(bl.point))/2/module + 7
+	estimate := int(math.Round((modules - 17) / 4))
+	err := errNoQR
+	for _, version := range []int{estimate, estimate - 1, estimate + 1} {
+		if version < 1 || version > 40 {
+			continue
+		}
+		s, ok := b.sample(tl, tr, bl, version)
+		if !ok {
+			continue
+		}
+		if version >= 7 {
+			v, ok := s.readVersion()
+			if !ok {
+				continue
+			}
+			if v != version {
+				if s, ok = b.sample(tl, tr, bl, v); !ok {
+					continue
+				}
+			}
+		}
+		var text string
+		if text, err = s.decode(); err == nil {
+			return text, nil
+		}
+	}
+	return "", err
+}
+
+// moduleSize returns the size of the modules of the symbol with the
+// given finder patterns. Their modules are measured across rows and
+// columns of the image, which are longer if the symbol is rotated.
+func moduleSize(tl, tr, bl finder) float64 {
+	module := (tl.module + tr.module + bl.module) / 3
+	d := tl.dist(tr.point)
+	cos, sin := math.Abs(tr.x-tl.x)/d, math.Abs(tr.y-tl.y)/d
+	return module * math.Max(cos, sin)
+}
+
+// sample reads the modules of a symbol of version at the finder patterns.
+// It maps the symbol onto the image through the centers of the finder
+// patterns and, from version 2, the bottom right alignment pattern,
+// which corrects for perspective.
+func (b *bitImage) sample(tl, tr, bl finder, version int) (*qrSymbol, bool) {
+	size := float64(qrSize(version))
+	br := point{tr.x + bl.x - tl.x, tr.y + bl.y - tl.y}
+	brModule := size - 3.5
+	if version >= 2 {
+		// where the alignment pattern would be in a flat symbol
+		f := (size - 10) / (size - 7)
+		est := point{tl.x + f*(br.x-tl.x), tl.y + f*(br.y-tl.y)}
+		u := point{(tr.x - tl.x) / (size - 7), (tr.y - tl.y) / (size - 7)}
+		v := point{(bl.x - tl.x) / (size - 7), (bl.y - tl.y) / (size - 7)}
+		if p, ok := b.findAlignment(est, u, v); ok {
+			br, brModule = p, size-6.5
+		}
+	}
+	h, ok := newHomography(
+		[4]point{{3.5, 3.5}, {size - 3.5, 3.5}, {3.5, size - 3.5}, {brModule, brModule}},
+		[4]point{tl.point, tr.point, bl.point, br},
+	)
+	if !ok {
+		return nil, false
+	}
+	s := newQRSymbol(version)
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			p := h.apply(point{float64(x) + 0.5, float64(y) + 0.5})
+			px, py := int(math.Floor(p.x)), int(math.Floor(p.y))
+			if !b.inside(px, py) {
+				return nil, false
+			}
+			s.dark[y][x] = b.at(px, py)
+		}
+	}
+	return s, true
+}
+
+// findAlignment looks for an alignment pattern, a dark module in a light
+// ring in a dark ring, near est, returning the center of the closest one.
+// u and v step one module along the rows and columns of the symbol.
+func (b *bitImage) findAlignment(est, u, v point) (point, bool) {
+	module := math.Hypot(u.x, u.y)
+	matches := func(x, y int) bool {
+		wrong := 0
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				px := float64(x) + 0.5 + float64(dx)*u.x + float64(dy)*v.x
+				py := float64(y) + 0.5 + float64(dx)*u.y + float64(dy)*v.y
+				if b.at(int(math.Floor(px)), int(math.Floor(py))) != (max2(abs(dx), abs(dy)) != 1) {
+					wrong++
+				}
+			}
+		}
+		return wrong <= 1
+	}
+	for _, radius := range []float64{4, 8, 16} {
+		r := radius * module
+		best, bestDist := point{}, math.Inf(1)
+		for y := int(est.y - r); y <= int(est.y+r); y++ {
+			for x := int(est.x - r); x <= int(est.x+r); x++ {
+				p := point{float64(x), float64(y)}
+				if d := p.dist(est); d < bestDist && b.at(x, y) && matches(x, y) {
+					best, bestDist = p, d
+				}
+			}
+		}
+		if bestDist == math.Inf(1) {
+			continue
+		}
+		// the center of the matching pixels around the closest one
+		var sum point
+		n := 0
+		for y := int(best.y - module); y <= int(best.y+module); y++ {
+			for x := int(best.x - module); x <= int(best.x+module); x++ {
+				if b.at(x, y) && matches(x, y) {
+					sum.x += float64(x)
+					sum.y += float64(y)
+					n++
+				}
+			}
+		}
+		return point{sum.x/float64(n) + 0.5, sum.y/float64(n) + 0.5}, true
+	}
+	return point{}, false
+}
+
+// A homography maps points of one plane onto another.
+type homography [9]float64
+
+// newHomography returns the homography mapping the points from onto to.
+func newHomography(from, to [4]point) (homography, bool) {
+	// solve the 8 linear equations of the 4 correspondences
+	var m [8][9]float64
+	for i := 0; i < 4; i++ {
+		x, y, u, v := from[i].x, from[i].y, to[i].x, to[i].y
+		m[2*i] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		m[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+	for c := 0; c < 8; c++ {
+		pivot := c
+		for r := c + 1; r < 8; r++ {
+			if math.Abs(m[r][c]) > math.Abs(m[pivot][c]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][c]) < 1e-12 {
+			return homography{}, false
+		}
+		m[c], m[pivot] = m[pivot], m[c]
+		for r := 0; r < 8; r++ {
+			if r == c {
+				continue
+			}
+			f := m[r][c] / m[c][c]
+			for k := c; k < 9; k++ {
+				m[r][k] -= f * m[c][k]
+			}
+		}
+	}
+	var h homography
+	for i := 0; i < 8; i++ {
+		h[i] = m[i][8] / m[i][i]
+	}
+	h[8] = 1
+	return h, true
+}
+
+func (h homography) apply(p point) point {
+	w := h[6]*p.x + h[7]*p.y + h[8]
+	return point{(h[0]*p.x + h[1]*p.y + h[2]) / w, (h[3]*p.x + h[4]*p.y + h[5]) / w}
+}
+
+// readVersion reads the version bits of a symbol, correcting up to
+// three wrong bits in either copy.
+func (s *qrSymbol) readVersion() (int, bool) {
+	for copy := 0; copy < 2; copy++ {
+		bits := 0
+		for i := 0; i < 18; i++ {
+			x1, y1, x2, y2 := s.versionPositions(i)
+			x, y := x1, y1
+			if copy == 1 {
+				x, y = x2, y2
+			}
+			if s.dark[y][x] {
+				bits |= 1 << i
+			}
+		}
+		for v := 7; v <= 40; v++ {
+			if bitCount(bits^qrVersionBits(v)) <= 3 {
+				return v, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// readFormat reads the level and mask of a symbol, correcting up to
+// three wrong bits in either copy.
+func (s *qrSymbol) readFormat() (level, mask int, ok bool) {
+	best, bestDist := 0, 16
+	for copy := 0; copy < 2; copy++ {
+		bits := 0
+		for i := 0; i < 15; i++ {
+			x1, y1, x2, y2 := s.formatPositions(i)
+			x, y := x1, y1
+			if copy == 1 {
+				x, y = x2, y2
+			}
+			if s.dark[y][x] {
+				bits |= 1 << i
+			}
+		}
+		for f := 0; f < 32; f++ {
+			if d := bitCount(bits ^ qrFormatBits(f>>3, f&7)); d < bestDist {
+				best, bestDist = f, d
+			}
+		}
+	}
+	return best >> 3, best & 7, bestDist <= 3
+}
+
+func bitCount(x int) int {
+	n := 0
+	for ; x != 0; x &= x - 1 {
+		n++
+	}
+	return n
+}
+
+// decode reads the data of a sampled symbol: it unmasks the codewords,
+// corrects their errors and parses the segments they hold.
+func (s *qrSymbol) decode() (string, error) {
+	level, mask, ok := s.readFormat()
+	if !ok {
+		return "", errors.New("unreadable QR code format")
+	}
+	codewords := make([]byte, qrCodewords(s.version))
+	i := 0
+	s.dataPositions(func(x, y int) {
+		if i < 8*len(codewords) && s.dark[y][x] != qrMask(mask, x, y) {
+			codewords[i/8] |= 0x80 >> (i % 8)
+		}
+		i++
+	})
+
+	l := qrTableLevel[level]
+	blocks, eccLen := qrBlocks[l][s.version], qrECCPerBlock[l][s.version]
+	total := len(codewords)
+	short := blocks - total%blocks
+	shortLen := total/blocks - eccLen
+	split := make([][]byte, blocks)
+	k := 0
+	for j := 0; j <= shortLen; j++ {
+		for b := range split {
+			if j < shortLen || b >= short {
+				split[b] = append(split[b], codewords[k])
+				k++
+			}
+		}
+	}
+	for j := 0; j < eccLen; j++ {
+		for b := range split {
+			split[b] = append(split[b], codewords[k])
+			k++
+		}
+	}
+	var data []byte
+	for _, block := range split {
+		if err := rsCorrect(block, eccLen); err != nil {
+			return "", err
+		}
+		data = append(data, block[:len(block)-eccLen]...)
+	}
+	return parseQRData(data, s.version)
+}
+
+// rsCorrect corrects the errors of a block of codewords ending with
+// n error correction codewords, in place.
+func rsCorrect(block []byte, n int) error {
+	syndromes := make([]byte, n)
+	clean := true
+	for i := range syndromes {
+		var s byte
+		for _, c := range block {
+			s = rsMul(s, rsExp[i]) ^ c
+		}
+		syndromes[i] = s
+		clean = clean && s == 0
+	}
+	if clean {
+		return nil
+	}
+	// Berlekamp-Massey: the error locator polynomial,
+	// lowest coefficient first
+	locator, prev := []byte{1}, []byte{1}
+	l, m, bb := 0, 1, byte(1)
+	for i := 0; i < n; i++ {
+		d := syndromes[i]
+		for j := 1; j <= l && j < len(locator); j++ {
+			d ^= rsMul(locator[j], syndromes[i-j])
+		}
+		if d == 0 {
+			m++
+			continue
+		}
+		t := append([]byte(nil), locator...)
+		coef := rsDiv(d, bb)
+		for len(locator) < len(prev)+m {
+			locator = append(locator, 0)
+		}
+		for j, p := range prev {
+			locator[j+m] ^= rsMul(coef, p)
+		}
+		if 2*l <= i {
+			l, prev, bb, m = i+1-l, t, d, 1
+		} else {
+			m++
+		}
+	}
+	if 2*l > n {
+		return errors.New("too many errors in QR code")
+	}
+	// Chien search: the roots of the locator are the inverses
+	// of the error positions
+	eval := func(p []byte, x byte) byte {
+		var v byte
+		for j := len(p) - 1; j >= 0; j-- {
+			v = rsMul(v, x) ^ p[j]
+		}
+		return v
+	}
+	var positions []int
+	for i := 0; i < len(block); i++ {
+		// position i from the end has the locator root α^-i
+		if eval(locator, rsExp[(255-i)%255]) == 0 {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) != l {
+		return errors.New("too many errors in QR code")
+	}
+	// Forney: the error values from the evaluator
+	// Ω = S·Λ mod x^n and the derivative of Λ
+	omega := make([]byte, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j <= i && j < len(locator); j++ {
+			omega[i] ^= rsMul(locator[j], syndromes[i-j])
+		}
+	}
+	deriv := make([]byte, len(locator))
+	for j := 1; j < len(locator); j += 2 {
+		deriv[j-1] = locator[j]
+	}
+	for _, i := range positions {
+		xInv := rsExp[(255-i)%255]
+		denom := eval(deriv, xInv)
+		if denom == 0 {
+			return errors.New("too many errors in QR code")
+		}
+		e := rsMul(rsExp[i], rsDiv(eval(omega, xInv), denom))
+		block[len(block)-1-i] ^= e
+	}
+	for i := 0; i < n; i++ {
+		var s byte
+		for _, c := range block {
+			s = rsMul(s, rsExp[i]) ^ c
+		}
+		if s != 0 {
+			return errors.New("too many errors in QR code")
+		}
+	}
+	return nil
+}
+
+// parseQRData returns the text of the data segments of a symbol.
+func parseQRData(data []byte, version int) (string, error) {
+	r := bitReader{data: data}
+	var text []byte
+	for r.left() >= 4 {
+		mode := r.read(4)
+		switch mode {
+		case 0: // terminator
+			return qrText(text), nil
+		case 1: // numeric
+			n := r.read(qrCountBits(1, version))
+			for ; n >= 3; n -= 3 {
+				text = append(text, fmt.Sprintf("%03d", r.read(10))...)
+			}
+			if n == 2 {
+				text = append(text, fmt.Sprintf("%02d", r.read(7))...)
+			} else if n == 1 {
+				text = append(text, fmt.Sprintf("%d", r.read(4))...)
+			}
+		case 2: // alphanumeric
+			const chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+			n := r.read(qrCountBits(2, version))
+			for ; n >= 2; n -= 2 {
+				v := r.read(11)
+				if v/45 >= 45 {
+					return "", errors.New("invalid QR code data")
+				}
+				text = append(text, chars[v/45], chars[v%45])
+			}
+			if n == 1 {
+				v := r.read(6)
+				if v >= 45 {
+					return "", errors.New("invalid QR code data")
+				}
+				text = append(text, chars[v])
+			}
+		case 4: // bytes
+			n := r.read(qrCountBits(4, version))
+			for ; n > 0; n-- {
+				text = append(text, byte(r.read(8)))
+			}
+		case 7: // ECI designator, assumed UTF-8 or Latin-1
+			if r.read(1) == 1 {
+				if r.read(1) == 1 {
+					r.read(19)
+				} else {
+					r.read(14)
+				}
+			} else {
+				r.read(7)
+			}
+		case 3: // structured append header
+			r.read(16)
+		case 5: // FNC1 in first position
+		case 9: // FNC1 in second position
+			r.read(8)
+		default:
+			return "", fmt.Errorf("unsupported QR code mode %d", mode)
+		}
+		if r.overrun {
+			return "", errors.New("truncated QR code data")
+		}
+	}
+	return qrText(text), nil
+}
+
+// qrText converts the bytes of a QR code to text, taking them as
+// Latin-1, the default encoding of QR codes, if they aren't UTF-8.
+func qrText(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	var sb strings.Builder
+	for _, c := range b {
+		sb.WriteRune(rune(c))
+	}
+	return sb.String()
+}
+
+// bitReader reads bits from a byte slice, most significant first.
+type bitReader struct {
+	data    []byte
+	n       int
+	overrun bool
+}
+
+func (r *bitReader) left() int {
+	return 8*len(r.data) - r.n
+}
+
+func (r *bitReader) read(bits int) int {
+	if bits > r.left() {
+		r.overrun = true
+		r.n = 8 * len(r.data)
+		return 0
+	}
+	v := 0
+	for i := 0; i < bits; i++ {
+		v = v<<1 | int(r.data[r.n/8]>>(7-r.n%8)&1)
+		r.n++
+	}
+	return v
+}
@@ -0,0 +1,197 @@
+package main
+
+import "errors"
+
+// encodeQR encodes text as a QR code in byte mode, at error correction
+// level M, in the smallest version which holds it.
+func encodeQR(text string) (*qrSymbol, error) {
+	level := qrLevelM
+	version := 1
+	for ; version <= 40; version++ {
+		if 4+qrCountBits(4, version)+8*len(text) <= 8*qrDataCodewords(version, level) {
+			break
+		}
+	}
+	if version > 40 {
+		return nil, errors.New("text too long for a QR code")
+	}
+
+	var w bitWriter
+	w.write(4, 4) // byte mode
+	w.write(len(text), qrCountBits(4, version))
+	for i := 0; i < len(text); i++ {
+		w.write(int(text[i]), 8)
+	}
+	capacity := 8 * qrDataCodewords(version, level)
+	for i := 0; i < 4 && w.n < capacity; i++ {
+		w.write(0, 1) // terminator
+	}
+	for w.n%8 != 0 {
+		w.write(0, 1)
+	}
+	for pad := 0xec; w.n < capacity; pad ^= 0xec ^ 0x11 {
+		w.write(pad, 8)
+	}
+
+	codewords := qrInterleave(w.bytes, version, level)
+	best, bestPenalty := (*qrSymbol)(nil), 0
+	for mask := 0; mask < 8; mask++ {
+		s := newQRSymbol(version)
+		i := 0
+		s.dataPositions(func(x, y int) {
+			if i < 8*len(codewords) {
+				s.dark[y][x] = codewords[i/8]>>(7-i%8)&1 != 0
+			}
+			s.dark[y][x] = s.dark[y][x] != qrMask(mask, x, y)
+			i++
+		})
+		s.setFormat(qrFormatBits(level, mask))
+		if p := s.penalty(); best == nil || p < bestPenalty {
+			best, bestPenalty = s, p
+		}
+	}
+	return best, nil
+}
+
+// qrCountBits returns the length of the character count of a mode
+// in a version: 1 numeric, 2 alphanumeric, 4 byte or 8 kanji.
+func qrCountBits(mode, version int) int {
+	i := 0
+	if version >= 27 {
+		i = 2
+	} else if version >= 10 {
+		i = 1
+	}
+	switch mode {
+	case 1:
+		return [3]int{10, 12, 14}[i]
+	case 2:
+		return [3]int{9, 11, 13}[i]
+	case 4:
+		return [3]int{8, 16, 16}[i]
+	}
+	return [3]int{8, 10, 12}[i]
+}
+
+// qrInterleave splits data into blocks, appends their error correction
+// codewords and interleaves them as they're placed in the symbol.
+func qrInterleave(data []byte, version, level int) []byte {
+	l := qrTableLevel[level]
+	blocks, eccLen := qrBlocks[l][version], qrECCPerBlock[l][version]
+	total := qrCodewords(version)
+	short := blocks - total%blocks
+	shortLen := total/blocks - eccLen
+	var data2, ecc [][]byte
+	for i := 0; i < blocks; i++ {
+		n := shortLen
+		if i >= short {
+			n++
+		}
+		data2 = append(data2, data[:n])
+		ecc = append(ecc, rsECC(data[:n], eccLen))
+		data = data[n:]
+	}
+	var out []byte
+	for i := 0; i <= shortLen; i++ {
+		for _, d := range data2 {
+			if i < len(d) {
+				out = append(out, d[i])
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for _, e := range ecc {
+			out = append(out, e[i])
+		}
+	}
+	return out
+}
+
+// penalty scores how hard s is to read, by the rules the mask
+// is chosen with: long runs, blocks, finder-like patterns and
+// an unbalanced share of dark modules.
+func (s *qrSymbol) penalty() int {
+	p := 0
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			x, y = y, x
+		}
+		if x < 0 || y < 0 || x >= s.size || y >= s.size {
+			return false
+		}
+		return s.dark[y][x]
+	}
+	finder := []bool{true, false, true, true, true, false, true}
+	for _, t := range []bool{false, true} {
+		for y := 0; y < s.size; y++ {
+			run := 0
+			for x := 0; x < s.size; x++ {
+				if x > 0 && at(x, y, t) == at(x-1, y, t) {
+					run++
+				} else {
+					run = 1
+				}
+				if run == 5 {
+					p += 3
+				} else if run > 5 {
+					p++
+				}
+			}
+			for x := -4; x < s.size; x++ {
+				match := true
+				for i, d := range finder {
+					if at(x+i, y, t) != d {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				before, after := true, true
+				for i := 1; i <= 4; i++ {
+					before = before && !at(x-i, y, t)
+					after = after && !at(x+6+i, y, t)
+				}
+				if before || after {
+					p += 40
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			if s.dark[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := s.dark[y][x]
+				if s.dark[y-1][x] == c && s.dark[y][x-1] == c && s.dark[y-1][x-1] == c {
+					p += 3
+				}
+			}
+		}
+	}
+	total := s.size * s.size
+	p += 10 * (abs(dark*20-total*10) / total)
+	return p
+}
+
+// bitWriter appends bits to a byte slice, most significant first.
+type bitWriter struct {
+	bytes []byte
+	n     int
+}
+
+func (w *bitWriter) write(v, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.bytes = append(w.bytes, 0)
+		}
+		if v>>i&1 != 0 {
+			w.bytes[w.n/8] |= 0x80 >> (w.n % 8)
+		}
+		w.n++
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// In read-only mode gauth never writes the keychain: TOTP codes are
+// generated as usual, while HOTP keys, whose counters have to be
+// stored, and commands which change keys fail before writing anything.
+// It's on with -readonly, with "readonly = yes" in the configuration,
+// or when the keychain can't be written, such as on a read-only
+// filesystem or a write-protected medium.
+
+// readonlyFlag is set by the -readonly flag.
+var readonlyFlag bool
+
+// readOnlyReason returns why c can't be written, or "" if it can.
+func (c *Keychain) readOnlyReason() string {
+	switch {
+	case c.memory:
+		return "it was read from " + c.file
+	case readonlyFlag:
+		return "-readonly was given"
+	case conf.get("readonly") == "yes":
+		return fmt.Sprintf("%s sets readonly = yes", configPath())
+	}
+	if err := checkWrite(c.file); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// checkWrite returns an error if file exists and can't be opened for
+// writing, without changing it.
+func checkWrite(file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err == nil {
+		f.Close()
+		return nil
+	}
+	if os.IsPermission(err) || errors.Is(err, syscall.EROFS) {
+		return fmt.Errorf("%s isn't writable", file)
+	}
+	return nil
+}
+
+// userKeychain returns the user's keychain, unread, for checking it.
+func userKeychain() *Keychain {
+	if memoryKeychain != nil {
+		return memoryKeychain
+	}
+	return &Keychain{file: keychainPath()}
+}
+
+// readOnly reports whether the user's keychain is read-only.
+func readOnly() bool {
+	return userKeychain().readOnlyReason() != ""
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var cmdRecovery = &command{
+	name:  "recovery",
+	usage: "recovery add name [code...] | recovery show [-all] name | recovery use name [code]",
+	short: "keep the recovery codes of a key",
+	long: `Recovery keeps the single-use recovery codes a provider hands out
+along with a key next to it, rather than in a text file somewhere.
+
+Recovery add adds codes to key name, given as arguments or, without
+any, read from standard input, as many per line as the provider's page
+lists. Recovery show prints the codes not used yet, one per line, and
+with -all the used ones too, marked as such. Recovery use marks code
+used, or without a code takes the first unused one, printing it, and
+tells how many remain.
+
+The codes are kept in the recovery attribute of the key, so they're as
+safe as the keychain: encrypt it if they should be.`,
+}
+
+var recoveryAll = cmdRecovery.flags.Bool("all", false, "also print the used codes")
+
+func init() {
+	cmdRecovery.run = runRecovery
+}
+
+// In the recovery attribute, codes are separated by commas, and used
+// codes start with a ~.
+const recoveryUsed = "~"
+
+// recoveryCode is a recovery code of a key.
+type recoveryCode struct {
+	code string
+	used bool
+}
+
+func (k Key) recoveryCodes() []recoveryCode {
+	var codes []recoveryCode
+	for _, c := range strings.Split(k.attr("recovery"), ",") {
+		if c == "" {
+			continue
+		}
+		codes = append(codes, recoveryCode{strings.TrimPrefix(c, recoveryUsed), strings.HasPrefix(c, recoveryUsed)})
+	}
+	return codes
+}
+
+func (k *Key) setRecoveryCodes(codes []recoveryCode) {
+	var list []string
+	for _, c := range codes {
+		if c.used {
+			list = append(list, recoveryUsed+c.code)
+		} else {
+			list = append(list, c.code)
+		}
+	}
+	k.set("recovery", strings.Join(list, ","))
+}
+
+// checkRecoveryCode reports whether code can be kept as a recovery code.
+func checkRecoveryCode(code string) error {
+	if strings.ContainsAny(code, ", \t\n") || strings.HasPrefix(code, recoveryUsed) {
+		return fmt.Errorf("invalid recovery code %q: no commas, spaces or leading %s", code, recoveryUsed)
+	}
+	return nil
+}
+
+func runRecovery(ctx context.Context, cmd *command, args []string) {
+	if len(args) == 0 {
+		cmd.usageExit()
+	}
+	// flags may also follow the subcommand
+	cmd.flags.Parse(args[1:])
+	sub, rest := args[0], cmd.flags.Args()
+	if len(rest) == 0 || *recoveryAll && sub != "show" {
+		cmd.usageExit()
+	}
+	name := rest[0]
+	c := openKeychain()
+	k, ok := c.keys[name]
+	if !ok {
+		fatalf(exitNotFound, "no such key %q", name)
+	}
+	codes := k.recoveryCodes()
+	switch sub {
+	case "add":
+		c.checkWritable()
+		added := rest[1:]
+		if len(added) == 0 {
+			s := bufio.NewScanner(stdin)
+			for s.Scan() {
+				added = append(added, strings.Fields(s.Text())...)
+			}
+			if err := s.Err(); err != nil {
+				log.Fatalf("reading codes: %v", err)
+			}
+		}
+		if len(added) == 0 {
+			log.Fatal("no codes given")
+		}
+		have := make(map[string]bool)
+		for _, rc := range codes {
+			have[rc.code] = true
+		}
+		n := 0
+		for _, code := range added {
+			if err := checkRecoveryCode(code); err != nil {
+				log.Fatal(err)
+			}
+			if have[code] {
+				continue
+			}
+			have[code] = true
+			codes = append(codes, recoveryCode{code: code})
+			n++
+		}
+		k.setRecoveryCodes(codes)
+		c.lines[k.line] = formatKey(name, k, k.count)
+		c.save()
+		audit("recovery-add", name)
+		fmt.Fprintf(os.Stderr, "added %d recovery codes to %s\n", n, name)
+	case "show":
+		if len(rest) != 1 {
+			cmd.usageExit()
+		}
+		for _, rc := range codes {
+			switch {
+			case !rc.used:
+				fmt.Println(rc.code)
+			case *recoveryAll:
+				fmt.Printf("%s (used)\n", rc.code)
+			}
+		}
+		audit("recovery-show", name)
+	case "use":
+		if len(rest) > 2 {
+			cmd.usageExit()
+		}
+		c.checkWritable()
+		i := -1
+		for j, rc := range codes {
+			if !rc.used && (len(rest) == 1 || rc.code == rest[1]) {
+				i = j
+				break
+			}
+		}
+		switch {
+		case i < 0 && len(rest) == 1:
+			log.Fatalf("%s has no unused recovery codes", name)
+		case i < 0:
+			for _, rc := range codes {
+				if rc.code == rest[1] {
+					log.Fatalf("the recovery code of %s was already used", name)
+				}
+			}
+			fatalf(exitNotFound, "%s has no recovery code %q", name, rest[1])
+		}
+		codes[i].used = true
+		k.setRecoveryCodes(codes)
+		c.lines[k.line] = formatKey(name, k, k.count)
+		c.save()
+		audit("recovery-use", name)
+		if len(rest) == 1 {
+			fmt.Println(codes[i].code)
+		}
+		left := 0
+		for _, rc := range codes {
+			if !rc.used {
+				left++
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%d recovery codes of %s left\n", left, name)
+		if left <= 2 {
+			log.Printf("warning: %s is running out of recovery codes: get new ones from the provider", name)
+		}
+	default:
+		cmd.usageExit()
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cmdRestore = &command{
+	name:  "restore",
+	usage: "restore [-f] [n]",
+	short: "roll the keychain back to a backup",
+	long: `Restore lists the backups gauth made of the keychain before
+rewriting it, newest first, numbered from 1:
+
+	1  2026-10-16 14:02  12 keys
+	2  2026-10-15 09:41  13 keys
+
+Restore n replaces the keychain with backup n, after asking, unless
+-f is given. The keychain is backed up first, so a restore can itself
+be undone. Backups of an encrypted keychain are encrypted too; their
+keys are counted once n is given. HOTP counters go back with the
+rest, so the next codes of those keys may have been used already.`,
+}
+
+var restoreForce = cmdRestore.flags.Bool("f", false, "don't ask for confirmation")
+
+func init() {
+	cmdRestore.run = runRestore
+}
+
+// backupTime returns when backup was made, from its name.
+func backupTime(backup string) (time.Time, bool) {
+	name := filepath.Base(backup)
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("20060102T150405.000000000Z", name[i+1:])
+	return t, err == nil
+}
+
+// backupSummary describes backup for the list.
+func backupSummary(backup string) string {
+	data, err := ioutil.ReadFile(backup)
+	if err != nil {
+		return err.Error()
+	}
+	defer wipe(data)
+	if isEncryptedKeychain(data) {
+		return "encrypted"
+	}
+	c, problems := scanKeychain(backup, data)
+	if len(problems) > 0 {
+		return fmt.Sprintf("%d keys, %d invalid lines", len(c.keys), len(problems))
+	}
+	return fmt.Sprintf("%d keys", len(c.keys))
+}
+
+func runRestore(ctx context.Context, cmd *command, args []string) {
+	if len(args) > 1 {
+		cmd.usageExit()
+	}
+	if memoryKeychain != nil {
+		log.Fatalf("the keychain read from %s has no backups", memoryKeychain.file)
+	}
+	file := keychainPath()
+	backups, err := listBackups(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(backups) == 0 {
+		log.Fatalf("no backups of %s in %s", file, backupDir(file))
+	}
+	// Newest first.
+	for i, j := 0, len(backups)-1; i < j; i, j = i+1, j-1 {
+		backups[i], backups[j] = backups[j], backups[i]
+	}
+	if len(args) == 0 {
+		for i, b := range backups {
+			when := "?"
+			if t, ok := backupTime(b); ok {
+				when = t.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%d  %s  %s\n", i+1, when, backupSummary(b))
+		}
+		return
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(backups) {
+		log.Fatalf("no backup %q: pick one of 1 to %d", args[0], len(backups))
+	}
+	userKeychain().checkWritable()
+	data, err := ioutil.ReadFile(backups[n-1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	lockMemory(data)
+	defer wipe(data)
+	// Reading it checks it decrypts and parses.
+	c := decryptAndParse(file, append([]byte(nil), data...))
+	if !*restoreForce {
+		when := filepath.Base(backups[n-1])
+		if t, ok := backupTime(backups[n-1]); ok {
+			when = "of " + t.Local().Format("2006-01-02 15:04")
+		}
+		if !confirm(fmt.Sprintf("replace the keychain with the backup %s, %d keys?", when, len(c.keys))) {
+			os.Exit(1)
+		}
+	}
+	unlock, err := lockKeychain()
+	if err != nil {
+		log.Fatalf("locking keychain: %v", err)
+	}
+	defer unlock()
+	if err := backup(file, nil); err != nil {
+		log.Fatal(backupError(err))
+	}
+	if err := writeKeychainFile(file, data); err != nil {
+		log.Fatal(err)
+	}
+	c.updateIntegrity()
+	audit("restore", "")
+	fmt.Fprintf(os.Stderr, "restored the keychain, %d keys\n", len(c.keys))
+}
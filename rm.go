@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+var cmdRm = &command{
+	name:  "rm",
+	usage: "rm [-f] name...",
+	short: "remove keys from the keychain",
+	long: `Rm deletes the named keys from the keychain, asking for confirmation
+unless -f is given. A removed secret can't be recovered.`,
+}
+
+var rmForce = cmdRm.flags.Bool("f", false, "don't ask for confirmation")
+
+func init() {
+	cmdRm.run = runRm
+}
+
+func runRm(ctx context.Context, cmd *command, args []string) {
+	if len(args) == 0 {
+		cmd.usageExit()
+	}
+	c := openKeychain()
+	for _, name := range args {
+		if _, ok := c.keys[name]; !ok {
+			fatalf(exitNotFound, "no such key %q", name)
+		}
+	}
+	var remove []string
+	asked := make(map[string]bool)
+	for _, name := range args {
+		if asked[name] {
+			continue // named twice
+		}
+		asked[name] = true
+		if !*rmForce && !confirm(fmt.Sprintf("remove %s?", name)) {
+			continue
+		}
+		remove = append(remove, name)
+	}
+	if len(remove) > 0 {
+		c.update(func(c *Keychain) {
+			for _, name := range remove {
+				if _, ok := c.keys[name]; !ok {
+					fatalf(exitNotFound, "no such key %q", name)
+				}
+				c.remove(name)
+			}
+		})
+	}
+	for _, name := range remove {
+		audit("remove", name)
+	}
+	fmt.Fprintf(os.Stderr, "removed %d keys\n", len(remove))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Once its flags are parsed, gauth sandboxes itself where the system
+// allows, so that code gone wrong in it can't send secrets over the
+// network, run programs, nor write outside the places gauth keeps its
+// files:
+//
+//	Linux    a seccomp filter refuses sockets other than unix ones
+//	         and, unless gauth runs programs, execve; Landlock refuses
+//	         writes outside the keychain's directory, the directories
+//	         of file backends, the audit log and the files the command
+//	         was asked to write
+//	OpenBSD  pledge drops the network promises, and exec unless gauth
+//	         runs programs, and unveil hides the file system but for
+//	         reading and the same places
+//
+// Landlock rules hold beneath a directory, so the keychain's directory
+// ($HOME) stays writable with its subdirectories, such as .gauth.bak.d;
+// only files and directories can be made there, not links, sockets or
+// devices.
+//
+// gauth runs programs for these commands, and then may exec:
+//
+//	add -secret-cmd, -clipboard, -qr, -qr-screen and -camera
+//	                     the command, clipboard tools and zbar
+//	show -clip           clipboard tools, and gauth to clear them
+//	open, paste, tui     the browser, clipboard tools and notify-send
+//	exec                 the command
+//	import               keepassxc-cli
+//	doctor               timedatectl
+//	sync, cloud          git and gcloud
+//	migrate              the programs of the destination backend
+//	agent                dbus-monitor and the approval dialogs
+//
+// and for every command when the configuration has programs run:
+// after-code hooks, "notify = yes", an audit log or "integrity =
+// keyring" (secret-tool or security), backends other than files, and a
+// keychain key protected by something other than a passphrase. Since
+// the programs inherit the Linux sandbox and need more, gauth then also
+// leaves writable the temporary directory, $XDG_RUNTIME_DIR and /dev,
+// for files of every kind; on OpenBSD they run unrestricted.
+//
+// Builds with cgo, the default of "go build" where a C compiler is
+// found, can't restrict all their threads with Landlock, so they only
+// have the seccomp filter; "gauth version" tells. Build with
+// CGO_ENABLED=0 for the whole sandbox.
+//
+// Outside offline mode, commands which need the network ("gauth open",
+// which starts the browser, and "gauth exec") and configurations with a
+// network backend aren't sandboxed. "sandbox = no" in the configuration
+// turns the sandbox off, as needed to write files elsewhere or to add
+// keys with -secret-cmd commands which use the network.
+
+// errNoSandbox is returned by restrict where the system has no sandbox.
+var errNoSandbox = errors.New("no sandbox on this system")
+
+// A sandboxPolicy tells what the sandbox leaves gauth to do.
+type sandboxPolicy struct {
+	dirs  []string // directories beneath which gauth writes files
+	files []string // files gauth writes elsewhere
+	exec  bool     // whether gauth runs programs
+}
+
+// always is the programs function of commands which run programs
+// whatever their flags.
+func always() bool { return true }
+
+// sandbox restricts gauth for running cmd with args.
+func sandbox(cmd *command, args []string) {
+	if conf.get("sandbox") == "no" {
+		return
+	}
+	if !offline() && (cmd.network || usesNetwork()) {
+		return
+	}
+	if err := restrict(sandboxPolicyFor(cmd, args)); err != nil && err != errNoSandbox {
+		log.Printf("warning: sandbox: %v", err)
+	}
+}
+
+// sandboxStatus describes the sandbox of this build, for gauth version.
+func sandboxStatus() string {
+	switch {
+	case conf.get("sandbox") == "no":
+		return "configuration turns it off"
+	case runtime.GOOS == "linux" && cgoBuild:
+		return "seccomp only: built with cgo, so without Landlock"
+	case runtime.GOOS == "linux":
+		return "seccomp and Landlock"
+	case runtime.GOOS == "openbsd" && cgoBuild:
+		return "pledge and unveil"
+	}
+	return "none"
+}
+
+// usesNetwork reports whether a backend which uses the network
+// is configured.
+func usesNetwork() bool {
+	for _, line := range conf["backend"] {
+		if f := strings.Fields(line); len(f) > 0 && (networkBackends[f[0]] || strings.HasPrefix(f[0], "ssh://")) {
+			return true
+		}
+	}
+	return false
+}
+
+// sandboxPolicyFor returns what running cmd with args needs: writing
+// in the keychain's directory, where all its files are, in those of
+// file backends, whose HOTP counters are written, the audit log, the
+// files cmd writes and, if it runs programs, what they need.
+func sandboxPolicyFor(cmd *command, args []string) sandboxPolicy {
+	p := sandboxPolicy{dirs: []string{filepath.Dir(keychainPath())}}
+	keychains := []string{keychainPath()}
+	for _, line := range conf["backend"] {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "file" {
+			keychains = append(keychains, expandHome(f[1]))
+			p.dirs = append(p.dirs, filepath.Dir(expandHome(f[1])))
+		}
+	}
+	if file := auditPath(); file != "" {
+		if _, err := os.Stat(file); err == nil {
+			p.files = append(p.files, file)
+		} else {
+			p.dirs = append(p.dirs, filepath.Dir(file))
+		}
+	}
+	if cmd.writes != nil {
+		for _, file := range cmd.writes(args) {
+			if file != "" {
+				p.dirs = append(p.dirs, filepath.Dir(file))
+			}
+		}
+	}
+	p.exec = cmd.programs != nil && cmd.programs() || configRunsPrograms(keychains)
+	if p.exec {
+		p.dirs = append(p.dirs, os.TempDir(), "/dev")
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			p.dirs = append(p.dirs, dir)
+		}
+		if cmd == cmdAgent {
+			p.dirs = append(p.dirs, filepath.Dir(agentSocket()))
+		}
+	}
+	return p
+}
+
+// configRunsPrograms reports whether the configuration, or the key
+// protector of one of keychains, has gauth run programs.
+func configRunsPrograms(keychains []string) bool {
+	if len(conf["after-code"]) > 0 || conf.get("notify") == "yes" || auditPath() != "" || integrityMode() == "keyring" {
+		return true
+	}
+	for _, line := range conf["backend"] {
+		if f := strings.Fields(line); len(f) > 0 && f[0] != "file" {
+			return true
+		}
+	}
+	if spec := encryptSpec(); len(spec) > 0 && spec[0] != "passphrase" {
+		return true
+	}
+	for _, file := range keychains {
+		data, err := ioutil.ReadFile(file)
+		if err != nil || !isEncryptedKeychain(data) {
+			continue
+		}
+		if k, _, err := parseEncrypted(data); err != nil || k.protector != "passphrase" {
+			return true
+		}
+	}
+	return false
+}
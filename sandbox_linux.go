@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prSetNoNewPrivs      = 38
+	seccompSetModeFilter = 1
+	seccompFilterTsync   = 1
+	seccompRetAllow      = 0x7fff0000
+	seccompRetErrno      = 0x00050000
+
+	bpfLoad    = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJeq     = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJge     = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	bpfRet     = 0x06 // BPF_RET | BPF_K
+	x32Flag    = 0x40000000
+	sysIOUring = 425 // io_uring_setup, which can open sockets too
+
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+	landlockRulePathBeneath  = 1
+	oPath                    = 0x200000 // O_PATH, which package syscall lacks
+	// The write accesses of Landlock ABI 1, from WRITE_FILE to MAKE_SYM.
+	landlockWriteAccess = 1<<1 | 1<<4 | 1<<5 | 1<<6 | 1<<7 | 1<<8 | 1<<9 | 1<<10 | 1<<11 | 1<<12
+	// Those gauth needs itself: WRITE_FILE, REMOVE_DIR, REMOVE_FILE,
+	// MAKE_DIR and MAKE_REG.
+	landlockFileAccess = 1<<1 | 1<<4 | 1<<5 | 1<<7 | 1<<8
+	landlockWriteFile  = 1 << 1
+)
+
+// sandboxArches gives the audit architecture and the numbers of the
+// socket, seccomp, execve and execveat system calls of the
+// architectures the sandbox supports.
+var sandboxArches = map[string]struct{ audit, socket, seccomp, execve, execveat uint32 }{
+	"amd64": {0xc000003e, 41, 317, 59, 322},
+	"arm64": {0xc00000b7, 198, 277, 221, 281},
+}
+
+type sockFilter struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
+
+// restrict applies the seccomp filter and the Landlock rules of p to
+// all threads. Either is skipped if the kernel doesn't support it, and
+// the Landlock rules in builds with cgo, whose threads can't all be
+// restricted at once, as gauth version reports.
+func restrict(p sandboxPolicy) error {
+	if _, ok := sandboxArches[runtime.GOARCH]; !ok {
+		return errNoSandbox
+	}
+	if err := restrictSyscalls(p.exec); err != nil {
+		return err
+	}
+	if cgoBuild {
+		return nil
+	}
+	return restrictWrites(p)
+}
+
+// Jump targets of the seccomp filter, resolved once it's complete.
+const (
+	toAllow = 0xfe
+	toDeny  = 0xff
+)
+
+// restrictSyscalls installs a seccomp filter which fails with EPERM the
+// creation of sockets other than unix ones, execve and execveat unless
+// exec is set, as well as system calls of other architectures and
+// ABIs, which would get around it.
+func restrictSyscalls(exec bool) error {
+	arch := sandboxArches[runtime.GOARCH]
+	filter := []sockFilter{
+		{bpfLoad, 0, 0, 4}, // arch
+		{bpfJeq, 0, toDeny, arch.audit},
+		{bpfLoad, 0, 0, 0}, // system call number
+		{bpfJge, toDeny, 0, x32Flag},
+		{bpfJeq, toDeny, 0, sysIOUring},
+	}
+	if !exec {
+		filter = append(filter,
+			sockFilter{bpfJeq, toDeny, 0, arch.execve},
+			sockFilter{bpfJeq, toDeny, 0, arch.execveat})
+	}
+	filter = append(filter,
+		sockFilter{bpfJeq, 0, toAllow, arch.socket},
+		sockFilter{bpfLoad, 0, 0, 16}, // first argument, the domain
+		sockFilter{bpfJeq, toAllow, toDeny, syscall.AF_UNIX},
+		sockFilter{bpfRet, 0, 0, seccompRetAllow},
+		sockFilter{bpfRet, 0, 0, seccompRetErrno | uint32(syscall.EPERM)})
+	allow, deny := len(filter)-2, len(filter)-1
+	target := func(i int, j uint8) uint8 {
+		switch j {
+		case toAllow:
+			return uint8(allow - i - 1)
+		case toDeny:
+			return uint8(deny - i - 1)
+		}
+		return j
+	}
+	for i := range filter[:allow] {
+		filter[i].jt, filter[i].jf = target(i, filter[i].jt), target(i, filter[i].jf)
+	}
+	prog := sockFprog{uint16(len(filter)), &filter[0]}
+
+	// The filter applies to the threads which don't gain privileges,
+	// and synchronizing it makes the other threads not gain them too.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if _, _, e := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); e != 0 {
+		return e
+	}
+	r, _, e := syscall.RawSyscall(uintptr(arch.seccomp), seccompSetModeFilter, seccompFilterTsync, uintptr(unsafe.Pointer(&prog)))
+	runtime.KeepAlive(filter)
+	if e == syscall.ENOSYS || e == syscall.EINVAL {
+		return nil // no seccomp filters
+	}
+	if e != 0 {
+		return e
+	}
+	if r != 0 {
+		return fmt.Errorf("thread %d can't be filtered", r)
+	}
+	return nil
+}
+
+// restrictWrites allows writes only to the files and beneath the
+// directories of p with Landlock. Unless p.exec is set, only the files
+// and directories gauth makes may be made there.
+func restrictWrites(p sandboxPolicy) error {
+	handled := uint64(landlockWriteAccess)
+	fd, _, e := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&handled)), unsafe.Sizeof(handled), 0)
+	if e == syscall.ENOSYS || e == syscall.EOPNOTSUPP {
+		return nil // no Landlock
+	}
+	if e != 0 {
+		return e
+	}
+	defer syscall.Close(int(fd))
+	dirAccess := uint64(landlockFileAccess)
+	if p.exec {
+		dirAccess = landlockWriteAccess
+	}
+	for _, dir := range p.dirs {
+		if err := addLandlockRule(fd, dir, dirAccess); err != nil {
+			return err
+		}
+	}
+	files := p.files
+	if p.exec {
+		// exec opens it for the output of programs not kept
+		files = append(files, os.DevNull)
+	}
+	for _, file := range files {
+		if err := addLandlockRule(fd, file, landlockWriteFile); err != nil {
+			return err
+		}
+	}
+	if _, _, e := syscall.AllThreadsSyscall(sysLandlockRestrictSelf, fd, 0, 0); e != 0 {
+		return e
+	}
+	return nil
+}
+
+// addLandlockRule allows access beneath path to the ruleset fd,
+// if path exists.
+func addLandlockRule(fd uintptr, path string, access uint64) error {
+	if path == "" {
+		return nil
+	}
+	d, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil // nothing to allow
+	}
+	defer syscall.Close(d)
+	// struct landlock_path_beneath_attr is packed
+	var attr [12]byte
+	binary.LittleEndian.PutUint64(attr[0:], access)
+	binary.LittleEndian.PutUint32(attr[8:], uint32(d))
+	if _, _, e := syscall.Syscall6(sysLandlockAddRule, fd, landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); e != 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+//go:build openbsd && cgo
+// +build openbsd,cgo
+
+package main
+
+// #include <stdlib.h>
+// #include <unistd.h>
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// restrict pledges gauth to what it uses besides the network and,
+// unless p.exec is set, running programs, and unveils the file system
+// for reading, the places of p for writing, and running programs if
+// p.exec is set. Package syscall can't make these system calls since
+// OpenBSD dropped indirect system calls, hence cgo.
+func restrict(p sandboxPolicy) error {
+	type rule struct{ path, perm string }
+	rules := []rule{{"/", "r"}}
+	promises := "stdio rpath wpath cpath fattr chown flock tty unix sendfd recvfd"
+	if p.exec {
+		rules[0].perm = "rx"
+		promises += " proc exec"
+	}
+	for _, path := range append(p.dirs, p.files...) {
+		if path != "" {
+			rules = append(rules, rule{path, "rwc"})
+		}
+	}
+	for _, r := range rules {
+		if err := unveil(r.path, r.perm); err != nil {
+			return fmt.Errorf("unveil %s: %v", r.path, err)
+		}
+	}
+	if err := unveil("", ""); err != nil {
+		return fmt.Errorf("unveil: %v", err)
+	}
+	cpromises := C.CString(promises)
+	defer C.free(unsafe.Pointer(cpromises))
+	if r, err := C.pledge(cpromises, nil); r != 0 {
+		return fmt.Errorf("pledge: %v", err)
+	}
+	return nil
+}
+
+// unveil calls unveil(2), locking the rules if path is "".
+func unveil(path, perm string) error {
+	if path == "" {
+		if r, err := C.unveil(nil, nil); r != 0 {
+			return err
+		}
+		return nil
+	}
+	p, m := C.CString(path), C.CString(perm)
+	defer C.free(unsafe.Pointer(p))
+	defer C.free(unsafe.Pointer(m))
+	if r, err := C.unveil(p, m); r != 0 {
+		return err
+	}
+	return nil
+}
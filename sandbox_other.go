@@ -0,0 +1,9 @@
+//go:build !linux && !(openbsd && cgo)
+// +build !linux
+// +build !openbsd !cgo
+
+package main
+
+func restrict(sandboxPolicy) error {
+	return errNoSandbox
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxScanSize is the size of the largest file import -scan looks into.
+const maxScanSize = 16 << 20
+
+// decrypts lists the formats whose encrypted files gauth can open.
+var decrypts = map[string]bool{"gauth": true, "aegis": true, "andotp": true, "keepass": true}
+
+// A found is an export file found by import -scan.
+type found struct {
+	path      string
+	format    string
+	encrypted bool
+	entries   []entry
+	err       error
+}
+
+// detectFormat identifies the export format of a file by its contents,
+// or returns "" if it isn't a known one. Encrypted files are only
+// identified, not opened.
+func detectFormat(path string, data []byte) (format string, encrypted bool) {
+	text := bytes.TrimSpace(data)
+	switch {
+	case isSealed(data):
+		return "gauth", true
+	case isEncryptedKeychain(data):
+		return "gauth", false // decrypted by its protector
+	case bytes.HasPrefix(data, kdbxMagic):
+		return "keepass", true
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		if bytes.Contains(data, []byte("export.data")) {
+			return "1password", false
+		}
+	case bytes.HasPrefix(text, []byte("{")):
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(text, &fields) != nil {
+			return "", false
+		}
+		has := func(names ...string) bool {
+			for _, n := range names {
+				if _, ok := fields[n]; !ok {
+					return false
+				}
+			}
+			return true
+		}
+		switch {
+		case has("version", "header", "db"):
+			var v aegisVault
+			json.Unmarshal(text, &v)
+			return "aegis", v.Header.Params != nil
+		case has("schemaVersion", "services"):
+			var b twoFASBackup
+			json.Unmarshal(text, &b)
+			return "2fas", b.ServicesEncrypted != ""
+		case has("encrypted", "items"):
+			var b struct{ Encrypted bool }
+			json.Unmarshal(text, &b)
+			return "bitwarden", b.Encrypted
+		case has("tokens"):
+			return "freeotp", false
+		}
+	case bytes.HasPrefix(text, []byte("[")):
+		var list []map[string]json.RawMessage
+		if json.Unmarshal(text, &list) != nil || len(list) == 0 {
+			return "", false
+		}
+		if _, ok := list[0]["decryptedSeed"]; ok {
+			return "authy", false
+		}
+		_, secret := list[0]["secret"]
+		_, typ := list[0]["type"]
+		if secret && typ {
+			return "andotp", false
+		}
+	case bytes.HasPrefix(text, []byte("<")):
+		if bytes.Contains(text, []byte("tokenOrder")) {
+			return "freeotp", false
+		}
+		if bytes.Contains(text, []byte("<KeePassFile")) {
+			return "keepass", false
+		}
+	case bytes.HasPrefix(text, []byte("otpauth://")) || bytes.HasPrefix(text, []byte("otpauth-migration://")):
+		return "otpauth", false
+	case strings.HasSuffix(path, ".json.aes"):
+		return "andotp", true
+	}
+	if i := bytes.IndexByte(text, '\n'); i > 0 {
+		header := strings.ToLower(string(text[:i]))
+		if strings.Contains(header, "title") && strings.Contains(header, "otpauth") {
+			return "1password", false
+		}
+	}
+	return "", false
+}
+
+// scan finds the export files under dir and reads those which
+// aren't encrypted.
+func scan(ctx context.Context, dir string) []*found {
+	var files []*found
+	p := startProgress("scanning "+dir, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		checkInterrupted(ctx, "scan interrupted, keychain unchanged")
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+		if !info.Mode().IsRegular() || info.Size() > maxScanSize || info.Size() == 0 {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+		format, encrypted := detectFormat(path, data)
+		if format == "" {
+			return nil
+		}
+		f := &found{path: path, format: format, encrypted: encrypted && decrypts[format]}
+		if !f.encrypted {
+			f.entries, f.err = importFound(ctx, f)
+		}
+		files = append(files, f)
+		p.add(1)
+		return nil
+	})
+	p.finish()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return files
+}
+
+// importFound reads found file f. The problems with its entries
+// are reported with the file name.
+func importFound(ctx context.Context, f *found) ([]entry, error) {
+	prefix := log.Prefix()
+	log.SetPrefix(prefix + f.path + ": ")
+	defer log.SetPrefix(prefix)
+	return importers[f.format](ctx, f.path)
+}
+
+// runScan imports all export files found under dir, after showing them.
+// Encrypted files ask for their password once the import is confirmed.
+func runScan(ctx context.Context, dir string) {
+	files := scan(ctx, dir)
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "no backups found in %s\n", dir)
+		return
+	}
+	usable := 0
+	for _, f := range files {
+		if f.err == nil {
+			usable++
+		}
+		switch {
+		case f.err != nil:
+			fmt.Printf("%s\t%s\t%v\n", f.path, f.format, f.err)
+		case f.encrypted:
+			fmt.Printf("%s\t%s\tencrypted\n", f.path, f.format)
+		default:
+			fmt.Printf("%s\t%s\t%d keys\n", f.path, f.format, len(f.entries))
+		}
+	}
+	if usable == 0 {
+		return
+	}
+	if !*importDryRun && !confirm(fmt.Sprintf("import the keys of %d files?", usable)) {
+		return
+	}
+	var entries []entry
+	for _, f := range files {
+		if f.encrypted {
+			if *importDryRun {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "%s:\n", f.path)
+			f.entries, f.err = importFound(ctx, f)
+			checkInterrupted(ctx, "import interrupted, keychain unchanged")
+			if f.err != nil {
+				log.Printf("%s: %v, skipped", f.path, f.err)
+			}
+		}
+		if f.err == nil {
+			entries = append(entries, f.entries...)
+		}
+	}
+	openKeychain().merge(ctx, entries)
+}
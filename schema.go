@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+var cmdSchema = &command{
+	name:  "schema",
+	usage: "schema [name]",
+	short: "print the JSON schema of gauth's JSON output",
+	long: `Schema prints the JSON Schema describing the JSON gauth writes: the
+output of "gauth agent status -json" (agent-status), the codes printed
+by "gauth show -json" (code), the errors written with -json-errors
+(error) and the records of the audit log (audit-record). With a name, it prints the schema of
+that output only.
+
+The schema is versioned. Every JSON object gauth prints has a "schema"
+field holding the version it follows. Within a version, fields are
+only ever added. A field which is going away is first marked
+"deprecated" in the schema, with a description of what replaces it,
+for a whole version before it's removed.`,
+}
+
+// schemaVersion is the version of the JSON output, which is
+// incremented whenever a field changes or is removed.
+const schemaVersion = 1
+
+// schemaDefs are the schemas of the JSON outputs, by name.
+var schemaDefs = map[string]string{
+	"agent-status": `{
+	"description": "The status of the agent, printed by \"gauth agent status -json\".",
+	"type": "object",
+	"required": ["schema", "running", "locked", "socket", "keys"],
+	"properties": {
+		"schema": {"const": 1, "description": "The schema version."},
+		"running": {"type": "boolean", "description": "Whether the agent is running."},
+		"locked": {"type": "boolean", "description": "Whether the agent is locked and won't serve codes."},
+		"pid": {"type": "integer", "description": "The process ID of the agent, if it's running."},
+		"started": {"type": "string", "format": "date-time", "description": "When the agent started, if it's running."},
+		"socket": {"type": "string", "description": "The socket or pipe the agent listens on."},
+		"keys": {"type": "integer", "minimum": 0, "description": "The number of keys of the agent's keychain, 0 if it isn't running or is locked."}
+	}
+}`,
+	"code": `{
+	"description": "A code, printed by \"gauth show -json\".",
+	"type": "object",
+	"required": ["schema", "name", "code", "source"],
+	"properties": {
+		"schema": {"const": 1, "description": "The schema version."},
+		"name": {"type": "string", "description": "The name of the key."},
+		"code": {"type": "string", "description": "The code."},
+		"expires": {"type": "string", "format": "date-time", "description": "When the code expires, for TOTP codes."},
+		"source": {"type": "string", "description": "The backend the key comes from, such as \"file:/home/user/.gauth\"."}
+	}
+}`,
+	"error": `{
+	"description": "An error or warning, written on stderr with -json-errors.",
+	"type": "object",
+	"required": ["schema", "kind", "message"],
+	"properties": {
+		"schema": {"const": 1, "description": "The schema version."},
+		"kind": {"enum": ["error", "warning", "not-found", "invalid-keychain", "clock-skew", "verify-failed"], "description": "What went wrong: \"error\" for errors of no other kind."},
+		"status": {"type": "integer", "description": "The status gauth exits with, when the error ends it with a known status."},
+		"message": {"type": "string", "description": "The message gauth writes without -json-errors."}
+	}
+}`,
+	"audit-record": `{
+	"description": "A line of the audit log.",
+	"type": "object",
+	"required": ["time", "event", "prev", "mac"],
+	"properties": {
+		"schema": {"const": 1, "description": "The schema version, missing from records written before the schema was versioned."},
+		"time": {"type": "string", "format": "date-time", "description": "When the event happened, in UTC."},
+		"event": {"type": "string", "description": "What happened, such as \"code\", \"add\" or \"import\"."},
+		"name": {"type": "string", "description": "The name of the key concerned, if any."},
+		"command": {"type": "string", "description": "The gauth command which caused the event, such as \"show\" or \"agent\"."},
+		"user": {"type": "string", "description": "The user running gauth, if known."},
+		"pid": {"type": "integer", "description": "The process which ran gauth, or the client of the agent, if known."},
+		"tty": {"type": "string", "description": "The terminal of that process, if it has one and it's known."},
+		"prev": {"type": "string", "description": "The MAC of the previous record, empty for the first."},
+		"mac": {"type": "string", "pattern": "^[0-9a-f]{64}$", "description": "The HMAC-SHA256 of the record's JSON encoding up to this field, under a key kept in the system keyring."}
+	}
+}`,
+}
+
+func init() {
+	cmdSchema.run = runSchema
+}
+
+func runSchema(ctx context.Context, cmd *command, args []string) {
+	if len(args) > 1 {
+		cmd.usageExit()
+	}
+	var doc []byte
+	if len(args) == 1 {
+		def, ok := schemaDefs[args[0]]
+		if !ok {
+			log.Fatalf("unknown output %q (use agent-status, code, error or audit-record)", args[0])
+		}
+		doc = []byte(def)
+	} else {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, `{"$schema": "https://json-schema.org/draft/2020-12/schema",`)
+		fmt.Fprintf(&buf, `"$id": "https://github.com/moldabekov/gauth/schema/v%d.json",`, schemaVersion)
+		fmt.Fprintf(&buf, `"title": "gauth JSON output", "version": %d, "$defs": {`, schemaVersion)
+		for i, name := range []string{"agent-status", "code", "error", "audit-record"} {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			fmt.Fprintf(&buf, "%q: %s", name, schemaDefs[name])
+		}
+		buf.WriteString("}}")
+		doc = buf.Bytes()
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, doc, "", "  "); err != nil {
+		log.Fatalf("invalid schema: %v", err)
+	}
+	out.WriteString("\n")
+	os.Stdout.Write(out.Bytes())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted backups, written by "gauth export -encrypt", are
+//
+//	magic (8 bytes) | version (1) | log2 N (1) | r (4) | p (4) | salt (16) | nonce (12) | ciphertext
+//
+// The ciphertext is the keychain, sealed with AES-256-GCM under a key
+// derived from the passphrase by scrypt. The header is authenticated
+// along with it, so any change to the file is detected on import.
+
+var sealedMagic = []byte("GAUTHENC")
+
+const (
+	sealedVersion = 1
+	sealedLogN    = 16
+	sealedR       = 8
+	sealedP       = 1
+	sealedHeader  = 8 + 1 + 1 + 4 + 4 + 16 + 12
+)
+
+func sealedCipher(passphrase string, header []byte) (cipher.AEAD, error) {
+	logN := uint(header[9])
+	r := binary.BigEndian.Uint32(header[10:])
+	p := binary.BigEndian.Uint32(header[14:])
+	if logN < 10 || logN > 22 || r == 0 || r > 32 || p == 0 || p > 16 {
+		return nil, errors.New("invalid key derivation parameters")
+	}
+	p1 := startProgress("deriving key", 0)
+	key, err := scrypt([]byte(passphrase), header[18:34], 1<<logN, int(r), int(p), 32)
+	p1.finish()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	wipe(key) // the cipher keeps its own schedule
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts data with passphrase.
+func seal(data []byte, passphrase string) ([]byte, error) {
+	header := make([]byte, sealedHeader)
+	copy(header, sealedMagic)
+	header[8] = sealedVersion
+	header[9] = sealedLogN
+	binary.BigEndian.PutUint32(header[10:], sealedR)
+	binary.BigEndian.PutUint32(header[14:], sealedP)
+	if _, err := io.ReadFull(rand.Reader, header[18:]); err != nil {
+		return nil, err
+	}
+	aead, err := sealedCipher(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, header[34:], data, header), nil
+}
+
+// isSealed reports whether data is an encrypted backup.
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+// unseal decrypts an encrypted backup.
+func unseal(data []byte, passphrase string) ([]byte, error) {
+	if !isSealed(data) || len(data) < sealedHeader {
+		return nil, errors.New("not an encrypted gauth backup")
+	}
+	if data[8] != sealedVersion {
+		return nil, fmt.Errorf("unsupported encrypted backup version %d", data[8])
+	}
+	header := data[:sealedHeader]
+	aead, err := sealedCipher(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, header[34:], data[sealedHeader:], header)
+	if err != nil {
+		return nil, errors.New("wrong passphrase, or the backup was modified")
+	}
+	return plain, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealRoundTrip(t *testing.T) {
+	backup := []byte("github 6 JBSWY3DPEHPK3PXP\n")
+	data, err := seal(backup, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSealed(data) || bytes.Contains(data, backup) {
+		t.Fatalf("seal(%q) = %q, not sealed", backup, data)
+	}
+	if got, err := unseal(data, "correct horse"); err != nil || !bytes.Equal(got, backup) {
+		t.Errorf("unseal = %q, %v, want %q", got, err, backup)
+	}
+	if got, err := unseal(data, "correct horse "); err == nil {
+		t.Errorf("unseal with a wrong passphrase = %q, want an error", got)
+	}
+}
+
+// TestSealTampered changes each part of an encrypted backup, header
+// included, which unseal must refuse.
+func TestSealTampered(t *testing.T) {
+	data, err := seal([]byte("github 6 JBSWY3DPEHPK3PXP\n"), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		what string
+		i    int
+		to   byte
+	}{
+		{"magic", 0, 'X'},
+		{"version", 8, 2},
+		{"log2 N", 9, 9},
+		{"r", 13, sealedR - 1},
+		{"p", 17, sealedP + 1},
+		{"salt", 20, data[20] ^ 1},
+		{"nonce", 40, data[40] ^ 1},
+		{"ciphertext", sealedHeader, data[sealedHeader] ^ 1},
+		{"tag", len(data) - 1, data[len(data)-1] ^ 1},
+	}
+	for _, tt := range tests {
+		tampered := append([]byte(nil), data...)
+		tampered[tt.i] = tt.to
+		if got, err := unseal(tampered, "correct horse"); err == nil {
+			t.Errorf("unseal with the %s changed = %q, want an error", tt.what, got)
+		}
+	}
+	if got, err := unseal(data[:sealedHeader-1], "correct horse"); err == nil {
+		t.Errorf("unseal of a cut header = %q, want an error", got)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var cmdSearch = &command{
+	name:  "search",
+	usage: "search [-regexp] [-codes] [-all] query",
+	short: "find keys by name, issuer, account or note",
+	long: `Search prints the keys whose name, issuer, account or note contains
+query, ignoring case, along with their issuer and account. Lines of
+notes which match are printed below the key. -regexp takes query as
+a regular expression (see "go doc regexp/syntax"), still ignoring case.
+
+-codes also prints the current code of each TOTP key found, as "gauth"
+alone does. Archived keys are searched only with -all. Search exits
+with status 1 if no key matches.`,
+}
+
+var (
+	searchRegexp = cmdSearch.flags.Bool("regexp", false, "take the query as a regular expression")
+	searchCodes  = cmdSearch.flags.Bool("codes", false, "also print the codes of the keys found")
+	searchAll    = cmdSearch.flags.Bool("all", false, "also search archived keys")
+)
+
+func init() {
+	cmdSearch.run = runSearch
+}
+
+func runSearch(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 1 {
+		cmd.usageExit()
+	}
+	match := func(s string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(args[0]))
+	}
+	if *searchRegexp {
+		if _, err := regexp.Compile(args[0]); err != nil {
+			log.Fatalf("invalid query: %v", err)
+		}
+		re := regexp.MustCompile("(?i)" + args[0])
+		match = re.MatchString
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	if !federation(openBackends()).search(ctx, match) {
+		os.Exit(1)
+	}
+}
+
+// search prints the keys a field of which matches,
+// and reports whether there were any.
+func (f federation) search(ctx context.Context, match func(string) bool) bool {
+	type result struct {
+		k     keyInfo
+		notes []string // matching lines of the note
+	}
+	all, err := f.keys(ctx)
+	if err != nil {
+		// Print the keys of the backends which answered, then fail.
+		defer exitOn(err)
+	}
+	var results []result
+	for _, k := range all {
+		if k.archived && !*searchAll {
+			continue
+		}
+		var notes []string
+		if k.note != "" {
+			for _, line := range strings.Split(k.note, "\n") {
+				if match(line) {
+					notes = append(notes, line)
+				}
+			}
+		}
+		if match(k.name) || match(k.issuer) || match(k.account) || len(notes) > 0 {
+			results = append(results, result{k, notes})
+		}
+	}
+	max, maxID, maxDigits := 0, 0, 0
+	for _, r := range results {
+		if w := displayWidth(r.k.name); max < w {
+			max = w
+		}
+		if w := displayWidth(r.k.identity()); maxID < w {
+			maxID = w
+		}
+		if maxDigits < r.k.digits {
+			maxDigits = r.k.digits
+		}
+	}
+	for _, r := range results {
+		line := padRight(isolate(r.k.name), max)
+		if maxID > 0 {
+			line += "  " + padRight(isolate(r.k.identity()), maxID)
+		}
+		if *searchCodes {
+			code := strings.Repeat("-", r.k.digits)
+			if !r.k.hotp && !r.k.protected {
+				var err error
+				code, err = r.k.source.code(ctx, r.k.name)
+				checkInterrupted(ctx, "interrupted")
+				if err != nil {
+					log.Printf("%s: %s: %v", r.k.source, r.k.name, err)
+					continue
+				}
+				audit("code", r.k.name)
+				afterCode(r.k.name, code, codeExpires(r.k))
+			}
+			line = fmt.Sprintf("%-*s  %s", maxDigits, code, line)
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+		for _, note := range r.notes {
+			fmt.Printf("    %s\n", isolate(note))
+		}
+	}
+	return len(results) > 0
+}
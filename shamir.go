@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Shamir's secret sharing splits a backup into n shares, any k of
+// which recover it while fewer reveal nothing. Each byte of the backup
+// is the constant term of a random polynomial of degree k-1 over
+// GF(256); share x holds the values of the polynomials at x.
+//
+// Shares are text files, to be printed or stored apart:
+//
+//	gauth shamir share
+//	id: 9f3c51d2a07be614
+//	share: 2 of 5
+//	threshold: 3
+//	digest: <SHA-256 of the backup>
+//	data:
+//	<base64>
+
+// gfExp and gfLog are the exponential and logarithm tables of GF(256)
+// with the AES polynomial x^8 + x^4 + x^3 + x + 1 and generator 3.
+var gfExp, gfLog = func() (exp [510]byte, log [256]byte) {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = x, x
+		log[x] = byte(i)
+		// multiply by 3: x*2 ^ x
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	return
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// A share is one part of a split secret.
+type share struct {
+	id     string
+	x      byte
+	n, k   int
+	digest []byte
+	data   []byte
+}
+
+// parseShamir parses a split such as "3of5".
+func parseShamir(s string) (k, n int, err error) {
+	f := strings.Split(strings.ToLower(s), "of")
+	if len(f) == 2 {
+		k, err1 := strconv.Atoi(f[0])
+		n, err2 := strconv.Atoi(f[1])
+		if err1 == nil && err2 == nil && k >= 2 && k <= n && n <= 255 {
+			return k, n, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid split %q: want KofN with 2 <= K <= N <= 255, as 3of5", s)
+}
+
+// split splits secret into n shares, k of which recover it.
+func split(secret []byte, k, n int) ([]share, error) {
+	id := make([]byte, 8)
+	coef := make([]byte, len(secret)*(k-1))
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, coef); err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(secret)
+	shares := make([]share, n)
+	for i := range shares {
+		x := byte(i + 1)
+		s := share{id: hex.EncodeToString(id), x: x, n: n, k: k, digest: digest[:], data: make([]byte, len(secret))}
+		for j, b := range secret {
+			// Horner's rule, from the highest coefficient down
+			y := byte(0)
+			for d := k - 2; d >= 0; d-- {
+				y = gfMul(y^coef[j*(k-1)+d], x)
+			}
+			s.data[j] = y ^ b
+		}
+		shares[i] = s
+	}
+	return shares, nil
+}
+
+// combine recovers the secret from k or more shares of one split.
+func combine(shares []share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+	first := shares[0]
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if s.id != first.id || s.k != first.k || len(s.data) != len(first.data) {
+			return nil, errors.New("the shares don't match: they come from different backups, or one is damaged")
+		}
+		if seen[s.x] {
+			return nil, fmt.Errorf("share %d given twice", s.x)
+		}
+		seen[s.x] = true
+	}
+	if len(shares) < first.k {
+		return nil, fmt.Errorf("%d shares are needed, got %d", first.k, len(shares))
+	}
+	shares = shares[:first.k]
+	secret := make([]byte, len(first.data))
+	for i, si := range shares {
+		// Lagrange basis polynomial of share i at 0
+		l := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				l = gfMul(l, gfDiv(sj.x, sj.x^si.x))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(si.data[b], l)
+		}
+	}
+	if sum := sha256.Sum256(secret); !bytes.Equal(sum[:], first.digest) {
+		return nil, errors.New("recovered backup doesn't match its digest: a share is damaged")
+	}
+	return secret, nil
+}
+
+func (s share) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "gauth shamir share\n")
+	fmt.Fprintf(&b, "id: %s\n", s.id)
+	fmt.Fprintf(&b, "share: %d of %d\n", s.x, s.n)
+	fmt.Fprintf(&b, "threshold: %d\n", s.k)
+	fmt.Fprintf(&b, "digest: %x\n", s.digest)
+	fmt.Fprintf(&b, "data:\n")
+	enc := base64.StdEncoding.EncodeToString(s.data)
+	for len(enc) > 64 {
+		b.WriteString(enc[:64] + "\n")
+		enc = enc[64:]
+	}
+	b.WriteString(enc + "\n")
+	return b.String()
+}
+
+// readShare reads a share file.
+func readShare(file string) (share, error) {
+	var s share
+	f, err := os.Open(file)
+	if err != nil {
+		return s, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() || strings.TrimSpace(sc.Text()) != "gauth shamir share" {
+		return s, fmt.Errorf("%s: not a gauth shamir share", file)
+	}
+	var data strings.Builder
+	inData := false
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if inData {
+			data.WriteString(line)
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], strings.TrimSpace(line[i+1:])
+		switch key {
+		case "id":
+			s.id = value
+		case "share":
+			var x int
+			if _, err := fmt.Sscanf(value, "%d of %d", &x, &s.n); err != nil || x < 1 || x > 255 {
+				return s, fmt.Errorf("%s: invalid share number %q", file, value)
+			}
+			s.x = byte(x)
+		case "threshold":
+			if s.k, err = strconv.Atoi(value); err != nil || s.k < 2 {
+				return s, fmt.Errorf("%s: invalid threshold %q", file, value)
+			}
+		case "digest":
+			if s.digest, err = hex.DecodeString(value); err != nil {
+				return s, fmt.Errorf("%s: invalid digest", file)
+			}
+		case "data":
+			inData = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return s, err
+	}
+	if s.data, err = base64.StdEncoding.DecodeString(data.String()); err != nil {
+		return s, fmt.Errorf("%s: invalid data: %v", file, err)
+	}
+	if s.id == "" || s.x == 0 || s.k == 0 || len(s.digest) != sha256.Size {
+		return s, fmt.Errorf("%s: incomplete share", file)
+	}
+	return s, nil
+}
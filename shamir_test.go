@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestGFArithmetic(t *testing.T) {
+	// FIPS 197, section 4.2: {57} • {83} = {c1}.
+	if got := gfMul(0x57, 0x83); got != 0xc1 {
+		t.Errorf("gfMul(0x57, 0x83) = %#x, want 0xc1", got)
+	}
+	for a := 1; a < 256; a++ {
+		for _, b := range []byte{1, 2, 3, 0x53, 0xca, 0xff} {
+			if got := gfDiv(gfMul(byte(a), b), b); got != byte(a) {
+				t.Fatalf("gfDiv(gfMul(%#x, %#x), %#x) = %#x", a, b, b, got)
+			}
+		}
+	}
+}
+
+// TestShamirRoundTrip recovers a secret from every set of k of n shares,
+// written out and read back as share files.
+func TestShamirRoundTrip(t *testing.T) {
+	secret := []byte("github 6 JBSWY3DPEHPK3PXP\nbank 8 JBSWY3DPEHPK3PXQ 00000000000000000042\n")
+	dir := t.TempDir()
+	for _, tt := range []struct{ k, n int }{{2, 2}, {2, 3}, {3, 5}, {5, 5}} {
+		shares, err := split(secret, tt.k, tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := make([]share, len(shares))
+		for i, s := range shares {
+			file := filepath.Join(dir, "share")
+			if err := ioutil.WriteFile(file, []byte(s.String()), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if read[i], err = readShare(file); err != nil {
+				t.Fatalf("readShare of share %d: %v", s.x, err)
+			}
+		}
+		for set := 0; set < 1<<uint(tt.n); set++ {
+			var some []share
+			for i := range read {
+				if set&(1<<uint(i)) != 0 {
+					some = append(some, read[i])
+				}
+			}
+			got, err := combine(some)
+			if len(some) < tt.k {
+				if err == nil {
+					t.Errorf("%dof%d: combine of %d shares succeeded", tt.k, tt.n, len(some))
+				}
+				continue
+			}
+			if err != nil || !bytes.Equal(got, secret) {
+				t.Errorf("%dof%d: combine of shares %b = %q, %v", tt.k, tt.n, set, got, err)
+			}
+		}
+	}
+}
+
+func TestShamirMistakes(t *testing.T) {
+	secret := []byte("the backup")
+	a, err := split(secret, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := split(secret, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := a[1]
+	damaged.data = append([]byte(nil), damaged.data...)
+	damaged.data[0] ^= 1
+	mistakes := map[string][]share{
+		"shares of two splits": {a[0], b[1]},
+		"a share twice":        {a[0], a[0]},
+		"a damaged share":      {a[0], damaged},
+		"no shares":            nil,
+	}
+	for what, shares := range mistakes {
+		if got, err := combine(shares); err == nil {
+			t.Errorf("combine of %s = %q, want an error", what, got)
+		}
+	}
+}
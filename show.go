@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var cmdShow = &command{
+	name:  "show",
+	usage: "show [-remaining] [-long] [-json | -clip] [-group n] [-no-color] [-all] [-sort order] [-tag tag | -stdin | name...]",
+	short: "print 2fa codes",
+	long: `Show prints the current code of the named key, or of each of the
+named keys in order, one per line. Without a name it
+prints the codes of all TOTP keys; HOTP keys are shown as dashes,
+since generating their codes advances the counter. -tag prints only
+the codes of the keys with the tag (see "gauth help tag"). -sort
+orders them as list does (see "gauth help list"). Archived keys are
+left out unless -all is given. On a terminal, or with -remaining,
+each TOTP code is followed by the seconds it stays valid, and codes
+expiring within 10 and 5 seconds are yellow and red; -no-color or the
+NO_COLOR variable turn colors off.
+
+Keys are searched in all configured backends; a name resolves to the
+first backend which has it. -long prints the backend next to the code.
+
+A name which isn't a key resolves to the only key whose name equals
+it or starts with it, ignoring case. If several keys do, or none but
+a few are a typo or two away, they're listed instead.
+
+-json prints each code as a JSON object on a line of its own, with the
+name of its key, its backend and, for TOTP codes, when it expires (see
+"gauth schema code"). Without a name, HOTP keys are left out.
+
+-group n prints the digits of codes in groups of n, such as "123 456"
+for -group 3, or in halves if n doesn't divide their length, as in
+"1234 5678". JSON output and copied codes keep the digits together.
+
+-clip copies the code of the named key to the clipboard instead of
+printing it, and clears the clipboard after 30 seconds, or the time
+"clip-timeout" gives, unless something else was copied meanwhile. A
+copy of gauth waits in the background to clear it, so gauth itself
+exits right away.
+
+-stdin reads the names from stdin instead, one per line, for pipelines
+such as "grep prod accounts.txt | gauth -stdin". Blank lines are
+skipped.
+
+"gauth name..." is a shortcut for "gauth show name...".`,
+}
+
+var (
+	flagRemaining = cmdShow.flags.Bool("remaining", false, "also print how long a TOTP code stays valid")
+	showLong      = cmdShow.flags.Bool("long", false, "also print the key name and the backend it comes from")
+	showJSON      = cmdShow.flags.Bool("json", false, "print the codes as JSON")
+	showGroup     = cmdShow.flags.Int("group", 0, "print codes in groups of `n` digits")
+	showAll       = cmdShow.flags.Bool("all", false, "also print the codes of archived keys")
+	showTag       = cmdShow.flags.String("tag", "", "print only the codes of the keys tagged `tag`")
+	showSort      = cmdShow.flags.String("sort", "", "sort the keys by `order`: name, recent or favorites")
+	showNoColor   = cmdShow.flags.Bool("no-color", false, "don't color codes about to expire")
+	showStdin     = cmdShow.flags.Bool("stdin", false, "read the key names from stdin, one per line")
+	showClip      = cmdShow.flags.Bool("clip", false, "copy the code to the clipboard for a while instead of printing it")
+)
+
+func init() {
+	cmdShow.run = runShow
+	cmdShow.programs = func() bool { return *showClip }
+}
+
+func runShow(ctx context.Context, cmd *command, args []string) {
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	if *showStdin {
+		if len(args) != 0 || *showTag != "" {
+			cmd.usageExit()
+		}
+		if memoryKeychain != nil && memoryKeychain.file == "stdin" {
+			log.Fatal("-stdin can't be used with -stdin-keychain, which reads stdin too")
+		}
+		args = readNames()
+		if len(args) == 0 {
+			return
+		}
+	}
+	if *showClip && (len(args) != 1 || *showJSON || *showLong) {
+		cmd.usageExit()
+	}
+	f := federation(openBackends())
+	if len(args) == 0 {
+		f.printAll(ctx)
+		return
+	}
+	if *showTag != "" {
+		cmd.usageExit()
+	}
+	f.print(ctx, args)
+}
+
+// readNames reads key names from stdin, one per line.
+func readNames() []string {
+	var names []string
+	for {
+		line, err := stdin.ReadString('\n')
+		if name := strings.TrimSpace(line); name != "" {
+			names = append(names, name)
+		}
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			log.Fatalf("reading names: %v", err)
+		}
+	}
+}
+
+// A codeRecord is a code printed by show -json.
+type codeRecord struct {
+	Schema  int        `json:"schema"`
+	Name    string     `json:"name"`
+	Code    string     `json:"code"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Source  string     `json:"source"`
+}
+
+func printJSON(k keyInfo, c timedCode) {
+	r := codeRecord{Schema: schemaVersion, Name: k.name, Code: c.code, Source: k.source.String()}
+	if !c.expires.IsZero() {
+		r.Expires = &c.expires
+	}
+	json.NewEncoder(os.Stdout).Encode(r)
+}
+
+func (c *Keychain) code(name string) string {
+	code, err := c.tryCode(name)
+	if err != nil {
+		exitOn(err)
+	}
+	return code
+}
+
+// tryCode is code returning its errors, for the agent.
+func (c *Keychain) tryCode(name string) (string, error) {
+	k, ok := c.keys[name]
+	if !ok {
+		return "", statusError{exitNotFound, fmt.Errorf("no such key %q", name)}
+	}
+	if k.attr("ocra") != "" {
+		return "", fmt.Errorf("%s is an OCRA key, whose codes answer challenges: use \"gauth ocra -challenge ... %s\"", name, name)
+	}
+	if k.offset != 0 {
+		codes, err := c.tryAdvance([]string{name})
+		if err != nil {
+			return "", err
+		}
+		return codes[0], nil
+	}
+	if err := k.unlock(name); err != nil {
+		return "", err
+	}
+	if pin := k.attr("yandex"); pin != "" {
+		return yandexCode(k.raw, pin, k.now()), nil
+	}
+	// Time-based key.
+	key := k.hmacKey()
+	code := genTOTP(k.hash(), key, k.now(), k.t0(), k.period(), k.digits)
+	wipe(key)
+	return fmt.Sprintf("%0*d", k.digits, code), nil
+}
+
+// advance generates the next codes of HOTP keys names, storing their
+// counters in a single write of the keychain.
+func (c *Keychain) advance(names []string) []string {
+	codes, err := c.tryAdvance(names)
+	if err != nil {
+		exitOn(err)
+	}
+	return codes
+}
+
+// tryAdvance is advance returning its errors.
+func (c *Keychain) tryAdvance(names []string) ([]string, error) {
+	if why := c.readOnlyReason(); why != "" {
+		return nil, fmt.Errorf("%s is an HOTP key, whose counter can't be stored in a read-only keychain: %s", names[0], why)
+	}
+	keys := make([]Key, len(names))
+	for i, name := range names {
+		keys[i] = c.keys[name]
+		if err := keys[i].unlock(name); err != nil {
+			return nil, err
+		}
+	}
+	codes := make([]string, len(names))
+	err := c.tryAdvanceCounters(names, func(i int, n uint64) {
+		k := keys[i]
+		key := k.hmacKey()
+		codes[i] = fmt.Sprintf("%0*d", k.digits, genHOTP(k.hash(), key, n, k.digits))
+		wipe(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// advanceCounters moves the counters of HOTP keys names one on, calling
+// gen with the index of each key and its new counter before storing
+// them. The counters are read again from the keychain file, holding the
+// keychain lock, which saving the keychain takes too, so that two
+// invocations at once never give out the same counter, nor write at
+// offsets another one moved.
+func (c *Keychain) advanceCounters(names []string, gen func(i int, n uint64)) {
+	if err := c.tryAdvanceCounters(names, gen); err != nil {
+		exitOn(err)
+	}
+}
+
+// tryAdvanceCounters is advanceCounters returning its errors.
+func (c *Keychain) tryAdvanceCounters(names []string, gen func(i int, n uint64)) error {
+	unlock, err := lockKeychain()
+	if err != nil {
+		return fmt.Errorf("locking keychain: %v", err)
+	}
+	defer unlock()
+	fresh, err := c.reread(names)
+	if err != nil {
+		return err
+	}
+	counters := make([]string, len(names))
+	for i, name := range names {
+		k, ok := fresh.keys[name]
+		if !ok || k.offset == 0 || k.text != c.keys[name].text {
+			return fmt.Errorf("%s changed in the keychain while its code was made: try again", name)
+		}
+		n, err := strconv.ParseUint(k.count, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key counter for %q (%q)", name, k.count)
+		}
+		n++
+		gen(i, n)
+		counters[i] = fmt.Sprintf("%0*d", counterLen, n)
+	}
+	if fresh.enc != nil {
+		// An encrypted keychain is rewritten whole.
+		for i, name := range names {
+			k := fresh.keys[name]
+			fresh.lines[k.line] = formatKey(name, k, counters[i])
+		}
+		if err := fresh.trySave(); err != nil {
+			return err
+		}
+		*c = *fresh
+		return nil
+	}
+	f, err := os.OpenFile(c.file, os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("opening keychain: %v", err)
+	}
+	defer f.Close()
+	was, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("updating keychain: %v", err)
+	}
+	for i, name := range names {
+		k := fresh.keys[name]
+		if _, err := f.WriteAt([]byte(counters[i]), int64(k.offset)); err != nil {
+			return fmt.Errorf("updating keychain: %v", err)
+		}
+		// For the next code, in gauth tui.
+		k.count = counters[i]
+		c.keys[name] = k
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("updating keychain: %v", err)
+	}
+	if now, err := f.Stat(); err == nil {
+		restampIndex(c.file, was, now)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing keychain while updating keychain: %v", err)
+	}
+	return nil
+}
+
+// reread reads the keychain file again, for the keys names as they are
+// now: through the index if c was read through it, else whole.
+func (c *Keychain) reread(names []string) (*Keychain, error) {
+	if c.indexed {
+		fresh := &Keychain{file: c.file, keys: make(map[string]Key), indexed: true}
+		for _, name := range names {
+			one, known := readIndexed(c.file, name)
+			if !known || one == nil {
+				return tryReadKeychain(c.file)
+			}
+			fresh.keys[name] = one.keys[name]
+		}
+		return fresh, nil
+	}
+	return tryReadKeychain(c.file)
+}
+
+// print prints the codes of the named keys. All names are resolved
+// first, so that a typo doesn't leave HOTP counters half advanced.
+func (f federation) print(ctx context.Context, names []string) {
+	keys := make([]keyInfo, len(names))
+	for i, name := range names {
+		k, err := f.resolve(ctx, name)
+		if err != nil {
+			fatal(exitNotFound, err)
+		}
+		keys[i] = k
+	}
+	advanced := advanceFiles(keys)
+	for i, k := range keys {
+		var c timedCode
+		if code, ok := advanced[i]; ok {
+			c = usedCode(k, code, time.Now())
+		} else {
+			c = currentCode(ctx, k)
+		}
+		if *showClip {
+			after := clipTimeout()
+			if err := copyCode(c.code, after); err != nil {
+				log.Fatalf("copying code: %v", err)
+			}
+			fmt.Fprintf(os.Stderr, "copied the code of %s, cleared in %v\n", k.name, after)
+			continue
+		}
+		switch {
+		case *showJSON:
+			printJSON(k, c)
+		case *showLong:
+			fmt.Printf("%s\t%s\t%s\n", groupDigits(c.code, *showGroup), isolate(k.name), k.source)
+		default:
+			fmt.Printf("%s\n", groupDigits(c.code, *showGroup))
+		}
+		if *flagRemaining && !k.hotp {
+			fmt.Fprintln(os.Stderr, remaining(int(c.expires.Unix()-time.Now().Unix())))
+		}
+	}
+}
+
+// advanceFiles generates the codes of the HOTP keys among keys kept in
+// keychain files, writing the counters of each file once rather than
+// once per key. It returns them by index in keys.
+func advanceFiles(keys []keyInfo) map[int]string {
+	codes := make(map[int]string)
+	files := make(map[*fileBackend][]int)
+	var order []*fileBackend
+	for i, k := range keys {
+		fb, ok := k.source.(*fileBackend)
+		if !ok || !k.hotp || k.ocra {
+			continue
+		}
+		if files[fb] == nil {
+			order = append(order, fb)
+		}
+		files[fb] = append(files[fb], i)
+	}
+	for _, fb := range order {
+		var names []string
+		for _, i := range files[fb] {
+			names = append(names, keys[i].name)
+		}
+		for j, code := range fb.keychainOf(names...).advance(names) {
+			codes[files[fb][j]] = code
+		}
+	}
+	return codes
+}
+
+// totpResult is the code of a key, or why there is none.
+type totpResult struct {
+	code string
+	err  error
+	done bool
+}
+
+// generateCodes generates the TOTP codes of keys at once, on as many
+// goroutines as there are CPUs. Keys of local keychains are generated
+// concurrently; those of vaults and tokens, which may prompt or talk to
+// a server, one at a time. HOTP and protected keys are skipped, with
+// done false.
+func generateCodes(ctx context.Context, keys []keyInfo) []totpResult {
+	results := make([]totpResult, len(keys))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := runtime.NumCPU(); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				code, err := keys[i].source.code(ctx, keys[i].name)
+				results[i] = totpResult{code, err, true}
+			}
+		}()
+	}
+	var serial []int
+	for i, k := range keys {
+		if k.hotp || k.protected {
+			continue
+		}
+		if _, ok := storeOf(k.source); !ok {
+			serial = append(serial, i)
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, i := range serial {
+		code, err := keys[i].source.code(ctx, keys[i].name)
+		results[i] = totpResult{code, err, true}
+	}
+	return results
+}
+
+func (f federation) printAll(ctx context.Context) {
+	all, err := f.keys(ctx)
+	if err != nil {
+		// Show the codes of the backends which answered, then fail.
+		defer exitOn(err)
+	}
+	var keys []keyInfo
+	for _, k := range all {
+		if k.hasTag(*showTag) && (*showAll || !k.archived) {
+			keys = append(keys, k)
+		}
+	}
+	sortKeys(keys, *showSort)
+	// On a terminal, or with -remaining, each TOTP code is followed by
+	// the seconds it stays valid, to tell whether to wait for the next.
+	countdown := *flagRemaining || isTerminal(os.Stdout.Fd())
+	color := coloring(*showNoColor)
+	max := 0
+	maxDigits := 0
+	for _, k := range keys {
+		if w := displayWidth(k.name); max < w {
+			max = w
+		}
+		if w := len(groupDigits(strings.Repeat("-", k.digits), *showGroup)); maxDigits < w {
+			maxDigits = w
+		}
+	}
+	// Protected keys ask for their passphrase only when named.
+	results := generateCodes(ctx, keys)
+	if ctx.Err() != nil {
+		os.Exit(130)
+	}
+	for i, k := range keys {
+		code := strings.Repeat("-", k.digits)
+		left, paintCode := "", colorDim
+		if r := results[i]; r.done {
+			if r.err != nil {
+				log.Printf("%s: %s: %v", k.source, k.name, r.err)
+				continue
+			}
+			code = r.code
+			audit("code", k.name)
+			now := time.Now()
+			end := k.stepEnd(now)
+			secs := end.Unix() - now.Unix()
+			afterCode(k.name, code, end)
+			if *showJSON {
+				printJSON(k, timedCode{code, end})
+				continue
+			}
+			if countdown {
+				left = fmt.Sprintf("%2ds", secs)
+			}
+			paintCode = expiryColor(secs)
+		} else if *showJSON {
+			continue
+		}
+		code = fmt.Sprintf("%-*s", maxDigits, groupDigits(code, *showGroup))
+		if color && paintCode != "" {
+			code = paint(code, paintCode)
+		}
+		name := isolate(k.name)
+		if countdown || *showLong {
+			name = padRight(name, max)
+		}
+		if countdown {
+			name += "  " + padRight(left, 3)
+		}
+		if *showLong {
+			fmt.Printf("%s\t%s\t%s\n", code, name, k.source)
+			continue
+		}
+		fmt.Printf("%s\t%s\n", code, strings.TrimRight(name, " "))
+	}
+}
+
+// groupDigits splits code in groups of n digits, or in halves if n
+// doesn't divide its length. It returns code as is if n is 0.
+func groupDigits(code string, n int) string {
+	if n <= 0 || n >= len(code) {
+		return code
+	}
+	if len(code)%n != 0 {
+		return groupCode(code)
+	}
+	var groups []string
+	for i := 0; i < len(code); i += n {
+		groups = append(groups, code[i:i+n])
+	}
+	return strings.Join(groups, " ")
+}
@@ -0,0 +1,155 @@
+//go:build !offline
+// +build !offline
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sopsBackend is a keychain kept in a file encrypted with sops
+// (https://github.com/getsops/sops), so it's protected by the KMS, age
+// or PGP keys configured in .sops.yaml. The file is YAML or JSON; the
+// keychain lines are the value of one of its keys, "keychain" unless
+// given:
+//
+//	backend = sops ~/team/2fa.sops.yaml [key]
+//
+// The file is decrypted by the sops command when first needed. Storing
+// the counters of HOTP keys needs sops 3.9 or later, which can set
+// values read from stdin.
+type sopsBackend struct {
+	path string
+	key  string
+
+	mu sync.Mutex
+	c  *Keychain
+}
+
+func init() {
+	backendTypes["sops"] = openSopsBackend
+}
+
+func openSopsBackend(args []string) (backend, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("usage: sops file [key]")
+	}
+	b := &sopsBackend{path: expandHome(args[0]), key: "keychain"}
+	if len(args) == 2 {
+		b.key = args[1]
+	}
+	return b, nil
+}
+
+func (b *sopsBackend) String() string { return "sops:" + b.path }
+
+// keyPath returns the sops path of the keychain in the file.
+func (b *sopsBackend) keyPath() string {
+	key, _ := json.Marshal(b.key)
+	return "[" + string(key) + "]"
+}
+
+// sops runs the sops command with stdin as its input.
+func (b *sopsBackend) sops(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "sops", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("sops not found")
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *sopsBackend) keychain(ctx context.Context) (*Keychain, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.c != nil {
+		return b.c, nil
+	}
+	data, err := b.sops(ctx, nil, "--decrypt", "--extract", b.keyPath(), b.path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	c, err := tryParseKeychain(b.String(), data)
+	if err != nil {
+		return nil, err
+	}
+	b.c = c
+	b.c.memory = true
+	return b.c, nil
+}
+
+func (b *sopsBackend) keys(ctx context.Context) ([]keyInfo, error) {
+	c, err := b.keychain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.keyInfos(), nil
+}
+
+func (b *sopsBackend) code(ctx context.Context, name string) (string, error) {
+	c, err := b.keychain(ctx)
+	if err != nil {
+		return "", err
+	}
+	k, ok := c.keys[name]
+	if !ok {
+		return "", fmt.Errorf("no such key %q", name)
+	}
+	if k.offset == 0 {
+		return c.tryCode(name)
+	}
+	if err := k.unlock(name); err != nil {
+		return "", err
+	}
+	// Store the advanced counter in the encrypted file
+	// before handing out the code.
+	n, err := strconv.ParseUint(k.count, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid key counter for %q", name)
+	}
+	n++
+	c.lines[k.line] = formatKey(name, k, fmt.Sprintf("%0*d", counterLen, n))
+	if err := b.store(ctx, c.lines); err != nil {
+		return "", fmt.Errorf("storing counter: %v", err)
+	}
+	key := k.hmacKey()
+	defer wipe(key)
+	return fmt.Sprintf("%0*d", k.digits, genHOTP(k.hash(), key, n, k.digits)), nil
+}
+
+// store replaces the keychain in the file with lines.
+func (b *sopsBackend) store(ctx context.Context, lines []string) error {
+	data := keychainText(lines)
+	defer wipe(data)
+	value, _ := json.Marshal(string(data))
+	if _, err := b.sops(ctx, value, "set", "--value-stdin", b.path, b.keyPath()); err != nil {
+		return err
+	}
+	c, err := tryParseKeychain(b.String(), data)
+	if err != nil {
+		return err
+	}
+	b.c = c
+	b.c.memory = true
+	return nil
+}
@@ -0,0 +1,287 @@
+//go:build !offline
+// +build !offline
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sshBackend is a keychain file on a server, reached over SFTP, so a
+// single keychain on a trusted machine can serve several clients:
+//
+//	backend = ssh://[user@]host[:port]/path/to/.gauth
+//
+// As with git, the path is absolute, or relative to the home directory
+// if it starts with /~/. The sftp command does the transfers, so the
+// user's ssh configuration, keys and agent apply; it can't ask for
+// passwords. The keychain may be encrypted, and is decrypted here.
+//
+// Writing the counter of an HOTP key takes a lock, the directory
+// path.lock, which only one client can make. The keychain is then
+// read again, its counter advanced, and the new keychain uploaded
+// and renamed over the old one, so readers never see it half written.
+type sshBackend struct {
+	url  string
+	dest string // [user@]host
+	port string
+	path string
+
+	mu sync.Mutex
+	c  *Keychain
+}
+
+func init() {
+	backendTypes["ssh"] = openSSHBackend
+}
+
+func openSSHBackend(args []string) (backend, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("usage: ssh://[user@]host[:port]/path")
+	}
+	u, err := url.Parse(args[0])
+	if err != nil || u.Scheme != "ssh" || u.Host == "" || u.Path == "" || u.Path == "/" {
+		return nil, fmt.Errorf("invalid URL %q, want ssh://[user@]host[:port]/path", args[0])
+	}
+	b := &sshBackend{url: args[0], dest: u.Hostname(), port: u.Port(), path: u.Path}
+	if u.User != nil {
+		b.dest = u.User.Username() + "@" + b.dest
+	}
+	if strings.HasPrefix(b.path, "/~/") {
+		b.path = b.path[len("/~/"):]
+	}
+	return b, nil
+}
+
+func (b *sshBackend) String() string { return b.url }
+
+// sftp runs the sftp commands of batch. Commands starting with - may
+// fail without stopping it.
+func (b *sshBackend) sftp(ctx context.Context, batch ...string) error {
+	args := []string{"-q", "-b", "-"}
+	if b.port != "" {
+		args = append(args, "-P", b.port)
+	}
+	cmd := exec.CommandContext(ctx, "sftp", append(args, b.dest)...)
+	cmd.Stdin = strings.NewReader(strings.Join(batch, "\n") + "\n")
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrNotFound) {
+		return errors.New("sftp not found")
+	}
+	if err != nil {
+		var msgs []string
+		for _, line := range strings.Split(out.String(), "\n") {
+			// Batch mode echoes the commands.
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "sftp>") {
+				msgs = append(msgs, line)
+			}
+		}
+		if len(msgs) > 0 {
+			return errors.New(strings.Join(msgs, "; "))
+		}
+		return fmt.Errorf("sftp: %v", err)
+	}
+	return nil
+}
+
+// sftpQuote quotes a path for an sftp command.
+func sftpQuote(path string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(path) + `"`
+}
+
+// fetch downloads the keychain, and makes the lock first if lock is set.
+func (b *sshBackend) fetch(ctx context.Context, lock bool) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "gauth-ssh")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "keychain")
+	if lock {
+		if err := b.lock(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.sftp(ctx, "get "+sftpQuote(b.path)+" "+sftpQuote(local)); err != nil {
+		if lock {
+			b.unlock(ctx)
+		}
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(local)
+	shred(local)
+	if err != nil {
+		return nil, err
+	}
+	lockMemory(data)
+	return data, nil
+}
+
+// lock makes the lock, waiting for up to 10 seconds
+// for another client to remove its own.
+func (b *sshBackend) lock(ctx context.Context) error {
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = b.sftp(ctx, "mkdir "+sftpQuote(b.path+".lock")); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("%v (if %s.lock was left by a client which died, remove it)", err, b.path)
+}
+
+func (b *sshBackend) unlock(ctx context.Context) error {
+	return b.sftp(ctx, "-rmdir "+sftpQuote(b.path+".lock"))
+}
+
+func (b *sshBackend) keychain(ctx context.Context) (*Keychain, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.c != nil {
+		return b.c, nil
+	}
+	data, err := b.fetch(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	c, err := tryDecryptAndParse(b.String(), data)
+	if err != nil {
+		return nil, err
+	}
+	b.c = c
+	b.c.memory = true
+	return b.c, nil
+}
+
+func (b *sshBackend) keys(ctx context.Context) ([]keyInfo, error) {
+	c, err := b.keychain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.keyInfos(), nil
+}
+
+func (b *sshBackend) code(ctx context.Context, name string) (string, error) {
+	c, err := b.keychain(ctx)
+	if err != nil {
+		return "", err
+	}
+	k, ok := c.keys[name]
+	if !ok {
+		return "", fmt.Errorf("no such key %q", name)
+	}
+	if k.offset == 0 {
+		return c.tryCode(name)
+	}
+	return b.advance(ctx, name)
+}
+
+// advance stores the next counter of HOTP key name on the server
+// and returns its code. It reads the keychain again under the lock,
+// so no other client's counter is lost.
+func (b *sshBackend) advance(ctx context.Context, name string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, err := b.fetch(ctx, true)
+	if err != nil {
+		return "", err
+	}
+	defer b.unlock(ctx)
+	c, err := tryDecryptAndParse(b.String(), data)
+	if err != nil {
+		return "", err
+	}
+	k, ok := c.keys[name]
+	if !ok || k.offset == 0 {
+		return "", fmt.Errorf("key %q changed on the server", name)
+	}
+	if err := k.unlock(name); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(k.count, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid key counter for %q", name)
+	}
+	n++
+	c.lines[k.line] = formatKey(name, k, fmt.Sprintf("%0*d", counterLen, n))
+	if err := b.upload(ctx, c.lines, c.enc); err != nil {
+		return "", fmt.Errorf("storing counter: %v", err)
+	}
+	key := k.hmacKey()
+	defer wipe(key)
+	return fmt.Sprintf("%0*d", k.digits, genHOTP(k.hash(), key, n, k.digits)), nil
+}
+
+// store replaces the keychain on the server with lines, keeping its
+// encryption. It takes the lock, so the keychain has to exist.
+func (b *sshBackend) store(ctx context.Context, lines []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, err := b.fetch(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer b.unlock(ctx)
+	c, err := tryDecryptAndParse(b.String(), data)
+	if err != nil {
+		return err
+	}
+	return b.upload(ctx, lines, c.enc)
+}
+
+// upload replaces the keychain on the server with lines, encrypted
+// with enc unless it's nil. The lock has to be held.
+func (b *sshBackend) upload(ctx context.Context, lines []string, enc *keychainKey) error {
+	data := keychainText(lines)
+	defer wipe(data)
+	out := data
+	if enc != nil {
+		var err error
+		if out, err = enc.encrypt(data); err != nil {
+			return err
+		}
+	}
+	dir, err := ioutil.TempDir("", "gauth-ssh")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "keychain")
+	if err := ioutil.WriteFile(local, out, 0600); err != nil {
+		return err
+	}
+	defer shred(local)
+	tmp := sftpQuote(b.path + ".new")
+	// -p keeps the mode of local, 0600.
+	if err := b.sftp(ctx, "put -p "+sftpQuote(local)+" "+tmp, "rename "+tmp+" "+sftpQuote(b.path)); err != nil {
+		// Servers without posix-rename don't rename over a file.
+		if err := b.sftp(ctx, "rm "+sftpQuote(b.path), "rename "+tmp+" "+sftpQuote(b.path)); err != nil {
+			return err
+		}
+	}
+	c, err := tryParseKeychain(b.String(), data)
+	if err != nil {
+		return err
+	}
+	b.c = c
+	b.c.enc = enc
+	b.c.memory = true
+	return nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var cmdSync = &command{
+	name:  "sync",
+	usage: "sync [-force] init [remote] | push | pull",
+	short: "sync the keychain through a git repository",
+	long: `Sync keeps the keychain in a git repository, as pass does, so
+several machines can share it. The repository is $HOME/.gauth.sync
+(the profile's, with -profile), which holds a copy of the keychain;
+encrypt the keychain (see "gauth help encrypt") before pushing it
+anywhere, with a protector which works on every machine, such as a
+passphrase or a FIDO2 security key.
+
+A keychain which isn't encrypted is only committed with -force, as
+its secrets would stay in the history of the repository and of every
+remote.
+
+Sync init makes the repository. With a remote, it pushes the keychain
+to it, or clones it if there's no keychain here yet. Sync push commits
+the changes to the keychain, with a message naming the keys added,
+removed and updated, or only counting them if the keychain is
+encrypted, and pushes them to the remote, if any. Sync pull
+commits local changes the same way, then fast-forwards to the remote
+and updates the keychain. If the keychain was changed both here and
+elsewhere since the last sync, pull stops and leaves the keychain as
+it is, for the repository to be reconciled with git.
+
+Sync runs git, which talks to the remote. In offline mode, only local
+remotes are reached.`,
+}
+
+var syncForce = cmdSync.flags.Bool("force", false, "commit a keychain which isn't encrypted")
+
+func init() {
+	cmdSync.run = runSync
+	cmdSync.network = true
+	cmdSync.programs = always
+}
+
+// syncDir returns the repository syncing the keychain.
+func syncDir() string {
+	return keychainPath() + ".sync"
+}
+
+// syncFile is the keychain's file in the repository.
+const syncFile = "keychain"
+
+func runSync(ctx context.Context, cmd *command, args []string) {
+	if len(args) == 0 {
+		cmd.usageExit()
+	}
+	if memoryKeychain != nil {
+		log.Fatalf("the keychain read from %s can't be synced", memoryKeychain.file)
+	}
+	// flags may also follow the subcommand
+	cmd.flags.Parse(args[1:])
+	args = append([]string{args[0]}, cmd.flags.Args()...)
+	switch {
+	case args[0] == "init" && len(args) <= 2:
+		remote := ""
+		if len(args) == 2 {
+			remote = args[1]
+		}
+		syncInit(remote)
+	case args[0] == "push" && len(args) == 1:
+		syncCheckDir()
+		syncCommit()
+		if syncRemote() != "" {
+			syncCheckOffline()
+			if err := git(os.Stderr, "push", "origin", "HEAD"); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case args[0] == "pull" && len(args) == 1:
+		syncCheckDir()
+		syncCommit()
+		if syncRemote() == "" {
+			log.Fatalf("%s has no remote to pull from", syncDir())
+		}
+		syncCheckOffline()
+		if err := git(os.Stderr, "pull", "--ff-only", "origin"); err != nil {
+			log.Fatalf("%v\nthe keychain changed here and elsewhere since the last sync: reconcile %s with git, then pull", err, syncDir())
+		}
+		syncCheckout()
+	default:
+		cmd.usageExit()
+	}
+}
+
+func syncInit(remote string) {
+	dir := syncDir()
+	if _, err := os.Stat(dir); err == nil {
+		log.Fatalf("%s already exists", dir)
+	}
+	if remote != "" {
+		syncRemoteOffline(remote)
+	}
+	if _, err := os.Stat(keychainPath()); os.IsNotExist(err) && remote != "" {
+		// A new machine: take the keychain from the remote.
+		if err := runGit(exec.Command("git", "clone", remote, dir), os.Stderr); err != nil {
+			log.Fatal(err)
+		}
+		syncCheckout()
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Fatal(err)
+	}
+	if err := keychainPerm().applyDir(dir); err != nil {
+		log.Fatal(err)
+	}
+	if err := git(nil, "init", "-q"); err != nil {
+		log.Fatal(err)
+	}
+	syncCommit()
+	if remote != "" {
+		if err := git(nil, "remote", "add", "origin", remote); err != nil {
+			log.Fatal(err)
+		}
+		if err := git(os.Stderr, "push", "-u", "origin", "HEAD"); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "syncing the keychain through %s\n", dir)
+}
+
+func syncCheckDir() {
+	if _, err := os.Stat(filepath.Join(syncDir(), ".git")); err != nil {
+		log.Fatalf("the keychain isn't synced: run \"gauth sync init\" first")
+	}
+}
+
+// syncRemote returns the URL of the remote, or "" if there's none.
+func syncRemote() string {
+	out, err := gitOutput("remote", "get-url", "origin")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// syncCheckOffline exits if reaching the remote uses the network
+// in offline mode.
+func syncCheckOffline() {
+	syncRemoteOffline(syncRemote())
+}
+
+func syncRemoteOffline(remote string) {
+	local := strings.HasPrefix(remote, "/") || strings.HasPrefix(remote, "file://")
+	if offline() && !local {
+		log.Fatalf("syncing with %s uses the network, which offline mode forbids", remote)
+	}
+}
+
+// syncedRef is the commit whose keychain the local keychain was
+// last synced with.
+const syncedRef = "refs/gauth/synced"
+
+// syncCommit commits the keychain to the repository if it changed
+// since it was last synced.
+func syncCommit() {
+	data, err := ioutil.ReadFile(keychainPath())
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	head, _ := gitOutput("show", "HEAD:"+syncFile)
+	synced, err := gitOutput("show", syncedRef+":"+syncFile)
+	if err != nil {
+		synced = head
+	}
+	if synced != nil && bytes.Equal(synced, data) {
+		return
+	}
+	if !bytes.Equal(synced, head) {
+		log.Fatalf("the keychain changed both here and in %s since the last sync: reconcile them with git, then pull", syncDir())
+	}
+	if !isEncryptedKeychain(data) && !*syncForce {
+		log.Fatal(`the keychain isn't encrypted, and its secrets would stay in the history of the repository: encrypt it (see "gauth help encrypt"), or give -force`)
+	}
+	msg := syncMessage(head, data)
+	if err := writeKeychainFile(filepath.Join(syncDir(), syncFile), data); err != nil {
+		log.Fatal(err)
+	}
+	if err := git(nil, "add", syncFile); err != nil {
+		log.Fatal(err)
+	}
+	args := []string{"commit", "-q", "-m", msg}
+	if _, err := gitOutput("config", "user.email"); err != nil {
+		// git refuses to commit without an identity.
+		host, _ := os.Hostname()
+		args = append([]string{"-c", "user.name=gauth", "-c", "user.email=gauth@" + host}, args...)
+	}
+	if err := git(nil, args...); err != nil {
+		log.Fatal(err)
+	}
+	syncMark()
+}
+
+// syncMark records that the keychain is in sync with HEAD.
+func syncMark() {
+	if err := git(nil, "update-ref", syncedRef, "HEAD"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// syncMessage describes the change of the keychain from old to data
+// for a commit message. The keys of an encrypted keychain are counted
+// rather than named, which would give them away.
+func syncMessage(old, data []byte) string {
+	host, _ := os.Hostname()
+	from := ""
+	if host != "" {
+		from = "\n\nFrom " + host + "."
+	}
+	if old == nil {
+		return "Add keychain" + from
+	}
+	before := decryptAndParse("previous keychain", append([]byte(nil), old...))
+	after := decryptAndParse(keychainPath(), append([]byte(nil), data...))
+	var added, removed, updated []string
+	for name, k := range after.keys {
+		if o, ok := before.keys[name]; !ok {
+			added = append(added, name)
+		} else if before.lines[o.line] != after.lines[k.line] {
+			updated = append(updated, name)
+		}
+	}
+	for name := range before.keys {
+		if _, ok := after.keys[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	encrypted := isEncryptedKeychain(old) || isEncryptedKeychain(data)
+	var parts []string
+	for _, p := range []struct {
+		verb  string
+		names []string
+	}{{"add", added}, {"remove", removed}, {"update", updated}} {
+		switch {
+		case len(p.names) == 0:
+		case encrypted && len(p.names) == 1:
+			parts = append(parts, p.verb+" 1 key")
+		case encrypted:
+			parts = append(parts, fmt.Sprintf("%s %d keys", p.verb, len(p.names)))
+		default:
+			sort.Strings(p.names)
+			parts = append(parts, p.verb+" "+strings.Join(p.names, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return "Rewrite keychain" + from
+	}
+	msg := strings.Join(parts, "; ")
+	return strings.ToUpper(msg[:1]) + msg[1:] + from
+}
+
+// syncCheckout replaces the keychain with the repository's copy.
+func syncCheckout() {
+	data, err := ioutil.ReadFile(filepath.Join(syncDir(), syncFile))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cur, err := ioutil.ReadFile(keychainPath()); err == nil && bytes.Equal(cur, data) {
+		syncMark()
+		fmt.Fprintln(os.Stderr, "the keychain is up to date")
+		return
+	}
+	userKeychain().checkWritable()
+	// Reading it checks it decrypts and parses.
+	c := decryptAndParse(keychainPath(), append([]byte(nil), data...))
+	if err := backup(keychainPath(), nil); err != nil {
+		log.Fatal(backupError(err))
+	}
+	if err := writeKeychainFile(keychainPath(), data); err != nil {
+		log.Fatal(err)
+	}
+	c.updateIntegrity()
+	syncMark()
+	fmt.Fprintf(os.Stderr, "updated the keychain, %d keys\n", len(c.keys))
+}
+
+// git runs git in the repository. Its output goes to w if it isn't
+// nil, and makes up the error otherwise.
+func git(w *os.File, args ...string) error {
+	return runGit(exec.Command("git", append([]string{"-C", syncDir()}, args...)...), w)
+}
+
+func gitOutput(args ...string) ([]byte, error) {
+	return exec.Command("git", append([]string{"-C", syncDir()}, args...)...).Output()
+}
+
+// runGit runs a git command, with its output going to w if it isn't nil.
+func runGit(cmd *exec.Cmd, w *os.File) error {
+	var msgs bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &msgs, &msgs
+	if w != nil {
+		cmd.Stdout, cmd.Stderr = w, w
+	}
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrNotFound) {
+		return errors.New("git not found")
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(msgs.String()); msg != "" {
+			return fmt.Errorf("git: %s", msg)
+		}
+		return fmt.Errorf("git: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// credentialKeychain is the name of the systemd credential holding the
+// keychain, for services given it with
+//
+//	LoadCredentialEncrypted=gauth.keychain
+//
+// systemd decrypts the credential into the directory named by
+// $CREDENTIALS_DIRECTORY, which only the service can read.
+const credentialKeychain = "gauth.keychain"
+
+// readCredentialKeychain reads the keychain from the systemd credential,
+// if the service has one, and returns nil otherwise. Like the keychain
+// read with -stdin-keychain, it's kept in memory only.
+func readCredentialKeychain() *Keychain {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return nil
+	}
+	file := filepath.Join(dir, credentialKeychain)
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	lockMemory(data)
+	c := decryptAndParse(file, data)
+	c.memory = true
+	return c
+}
+
+// systemdProtector encrypts keys as systemd credentials with
+// systemd-creds, under the host key in /var/lib/systemd/credential.secret,
+// the TPM, or both:
+//
+//	encrypt = systemd-creds [auto|host|tpm2|host+tpm2]
+//
+// Run as another user than root, the credential is scoped to that user,
+// which needs systemd 256 or later.
+//
+// The wrapped key is
+//
+//	scope (1, 's' for the system, 'u' for the user) | credential
+//
+// the credential as systemd-creds encrypt writes it.
+type systemdProtector struct {
+	with string
+}
+
+// systemdCredentialKey is the name of the credentials made by
+// systemdProtector, which systemd-creds checks when decrypting them.
+const systemdCredentialKey = "gauth.key"
+
+func init() {
+	keyProtectors["systemd-creds"] = newSystemdProtector
+}
+
+func newSystemdProtector(args []string) (keyProtector, error) {
+	switch {
+	case len(args) == 0:
+		return systemdProtector{}, nil
+	case len(args) == 1:
+		switch args[0] {
+		case "auto", "host", "tpm2", "host+tpm2":
+			return systemdProtector{with: args[0]}, nil
+		}
+	}
+	return nil, errors.New("systemd-creds takes auto, host, tpm2 or host+tpm2")
+}
+
+func (p systemdProtector) wrap(key []byte) ([]byte, error) {
+	scope := byte('s')
+	if os.Getuid() != 0 {
+		scope = 'u'
+	}
+	args := []string{"encrypt", "--name=" + systemdCredentialKey}
+	if p.with != "" {
+		args = append(args, "--with-key="+p.with)
+	}
+	cred, err := systemdCreds(scope, key, append(args, "-", "-")...)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{scope}, cred...), nil
+}
+
+func (systemdProtector) unwrap(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 2 || (wrapped[0] != 's' && wrapped[0] != 'u') {
+		return nil, errors.New("invalid wrapped key")
+	}
+	return systemdCreds(wrapped[0], wrapped[1:], "decrypt", "--name="+systemdCredentialKey, "-", "-")
+}
+
+// systemdCreds runs systemd-creds in scope with stdin as its input,
+// and returns its output.
+func systemdCreds(scope byte, stdin []byte, args ...string) ([]byte, error) {
+	if scope == 'u' {
+		args = append([]string{"--user"}, args...)
+	}
+	cmd := exec.Command("systemd-creds", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("systemd-creds not found")
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("systemd-creds: %s", msg)
+		}
+		return nil, fmt.Errorf("systemd-creds: %v", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+var cmdTag = &command{
+	name:  "tag",
+	usage: "tag [-d] name [tag...]",
+	short: "tag keys",
+	long: `Tag adds the given tags to the named key, or with -d removes them.
+Without tags, it prints the tags of the key.
+
+Tags group keys, such as "work" or "backup": "gauth list -tag work"
+lists the keys tagged work and "gauth show -tag work" (or just
+"gauth -tag work") prints their codes.`,
+}
+
+var tagDelete = cmdTag.flags.Bool("d", false, "remove the tags instead of adding them")
+
+func init() {
+	cmdTag.run = runTag
+}
+
+func runTag(ctx context.Context, cmd *command, args []string) {
+	if len(args) == 0 || *tagDelete && len(args) == 1 {
+		cmd.usageExit()
+	}
+	name, tags := args[0], args[1:]
+	c := openKeychain()
+	k, ok := c.keys[name]
+	if !ok {
+		fatalf(exitNotFound, "no such key %q", name)
+	}
+	if len(tags) == 0 {
+		for _, t := range k.tags() {
+			fmt.Println(t)
+		}
+		return
+	}
+	for _, t := range tags {
+		if err := checkTag(t); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var list []string
+	c.update(func(c *Keychain) {
+		k, ok := c.keys[name]
+		if !ok {
+			fatalf(exitNotFound, "no such key %q", name)
+		}
+		have := make(map[string]bool)
+		for _, t := range k.tags() {
+			have[t] = true
+		}
+		for _, t := range tags {
+			have[t] = !*tagDelete
+		}
+		for t, ok := range have {
+			if ok {
+				list = append(list, t)
+			}
+		}
+		k.setTags(list)
+		c.lines[k.line] = formatKey(name, k, k.count)
+	})
+	audit("tag", name)
+	if len(list) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: no tags\n", name)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: tags %s\n", name, strings.Join(list, " "))
+}
+
+// checkTag reports whether t can be used as a tag.
+func checkTag(t string) error {
+	if t == "" || strings.ContainsAny(t, ", \t\n") {
+		return fmt.Errorf("invalid tag %q: tags can't be empty or contain commas or spaces", t)
+	}
+	return nil
+}
+
+// tags returns the tags of k, which are kept sorted
+// in its tags attribute, separated by commas.
+func (k Key) tags() []string {
+	if t := k.attr("tags"); t != "" {
+		return strings.Split(t, ",")
+	}
+	return nil
+}
+
+func (k *Key) setTags(tags []string) {
+	sort.Strings(tags)
+	k.set("tags", strings.Join(tags, ","))
+}
+
+// hasTag reports whether k is tagged t. All keys have the empty tag.
+func (k keyInfo) hasTag(t string) bool {
+	if t == "" {
+		return true
+	}
+	for _, kt := range k.tags {
+		if kt == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package main
+
+import "errors"
+
+func isTerminal(fd uintptr) bool { return false }
+
+func colorTerminal(fd uintptr) bool { return false }
+
+func noEcho(fd uintptr) (restore func(), err error) {
+	return nil, errors.New("hiding terminal input is not supported on this system")
+}
+
+func rawMode(fd, out uintptr) (restore func(), err error) {
+	return nil, errors.New("raw terminal input is not supported on this system")
+}
+
+func terminalSize(fd uintptr) (width, height int, err error) {
+	return 0, 0, errors.New("terminal sizes are not supported on this system")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	enableProcessedInput            = 0x1
+	enableLineInput                 = 0x2
+	enableEchoInput                 = 0x4
+	enableVirtualTerminalInput      = 0x200
+	enableVirtualTerminalProcessing = 0x4
+)
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+)
+
+func setConsoleMode(h syscall.Handle, mode uint32) error {
+	if r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// isTerminal reports whether fd is a console.
+func isTerminal(fd uintptr) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(fd), &mode) == nil
+}
+
+// colorTerminal reports whether fd is a console showing colors, which
+// it's made to understand escape sequences for, as on Windows 10.
+func colorTerminal(fd uintptr) bool {
+	h := syscall.Handle(fd)
+	var mode uint32
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	return mode&enableVirtualTerminalProcessing != 0 || setConsoleMode(h, mode|enableVirtualTerminalProcessing) == nil
+}
+
+// noEcho turns off echoing of the input of console fd.
+// The returned function restores the previous state.
+func noEcho(fd uintptr) (restore func(), err error) {
+	h := syscall.Handle(fd)
+	var mode uint32
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(h, mode&^enableEchoInput); err != nil {
+		return nil, err
+	}
+	return func() { setConsoleMode(h, mode) }, nil
+}
+
+// rawMode makes console fd read keys one by one as typed, without echo,
+// as escape sequences, and console out understand escape sequences.
+// The returned function restores the previous state.
+func rawMode(fd, out uintptr) (restore func(), err error) {
+	in, o := syscall.Handle(fd), syscall.Handle(out)
+	var inMode, outMode uint32
+	if err := syscall.GetConsoleMode(in, &inMode); err != nil {
+		return nil, err
+	}
+	if err := syscall.GetConsoleMode(o, &outMode); err != nil {
+		return nil, err
+	}
+	raw := inMode&^(enableEchoInput|enableLineInput|enableProcessedInput) | enableVirtualTerminalInput
+	if err := setConsoleMode(in, raw); err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(o, outMode|enableVirtualTerminalProcessing); err != nil {
+		setConsoleMode(in, inMode)
+		return nil, err
+	}
+	return func() {
+		setConsoleMode(in, inMode)
+		setConsoleMode(o, outMode)
+	}, nil
+}
+
+// terminalSize returns the width and height of the window of console fd.
+func terminalSize(fd uintptr) (width, height int, err error) {
+	var info struct {
+		size, cursor             [2]int16
+		attributes               uint16
+		left, top, right, bottom int16
+		maxSize                  [2]int16
+	}
+	if r, _, err := procGetConsoleScreenBufferInfo.Call(fd, uintptr(unsafe.Pointer(&info))); r == 0 {
+		return 0, 0, err
+	}
+	return int(info.right-info.left) + 1, int(info.bottom-info.top) + 1, nil
+}
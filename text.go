@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// wide lists the East Asian Wide and Fullwidth ranges,
+// which take two terminal columns.
+var wide = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x1100, 0x115f, 1},
+		{0x2329, 0x232a, 1},
+		{0x2e80, 0x303e, 1},
+		{0x3041, 0x33ff, 1},
+		{0x3400, 0x4dbf, 1},
+		{0x4e00, 0x9fff, 1},
+		{0xa000, 0xa4cf, 1},
+		{0xa960, 0xa97f, 1},
+		{0xac00, 0xd7a3, 1},
+		{0xf900, 0xfaff, 1},
+		{0xfe10, 0xfe19, 1},
+		{0xfe30, 0xfe6f, 1},
+		{0xff00, 0xff60, 1},
+		{0xffe0, 0xffe6, 1},
+	},
+	R32: []unicode.Range32{
+		{0x1f300, 0x1f64f, 1},
+		{0x1f900, 0x1f9ff, 1},
+		{0x20000, 0x2fffd, 1},
+		{0x30000, 0x3fffd, 1},
+	},
+}
+
+// displayWidth returns the number of terminal columns s occupies.
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		switch {
+		case r == 0x200d || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf, unicode.Cc):
+			// combining marks, joiners and bidi controls take no space
+		case unicode.Is(wide, r):
+			n += 2
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// padRight pads s with spaces to the display width w.
+func padRight(s string, w int) string {
+	if n := displayWidth(s); n < w {
+		return s + strings.Repeat(" ", w-n)
+	}
+	return s
+}
+
+// isRTL reports whether s contains right-to-left letters.
+func isRTL(s string) bool {
+	for _, r := range s {
+		if unicode.In(r, unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Nko) {
+			return true
+		}
+	}
+	return false
+}
+
+// isolate wraps right-to-left text in Unicode bidi isolates (FSI ... PDI),
+// so it can't reorder the codes and columns printed around it.
+func isolate(s string) string {
+	if !isRTL(s) {
+		return s
+	}
+	return "\u2068" + s + "\u2069"
+}
+
+// lang returns the language of the user's locale, such as "en" or "ru".
+func lang() string {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		if i := strings.IndexAny(v, "_.@"); i >= 0 {
+			v = v[:i]
+		}
+		if v == "C" || v == "POSIX" {
+			return "en"
+		}
+		return strings.ToLower(v)
+	}
+	return "en"
+}
+
+// localDigits rewrites the ASCII digits of s in the native digits of
+// languages which use them. It's used for prose only, never for codes.
+func localDigits(l, s string) string {
+	var zero rune
+	switch l {
+	case "ar":
+		zero = '٠'
+	case "fa":
+		zero = '۰'
+	default:
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if '0' <= r && r <= '9' {
+			return zero + r - '0'
+		}
+		return r
+	}, s)
+}
+
+// slavicPlural picks the Russian (and alike) plural form for n.
+func slavicPlural(n int, one, few, many string) string {
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return one
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 10 || n%100 >= 20):
+		return few
+	}
+	return many
+}
+
+// remaining phrases the remaining validity of a code in the user's language.
+func remaining(secs int) string {
+	l := lang()
+	n := localDigits(l, fmt.Sprint(secs))
+	switch l {
+	case "ru":
+		return fmt.Sprintf("действителен ещё %s %s", n, slavicPlural(secs, "секунду", "секунды", "секунд"))
+	case "uk":
+		return fmt.Sprintf("дійсний ще %s %s", n, slavicPlural(secs, "секунду", "секунди", "секунд"))
+	case "kk":
+		return fmt.Sprintf("тағы %s секунд жарамды", n)
+	case "de":
+		return fmt.Sprintf("noch %s %s gültig", n, map[bool]string{true: "Sekunde", false: "Sekunden"}[secs == 1])
+	case "fr":
+		return fmt.Sprintf("valide encore %s %s", n, map[bool]string{true: "seconde", false: "secondes"}[secs <= 1])
+	case "es":
+		return fmt.Sprintf("válido durante %s %s más", n, map[bool]string{true: "segundo", false: "segundos"}[secs == 1])
+	case "ja":
+		return fmt.Sprintf("残り%s秒", n)
+	case "zh":
+		return fmt.Sprintf("剩余%s秒", n)
+	case "ko":
+		return fmt.Sprintf("%s초 남음", n)
+	case "ar":
+		return isolate(fmt.Sprintf("صالح لمدة %s ثانية", n))
+	case "fa":
+		return isolate(fmt.Sprintf("%s ثانیه باقی مانده", n))
+	case "he":
+		return isolate(fmt.Sprintf("בתוקף עוד %s שניות", n))
+	}
+	return fmt.Sprintf("valid for %s more %s", n, map[bool]string{true: "second", false: "seconds"}[secs == 1])
+}
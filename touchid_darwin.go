@@ -0,0 +1,203 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework Foundation -framework Security -framework LocalAuthentication
+
+#import <Foundation/Foundation.h>
+#import <LocalAuthentication/LocalAuthentication.h>
+#import <Security/Security.h>
+#include <stdlib.h>
+#include <string.h>
+
+#define seAlgorithm kSecKeyAlgorithmECIESEncryptionCofactorVariableIVX963SHA256AESGCM
+
+static char *errorString(CFErrorRef e) {
+	NSError *err = (__bridge_transfer NSError *)e;
+	if (err == nil) {
+		return strdup("unknown error");
+	}
+	return strdup(err.localizedDescription.UTF8String);
+}
+
+static char *statusString(OSStatus st) {
+	NSString *msg = (__bridge_transfer NSString *)SecCopyErrorMessageString(st, NULL);
+	if (msg == nil) {
+		msg = [NSString stringWithFormat:@"error %d", (int)st];
+	}
+	return strdup(msg.UTF8String);
+}
+
+static NSData *keyTag(void) {
+	return [@"com.github.moldabekov.gauth.keychain" dataUsingEncoding:NSUTF8StringEncoding];
+}
+
+// sePrivateKey returns the Secure Enclave key of gauth, creating it if
+// create is set and there's none. Using it asks for Touch ID, with the
+// reason given by ctx.
+static SecKeyRef sePrivateKey(int create, LAContext *ctx, char **err) {
+	NSMutableDictionary *query = [@{
+		(id)kSecClass: (id)kSecClassKey,
+		(id)kSecAttrApplicationTag: keyTag(),
+		(id)kSecAttrKeyType: (id)kSecAttrKeyTypeECSECPrimeRandom,
+		(id)kSecReturnRef: @YES,
+	} mutableCopy];
+	if (ctx != nil) {
+		query[(id)kSecUseAuthenticationContext] = ctx;
+	}
+	CFTypeRef key = NULL;
+	OSStatus st = SecItemCopyMatching((__bridge CFDictionaryRef)query, &key);
+	if (st == errSecSuccess) {
+		return (SecKeyRef)key;
+	}
+	if (st != errSecItemNotFound || !create) {
+		*err = statusString(st);
+		return NULL;
+	}
+
+	CFErrorRef e = NULL;
+	SecAccessControlRef access = SecAccessControlCreateWithFlags(kCFAllocatorDefault,
+		kSecAttrAccessibleWhenUnlockedThisDeviceOnly,
+		kSecAccessControlPrivateKeyUsage | kSecAccessControlBiometryAny, &e);
+	if (access == NULL) {
+		*err = errorString(e);
+		return NULL;
+	}
+	NSDictionary *attrs = @{
+		(id)kSecAttrKeyType: (id)kSecAttrKeyTypeECSECPrimeRandom,
+		(id)kSecAttrKeySizeInBits: @256,
+		(id)kSecAttrTokenID: (id)kSecAttrTokenIDSecureEnclave,
+		(id)kSecPrivateKeyAttrs: @{
+			(id)kSecAttrIsPermanent: @YES,
+			(id)kSecAttrApplicationTag: keyTag(),
+			(id)kSecAttrAccessControl: (__bridge_transfer id)access,
+		},
+	};
+	SecKeyRef priv = SecKeyCreateRandomKey((__bridge CFDictionaryRef)attrs, &e);
+	if (priv == NULL) {
+		*err = errorString(e);
+	}
+	return priv;
+}
+
+static void *copyData(CFDataRef d, int *n) {
+	*n = (int)CFDataGetLength(d);
+	void *out = malloc(*n);
+	memcpy(out, CFDataGetBytePtr(d), *n);
+	return out;
+}
+
+// seWrap encrypts key to the public half of the Secure Enclave key,
+// which needs no Touch ID.
+static void *seWrap(const void *key, int n, int *outLen, char **err) {
+	@autoreleasepool {
+		SecKeyRef priv = sePrivateKey(1, nil, err);
+		if (priv == NULL) {
+			return NULL;
+		}
+		SecKeyRef pub = SecKeyCopyPublicKey(priv);
+		CFRelease(priv);
+		if (pub == NULL) {
+			*err = strdup("the Secure Enclave key has no public key");
+			return NULL;
+		}
+		CFErrorRef e = NULL;
+		NSData *plain = [NSData dataWithBytesNoCopy:(void *)key length:n freeWhenDone:NO];
+		CFDataRef wrapped = SecKeyCreateEncryptedData(pub, seAlgorithm, (__bridge CFDataRef)plain, &e);
+		CFRelease(pub);
+		if (wrapped == NULL) {
+			*err = errorString(e);
+			return NULL;
+		}
+		void *out = copyData(wrapped, outLen);
+		CFRelease(wrapped);
+		return out;
+	}
+}
+
+// seUnwrap decrypts wrapped with the Secure Enclave key,
+// once Touch ID confirms it's wanted for reason.
+static void *seUnwrap(const void *wrapped, int n, const char *reason, int *outLen, char **err) {
+	@autoreleasepool {
+		LAContext *ctx = [[LAContext alloc] init];
+		ctx.localizedReason = [NSString stringWithUTF8String:reason];
+		SecKeyRef priv = sePrivateKey(0, ctx, err);
+		if (priv == NULL) {
+			return NULL;
+		}
+		CFErrorRef e = NULL;
+		NSData *data = [NSData dataWithBytesNoCopy:(void *)wrapped length:n freeWhenDone:NO];
+		CFDataRef key = SecKeyCreateDecryptedData(priv, seAlgorithm, (__bridge CFDataRef)data, &e);
+		CFRelease(priv);
+		if (key == NULL) {
+			*err = errorString(e);
+			return NULL;
+		}
+		void *out = copyData(key, outLen);
+		CFRelease(key);
+		return out;
+	}
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// touchIDProtector wraps keys to a P-256 key kept in the Secure Enclave,
+// which only decrypts them once Touch ID confirms the fingerprint of
+// one of the users enrolled. The Secure Enclave key is created on first
+// use and stays in the login keychain; keeping it there needs gauth to
+// be signed with a keychain-access-groups entitlement.
+//
+// The wrapped key is the ECIES ciphertext of the key, with the cofactor
+// variant of X9.63 key derivation and AES-GCM.
+type touchIDProtector struct{}
+
+func init() {
+	keyProtectors["touchid"] = newTouchIDProtector
+}
+
+func newTouchIDProtector(args []string) (keyProtector, error) {
+	if len(args) != 0 {
+		return nil, errors.New("touchid takes no arguments")
+	}
+	return touchIDProtector{}, nil
+}
+
+func (touchIDProtector) wrap(key []byte) ([]byte, error) {
+	var n C.int
+	var cerr *C.char
+	out := C.seWrap(unsafe.Pointer(&key[0]), C.int(len(key)), &n, &cerr)
+	return secureEnclaveResult(out, n, cerr)
+}
+
+func (touchIDProtector) unwrap(wrapped []byte) ([]byte, error) {
+	if len(wrapped) == 0 {
+		return nil, errors.New("invalid wrapped key")
+	}
+	reason := C.CString("decrypt the gauth keychain")
+	defer C.free(unsafe.Pointer(reason))
+	var n C.int
+	var cerr *C.char
+	out := C.seUnwrap(unsafe.Pointer(&wrapped[0]), C.int(len(wrapped)), reason, &n, &cerr)
+	return secureEnclaveResult(out, n, cerr)
+}
+
+// secureEnclaveResult copies the n bytes of out and wipes and frees
+// them, or returns the error message cerr.
+func secureEnclaveResult(out unsafe.Pointer, n C.int, cerr *C.char) ([]byte, error) {
+	if out == nil {
+		defer C.free(unsafe.Pointer(cerr))
+		return nil, errors.New(C.GoString(cerr))
+	}
+	b := C.GoBytes(out, n)
+	C.memset(out, 0, C.size_t(n))
+	C.free(out)
+	return b, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tpmProtector seals keys to the TPM with tpm2-tools, under an ECC
+// primary key of the owner hierarchy, which the TPM derives anew from
+// its seed each time. With PCRs, a key unseals only while they hold the
+// values they had when it was sealed. tpm2-tools reach the TPM through
+// /dev/tpmrm0, or the TCTI named by $TPM2TOOLS_TCTI, such as tbs on
+// Windows.
+//
+// The wrapped key is
+//
+//	PCR selection length (1) | PCR selection | public length (2) | public |
+//	private length (2) | private
+//
+// the sealed object as tpm2_create writes it.
+type tpmProtector struct {
+	pcrs string // PCR selection, such as sha256:0,7, or ""
+}
+
+func newTPMProtector(args []string) (keyProtector, error) {
+	switch len(args) {
+	case 0:
+		return tpmProtector{}, nil
+	case 1:
+		for _, f := range strings.Split(args[0], ",") {
+			if n, err := strconv.Atoi(f); err != nil || n < 0 || n > 23 {
+				return nil, fmt.Errorf("invalid PCR %q", f)
+			}
+		}
+		return tpmProtector{pcrs: "sha256:" + args[0]}, nil
+	}
+	return nil, errors.New("tpm takes a list of PCRs, such as 0,7")
+}
+
+func (p tpmProtector) wrap(key []byte) ([]byte, error) {
+	dir, err := tpmPrimary()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	args := []string{"create", "-Q", "-C", "primary.ctx", "-i", "-", "-u", "seal.pub", "-r", "seal.priv"}
+	if p.pcrs != "" {
+		if _, err := tpm2(dir, nil, "createpolicy", "-Q", "--policy-pcr", "-l", p.pcrs, "-L", "policy.dat"); err != nil {
+			return nil, err
+		}
+		// Only the policy unseals the key, not an empty password.
+		args = append(args, "-L", "policy.dat", "-a", "fixedtpm|fixedparent")
+	}
+	if _, err := tpm2(dir, key, args...); err != nil {
+		return nil, err
+	}
+	pub, err := ioutil.ReadFile(filepath.Join(dir, "seal.pub"))
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ioutil.ReadFile(filepath.Join(dir, "seal.priv"))
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteByte(byte(len(p.pcrs)))
+	b.WriteString(p.pcrs)
+	for _, part := range [][]byte{pub, priv} {
+		binary.Write(&b, binary.BigEndian, uint16(len(part)))
+		b.Write(part)
+	}
+	return b.Bytes(), nil
+}
+
+func (tpmProtector) unwrap(wrapped []byte) ([]byte, error) {
+	invalid := errors.New("invalid sealed key")
+	if len(wrapped) < 1 || len(wrapped) < 1+int(wrapped[0]) {
+		return nil, invalid
+	}
+	pcrs := string(wrapped[1 : 1+wrapped[0]])
+	rest := wrapped[1+wrapped[0]:]
+	var parts [][]byte
+	for i := 0; i < 2; i++ {
+		if len(rest) < 2 {
+			return nil, invalid
+		}
+		n := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+n {
+			return nil, invalid
+		}
+		parts = append(parts, rest[2:2+n])
+		rest = rest[2+n:]
+	}
+
+	dir, err := tpmPrimary()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "seal.pub"), parts[0], 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "seal.priv"), parts[1], 0600); err != nil {
+		return nil, err
+	}
+	if _, err := tpm2(dir, nil, "load", "-Q", "-C", "primary.ctx", "-u", "seal.pub", "-r", "seal.priv", "-c", "seal.ctx"); err != nil {
+		return nil, err
+	}
+	args := []string{"unseal", "-Q", "-c", "seal.ctx"}
+	if pcrs != "" {
+		args = append(args, "-p", "pcr:"+pcrs)
+	}
+	key, err := tpm2(dir, nil, args...)
+	if err != nil {
+		if pcrs != "" {
+			return nil, fmt.Errorf("%v (did PCRs %s change?)", err, pcrs)
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// tpmPrimary creates the primary key in the context file primary.ctx
+// of a new temporary directory.
+func tpmPrimary() (string, error) {
+	if _, err := exec.LookPath("tpm2_createprimary"); err != nil {
+		return "", errors.New("tpm2-tools aren't installed")
+	}
+	dir, err := ioutil.TempDir("", "gauth-tpm")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tpm2(dir, nil, "createprimary", "-Q", "-C", "o", "-G", "ecc", "-c", "primary.ctx"); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// tpm2 runs tpm2-tools command tpm2_args[0] in dir, with stdin as its
+// input, and returns its output.
+func tpm2(dir string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("tpm2_"+args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("tpm2_%s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("tpm2_%s: %v", args[0], err)
+	}
+	return out, nil
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var cmdTUI = &command{
+	name:  "tui",
+	usage: "tui",
+	short: "browse keys and copy codes full-screen",
+	long: `Tui shows the keys of the keychain full-screen, with their current
+codes and a bar counting down the seconds each stays valid, updated
+live. The keys are:
+
+	Up, Down, k, j   move; PgUp, PgDn, Home and End too
+	/                search: typing narrows the list to the keys whose
+	                 name, issuer or account match; Enter keeps the
+	                 search, Esc clears it
+	Enter            copy the code of the key, cleared from the
+	                 clipboard after 30 seconds or clip-timeout
+	a                add a key, asking for its name and secret, or an
+	                 otpauth:// URI
+	e                edit the issuer, account, login page and note
+	d                delete the key, after asking
+	q, Ctrl-C        quit
+
+HOTP keys show dashes until Enter generates their next code, which
+advances their counter. Archived keys aren't shown. Tui works on the
+keychain file; keys of other backends aren't listed.`,
+}
+
+func init() {
+	cmdTUI.run = runTUI
+	cmdTUI.programs = always
+}
+
+// A tui is the state of the full-screen view.
+type tui struct {
+	c      *Keychain
+	keys   []keyInfo // listed, in order
+	sel    int
+	top    int
+	query  string
+	search bool   // typing the query
+	status string // shown until the next key
+	// hotp holds the codes generated for HOTP keys.
+	hotp map[string]string
+	// audited holds when the time step of the last code of each key
+	// recorded in the audit log ends.
+	audited map[string]int64
+
+	input   chan []byte
+	pending []string
+	tick    *time.Ticker
+	sig     chan os.Signal
+
+	active  bool
+	restore func()
+}
+
+func runTUI(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 0 {
+		cmd.usageExit()
+	}
+	if !isTerminal(os.Stdin.Fd()) || !isTerminal(os.Stdout.Fd()) {
+		log.Fatal("tui needs a terminal")
+	}
+	t := &tui{
+		c:       openKeychain(),
+		hotp:    make(map[string]string),
+		audited: make(map[string]int64),
+		input:   make(chan []byte),
+		tick:    time.NewTicker(time.Second),
+		sig:     make(chan os.Signal, 1),
+	}
+	if err := t.enter(); err != nil {
+		log.Fatal(err)
+	}
+	// Messages, fatal ones included, are written on the terminal as
+	// it was, not lost in the full screen.
+	log.SetOutput(tuiLog{t})
+	defer t.leave()
+	signal.Notify(t.sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for {
+			b := make([]byte, 256)
+			n, err := os.Stdin.Read(b)
+			if err != nil {
+				close(t.input)
+				return
+			}
+			t.input <- b[:n]
+		}
+	}()
+	t.load()
+	t.run()
+}
+
+// enter switches the terminal to the full screen, in raw mode.
+func (t *tui) enter() error {
+	restore, err := rawMode(os.Stdin.Fd(), os.Stdout.Fd())
+	if err != nil {
+		return fmt.Errorf("tui: %v", err)
+	}
+	t.restore, t.active = restore, true
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	return nil
+}
+
+// leave restores the terminal.
+func (t *tui) leave() {
+	if !t.active {
+		return
+	}
+	fmt.Print("\x1b[?25h\x1b[?1049l")
+	t.restore()
+	t.active = false
+}
+
+type tuiLog struct{ t *tui }
+
+func (l tuiLog) Write(p []byte) (int, error) {
+	l.t.leave()
+	// A warning: the next draw goes back to the full screen.
+	l.t.status = strings.TrimPrefix(strings.TrimSpace(string(p)), "gauth: ")
+	return os.Stderr.Write(p)
+}
+
+// load lists the keys matching the query.
+func (t *tui) load() {
+	t.keys = t.keys[:0]
+	for _, k := range t.c.keyInfos() {
+		if !k.archived && k.matches(t.query) {
+			t.keys = append(t.keys, k)
+		}
+	}
+	sortKeys(t.keys, "")
+	if t.sel >= len(t.keys) {
+		t.sel = len(t.keys) - 1
+	}
+	if t.sel < 0 {
+		t.sel = 0
+	}
+}
+
+func (t *tui) selected() (keyInfo, bool) {
+	if t.sel < len(t.keys) {
+		return t.keys[t.sel], true
+	}
+	return keyInfo{}, false
+}
+
+// nextKey waits for a key, returning "" when it's time to draw again.
+// Keys are single characters, or names such as "up" and "enter".
+func (t *tui) nextKey() string {
+	for len(t.pending) == 0 {
+		select {
+		case <-t.tick.C:
+			return ""
+		case <-t.sig:
+			return "quit"
+		case b, ok := <-t.input:
+			if !ok {
+				return "quit"
+			}
+			t.pending = parseKeys(b)
+		}
+	}
+	key := t.pending[0]
+	t.pending = t.pending[1:]
+	return key
+}
+
+// csiKeys map the escape sequences of terminals to keys.
+var csiKeys = map[string]string{
+	"A": "up", "B": "down", "C": "right", "D": "left",
+	"H": "home", "F": "end", "1~": "home", "4~": "end", "7~": "home", "8~": "end",
+	"5~": "pgup", "6~": "pgdn", "3~": "delete",
+}
+
+// parseKeys splits the input read from the terminal into keys.
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch c := b[0]; {
+		case c == 0x1b && len(b) > 2 && (b[1] == '[' || b[1] == 'O'):
+			i := 2
+			for i < len(b) && (b[i] < 0x40 || b[i] > 0x7e) {
+				i++
+			}
+			if i == len(b) {
+				return keys
+			}
+			if k, ok := csiKeys[string(b[2:i+1])]; ok {
+				keys = append(keys, k)
+			}
+			b = b[i+1:]
+			continue
+		case c == 0x1b:
+			keys = append(keys, "esc")
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c == 0x03:
+			keys = append(keys, "quit")
+		case c == 0x0e:
+			keys = append(keys, "down")
+		case c == 0x10:
+			keys = append(keys, "up")
+		case c == 0x15:
+			keys = append(keys, "ctrl-u")
+		case c >= 0x20:
+			r, n := utf8.DecodeRune(b)
+			keys = append(keys, string(r))
+			b = b[n:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+func (t *tui) run() {
+	for {
+		t.draw("")
+		key := t.nextKey()
+		if key != "" {
+			t.status = ""
+		}
+		if t.search {
+			switch key {
+			case "enter":
+				t.search = false
+				continue
+			case "esc":
+				t.search, t.query = false, ""
+				t.load()
+				continue
+			case "backspace":
+				if t.query != "" {
+					_, n := utf8.DecodeLastRuneInString(t.query)
+					t.query = t.query[:len(t.query)-n]
+					t.load()
+				}
+				continue
+			case "ctrl-u":
+				t.query = ""
+				t.load()
+				continue
+			}
+			if utf8.RuneCountInString(key) == 1 {
+				t.query += key
+				t.sel = 0
+				t.load()
+				continue
+			}
+		}
+		_, _, rows := t.layout()
+		switch key {
+		case "q", "quit":
+			return
+		case "up", "k":
+			t.sel--
+		case "down", "j":
+			t.sel++
+		case "pgup":
+			t.sel -= rows
+		case "pgdn":
+			t.sel += rows
+		case "home":
+			t.sel = 0
+		case "end":
+			t.sel = len(t.keys) - 1
+		case "/":
+			t.search = true
+		case "esc":
+			t.query = ""
+			t.load()
+		case "enter":
+			t.copyCode()
+		case "a":
+			t.add()
+		case "e":
+			t.edit()
+		case "d", "delete":
+			t.remove()
+		}
+		if t.sel >= len(t.keys) {
+			t.sel = len(t.keys) - 1
+		}
+		if t.sel < 0 {
+			t.sel = 0
+		}
+	}
+}
+
+// layout returns the size of the terminal and the number of rows for
+// keys, between the title and the footer.
+func (t *tui) layout() (width, height, rows int) {
+	width, height, err := terminalSize(os.Stdout.Fd())
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	rows = height - 2
+	if rows < 1 {
+		rows = 1
+	}
+	return width, height, rows
+}
+
+// draw draws the screen, with footer as its last line if it's not "".
+// A footer means a question, which shows the cursor after it.
+func (t *tui) draw(footer string) {
+	if !t.active {
+		if err := t.enter(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	width, _, rows := t.layout()
+	if t.sel < t.top {
+		t.top = t.sel
+	}
+	if t.sel >= t.top+rows {
+		t.top = t.sel - rows + 1
+	}
+	if t.top > 0 && t.top > len(t.keys)-rows {
+		t.top = len(t.keys) - rows
+		if t.top < 0 {
+			t.top = 0
+		}
+	}
+	var b strings.Builder
+	b.WriteString("\x1b[?25l\x1b[H")
+	title := fmt.Sprintf("gauth  %s  %d keys", t.c.file, len(t.keys))
+	b.WriteString("\x1b[7m" + fit(title, width) + "\x1b[0m\r\n")
+
+	nameWidth := 0
+	for _, k := range t.keys {
+		if w := displayWidth(k.name); w > nameWidth {
+			nameWidth = w
+		}
+	}
+	// The code, bar and seconds take 24 columns.
+	if max := width - 26; nameWidth > max {
+		nameWidth = max
+	}
+	if nameWidth < 8 {
+		nameWidth = 8
+	}
+	now := time.Now()
+	for i := t.top; i < t.top+rows; i++ {
+		if i >= len(t.keys) {
+			b.WriteString("\x1b[K\r\n")
+			continue
+		}
+		line := fit(t.row(t.keys[i], nameWidth, now), width)
+		if i == t.sel {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		b.WriteString(line + "\r\n")
+	}
+	if len(t.keys) == 0 && rows > 0 {
+		msg := "no keys: press a to add one"
+		if t.query != "" {
+			msg = "no keys match " + t.query
+		}
+		// Over the first row.
+		fmt.Fprintf(&b, "\x1b[2;1H%s\x1b[K\x1b[%d;1H", fit(msg, width), rows+2)
+	}
+	switch {
+	case footer != "":
+		b.WriteString(truncate(footer, width-1) + "\x1b[K\x1b[?25h")
+	case t.search:
+		b.WriteString(truncate("/"+t.query, width-1) + "\x1b[K\x1b[?25h")
+	case t.status != "":
+		b.WriteString(fit(t.status, width))
+	case t.query != "":
+		b.WriteString(fit("/"+t.query+"   esc clear  enter copy  a add  e edit  d delete  q quit", width))
+	default:
+		b.WriteString(fit("/ search  enter copy  a add  e edit  d delete  q quit", width))
+	}
+	fmt.Print(b.String())
+}
+
+// row renders the line of key k.
+func (t *tui) row(k keyInfo, nameWidth int, now time.Time) string {
+	name := padRight(truncate(isolate(k.name), nameWidth), nameWidth)
+	if k.hotp {
+		code, ok := t.hotp[k.name]
+		if !ok {
+			code = strings.Repeat("-", k.digits)
+		}
+		return fmt.Sprintf(" %s  %-9s  %-10s  HOTP", name, groupCode(code), "")
+	}
+	if k.protected {
+		return fmt.Sprintf(" %s  %-9s  %-10s  protected", name, groupCode(strings.Repeat("-", k.digits)), "")
+	}
+	period := int64(k.period)
+	if period <= 0 {
+		period = 30
+	}
+	end := k.stepEnd(now).Unix()
+	code := t.c.code(k.name)
+	if t.audited[k.name] != end {
+		audit("code", k.name)
+		t.audited[k.name] = end
+	}
+	left := end - now.Unix()
+	full := int(left * 10 / period)
+	bar := strings.Repeat("█", full) + strings.Repeat("░", 10-full)
+	return fmt.Sprintf(" %s  %-9s  %s  %2ds", name, groupCode(code), bar, left)
+}
+
+// groupCode splits a code in two halves, as phone apps show them.
+func groupCode(code string) string {
+	if len(code) < 6 {
+		return code
+	}
+	return code[:len(code)/2] + " " + code[len(code)/2:]
+}
+
+// truncate cuts s to w columns.
+func truncate(s string, w int) string {
+	if displayWidth(s) <= w {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && displayWidth(string(r))+1 > w {
+		r = r[:len(r)-1]
+	}
+	return string(r) + "…"
+}
+
+// fit cuts or pads s to w columns, clearing the rest of the line.
+func fit(s string, w int) string {
+	return padRight(truncate(s, w), w) + "\x1b[K"
+}
+
+// prompt asks for a line of text in the footer, starting with text.
+// Hidden text, such as a secret, is shown as stars. It returns false
+// if the question was cancelled with Esc.
+func (t *tui) prompt(question, text string, hidden bool) (string, bool) {
+	for {
+		shown := text
+		if hidden {
+			shown = strings.Repeat("*", utf8.RuneCountInString(text))
+		}
+		t.draw(question + shown)
+		switch key := t.nextKey(); key {
+		case "":
+		case "enter":
+			return text, true
+		case "esc", "quit":
+			return "", false
+		case "backspace":
+			_, n := utf8.DecodeLastRuneInString(text)
+			text = text[:len(text)-n]
+		case "ctrl-u":
+			text = ""
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				text += key
+			}
+		}
+	}
+}
+
+// ask asks a yes or no question in the footer.
+func (t *tui) ask(question string) bool {
+	for {
+		t.draw(question + " [y/N] ")
+		switch key := t.nextKey(); key {
+		case "":
+		case "y", "Y":
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// writable reports whether the keychain can be written, saying why
+// not in the status line.
+func (t *tui) writable() bool {
+	if why := t.c.readOnlyReason(); why != "" {
+		t.status = "the keychain is read-only: " + why
+		return false
+	}
+	return true
+}
+
+func (t *tui) copyCode() {
+	k, ok := t.selected()
+	if !ok {
+		return
+	}
+	if k.ocra {
+		t.status = k.name + " is an OCRA key: use gauth ocra"
+		return
+	}
+	if k.protected {
+		t.status = k.name + " has a passphrase of its own: use gauth show " + k.name
+		return
+	}
+	if k.hotp && !t.writable() {
+		return
+	}
+	code := t.c.code(k.name)
+	audit("code", k.name)
+	recordUse(k.name)
+	if k.hotp {
+		t.hotp[k.name] = code
+	}
+	afterCode(k.name, code, codeExpires(k))
+	after := clipTimeout()
+	if err := copyCode(code, after); err != nil {
+		t.status = "copying code: " + err.Error()
+		return
+	}
+	t.status = fmt.Sprintf("copied the code of %s, cleared in %v", k.name, after)
+	if notifying(false) {
+		notifyCopied(k.name, codeExpires(k))
+	}
+}
+
+func (t *tui) add() {
+	if !t.writable() {
+		return
+	}
+	name, ok := t.prompt("name: ", "", false)
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return
+	}
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		t.status = "spaces aren't allowed"
+		return
+	}
+	if err := checkCommandName(name); err != nil {
+		t.status = err.Error()
+		return
+	}
+	if _, exists := t.c.keys[name]; exists {
+		t.status = fmt.Sprintf("key %q already exists", name)
+		return
+	}
+	text, ok := t.prompt("secret or otpauth:// URI for "+name+": ", "", true)
+	if !ok || strings.TrimSpace(text) == "" {
+		return
+	}
+	k, counter, err := parseSecret(text)
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	if have, ok := t.c.findSecret(k.raw); ok {
+		t.status = have + " has the same secret"
+		return
+	}
+	t.c.lines = append(t.c.lines, formatKey(name, k, counter))
+	t.c.save()
+	audit("add", name)
+	t.load()
+	for i, k := range t.keys {
+		if k.name == name {
+			t.sel = i
+		}
+	}
+	t.status = "added " + name
+}
+
+func (t *tui) edit() {
+	info, ok := t.selected()
+	if !ok || !t.writable() {
+		return
+	}
+	k := t.c.keys[info.name]
+	changed := false
+	for _, attr := range []string{"issuer", "account", "url", "note"} {
+		value, ok := t.prompt(attr+": ", k.attr(attr), false)
+		if !ok {
+			return
+		}
+		value = strings.TrimSpace(value)
+		if attr == "url" && value != "" {
+			if err := checkURL(value); err != nil {
+				t.status = err.Error()
+				return
+			}
+		}
+		if value != k.attr(attr) {
+			k.set(attr, value)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	t.c.lines[k.line] = formatKey(info.name, k, k.count)
+	t.c.save()
+	audit("edit", info.name)
+	t.load()
+	t.status = "edited " + info.name
+}
+
+func (t *tui) remove() {
+	k, ok := t.selected()
+	if !ok || !t.writable() {
+		return
+	}
+	if !t.ask("delete " + k.name + "? Its secret can't be recovered.") {
+		return
+	}
+	t.c.remove(k.name)
+	t.c.save()
+	audit("remove", k.name)
+	delete(t.hotp, k.name)
+	t.load()
+	t.status = "deleted " + k.name
+}
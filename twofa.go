@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/base32"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The keychain of rsc/2fa, $HOME/.2fa, which gauth's format extends,
+// has lines
+//
+//	name digits secret [counter]
+//
+// with no attributes, and the counters of HOTP keys padded with zeros
+// to 20 digits. Importing it as format 2fa checks each line strictly
+// rather than as a gauth keychain, reporting every line skipped and
+// why, and repairing what can be: counters padded to another length,
+// carriage returns and runs of spaces left by editing the file by hand.
+
+func init() {
+	importers["2fa"] = importTwoFA
+}
+
+// importTwoFA reads the keychain of rsc/2fa.
+func importTwoFA(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(data)
+	var entries []entry
+	seen := make(map[string]int) // index in entries of each name
+	skipped, fixed := 0, 0
+	for i, line := range strings.Split(string(data), "\n") {
+		lineno := i + 1
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		e, fixes, err := parseTwoFALine(line)
+		if err != nil {
+			log.Printf("%s:%d: skipped: %v", file, lineno, err)
+			skipped++
+			continue
+		}
+		for _, fix := range fixes {
+			log.Printf("%s:%d: fixed: %s", file, lineno, fix)
+		}
+		if len(fixes) > 0 {
+			fixed++
+		}
+		// 2fa keeps the last line of a name.
+		if j, ok := seen[e.name]; ok {
+			log.Printf("%s:%d: %s replaces the key of the same name", file, lineno, e.name)
+			entries[j] = e
+			continue
+		}
+		seen[e.name] = len(entries)
+		entries = append(entries, e)
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d keys read, %d lines fixed, %d skipped\n", file, len(entries), fixed, skipped)
+	return entries, nil
+}
+
+// parseTwoFALine parses a line of a 2fa keychain, returning the fixes
+// made to it, or why it's invalid.
+func parseTwoFALine(line string) (entry, []string, error) {
+	var e entry
+	var fixes []string
+	if strings.HasSuffix(line, "\r") {
+		line = strings.TrimSuffix(line, "\r")
+		fixes = append(fixes, "removed the carriage return")
+	}
+	f := strings.Fields(line)
+	if strings.Join(f, " ") != line {
+		fixes = append(fixes, "removed extra spaces")
+	}
+	if len(f) != 3 && len(f) != 4 {
+		return e, nil, fmt.Errorf("%d fields, not name, digits, secret and an optional counter", len(f))
+	}
+	e.name = f[0]
+	digits, err := strconv.Atoi(f[1])
+	if err != nil || digits < 6 || digits > 8 {
+		return e, nil, fmt.Errorf("%s: invalid number of digits %q, not 6, 7 or 8", e.name, f[1])
+	}
+	raw, err := decodeKey(f[2])
+	if err != nil {
+		return e, nil, fmt.Errorf("%s: invalid base32 secret: %v", e.name, err)
+	}
+	lockMemory(raw)
+	e.key = Key{digits: digits, raw: raw, text: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)}
+	if len(f) == 4 {
+		n, err := strconv.ParseUint(f[3], 10, 64)
+		if err != nil {
+			return e, nil, fmt.Errorf("%s: invalid counter %q", e.name, f[3])
+		}
+		e.counter = fmt.Sprintf("%0*d", counterLen, n)
+		if e.counter != f[3] {
+			fixes = append(fixes, fmt.Sprintf("%s: padded the counter %s to %d digits", e.name, f[3], counterLen))
+		}
+	}
+	return e, fixes, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+)
+
+// 2FAS Authenticator backups (.2fas files) are JSON:
+//
+//	{"schemaVersion": 4, "services": [{"name": "GitHub", "secret": "...",
+//	  "otp": {"account": "alice", "issuer": "GitHub", "digits": 6,
+//	  "period": 30, "algorithm": "SHA1", "tokenType": "TOTP"}}]}
+//
+// Password-protected backups hold servicesEncrypted instead; they have
+// to be exported again without a password.
+
+type twoFASService struct {
+	Name   string
+	Secret string
+	OTP    struct {
+		Account   string
+		Issuer    string
+		Digits    int
+		Period    int
+		Algorithm string
+		TokenType string
+		Counter   uint64
+	}
+}
+
+type twoFASBackup struct {
+	SchemaVersion     int
+	Services          []twoFASService
+	ServicesEncrypted string
+}
+
+func importTwoFAS(ctx context.Context, file string) ([]entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var b twoFASBackup
+	if err := json.Unmarshal(data, &b); err != nil || b.SchemaVersion == 0 {
+		return nil, errors.New("not a 2FAS backup")
+	}
+	if b.ServicesEncrypted != "" {
+		return nil, errors.New("encrypted 2FAS backups aren't supported, export without a password")
+	}
+	var entries []entry
+	for _, s := range b.Services {
+		issuer := s.OTP.Issuer
+		if issuer == "" {
+			issuer = s.Name
+		}
+		name := entryName(issuer, s.OTP.Account)
+		o := s.OTP
+		if o.TokenType == "" {
+			o.TokenType = "TOTP"
+		}
+		if o.Algorithm == "" {
+			o.Algorithm = "SHA1"
+		}
+		if o.Digits == 0 {
+			o.Digits = 6
+		}
+		if o.Period == 0 {
+			o.Period = 30
+		}
+		e, err := newEntry(name, s.Secret, o.TokenType, o.Algorithm, o.Digits, o.Period, o.Counter)
+		if err != nil {
+			log.Printf("%s: skipped: %v", name, err)
+			continue
+		}
+		e.key.setIdentity(issuer, s.OTP.Account)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
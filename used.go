@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The times keys were last used are kept in a file next to the
+// keychain, $HOME/.gauth.used, so the keychain isn't rewritten, and
+// backed up, whenever a code is shown. Its lines are
+//
+//	name unix-time
+//
+// Showing all codes at once doesn't count as using them.
+
+func usedPath() string {
+	return keychainPath() + ".used"
+}
+
+// readUsed returns the time each key was last used.
+func readUsed() map[string]time.Time {
+	used := make(map[string]time.Time)
+	data, err := ioutil.ReadFile(usedPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("reading use times: %v", err)
+		}
+		return used
+	}
+	parseUsed(data, used)
+	return used
+}
+
+func parseUsed(data []byte, used map[string]time.Time) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) != 2 {
+			continue
+		}
+		if t, err := strconv.ParseInt(f[1], 10, 64); err == nil {
+			used[f[0]] = time.Unix(t, 0)
+		}
+	}
+}
+
+// recordUse records that a code of key name was used now.
+// Failing to record it isn't fatal.
+func recordUse(name string) {
+	if readOnly() {
+		return
+	}
+	if err := writeUse(name, time.Now()); err != nil {
+		log.Printf("recording use of %s: %v", name, err)
+	}
+}
+
+func writeUse(name string, t time.Time) error {
+	f, err := os.OpenFile(usedPath(), os.O_CREATE|os.O_RDWR, keychainPerm().mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	used := make(map[string]time.Time)
+	parseUsed(data, used)
+	used[name] = t
+	var names []string
+	for n := range used {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, n := range names {
+		fmt.Fprintf(&buf, "%s %d\n", n, used[n].Unix())
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(buf.Bytes(), 0)
+	return err
+}
@@ -0,0 +1,113 @@
+//go:build !offline
+// +build !offline
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// vaultBackend generates codes with the TOTP secrets engine of a
+// HashiCorp Vault server. Its keys never leave the server. The token
+// is taken from $VAULT_TOKEN or ~/.vault-token:
+//
+//	backend = vault https://vault.example.com totp
+type vaultBackend struct {
+	addr  string
+	mount string
+}
+
+func init() {
+	backendTypes["vault"] = openVaultBackend
+}
+
+func openVaultBackend(args []string) (backend, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("usage: vault address [mount]")
+	}
+	b := &vaultBackend{addr: strings.TrimSuffix(args[0], "/"), mount: "totp"}
+	if len(args) == 2 {
+		b.mount = strings.Trim(args[1], "/")
+	}
+	return b, nil
+}
+
+func (b *vaultBackend) String() string { return "vault:" + b.addr + "/" + b.mount }
+
+var vaultClient = &http.Client{Timeout: 10 * time.Second}
+
+func vaultToken() (string, error) {
+	if t := os.Getenv("VAULT_TOKEN"); t != "" {
+		return t, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(os.Getenv("HOME"), ".vault-token"))
+	if err != nil {
+		return "", fmt.Errorf("no vault token: set VAULT_TOKEN or run vault login")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// request sends a request to the vault API and decodes
+// the data field of the response into v.
+func (b *vaultBackend) request(ctx context.Context, method, path string, v interface{}) error {
+	token, err := vaultToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, b.addr+"/v1/"+b.mount+"/"+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := vaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == "LIST" {
+		return nil // no keys yet
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct{ Errors []string }
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, strings.Join(e.Errors, "; "))
+	}
+	var r struct{ Data json.RawMessage }
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return err
+	}
+	return json.Unmarshal(r.Data, v)
+}
+
+func (b *vaultBackend) keys(ctx context.Context) ([]keyInfo, error) {
+	var data struct{ Keys []string }
+	if err := b.request(ctx, "LIST", "keys", &data); err != nil {
+		return nil, err
+	}
+	var keys []keyInfo
+	for _, name := range data.Keys {
+		keys = append(keys, keyInfo{name: name})
+	}
+	return keys, nil
+}
+
+func (b *vaultBackend) code(ctx context.Context, name string) (string, error) {
+	var data struct{ Code string }
+	if err := b.request(ctx, "GET", "code/"+url.PathEscape(name), &data); err != nil {
+		return "", err
+	}
+	return data.Code, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cmdVerify = &command{
+	name:  "verify",
+	usage: "verify [-skew n] [-lookahead n] name code",
+	short: "check a code, as a server would",
+	long: `Verify checks code against key name the way a server checks the codes
+its users type, so gauth can validate codes for a service: it exits
+with status 0 if the code is valid, and 5 if it isn't.
+
+A TOTP code is valid for the current time step and the -skew steps
+before and after it, 1 by default, allowing for clocks which differ
+and codes typed as they expire. An HOTP code is valid for the counter
+after the key's, or one of the -lookahead counters after that, 0 by
+default, allowing for codes generated but never used; the counter of
+the key then moves to that of the code.
+
+A code is valid only once, as RFC 6238 requires: for HOTP keys the
+counter moves past it, and for TOTP keys the last time step accepted
+is kept in the accepted attribute of the key, so a code of that step
+or an earlier one is rejected even within the window. Both are stored
+in the backend holding the key, which has to be one gauth can write:
+the keychain, which can't be read-only, or a keyring, sops or ssh
+backend. Checks on one machine take turns, so a code typed twice at
+once is accepted once.`,
+}
+
+var (
+	verifySkew      = cmdVerify.flags.Int("skew", 1, "accept TOTP codes up to `n` time steps away")
+	verifyLookahead = cmdVerify.flags.Int("lookahead", 0, "accept HOTP codes up to `n` counters ahead")
+)
+
+func init() {
+	cmdVerify.run = runVerify
+}
+
+func runVerify(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 2 || *verifySkew < 0 || *verifyLookahead < 0 {
+		cmd.usageExit()
+	}
+	name := args[0]
+	// Codes may be typed in groups.
+	code := strings.Map(checkSpace, args[1])
+	// Concurrent checks of a code accept it once.
+	unlock, err := lockKeychain()
+	if err != nil {
+		log.Fatalf("locking keychain: %v", err)
+	}
+	defer unlock()
+	info, err := federation(openBackends()).lookup(ctx, name)
+	if err != nil {
+		fatal(exitNotFound, err)
+	}
+	store, ok := storeOf(info.source)
+	if !ok {
+		log.Fatalf("%s doesn't give out its secrets, so gauth can't check codes of its keys", info.source)
+	}
+	c, err := store.keychain(ctx)
+	checkInterrupted(ctx, "interrupted")
+	if err != nil {
+		log.Fatalf("%s: %v", info.source, err)
+	}
+	k, ok := c.keys[name]
+	if !ok {
+		fatalf(exitNotFound, "no such key %q", name)
+	}
+	if k.attr("ocra") != "" {
+		log.Fatalf("%s is an OCRA key, whose codes answer challenges", name)
+	}
+	if why := c.readOnlyReason(); why != "" {
+		log.Fatalf("accepted codes can't be recorded in a read-only keychain: %s", why)
+	}
+	if len(code) != k.digits {
+		fatalf(exitVerifyFailed, "the code of %s has %d digits", name, k.digits)
+	}
+	// The key is stored back as it's stored, sealed if it's protected.
+	stored := k
+	if err := k.unlock(name); err != nil {
+		log.Fatal(err)
+	}
+	key := k.hmacKey()
+	defer wipe(key)
+	if k.offset != 0 {
+		n, err := strconv.ParseUint(k.count, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid key counter for %q (%q)", name, k.count)
+		}
+		for m := n + 1; m <= n+1+uint64(*verifyLookahead); m++ {
+			if codesEqual(fmt.Sprintf("%0*d", k.digits, genHOTP(k.hash(), key, m, k.digits)), code) {
+				c.lines[k.line] = formatKey(name, stored, fmt.Sprintf("%0*d", counterLen, m))
+				if err := store.store(ctx, c.lines); err != nil {
+					log.Fatalf("%s: storing counter: %v", info.source, err)
+				}
+				audit("verify", name)
+				return
+			}
+		}
+		fatalf(exitVerifyFailed, "invalid code for %s", name)
+	}
+	period, t0 := int64(k.period()), k.t0()
+	step := (k.now().Unix() - t0) / period
+	for s := step - int64(*verifySkew); s <= step+int64(*verifySkew); s++ {
+		t := time.Unix(t0+s*period, 0)
+		var want string
+		if pin := k.attr("yandex"); pin != "" {
+			want = yandexCode(k.raw, pin, t)
+		} else {
+			want = fmt.Sprintf("%0*d", k.digits, genTOTP(k.hash(), key, t, t0, int(period), k.digits))
+		}
+		if !codesEqual(want, code) {
+			continue
+		}
+		if last, ok := acceptedStep(k); ok && s <= last {
+			fatalf(exitVerifyFailed, "the code of %s was already used", name)
+		}
+		stored.set("accepted", strconv.FormatInt(s, 10))
+		c.lines[k.line] = formatKey(name, stored, k.count)
+		if err := store.store(ctx, c.lines); err != nil {
+			log.Fatalf("%s: recording accepted code: %v", info.source, err)
+		}
+		audit("verify", name)
+		return
+	}
+	fatalf(exitVerifyFailed, "invalid code for %s", name)
+}
+
+// codesEqual compares codes in constant time, so the time taken
+// doesn't tell how much of a guessed code was right.
+func codesEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// acceptedStep returns the last TOTP time step verify accepted for k,
+// kept in its accepted attribute, in whichever backend holds it.
+func acceptedStep(k Key) (int64, bool) {
+	v := k.attr("accepted")
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	return n, err == nil
+}
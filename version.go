@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var cmdVersion = &command{
+	name:  "version",
+	usage: "version",
+	short: "print the version and build of gauth",
+	long: `Version prints the version of gauth, the Go release and platform it
+was built for, the backend types it supports, whether it's in offline
+mode (see "gauth help" and the README) and how it sandboxes itself:
+"offline build" means the binary was built with the offline tag and
+holds no network code at all, while "-offline" and "configuration" tell
+how it was turned on at run time. Builds with cgo can't use Landlock,
+so on Linux their sandbox is the seccomp filter alone.`,
+}
+
+// version is the release of gauth, set when building releases:
+//
+//	go build -ldflags "-X main.version=v1.2.0"
+var version = "devel"
+
+func init() {
+	cmdVersion.run = runVersion
+}
+
+func runVersion(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 0 {
+		cmd.usageExit()
+	}
+	var types []string
+	for t := range backendTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	mode := "no"
+	switch {
+	case offlineBuild:
+		mode = "offline build"
+	case offlineFlag:
+		mode = "-offline"
+	case offline():
+		mode = "configuration"
+	}
+	fmt.Printf("gauth %s\n", version)
+	fmt.Printf("go:       %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("backends: %s\n", strings.Join(types, ", "))
+	fmt.Printf("offline:  %s\n", mode)
+	fmt.Printf("sandbox:  %s\n", sandboxStatus())
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var cmdWipe = &command{
+	name:  "wipe",
+	usage: "wipe [-f] | wipe -duress",
+	short: "destroy the keychain",
+	long: `Wipe overwrites the keychain with random bytes and deletes it, along
+with its backups, the keychain files of "file" backends, and the
+files gauth keeps next to the keychain, its sync repository included.
+It asks twice before, unless -f is given; the keychain needn't be
+decrypted.
+
+Wipe -duress sets a duress passphrase for a keychain encrypted with a
+passphrase ("encrypt = passphrase"). Given instead of the passphrase,
+it wipes the keychain as wipe -f does, and gauth fails as it does for
+a wrong passphrase. It must differ from the keychain passphrase.
+
+Flash storage and copy-on-write filesystems may keep copies of
+overwritten blocks, so encrypting the keychain is what makes wiping
+it reliable: the key is destroyed with the file. A running agent
+keeps its keys until it's stopped.`,
+}
+
+var (
+	wipeForce  = cmdWipe.flags.Bool("f", false, "don't ask for confirmation")
+	wipeDuress = cmdWipe.flags.Bool("duress", false, "set the duress passphrase")
+)
+
+func init() {
+	cmdWipe.run = runWipe
+}
+
+func runWipe(ctx context.Context, cmd *command, args []string) {
+	if len(args) != 0 {
+		cmd.usageExit()
+	}
+	if memoryKeychain != nil {
+		log.Fatalf("the keychain read from %s isn't on disk", memoryKeychain.file)
+	}
+	userKeychain().checkWritable()
+	if *wipeDuress {
+		setDuress()
+		return
+	}
+	if !*wipeForce {
+		if !confirm("wipe the keychain and its backups? This can't be undone.") {
+			os.Exit(1)
+		}
+		fmt.Fprint(os.Stderr, `type "wipe" to confirm: `)
+		text, _ := stdin.ReadString('\n')
+		if strings.TrimSpace(text) != "wipe" {
+			os.Exit(1)
+		}
+	}
+	if err := wipeKeychain(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintln(os.Stderr, "wiped the keychain")
+}
+
+// wipeKeychain overwrites and deletes the keychain, its backups,
+// the files next to it and the keychains of file backends.
+// It carries on past errors, and returns the first.
+func wipeKeychain() error {
+	files := []string{keychainPath()}
+	for _, line := range conf["backend"] {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "file" {
+			files = append(files, expandHome(f[1]))
+		}
+	}
+	var first error
+	fail := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+	for _, file := range files {
+		backups, _ := filepath.Glob(filepath.Join(backupDir(file), "*"))
+		for _, f := range append([]string{file, integrityPath(file), indexPath(file)}, backups...) {
+			if err := shred(f); err != nil {
+				fail(err)
+			}
+		}
+		if err := os.Remove(backupDir(file)); err != nil && !os.IsNotExist(err) {
+			fail(err)
+		}
+	}
+	for _, f := range []string{usedPath(), keychainLockPath(), duressPath(), cloudStatePath(), cloudConflictPath()} {
+		if err := shred(f); err != nil {
+			fail(err)
+		}
+	}
+	// The sync repository holds copies of the keychain too.
+	filepath.Walk(syncDir(), func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			os.Chmod(path, 0600) // git objects are read-only
+			if err := shred(path); err != nil {
+				fail(err)
+			}
+		}
+		return nil
+	})
+	if err := os.RemoveAll(syncDir()); err != nil {
+		fail(err)
+	}
+	return first
+}
+
+// shred overwrites file with random bytes, flushes it to disk
+// and deletes it. A missing file isn't an error.
+func shred(file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.Mode().IsRegular() {
+		_, err = io.CopyN(f, rand.Reader, fi.Size())
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("overwriting %s: %v", file, err)
+	}
+	return os.Remove(file)
+}
+
+// duressPath returns the file of the duress passphrase: a known
+// text sealed with it, as encrypted backups are.
+func duressPath() string {
+	return keychainPath() + ".duress"
+}
+
+const duressText = "gauth duress"
+
+func setDuress() {
+	if spec := encryptSpec(); len(spec) == 0 || spec[0] != "passphrase" {
+		log.Fatalf("a duress passphrase needs \"encrypt = passphrase\" in %s", configPath())
+	}
+	passphrase, err := readNewPassword("duress passphrase: ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if passphrase == "" {
+		log.Fatal("empty passphrase")
+	}
+	// The real passphrase would wipe the keychain instead of opening it.
+	if data, err := ioutil.ReadFile(keychainPath()); err == nil && isEncryptedKeychain(data) {
+		if k, _, err := parseEncrypted(data); err == nil && k.protector == "passphrase" {
+			if key, err := unseal(k.wrapped, passphrase); err == nil {
+				wipe(key)
+				log.Fatal("the duress passphrase can't be the keychain passphrase")
+			}
+		}
+	}
+	data, err := seal([]byte(duressText), passphrase)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(duressPath(), data, keychainPerm().mode); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintln(os.Stderr, "set the duress passphrase")
+}
+
+// isDuress reports whether passphrase is the duress passphrase.
+func isDuress(passphrase string) bool {
+	data, err := ioutil.ReadFile(duressPath())
+	if err != nil {
+		return false
+	}
+	text, err := unseal(data, passphrase)
+	return err == nil && string(text) == duressText
+}
+
+// checkDuress wipes the keychain if passphrase is the duress
+// passphrase, and returns the error of a wrong passphrase either way.
+func checkDuress(passphrase string) error {
+	if isDuress(passphrase) {
+		wipeKeychain()
+	}
+	return errors.New("wrong passphrase")
+}
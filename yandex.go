@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+)
+
+// Yandex.Key keys generate TOTP codes of their own kind: the HMAC key is
+// the SHA-256 digest of the user's PIN followed by the secret, and the
+// code is 8 letters, the truncated HMAC-SHA256 in base 26. Their
+// keychain lines carry the PIN in the yandex attribute:
+//
+//	yandex 8 LA2V6KMCGYMWWVEW64RNP3JA3I yandex=5239
+//
+// "gauth add -yandex name" asks for it, as do otpauth://yaotp/ URIs,
+// which Yandex's QR codes hold without the PIN.
+
+// yandexSecretLen is the length of Yandex secrets. The secrets of
+// Yandex's QR codes are longer, ending with a checksum.
+const yandexSecretLen = 16
+
+func init() {
+	attrCheckers["yandex"] = func(v string) bool {
+		for _, r := range v {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+		return v != ""
+	}
+}
+
+// yandexCode returns the Yandex.Key code of secret and pin at time t.
+func yandexCode(secret []byte, pin string, t time.Time) string {
+	if len(secret) > yandexSecretLen {
+		secret = secret[:yandexSecretLen]
+	}
+	data := append([]byte(pin), secret...)
+	sum := sha256.Sum256(data)
+	wipe(data)
+	key := sum[:]
+	if key[0] == 0 {
+		key = key[1:]
+	}
+	h := hmac.New(sha256.New, key)
+	binary.Write(h, binary.BigEndian, uint64(t.Unix()/30))
+	mac := h.Sum(nil)
+	wipe(sum[:])
+	i := mac[len(mac)-1] & 0x0F
+	v := binary.BigEndian.Uint64(mac[i:]) & 0x7FFFFFFFFFFFFFFF
+	code := make([]byte, 8)
+	for j := len(code) - 1; j >= 0; j-- {
+		code[j] = 'a' + byte(v%26)
+		v /= 26
+	}
+	return string(code)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// TestYandexCode checks yandexCode against the vectors of Aegis's
+// YAOTP tests, whose secrets end with the checksum of Yandex's QR codes.
+func TestYandexCode(t *testing.T) {
+	tests := []struct {
+		pin, secret string
+		time        int64
+		want        string
+	}{
+		{"5239", "6SB2IKNM6OBZPAVBVTOHDKS4FAAAAAAADFUTQMBTRY", 1641559648, "umozdicq"},
+		{"7586", "LA2V6KMCGYMWWVEW64RNP3JA3IAAAAAAHTSG4HRZPI", 1581064020, "oactmacq"},
+		{"7586", "LA2V6KMCGYMWWVEW64RNP3JA3IAAAAAAHTSG4HRZPI", 1581090810, "wemdwrix"},
+		{"5210481216086702", "JBGSAU4G7IEZG6OY4UAXX62JU4AAAAAAHTSG4HXU3M", 1581091469, "dfrpywob"},
+		{"5210481216086702", "JBGSAU4G7IEZG6OY4UAXX62JU4AAAAAAHTSG4HXU3M", 1581093059, "vunyprpd"},
+	}
+	for _, tt := range tests {
+		secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(tt.secret)
+		if err != nil {
+			t.Fatalf("decoding %s: %v", tt.secret, err)
+		}
+		if got := yandexCode(secret, tt.pin, time.Unix(tt.time, 0)); got != tt.want {
+			t.Errorf("yandexCode(%s, %s, %d) = %s, want %s", tt.secret, tt.pin, tt.time, got, tt.want)
+		}
+		// The secret without its checksum gives the same codes.
+		if got := yandexCode(secret[:yandexSecretLen], tt.pin, time.Unix(tt.time, 0)); got != tt.want {
+			t.Errorf("yandexCode(%s without checksum, %s, %d) = %s, want %s", tt.secret, tt.pin, tt.time, got, tt.want)
+		}
+	}
+}
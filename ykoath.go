@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// yubikeyBackend lists and generates the codes of the credentials kept
+// in the OATH application of a YubiKey, whose secrets never leave it.
+// ykman (https://developers.yubico.com/yubikey-manager/) talks to the
+// YubiKey over PC/SC:
+//
+//	backend = yubikey [serial]
+//
+// The serial number picks a YubiKey when several are plugged in. ykman
+// asks for the OATH password itself, if one is set, and for a touch of
+// the credentials which require it. Credentials are named as ykman
+// names them, issuer:account, and are changed with ykman too.
+type yubikeyBackend struct {
+	serial string
+
+	once  sync.Once
+	creds []keyInfo
+	err   error
+}
+
+func init() {
+	backendTypes["yubikey"] = openYubikeyBackend
+}
+
+func openYubikeyBackend(args []string) (backend, error) {
+	switch len(args) {
+	case 0:
+		return &yubikeyBackend{}, nil
+	case 1:
+		if _, err := strconv.Atoi(args[0]); err != nil {
+			return nil, fmt.Errorf("invalid serial number %q", args[0])
+		}
+		return &yubikeyBackend{serial: args[0]}, nil
+	}
+	return nil, fmt.Errorf("usage: yubikey [serial]")
+}
+
+func (b *yubikeyBackend) String() string {
+	if b.serial != "" {
+		return "yubikey:" + b.serial
+	}
+	return "yubikey"
+}
+
+// ykman runs an oath accounts subcommand of ykman. Its messages go to
+// stderr if it isn't nil, and make up the error otherwise.
+func (b *yubikeyBackend) ykman(ctx context.Context, stderr io.Writer, args ...string) ([]byte, error) {
+	var all []string
+	if b.serial != "" {
+		all = append(all, "--device", b.serial)
+	}
+	all = append(append(all, "oath", "accounts"), args...)
+	cmd := exec.CommandContext(ctx, "ykman", all...)
+	cmd.Stdin = os.Stdin // for the password
+	var msgs bytes.Buffer
+	cmd.Stderr = &msgs
+	if stderr != nil {
+		cmd.Stderr = stderr
+	}
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("ykman not found")
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(msgs.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, fmt.Errorf("ykman: %v", err)
+	}
+	return out, nil
+}
+
+func (b *yubikeyBackend) keys(ctx context.Context) ([]keyInfo, error) {
+	b.once.Do(func() {
+		var out []byte
+		out, b.err = b.ykman(ctx, nil, "list", "--oath-type", "--period")
+		if b.err != nil {
+			return
+		}
+		b.creds, b.err = parseYkmanList(out)
+		for i := range b.creds {
+			b.creds[i].source = b
+		}
+	})
+	return b.creds, b.err
+}
+
+// parseYkmanList parses the lines of "ykman oath accounts list
+// --oath-type --period", "name, TOTP, 30".
+func parseYkmanList(out []byte) ([]keyInfo, error) {
+	var keys []keyInfo
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		f := strings.Split(line, ", ")
+		if len(f) < 3 {
+			return nil, fmt.Errorf("unexpected ykman output %q", line)
+		}
+		n := len(f)
+		name := strings.Join(f[:n-2], ", ")
+		k := keyInfo{name: name, digits: 6, hotp: f[n-2] == "HOTP"}
+		if !k.hotp {
+			k.period, _ = strconv.Atoi(f[n-1])
+		}
+		if i := strings.Index(name, ":"); i >= 0 {
+			k.issuer, k.account = name[:i], name[i+1:]
+		} else {
+			k.account = name
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (b *yubikeyBackend) code(ctx context.Context, name string) (string, error) {
+	// Let ykman ask for a touch.
+	out, err := b.ykman(ctx, os.Stderr, "code", "--single", name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// yubikeyProtector wraps keys under the HMAC-SHA1 challenge-response
+// of a YubiKey slot, as KeePassXC does: a random challenge is sent to
+// the YubiKey, and the SHA-256 of its response encrypts the key with
+// AES-256-GCM. Without the YubiKey, whose HMAC secret can't be read
+// back, the keychain can't be decrypted. The challenge is sent with
+// ykman, or ykchalresp from yubikey-personalization.
+//
+// The wrapped key is
+//
+//	slot (1) | challenge (32) | nonce (12) | ciphertext
+type yubikeyProtector struct {
+	slot byte // 1 or 2
+}
+
+const yubikeyChallengeLen = 32
+
+func init() {
+	keyProtectors["yubikey"] = newYubikeyProtector
+}
+
+func newYubikeyProtector(args []string) (keyProtector, error) {
+	switch {
+	case len(args) == 0:
+		return yubikeyProtector{slot: 2}, nil
+	case len(args) == 1 && (args[0] == "1" || args[0] == "2"):
+		return yubikeyProtector{slot: args[0][0] - '0'}, nil
+	}
+	return nil, errors.New("yubikey takes a slot, 1 or 2 (the default)")
+}
+
+func (p yubikeyProtector) wrap(key []byte) ([]byte, error) {
+	challenge := make([]byte, yubikeyChallengeLen)
+	if _, err := io.ReadFull(rand.Reader, challenge); err != nil {
+		return nil, err
+	}
+	kek, err := yubikeyKEK(p.slot, challenge)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(kek)
+	ct, err := kekWrap(kek, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{p.slot}, challenge...), ct...), nil
+}
+
+func (yubikeyProtector) unwrap(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 1+yubikeyChallengeLen {
+		return nil, errors.New("invalid wrapped key")
+	}
+	slot, challenge := wrapped[0], wrapped[1:1+yubikeyChallengeLen]
+	kek, err := yubikeyKEK(slot, challenge)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(kek)
+	key, err := kekUnwrap(kek, wrapped[1+yubikeyChallengeLen:])
+	if err != nil {
+		return nil, fmt.Errorf("the YubiKey's response doesn't decrypt the keychain (another YubiKey, or slot %d reprogrammed?)", slot)
+	}
+	return key, nil
+}
+
+// yubikeyKEK sends challenge to the YubiKey slot and returns
+// the SHA-256 of the response.
+func yubikeyKEK(slot byte, challenge []byte) ([]byte, error) {
+	var cmd *exec.Cmd
+	s, c := fmt.Sprint(slot), hex.EncodeToString(challenge)
+	if _, err := exec.LookPath("ykman"); err == nil {
+		cmd = exec.Command("ykman", "otp", "calculate", s, c)
+	} else if _, err := exec.LookPath("ykchalresp"); err == nil {
+		cmd = exec.Command("ykchalresp", "-"+s, "-x", c)
+	} else {
+		return nil, errors.New("neither ykman nor ykchalresp is installed")
+	}
+	if isTerminal(os.Stderr.Fd()) {
+		fmt.Fprintln(os.Stderr, "touch your YubiKey if it blinks")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", cmd.Args[0], msg)
+		}
+		return nil, fmt.Errorf("%s: %v", cmd.Args[0], err)
+	}
+	resp, err := hex.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil || len(resp) != 20 {
+		return nil, fmt.Errorf("%s: unexpected output", cmd.Args[0])
+	}
+	kek := sha256.Sum256(resp)
+	wipe(resp)
+	return kek[:], nil
+}